@@ -2,104 +2,83 @@ package chroot
 
 import (
 	"fmt"
+	"github.com/mitchellh/packer/communicator/local"
 	"github.com/mitchellh/packer/packer"
 	"io"
-	"io/ioutil"
-	"log"
-	"os"
-	"os/exec"
 	"path/filepath"
-	"syscall"
+	"strings"
 )
 
 // Communicator is a special communicator that works by executing
 // commands locally but within a chroot.
 type Communicator struct {
 	Chroot     string
-	CmdWrapper CommandWrapper
+	CmdWrapper local.CommandWrapper
 }
 
 func (c *Communicator) Start(cmd *packer.RemoteCmd) error {
-	command, err := c.CmdWrapper(
-		fmt.Sprintf("chroot %s %s", c.Chroot, cmd.Command))
-	if err != nil {
-		return err
-	}
-
-	localCmd := ShellCommand(command)
-	localCmd.Stdin = cmd.Stdin
-	localCmd.Stdout = cmd.Stdout
-	localCmd.Stderr = cmd.Stderr
-	log.Printf("Executing: %s %#v", localCmd.Path, localCmd.Args)
-	if err := localCmd.Start(); err != nil {
-		return err
+	comm := &local.Communicator{
+		CommandWrapper: func(command string) (string, error) {
+			return c.CmdWrapper(fmt.Sprintf("chroot %s %s", c.Chroot, command))
+		},
 	}
 
-	go func() {
-		exitStatus := 0
-		if err := localCmd.Wait(); err != nil {
-			if exitErr, ok := err.(*exec.ExitError); ok {
-				exitStatus = 1
-
-				// There is no process-independent way to get the REAL
-				// exit status so we just try to go deeper.
-				if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
-					exitStatus = status.ExitStatus()
-				}
-			}
-		}
-
-		log.Printf(
-			"Chroot execution exited with '%d': '%s'",
-			exitStatus, cmd.Command)
-		cmd.SetExited(exitStatus)
-	}()
-
-	return nil
+	return comm.Start(cmd)
 }
 
 func (c *Communicator) Upload(dst string, r io.Reader) error {
-	dst = filepath.Join(c.Chroot, dst)
-	log.Printf("Uploading to chroot dir: %s", dst)
-	tf, err := ioutil.TempFile("", "packer-amazon-chroot")
-	if err != nil {
-		return fmt.Errorf("Error preparing shell script: %s", err)
-	}
-	defer os.Remove(tf.Name())
-	io.Copy(tf, r)
+	return c.files().Upload(filepath.Join(c.Chroot, dst), r)
+}
 
-	cpCmd, err := c.CmdWrapper(fmt.Sprintf("cp %s %s", tf.Name(), dst))
-	if err != nil {
+// UploadFile copies the file into the chroot with its mode. The owner and
+// group are names within the chroot, so they are set by a command run
+// inside of it.
+func (c *Communicator) UploadFile(dst string, r io.Reader, opts *packer.UploadOptions) error {
+	mode := &packer.UploadOptions{Mode: opts.Mode}
+	if err := c.files().UploadFile(filepath.Join(c.Chroot, dst), r, mode); err != nil {
 		return err
 	}
 
-	return ShellCommand(cpCmd).Run()
-}
+	if opts.Owner == "" && opts.Group == "" {
+		return nil
+	}
 
-func (c *Communicator) UploadDir(dst string, src string, exclude []string) error {
-	// TODO: remove any file copied if it appears in `exclude`
-	chrootDest := filepath.Join(c.Chroot, dst)
-	log.Printf("Uploading directory '%s' to '%s'", src, chrootDest)
-	cpCmd, err := c.CmdWrapper(fmt.Sprintf("cp -R %s* %s", src, chrootDest))
-	if err != nil {
+	// The commands in the chroot already run as root, and sudo may not
+	// be installed in it.
+	owner := &packer.UploadOptions{Owner: opts.Owner, Group: opts.Group}
+	cmd := owner.RemoteCmd(dst)
+	cmd.Elevated = false
+	if err := c.Start(cmd); err != nil {
 		return err
 	}
 
-	return ShellCommand(cpCmd).Run()
+	cmd.Wait()
+	if cmd.ExitStatus != 0 {
+		return fmt.Errorf("Error changing the owner of %s, exit status %d", dst, cmd.ExitStatus)
+	}
+
+	return nil
+}
+
+func (c *Communicator) UploadDir(dst string, src string, exclude []string) error {
+	return c.files().UploadDir(filepath.Join(c.Chroot, dst), src, exclude)
 }
 
 func (c *Communicator) Download(src string, w io.Writer) error {
-	src = filepath.Join(c.Chroot, src)
-	log.Printf("Downloading from chroot dir: %s", src)
-	f, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
+	return c.files().Download(filepath.Join(c.Chroot, src), w)
+}
 
-	if _, err := io.Copy(w, f); err != nil {
-		return err
+func (c *Communicator) DownloadDir(src string, dst string, exclude []string) error {
+	chrootSrc := filepath.Join(c.Chroot, src)
+	if strings.HasSuffix(src, "/") {
+		chrootSrc += "/"
 	}
 
-	return nil
+	return c.files().DownloadDir(chrootSrc, dst, exclude)
+}
+
+// files returns the local communicator that copies files into and out of
+// the chroot, which it does from outside of the chroot.
+func (c *Communicator) files() *local.Communicator {
+	return &local.Communicator{CommandWrapper: c.CmdWrapper}
 }
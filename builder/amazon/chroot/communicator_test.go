@@ -2,6 +2,10 @@ package chroot
 
 import (
 	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
 )
 
@@ -11,4 +15,56 @@ func TestCommunicator_ImplementsCommunicator(t *testing.T) {
 	if _, ok := raw.(packer.Communicator); !ok {
 		t.Fatalf("Communicator should be a communicator")
 	}
+
+	if _, ok := raw.(packer.FileUploader); !ok {
+		t.Fatalf("Communicator should be a file uploader")
+	}
+}
+
+func TestCommunicatorDownloadDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("not supported on windows")
+	}
+
+	chroot, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(chroot)
+
+	dst, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dst)
+
+	if err := os.MkdirAll(filepath.Join(chroot, "var", "log"), 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	for _, name := range []string{"build.log", "build.tmp"} {
+		path := filepath.Join(chroot, "var", "log", name)
+		if err := ioutil.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	comm := &Communicator{
+		Chroot: chroot,
+		CmdWrapper: func(command string) (string, error) {
+			return command, nil
+		},
+	}
+
+	if err := comm.DownloadDir("/var/log/", dst, []string{"*.tmp"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dst, "build.log")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dst, "build.tmp")); !os.IsNotExist(err) {
+		t.Fatalf("excluded file should not be downloaded: %s", err)
+	}
 }
@@ -2,6 +2,7 @@ package chroot
 
 import (
 	"fmt"
+	"github.com/mitchellh/packer/communicator/local"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -21,7 +22,7 @@ func TestCopyFile(t *testing.T) {
 	}
 	first.Sync()
 
-	cmd := ShellCommand(fmt.Sprintf("cp %s %s", first.Name(), newName))
+	cmd := local.ShellCommand(fmt.Sprintf("cp %s %s", first.Name(), newName))
 	if err := cmd.Run(); err != nil {
 		t.Fatalf("Couldn't copy file")
 	}
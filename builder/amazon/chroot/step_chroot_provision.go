@@ -2,6 +2,7 @@ package chroot
 
 import (
 	"github.com/mitchellh/multistep"
+	"github.com/mitchellh/packer/communicator/local"
 	"github.com/mitchellh/packer/packer"
 	"log"
 )
@@ -15,7 +16,7 @@ func (s *StepChrootProvision) Run(state multistep.StateBag) multistep.StepAction
 	hook := state.Get("hook").(packer.Hook)
 	mountPath := state.Get("mount_path").(string)
 	ui := state.Get("ui").(packer.Ui)
-	wrappedCommand := state.Get("wrappedCommand").(CommandWrapper)
+	wrappedCommand := state.Get("wrappedCommand").(local.CommandWrapper)
 
 	// Create our communicator
 	comm := &Communicator{
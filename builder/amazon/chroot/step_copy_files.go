@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/mitchellh/multistep"
+	"github.com/mitchellh/packer/communicator/local"
 	"github.com/mitchellh/packer/packer"
 	"log"
 	"path/filepath"
@@ -22,7 +23,7 @@ func (s *StepCopyFiles) Run(state multistep.StateBag) multistep.StepAction {
 	config := state.Get("config").(*Config)
 	mountPath := state.Get("mount_path").(string)
 	ui := state.Get("ui").(packer.Ui)
-	wrappedCommand := state.Get("wrappedCommand").(CommandWrapper)
+	wrappedCommand := state.Get("wrappedCommand").(local.CommandWrapper)
 	stderr := new(bytes.Buffer)
 
 	s.files = make([]string, 0, len(config.CopyFiles))
@@ -42,7 +43,7 @@ func (s *StepCopyFiles) Run(state multistep.StateBag) multistep.StepAction {
 			}
 
 			stderr.Reset()
-			cmd := ShellCommand(cmdText)
+			cmd := local.ShellCommand(cmdText)
 			cmd.Stderr = stderr
 			if err := cmd.Run(); err != nil {
 				err := fmt.Errorf(
@@ -68,7 +69,7 @@ func (s *StepCopyFiles) Cleanup(state multistep.StateBag) {
 }
 
 func (s *StepCopyFiles) CleanupFunc(state multistep.StateBag) error {
-	wrappedCommand := state.Get("wrappedCommand").(CommandWrapper)
+	wrappedCommand := state.Get("wrappedCommand").(local.CommandWrapper)
 	if s.files != nil {
 		for _, file := range s.files {
 			log.Printf("Removing: %s", file)
@@ -77,7 +78,7 @@ func (s *StepCopyFiles) CleanupFunc(state multistep.StateBag) error {
 				return err
 			}
 
-			localCmd := ShellCommand(localCmdText)
+			localCmd := local.ShellCommand(localCmdText)
 			if err := localCmd.Run(); err != nil {
 				return err
 			}
@@ -19,6 +19,10 @@ type Artifact struct {
 
 	// EC2 connection for performing API stuff.
 	Conn *ec2.EC2
+
+	// SSHHostKeys maps the SSH address of the instance the AMIs were
+	// created from to the host key it had, if SSH was used.
+	SSHHostKeys map[string]string
 }
 
 func (a *Artifact) BuilderId() string {
@@ -49,6 +53,34 @@ func (a *Artifact) String() string {
 	return fmt.Sprintf("AMIs were created:\n\n%s", strings.Join(amiStrings, "\n"))
 }
 
+// State returns the builder specific state of the artifact. The
+// following keys are available:
+//
+//   amis          map[string]string - A map of regions to AMI IDs
+//   ssh_host_keys map[string]string - A map of the SSH address of the
+//                                     instance to its host key, if SSH was
+//                                     used
+func (a *Artifact) State(name string) interface{} {
+	switch name {
+	case packer.ArtifactStateKeysKey:
+		if a.SSHHostKeys != nil {
+			return []string{"amis", "ssh_host_keys"}
+		}
+
+		return []string{"amis"}
+	case "amis":
+		return a.Amis
+	case "ssh_host_keys":
+		if a.SSHHostKeys == nil {
+			return nil
+		}
+
+		return a.SSHHostKeys
+	default:
+		return nil
+	}
+}
+
 func (a *Artifact) Destroy() error {
 	errors := make([]error, 0)
 
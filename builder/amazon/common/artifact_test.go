@@ -46,3 +46,33 @@ west: bar`
 	result := a.String()
 	assert.Equal(result, expected, "should match output")
 }
+
+func TestArtifactState(t *testing.T) {
+	amis := map[string]string{"east": "foo"}
+	a := &Artifact{Amis: amis}
+
+	result, ok := a.State("amis").(map[string]string)
+	if !ok || result["east"] != "foo" {
+		t.Fatalf("bad: %#v", a.State("amis"))
+	}
+
+	if a.State("unknown") != nil {
+		t.Fatal("unknown state should be nil")
+	}
+	// Host keys are only listed when SSH was used
+	keys := a.State(packer.ArtifactStateKeysKey).([]string)
+	if len(keys) != 1 || a.State("ssh_host_keys") != nil {
+		t.Fatalf("bad: %#v", keys)
+	}
+
+	a.SSHHostKeys = map[string]string{"1.2.3.4:22": "ssh-rsa AAAA"}
+	keys = a.State(packer.ArtifactStateKeysKey).([]string)
+	if len(keys) != 2 {
+		t.Fatalf("bad: %#v", keys)
+	}
+
+	hostKeys, ok := a.State("ssh_host_keys").(map[string]string)
+	if !ok || hostKeys["1.2.3.4:22"] != "ssh-rsa AAAA" {
+		t.Fatalf("bad: %#v", a.State("ssh_host_keys"))
+	}
+}
@@ -4,9 +4,8 @@ import (
 	"fmt"
 	"github.com/mitchellh/goamz/ec2"
 	"github.com/mitchellh/multistep"
+	packercommon "github.com/mitchellh/packer/common"
 	"github.com/mitchellh/packer/packer"
-	"os"
-	"runtime"
 )
 
 type StepKeyPair struct {
@@ -39,26 +38,12 @@ func (s *StepKeyPair) Run(state multistep.StateBag) multistep.StepAction {
 	// directory.
 	if s.Debug {
 		ui.Message(fmt.Sprintf("Saving key for debug purposes: %s", s.DebugKeyPath))
-		f, err := os.Create(s.DebugKeyPath)
-		if err != nil {
+		if err := packercommon.SaveDebugKey(s.DebugKeyPath, keyResp.KeyMaterial); err != nil {
 			state.Put("error", fmt.Errorf("Error saving debug key: %s", err))
 			return multistep.ActionHalt
 		}
-		defer f.Close()
 
-		// Write the key out
-		if _, err := f.Write([]byte(keyResp.KeyMaterial)); err != nil {
-			state.Put("error", fmt.Errorf("Error saving debug key: %s", err))
-			return multistep.ActionHalt
-		}
-
-		// Chmod it so that it is SSH ready
-		if runtime.GOOS != "windows" {
-			if err := f.Chmod(0600); err != nil {
-				state.Put("error", fmt.Errorf("Error setting permissions of debug key: %s", err))
-				return multistep.ActionHalt
-			}
-		}
+		state.Put("ssh_debug_key_path", s.DebugKeyPath)
 	}
 
 	return multistep.ActionContinue
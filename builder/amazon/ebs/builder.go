@@ -19,11 +19,12 @@ import (
 const BuilderId = "mitchellh.amazonebs"
 
 type config struct {
-	common.PackerConfig    `mapstructure:",squash"`
-	awscommon.AccessConfig `mapstructure:",squash"`
-	awscommon.AMIConfig    `mapstructure:",squash"`
-	awscommon.BlockDevices `mapstructure:",squash"`
-	awscommon.RunConfig    `mapstructure:",squash"`
+	common.PackerConfig     `mapstructure:",squash"`
+	awscommon.AccessConfig  `mapstructure:",squash"`
+	awscommon.AMIConfig     `mapstructure:",squash"`
+	awscommon.BlockDevices  `mapstructure:",squash"`
+	awscommon.RunConfig     `mapstructure:",squash"`
+	common.SSHConnectConfig `mapstructure:",squash"`
 
 	tpl *packer.ConfigTemplate
 }
@@ -51,6 +52,7 @@ func (b *Builder) Prepare(raws ...interface{}) error {
 	errs = packer.MultiErrorAppend(errs, b.config.AccessConfig.Prepare(b.config.tpl)...)
 	errs = packer.MultiErrorAppend(errs, b.config.AMIConfig.Prepare(b.config.tpl)...)
 	errs = packer.MultiErrorAppend(errs, b.config.RunConfig.Prepare(b.config.tpl)...)
+	errs = packer.MultiErrorAppend(errs, b.config.SSHConnectConfig.Prepare(b.config.tpl)...)
 
 	if errs != nil && len(errs.Errors) > 0 {
 		return errs
@@ -74,7 +76,7 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 	ec2conn := ec2.New(auth, region)
 
 	// Setup the state bag and initial state for the steps
-	state := new(multistep.BasicStateBag)
+	state := new(common.StateBag)
 	state.Put("config", b.config)
 	state.Put("ec2", ec2conn)
 	state.Put("hook", hook)
@@ -107,6 +109,7 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 			SSHAddress:     awscommon.SSHAddress(ec2conn, b.config.SSHPort),
 			SSHConfig:      awscommon.SSHConfig(b.config.SSHUsername),
 			SSHWaitTimeout: b.config.SSHTimeout(),
+			ConnectConfig:  &b.config.SSHConnectConfig,
 		},
 		&common.StepProvision{},
 		&stepStopInstance{},
@@ -126,10 +129,7 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 
 	// Run!
 	if b.config.PackerDebug {
-		b.runner = &multistep.DebugRunner{
-			Steps:   steps,
-			PauseFn: common.MultistepDebugFn(ui),
-		}
+		b.runner = common.MultistepDebugRunner(steps, ui)
 	} else {
 		b.runner = &multistep.BasicRunner{Steps: steps}
 	}
@@ -146,11 +146,14 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 		return nil, nil
 	}
 
+	sshHostKeys, _ := state.Get("ssh_host_keys").(map[string]string)
+
 	// Build the artifact and return it
 	artifact := &awscommon.Artifact{
 		Amis:           state.Get("amis").(map[string]string),
 		BuilderIdValue: BuilderId,
 		Conn:           ec2conn,
+		SSHHostKeys:    sshHostKeys,
 	}
 
 	return artifact, nil
@@ -21,11 +21,12 @@ const BuilderId = "mitchellh.amazon.instance"
 // Config is the configuration that is chained through the steps and
 // settable from the template.
 type Config struct {
-	common.PackerConfig    `mapstructure:",squash"`
-	awscommon.AccessConfig `mapstructure:",squash"`
-	awscommon.AMIConfig    `mapstructure:",squash"`
-	awscommon.BlockDevices `mapstructure:",squash"`
-	awscommon.RunConfig    `mapstructure:",squash"`
+	common.PackerConfig     `mapstructure:",squash"`
+	awscommon.AccessConfig  `mapstructure:",squash"`
+	awscommon.AMIConfig     `mapstructure:",squash"`
+	awscommon.BlockDevices  `mapstructure:",squash"`
+	awscommon.RunConfig     `mapstructure:",squash"`
+	common.SSHConnectConfig `mapstructure:",squash"`
 
 	AccountId           string `mapstructure:"account_id"`
 	BundleDestination   string `mapstructure:"bundle_destination"`
@@ -98,6 +99,7 @@ func (b *Builder) Prepare(raws ...interface{}) error {
 	errs = packer.MultiErrorAppend(errs, b.config.AccessConfig.Prepare(b.config.tpl)...)
 	errs = packer.MultiErrorAppend(errs, b.config.AMIConfig.Prepare(b.config.tpl)...)
 	errs = packer.MultiErrorAppend(errs, b.config.RunConfig.Prepare(b.config.tpl)...)
+	errs = packer.MultiErrorAppend(errs, b.config.SSHConnectConfig.Prepare(b.config.tpl)...)
 
 	validates := map[string]*string{
 		"bundle_upload_command": &b.config.BundleUploadCommand,
@@ -177,7 +179,7 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 	ec2conn := ec2.New(auth, region)
 
 	// Setup the state bag and initial state for the steps
-	state := new(multistep.BasicStateBag)
+	state := new(common.StateBag)
 	state.Put("config", &b.config)
 	state.Put("ec2", ec2conn)
 	state.Put("hook", hook)
@@ -210,6 +212,7 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 			SSHAddress:     awscommon.SSHAddress(ec2conn, b.config.SSHPort),
 			SSHConfig:      awscommon.SSHConfig(b.config.SSHUsername),
 			SSHWaitTimeout: b.config.SSHTimeout(),
+			ConnectConfig:  &b.config.SSHConnectConfig,
 		},
 		&common.StepProvision{},
 		&StepUploadX509Cert{},
@@ -232,10 +235,7 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 
 	// Run!
 	if b.config.PackerDebug {
-		b.runner = &multistep.DebugRunner{
-			Steps:   steps,
-			PauseFn: common.MultistepDebugFn(ui),
-		}
+		b.runner = common.MultistepDebugRunner(steps, ui)
 	} else {
 		b.runner = &multistep.BasicRunner{Steps: steps}
 	}
@@ -252,11 +252,14 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 		return nil, nil
 	}
 
+	sshHostKeys, _ := state.Get("ssh_host_keys").(map[string]string)
+
 	// Build the artifact and return it
 	artifact := &awscommon.Artifact{
 		Amis:           state.Get("amis").(map[string]string),
 		BuilderIdValue: BuilderId,
 		Conn:           ec2conn,
+		SSHHostKeys:    sshHostKeys,
 	}
 
 	return artifact, nil
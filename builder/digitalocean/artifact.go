@@ -2,6 +2,7 @@ package digitalocean
 
 import (
 	"fmt"
+	"github.com/mitchellh/packer/packer"
 	"log"
 )
 
@@ -14,6 +15,10 @@ type Artifact struct {
 
 	// The client for making API calls
 	client *DigitalOceanClient
+
+	// The host keys of the droplet the snapshot was created from, by
+	// SSH address
+	sshHostKeys map[string]string
 }
 
 func (*Artifact) BuilderId() string {
@@ -33,6 +38,36 @@ func (a *Artifact) String() string {
 	return fmt.Sprintf("A snapshot was created: %v", a.snapshotName)
 }
 
+// State returns the builder specific state of the artifact. The
+// following keys are available:
+//
+//   snapshot_id   uint              - The ID of the snapshot image
+//   snapshot_name string            - The name of the snapshot image
+//   ssh_host_keys map[string]string - A map of the SSH address of the
+//                                     droplet to its host key
+func (a *Artifact) State(name string) interface{} {
+	switch name {
+	case packer.ArtifactStateKeysKey:
+		if a.sshHostKeys != nil {
+			return []string{"snapshot_id", "snapshot_name", "ssh_host_keys"}
+		}
+
+		return []string{"snapshot_id", "snapshot_name"}
+	case "snapshot_id":
+		return a.snapshotId
+	case "snapshot_name":
+		return a.snapshotName
+	case "ssh_host_keys":
+		if a.sshHostKeys == nil {
+			return nil
+		}
+
+		return a.sshHostKeys
+	default:
+		return nil
+	}
+}
+
 func (a *Artifact) Destroy() error {
 	log.Printf("Destroying image: %d", a.snapshotId)
 	return a.client.DestroyImage(a.snapshotId)
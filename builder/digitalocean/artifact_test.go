@@ -14,10 +14,34 @@ func TestArtifact_Impl(t *testing.T) {
 }
 
 func TestArtifactString(t *testing.T) {
-	a := &Artifact{"packer-foobar", 42, nil}
+	a := &Artifact{"packer-foobar", 42, nil, nil}
 	expected := "A snapshot was created: packer-foobar"
 
 	if a.String() != expected {
 		t.Fatalf("artifact string should match: %v", expected)
 	}
 }
+
+func TestArtifactState(t *testing.T) {
+	a := &Artifact{"packer-foobar", 42, nil, map[string]string{"1.2.3.4:22": "ssh-rsa AAAA"}}
+
+	if a.State("snapshot_id") != uint(42) {
+		t.Fatalf("bad: %#v", a.State("snapshot_id"))
+	}
+
+	if a.State("snapshot_name") != "packer-foobar" {
+		t.Fatalf("bad: %#v", a.State("snapshot_name"))
+	}
+
+	hostKeys, ok := a.State("ssh_host_keys").(map[string]string)
+	if !ok || hostKeys["1.2.3.4:22"] != "ssh-rsa AAAA" {
+		t.Fatalf("bad: %#v", a.State("ssh_host_keys"))
+	}
+
+	// Without host keys, they aren't part of the state
+	a = &Artifact{"packer-foobar", 42, nil, nil}
+	keys := a.State(packer.ArtifactStateKeysKey).([]string)
+	if len(keys) != 2 || a.State("ssh_host_keys") != nil {
+		t.Fatalf("bad: %#v %#v", keys, a.State("ssh_host_keys"))
+	}
+}
@@ -21,7 +21,8 @@ const BuilderId = "pearkes.digitalocean"
 // to use while communicating with DO and describes the image
 // you are creating
 type config struct {
-	common.PackerConfig `mapstructure:",squash"`
+	common.PackerConfig     `mapstructure:",squash"`
+	common.SSHConnectConfig `mapstructure:",squash"`
 
 	ClientID string `mapstructure:"client_id"`
 	APIKey   string `mapstructure:"api_key"`
@@ -63,6 +64,7 @@ func (b *Builder) Prepare(raws ...interface{}) error {
 
 	// Accumulate any errors
 	errs := common.CheckUnusedConfig(md)
+	errs = packer.MultiErrorAppend(errs, b.config.SSHConnectConfig.Prepare(b.config.tpl)...)
 
 	// Optional configuration with defaults
 	if b.config.APIKey == "" {
@@ -173,7 +175,7 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 	client := DigitalOceanClient{}.New(b.config.ClientID, b.config.APIKey)
 
 	// Set up the state
-	state := new(multistep.BasicStateBag)
+	state := new(common.StateBag)
 	state.Put("config", b.config)
 	state.Put("client", client)
 	state.Put("hook", hook)
@@ -181,13 +183,17 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 
 	// Build the steps
 	steps := []multistep.Step{
-		new(stepCreateSSHKey),
+		&stepCreateSSHKey{
+			Debug:        b.config.PackerDebug,
+			DebugKeyPath: fmt.Sprintf("do_%s.pem", b.config.PackerBuildName),
+		},
 		new(stepCreateDroplet),
 		new(stepDropletInfo),
 		&common.StepConnectSSH{
 			SSHAddress:     sshAddress,
 			SSHConfig:      sshConfig,
 			SSHWaitTimeout: 5 * time.Minute,
+			ConnectConfig:  &b.config.SSHConnectConfig,
 		},
 		new(common.StepProvision),
 		new(stepShutdown),
@@ -197,10 +203,7 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 
 	// Run the steps
 	if b.config.PackerDebug {
-		b.runner = &multistep.DebugRunner{
-			Steps:   steps,
-			PauseFn: common.MultistepDebugFn(ui),
-		}
+		b.runner = common.MultistepDebugRunner(steps, ui)
 	} else {
 		b.runner = &multistep.BasicRunner{Steps: steps}
 	}
@@ -217,10 +220,12 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 		return nil, nil
 	}
 
+	sshHostKeys, _ := state.Get("ssh_host_keys").(map[string]string)
 	artifact := &Artifact{
 		snapshotName: state.Get("snapshot_name").(string),
 		snapshotId:   state.Get("snapshot_image_id").(uint),
 		client:       client,
+		sshHostKeys:  sshHostKeys,
 	}
 
 	return artifact, nil
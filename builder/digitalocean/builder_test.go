@@ -326,3 +326,34 @@ func TestBuilderPrepare_SnapshotName(t *testing.T) {
 	}
 
 }
+
+func TestBuilderPrepare_SSHFileTransfer(t *testing.T) {
+	var b Builder
+	config := testConfig()
+
+	// Test default
+	err := b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	if b.config.SSHFileTransfer != "scp" {
+		t.Errorf("invalid: %s", b.config.SSHFileTransfer)
+	}
+
+	// Test good
+	config["ssh_file_transfer_method"] = "sftp"
+	b = Builder{}
+	err = b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	// Test bad
+	config["ssh_file_transfer_method"] = "ftp"
+	b = Builder{}
+	err = b.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+}
@@ -10,11 +10,15 @@ import (
 	"encoding/pem"
 	"fmt"
 	"github.com/mitchellh/multistep"
+	"github.com/mitchellh/packer/common"
 	"github.com/mitchellh/packer/packer"
 	"log"
 )
 
 type stepCreateSSHKey struct {
+	Debug        bool
+	DebugKeyPath string
+
 	keyId uint
 }
 
@@ -35,7 +39,20 @@ func (s *stepCreateSSHKey) Run(state multistep.StateBag) multistep.StepAction {
 	}
 
 	// Set the private key in the statebag for later
-	state.Put("privateKey", string(pem.EncodeToMemory(&priv_blk)))
+	privateKey := string(pem.EncodeToMemory(&priv_blk))
+	state.Put("privateKey", privateKey)
+
+	// If we're in debug mode, output the private key to the working
+	// directory.
+	if s.Debug {
+		ui.Message(fmt.Sprintf("Saving key for debug purposes: %s", s.DebugKeyPath))
+		if err := common.SaveDebugKey(s.DebugKeyPath, privateKey); err != nil {
+			state.Put("error", fmt.Errorf("Error saving debug key: %s", err))
+			return multistep.ActionHalt
+		}
+
+		state.Put("ssh_debug_key_path", s.DebugKeyPath)
+	}
 
 	// Marshal the public key into SSH compatible format
 	// TODO properly handle the public key error
@@ -2,6 +2,7 @@ package openstack
 
 import (
 	"fmt"
+	"github.com/mitchellh/packer/packer"
 	"github.com/rackspace/gophercloud"
 	"log"
 )
@@ -16,6 +17,10 @@ type Artifact struct {
 
 	// OpenStack connection for performing API stuff.
 	Conn gophercloud.CloudServersProvider
+
+	// SSHHostKeys maps the SSH address of the server the image was
+	// created from to the host key it had.
+	SSHHostKeys map[string]string
 }
 
 func (a *Artifact) BuilderId() string {
@@ -35,6 +40,33 @@ func (a *Artifact) String() string {
 	return fmt.Sprintf("An image was created: %v", a.ImageId)
 }
 
+// State returns the builder specific state of the artifact. The
+// following keys are available:
+//
+//   image_id      string            - The ID of the built image
+//   ssh_host_keys map[string]string - A map of the SSH address of the
+//                                     server to its host key
+func (a *Artifact) State(name string) interface{} {
+	switch name {
+	case packer.ArtifactStateKeysKey:
+		if a.SSHHostKeys != nil {
+			return []string{"image_id", "ssh_host_keys"}
+		}
+
+		return []string{"image_id"}
+	case "image_id":
+		return a.ImageId
+	case "ssh_host_keys":
+		if a.SSHHostKeys == nil {
+			return nil
+		}
+
+		return a.SSHHostKeys
+	default:
+		return nil
+	}
+}
+
 func (a *Artifact) Destroy() error {
 	log.Printf("Destroying image: %d", a.ImageId)
 	return a.Conn.DeleteImageById(a.ImageId)
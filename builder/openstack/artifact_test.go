@@ -37,3 +37,14 @@ func TestArtifactString(t *testing.T) {
 	result := a.String()
 	assert.Equal(result, expected, "should match output")
 }
+
+func TestArtifactState(t *testing.T) {
+	assert := asserts.NewTestingAsserts(t, true)
+
+	a := &Artifact{ImageId: "b8cdf55b-c916-40bd-b190-389ec144c4ed"}
+	assert.Equal(a.State(packer.ArtifactStateKeysKey), []string{"image_id"}, "should not list host keys")
+	assert.Nil(a.State("ssh_host_keys"), "should not have host keys")
+
+	a.SSHHostKeys = map[string]string{"1.2.3.4:22": "ssh-rsa AAAA"}
+	assert.Equal(a.State(packer.ArtifactStateKeysKey), []string{"image_id", "ssh_host_keys"}, "should list host keys")
+}
@@ -4,7 +4,7 @@
 package openstack
 
 import (
-	//"fmt"
+	"fmt"
 	"github.com/mitchellh/multistep"
 	"github.com/mitchellh/packer/common"
 	"github.com/mitchellh/packer/packer"
@@ -21,6 +21,8 @@ type config struct {
 	ImageConfig         `mapstructure:",squash"`
 	RunConfig           `mapstructure:",squash"`
 
+	common.SSHConnectConfig `mapstructure:",squash"`
+
 	tpl *packer.ConfigTemplate
 }
 
@@ -46,6 +48,7 @@ func (b *Builder) Prepare(raws ...interface{}) error {
 	errs = packer.MultiErrorAppend(errs, b.config.AccessConfig.Prepare(b.config.tpl)...)
 	errs = packer.MultiErrorAppend(errs, b.config.ImageConfig.Prepare(b.config.tpl)...)
 	errs = packer.MultiErrorAppend(errs, b.config.RunConfig.Prepare(b.config.tpl)...)
+	errs = packer.MultiErrorAppend(errs, b.config.SSHConnectConfig.Prepare(b.config.tpl)...)
 
 	if errs != nil && len(errs.Errors) > 0 {
 		return errs
@@ -73,7 +76,7 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 	}
 
 	// Setup the state bag and initial state for the steps
-	state := new(multistep.BasicStateBag)
+	state := new(common.StateBag)
 	state.Put("config", b.config)
 	state.Put("csp", csp)
 	state.Put("hook", hook)
@@ -81,7 +84,10 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 
 	// Build the steps
 	steps := []multistep.Step{
-		&StepKeyPair{},
+		&StepKeyPair{
+			Debug:        b.config.PackerDebug,
+			DebugKeyPath: fmt.Sprintf("os_%s.pem", b.config.PackerBuildName),
+		},
 		&StepRunSourceServer{
 			Name:        b.config.ImageName,
 			Flavor:      b.config.Flavor,
@@ -91,6 +97,7 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 			SSHAddress:     SSHAddress(csp, b.config.SSHPort),
 			SSHConfig:      SSHConfig(b.config.SSHUsername),
 			SSHWaitTimeout: b.config.SSHTimeout(),
+			ConnectConfig:  &b.config.SSHConnectConfig,
 		},
 		&common.StepProvision{},
 		&stepCreateImage{},
@@ -98,10 +105,7 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 
 	// Run!
 	if b.config.PackerDebug {
-		b.runner = &multistep.DebugRunner{
-			Steps:   steps,
-			PauseFn: common.MultistepDebugFn(ui),
-		}
+		b.runner = common.MultistepDebugRunner(steps, ui)
 	} else {
 		b.runner = &multistep.BasicRunner{Steps: steps}
 	}
@@ -118,11 +122,14 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 		return nil, nil
 	}
 
+	sshHostKeys, _ := state.Get("ssh_host_keys").(map[string]string)
+
 	// Build the artifact and return it
 	artifact := &Artifact{
 		ImageId:        state.Get("image").(string),
 		BuilderIdValue: BuilderId,
 		Conn:           csp,
+		SSHHostKeys:    sshHostKeys,
 	}
 
 	return artifact, nil
@@ -5,12 +5,16 @@ import (
 	"encoding/hex"
 	"fmt"
 	"github.com/mitchellh/multistep"
+	"github.com/mitchellh/packer/common"
 	"github.com/mitchellh/packer/packer"
 	"github.com/rackspace/gophercloud"
 	"log"
 )
 
 type StepKeyPair struct {
+	Debug        bool
+	DebugKeyPath string
+
 	keyName string
 }
 
@@ -34,6 +38,18 @@ func (s *StepKeyPair) Run(state multistep.StateBag) multistep.StepAction {
 	state.Put("keyPair", keyName)
 	state.Put("privateKey", keyResp.PrivateKey)
 
+	// If we're in debug mode, output the private key to the working
+	// directory.
+	if s.Debug {
+		ui.Message(fmt.Sprintf("Saving key for debug purposes: %s", s.DebugKeyPath))
+		if err := common.SaveDebugKey(s.DebugKeyPath, keyResp.PrivateKey); err != nil {
+			state.Put("error", fmt.Errorf("Error saving debug key: %s", err))
+			return multistep.ActionHalt
+		}
+
+		state.Put("ssh_debug_key_path", s.DebugKeyPath)
+	}
+
 	return multistep.ActionContinue
 }
 
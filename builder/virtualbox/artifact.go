@@ -2,14 +2,19 @@ package virtualbox
 
 import (
 	"fmt"
+	"github.com/mitchellh/packer/packer"
 	"os"
 )
 
 // Artifact is the result of running the VirtualBox builder, namely a set
 // of files associated with the resulting machine.
 type Artifact struct {
-	dir string
-	f   []string
+	dir         string
+	f           []string
+	vmName      string
+	format      string
+	macAddress  string
+	sshHostKeys map[string]string
 }
 
 func (*Artifact) BuilderId() string {
@@ -28,6 +33,49 @@ func (a *Artifact) String() string {
 	return fmt.Sprintf("VM files in directory: %s", a.dir)
 }
 
+// State returns the builder specific state of the artifact. The
+// following keys are available:
+//
+//   vm_name       string            - The name of the VM in VirtualBox
+//   disk_format   string            - The format of the export, "ovf" or "ova"
+//   mac_address   string            - The MAC address of the first network
+//                                     adapter
+//   ssh_host_keys map[string]string - A map of the SSH address of the VM
+//                                     to its host key
+func (a *Artifact) State(name string) interface{} {
+	switch name {
+	case packer.ArtifactStateKeysKey:
+		keys := []string{"vm_name", "disk_format"}
+		if a.macAddress != "" {
+			keys = append(keys, "mac_address")
+		}
+
+		if a.sshHostKeys != nil {
+			keys = append(keys, "ssh_host_keys")
+		}
+
+		return keys
+	case "vm_name":
+		return a.vmName
+	case "disk_format":
+		return a.format
+	case "mac_address":
+		if a.macAddress == "" {
+			return nil
+		}
+
+		return a.macAddress
+	case "ssh_host_keys":
+		if a.sshHostKeys == nil {
+			return nil
+		}
+
+		return a.sshHostKeys
+	default:
+		return nil
+	}
+}
+
 func (a *Artifact) Destroy() error {
 	return os.RemoveAll(a.dir)
 }
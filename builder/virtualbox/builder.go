@@ -22,7 +22,9 @@ type Builder struct {
 }
 
 type config struct {
-	common.PackerConfig `mapstructure:",squash"`
+	common.CommunicatorConfig `mapstructure:",squash"`
+	common.PackerConfig       `mapstructure:",squash"`
+	common.SSHConnectConfig   `mapstructure:",squash"`
 
 	BootCommand          []string   `mapstructure:"boot_command"`
 	DiskSize             uint       `mapstructure:"disk_size"`
@@ -78,6 +80,8 @@ func (b *Builder) Prepare(raws ...interface{}) error {
 
 	// Accumulate any errors
 	errs := common.CheckUnusedConfig(md)
+	errs = packer.MultiErrorAppend(errs, b.config.CommunicatorConfig.Prepare(b.config.tpl)...)
+	errs = packer.MultiErrorAppend(errs, b.config.SSHConnectConfig.Prepare(b.config.tpl)...)
 
 	if b.config.DiskSize == 0 {
 		b.config.DiskSize = 40000
@@ -310,7 +314,7 @@ func (b *Builder) Prepare(raws ...interface{}) error {
 			errs, errors.New("ssh_host_port_min must be less than ssh_host_port_max"))
 	}
 
-	if b.config.SSHUser == "" {
+	if b.config.SSHUser == "" && !b.config.UseWinRM() {
 		errs = packer.MultiErrorAppend(
 			errs, errors.New("An ssh_username must be specified."))
 	}
@@ -344,6 +348,20 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 		return nil, fmt.Errorf("Failed creating VirtualBox driver: %s", err)
 	}
 
+	var connectStep multistep.Step = &common.StepConnectSSH{
+		SSHAddress:     sshAddress,
+		SSHConfig:      sshConfig,
+		SSHWaitTimeout: b.config.sshWaitTimeout,
+		ConnectConfig:  &b.config.SSHConnectConfig,
+	}
+
+	if b.config.UseWinRM() {
+		connectStep = &common.StepConnectWinRM{
+			WinRMAddress: sshAddress,
+			Config:       &b.config.CommunicatorConfig,
+		}
+	}
+
 	steps := []multistep.Step{
 		new(stepDownloadGuestAdditions),
 		&common.StepDownload{
@@ -368,11 +386,7 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 		new(stepVBoxManage),
 		new(stepRun),
 		new(stepTypeBootCommand),
-		&common.StepConnectSSH{
-			SSHAddress:     sshAddress,
-			SSHConfig:      sshConfig,
-			SSHWaitTimeout: b.config.sshWaitTimeout,
-		},
+		connectStep,
 		new(stepUploadVersion),
 		new(stepUploadGuestAdditions),
 		new(common.StepProvision),
@@ -381,19 +395,21 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 	}
 
 	// Setup the state bag
-	state := new(multistep.BasicStateBag)
+	state := new(common.StateBag)
 	state.Put("cache", cache)
 	state.Put("config", &b.config)
 	state.Put("driver", driver)
 	state.Put("hook", hook)
 	state.Put("ui", ui)
 
+	// The debugger shows the key to connect to the machine with
+	if b.config.SSHKeyPath != "" {
+		state.Put("ssh_debug_key_path", b.config.SSHKeyPath)
+	}
+
 	// Run
 	if b.config.PackerDebug {
-		b.runner = &multistep.DebugRunner{
-			Steps:   steps,
-			PauseFn: common.MultistepDebugFn(ui),
-		}
+		b.runner = common.MultistepDebugRunner(steps, ui)
 	} else {
 		b.runner = &multistep.BasicRunner{Steps: steps}
 	}
@@ -407,7 +423,7 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 
 	// If we were interrupted or cancelled, then just exit.
 	if _, ok := state.GetOk(multistep.StateCancelled); ok {
-		return nil, errors.New("Build was cancelled.")
+		return nil, &packer.CancelledError{"Build was cancelled."}
 	}
 
 	if _, ok := state.GetOk(multistep.StateHalted); ok {
@@ -428,9 +444,17 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 		return nil, err
 	}
 
+	// There are no host keys when connecting with WinRM
+	sshHostKeys, _ := state.Get("ssh_host_keys").(map[string]string)
+	macAddress, _ := state.Get("macAddress").(string)
+
 	artifact := &Artifact{
-		dir: b.config.OutputDir,
-		f:   files,
+		dir:         b.config.OutputDir,
+		f:           files,
+		vmName:      state.Get("vmName").(string),
+		format:      b.config.Format,
+		macAddress:  macAddress,
+		sshHostKeys: sshHostKeys,
 	}
 
 	return artifact, nil
@@ -16,6 +16,10 @@ type Driver interface {
 	// Checks if the VM with the given name is running.
 	IsRunning(string) (bool, error)
 
+	// MacAddress returns the MAC address of the first network adapter
+	// of the VM with the given name.
+	MacAddress(string) (string, error)
+
 	// Stop stops a running machine, forcefully.
 	Stop(string) error
 
@@ -73,6 +77,28 @@ func (d *VBox42Driver) IsRunning(name string) (bool, error) {
 	return false, nil
 }
 
+func (d *VBox42Driver) MacAddress(name string) (string, error) {
+	var stdout bytes.Buffer
+
+	cmd := exec.Command(d.VBoxManagePath, "showvminfo", name, "--machinereadable")
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+
+	macRe := regexp.MustCompile(`^macaddress1="(.+?)"`)
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		// Need to trim off CR character when running in windows
+		line = strings.TrimRight(line, "\r")
+
+		if matches := macRe.FindStringSubmatch(line); matches != nil {
+			return matches[1], nil
+		}
+	}
+
+	return "", fmt.Errorf("No MAC address found for VM: %s", name)
+}
+
 func (d *VBox42Driver) Stop(name string) error {
 	if err := d.VBoxManage("controlvm", name, "poweroff"); err != nil {
 		return err
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/mitchellh/multistep"
 	"github.com/mitchellh/packer/packer"
+	"log"
 	"path/filepath"
 )
 
@@ -13,6 +14,8 @@ import (
 //
 // Produces:
 //   exportPath string - The path to the resulting export.
+//   macAddress string - The MAC address of the first network adapter, if
+//     it could be read.
 type stepExport struct{}
 
 func (s *stepExport) Run(state multistep.StateBag) multistep.StepAction {
@@ -49,6 +52,16 @@ func (s *stepExport) Run(state multistep.StateBag) multistep.StepAction {
 
 	}
 
+	// Remember the MAC address so that it can be part of the artifact. It
+	// is only metadata, so the export goes on without it.
+	mac, err := driver.MacAddress(vmName)
+	if err != nil {
+		log.Printf("Error reading MAC address of virtual machine: %s", err)
+		ui.Message("Couldn't read the MAC address, it won't be part of the artifact.")
+	} else {
+		state.Put("macAddress", mac)
+	}
+
 	// Export the VM to an OVF
 	outputPath := filepath.Join(config.OutputDir, vmName+"."+config.Format)
 
@@ -60,7 +73,7 @@ func (s *stepExport) Run(state multistep.StateBag) multistep.StepAction {
 	}
 
 	ui.Say("Exporting virtual machine...")
-	err := driver.VBoxManage(command...)
+	err = driver.VBoxManage(command...)
 	if err != nil {
 		err := fmt.Errorf("Error exporting virtual machine: %s", err)
 		state.Put("error", err)
@@ -9,8 +9,8 @@ import (
 	"net"
 )
 
-// This step adds a NAT port forwarding definition so that SSH is available
-// on the guest machine.
+// This step adds a NAT port forwarding definition so that SSH, or WinRM
+// if that is the communicator, is available on the guest machine.
 //
 // Uses:
 //
@@ -36,12 +36,17 @@ func (s *stepForwardSSH) Run(state multistep.StateBag) multistep.StepAction {
 		}
 	}
 
+	name, guestPort := "SSH", config.SSHPort
+	if config.UseWinRM() {
+		name, guestPort = "WinRM", config.WinRMPort
+	}
+
 	// Create a forwarded port mapping to the VM
-	ui.Say(fmt.Sprintf("Creating forwarded port mapping for SSH (host port %d)", sshHostPort))
+	ui.Say(fmt.Sprintf("Creating forwarded port mapping for %s (host port %d)", name, sshHostPort))
 	command := []string{
 		"modifyvm", vmName,
 		"--natpf1",
-		fmt.Sprintf("packerssh,tcp,127.0.0.1,%d,,%d", sshHostPort, config.SSHPort),
+		fmt.Sprintf("packerssh,tcp,127.0.0.1,%d,,%d", sshHostPort, guestPort),
 	}
 	if err := driver.VBoxManage(command...); err != nil {
 		err := fmt.Errorf("Error creating port forwarding rule: %s", err)
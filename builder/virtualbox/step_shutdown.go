@@ -1,7 +1,6 @@
 package virtualbox
 
 import (
-	"errors"
 	"fmt"
 	"github.com/mitchellh/multistep"
 	"github.com/mitchellh/packer/packer"
@@ -52,7 +51,7 @@ func (s *stepShutdown) Run(state multistep.StateBag) multistep.StepAction {
 
 			select {
 			case <-shutdownTimer:
-				err := errors.New("Timeout while waiting for machine to shut down.")
+				err := &packer.TimeoutError{"Timeout while waiting for machine to shut down."}
 				state.Put("error", err)
 				ui.Error(err.Error())
 				return multistep.ActionHalt
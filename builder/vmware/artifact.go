@@ -2,14 +2,17 @@ package vmware
 
 import (
 	"fmt"
+	"github.com/mitchellh/packer/packer"
 	"os"
 )
 
 // Artifact is the result of running the VMware builder, namely a set
 // of files associated with the resulting machine.
 type Artifact struct {
-	dir string
-	f   []string
+	dir         string
+	f           []string
+	vmName      string
+	sshHostKeys map[string]string
 }
 
 func (*Artifact) BuilderId() string {
@@ -28,6 +31,36 @@ func (a *Artifact) String() string {
 	return fmt.Sprintf("VM files in directory: %s", a.dir)
 }
 
+// State returns the builder specific state of the artifact. The
+// following keys are available:
+//
+//   vm_name       string            - The name of the VM
+//   disk_format   string            - The format of the disk, always "vmdk"
+//   ssh_host_keys map[string]string - A map of the SSH address of the VM
+//                                     to its host key
+func (a *Artifact) State(name string) interface{} {
+	switch name {
+	case packer.ArtifactStateKeysKey:
+		if a.sshHostKeys != nil {
+			return []string{"vm_name", "disk_format", "ssh_host_keys"}
+		}
+
+		return []string{"vm_name", "disk_format"}
+	case "vm_name":
+		return a.vmName
+	case "disk_format":
+		return "vmdk"
+	case "ssh_host_keys":
+		if a.sshHostKeys == nil {
+			return nil
+		}
+
+		return a.sshHostKeys
+	default:
+		return nil
+	}
+}
+
 func (a *Artifact) Destroy() error {
 	return os.RemoveAll(a.dir)
 }
@@ -12,3 +12,22 @@ func TestArtifact_Impl(t *testing.T) {
 		t.Fatal("Artifact must be a proper artifact")
 	}
 }
+
+func TestArtifactState(t *testing.T) {
+	a := &Artifact{vmName: "foo"}
+	if a.State("vm_name") != "foo" {
+		t.Fatalf("bad: %#v", a.State("vm_name"))
+	}
+
+	// Without host keys, they aren't part of the state
+	keys := a.State(packer.ArtifactStateKeysKey).([]string)
+	if len(keys) != 2 || a.State("ssh_host_keys") != nil {
+		t.Fatalf("bad: %#v %#v", keys, a.State("ssh_host_keys"))
+	}
+
+	a.sshHostKeys = map[string]string{"1.2.3.4:22": "ssh-rsa AAAA"}
+	keys = a.State(packer.ArtifactStateKeysKey).([]string)
+	if len(keys) != 3 || a.State("ssh_host_keys") == nil {
+		t.Fatalf("bad: %#v %#v", keys, a.State("ssh_host_keys"))
+	}
+}
@@ -24,7 +24,9 @@ type Builder struct {
 }
 
 type config struct {
-	common.PackerConfig `mapstructure:",squash"`
+	common.CommunicatorConfig `mapstructure:",squash"`
+	common.PackerConfig       `mapstructure:",squash"`
+	common.SSHConnectConfig   `mapstructure:",squash"`
 
 	DiskName          string            `mapstructure:"vmdk_name"`
 	DiskSize          uint              `mapstructure:"disk_size"`
@@ -80,6 +82,8 @@ func (b *Builder) Prepare(raws ...interface{}) error {
 
 	// Accumulate any errors
 	errs := common.CheckUnusedConfig(md)
+	errs = packer.MultiErrorAppend(errs, b.config.CommunicatorConfig.Prepare(b.config.tpl)...)
+	errs = packer.MultiErrorAppend(errs, b.config.SSHConnectConfig.Prepare(b.config.tpl)...)
 
 	if b.config.DiskName == "" {
 		b.config.DiskName = "disk"
@@ -274,7 +278,7 @@ func (b *Builder) Prepare(raws ...interface{}) error {
 		}
 	}
 
-	if b.config.SSHUser == "" {
+	if b.config.SSHUser == "" && !b.config.UseWinRM() {
 		errs = packer.MultiErrorAppend(
 			errs, errors.New("An ssh_username must be specified."))
 	}
@@ -342,6 +346,21 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 	// Seed the random number generator
 	rand.Seed(time.Now().UTC().UnixNano())
 
+	var connectStep multistep.Step = &common.StepConnectSSH{
+		SSHAddress:     sshAddress,
+		SSHConfig:      sshConfig,
+		SSHWaitTimeout: b.config.sshWaitTimeout,
+		NoPty:          b.config.SSHSkipRequestPty,
+		ConnectConfig:  &b.config.SSHConnectConfig,
+	}
+
+	if b.config.UseWinRM() {
+		connectStep = &common.StepConnectWinRM{
+			WinRMAddress: winrmAddress,
+			Config:       &b.config.CommunicatorConfig,
+		}
+	}
+
 	steps := []multistep.Step{
 		&stepPrepareTools{},
 		&common.StepDownload{
@@ -361,12 +380,7 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 		&stepConfigureVNC{},
 		&stepRun{},
 		&stepTypeBootCommand{},
-		&common.StepConnectSSH{
-			SSHAddress:     sshAddress,
-			SSHConfig:      sshConfig,
-			SSHWaitTimeout: b.config.sshWaitTimeout,
-			NoPty:          b.config.SSHSkipRequestPty,
-		},
+		connectStep,
 		&stepUploadTools{},
 		&common.StepProvision{},
 		&stepShutdown{},
@@ -376,19 +390,21 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 	}
 
 	// Setup the state bag
-	state := new(multistep.BasicStateBag)
+	state := new(common.StateBag)
 	state.Put("cache", cache)
 	state.Put("config", &b.config)
 	state.Put("driver", driver)
 	state.Put("hook", hook)
 	state.Put("ui", ui)
 
+	// The debugger shows the key to connect to the machine with
+	if b.config.SSHKeyPath != "" {
+		state.Put("ssh_debug_key_path", b.config.SSHKeyPath)
+	}
+
 	// Run!
 	if b.config.PackerDebug {
-		b.runner = &multistep.DebugRunner{
-			Steps:   steps,
-			PauseFn: common.MultistepDebugFn(ui),
-		}
+		b.runner = common.MultistepDebugRunner(steps, ui)
 	} else {
 		b.runner = &multistep.BasicRunner{Steps: steps}
 	}
@@ -402,7 +418,7 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 
 	// If we were interrupted or cancelled, then just exit.
 	if _, ok := state.GetOk(multistep.StateCancelled); ok {
-		return nil, errors.New("Build was cancelled.")
+		return nil, &packer.CancelledError{"Build was cancelled."}
 	}
 
 	if _, ok := state.GetOk(multistep.StateHalted); ok {
@@ -427,7 +443,17 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 		return nil, err
 	}
 
-	return &Artifact{b.config.OutputDir, files}, nil
+	// There are no host keys when connecting with WinRM
+	sshHostKeys, _ := state.Get("ssh_host_keys").(map[string]string)
+
+	artifact := &Artifact{
+		dir:         b.config.OutputDir,
+		f:           files,
+		vmName:      b.config.VMName,
+		sshHostKeys: sshHostKeys,
+	}
+
+	return artifact, nil
 }
 
 func (b *Builder) Cancel() {
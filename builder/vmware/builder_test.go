@@ -169,6 +169,31 @@ func TestBuilderPrepare_Defaults(t *testing.T) {
 	}
 }
 
+func TestBuilderPrepare_Communicator(t *testing.T) {
+	var b Builder
+	config := testConfig()
+
+	config["communicator"] = "winrm"
+	b = Builder{}
+	err := b.Prepare(config)
+	if err == nil {
+		t.Fatal("should require a winrm_username")
+	}
+
+	// An ssh_username isn't needed with WinRM
+	delete(config, "ssh_username")
+	config["winrm_username"] = "Administrator"
+	b = Builder{}
+	err = b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	if !b.config.UseWinRM() {
+		t.Fatal("should use WinRM")
+	}
+}
+
 func TestBuilderPrepare_DiskSize(t *testing.T) {
 	var b Builder
 	config := testConfig()
@@ -614,3 +639,34 @@ func TestBuilderPrepare_VMXData(t *testing.T) {
 		t.Fatal("should have two items in VMXData")
 	}
 }
+
+func TestBuilderPrepare_SSHFileTransfer(t *testing.T) {
+	var b Builder
+	config := testConfig()
+
+	// Test default
+	err := b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	if b.config.SSHFileTransfer != "scp" {
+		t.Errorf("invalid: %s", b.config.SSHFileTransfer)
+	}
+
+	// Test good
+	config["ssh_file_transfer_method"] = "sftp"
+	b = Builder{}
+	err = b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	// Test bad
+	config["ssh_file_transfer_method"] = "ftp"
+	b = Builder{}
+	err = b.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+}
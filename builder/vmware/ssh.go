@@ -13,6 +13,18 @@ import (
 
 func sshAddress(state multistep.StateBag) (string, error) {
 	config := state.Get("config").(*config)
+
+	ipAddress, err := guestIPAddress(state)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s:%d", ipAddress, config.SSHPort), nil
+}
+
+// guestIPAddress looks up the IP address of the VM from the DHCP lease of
+// its first network adapter.
+func guestIPAddress(state multistep.StateBag) (string, error) {
 	driver := state.Get("driver").(Driver)
 	vmxPath := state.Get("vmx_path").(string)
 
@@ -56,7 +68,7 @@ func sshAddress(state multistep.StateBag) (string, error) {
 	}
 
 	log.Printf("Detected IP: %s", ipAddress)
-	return fmt.Sprintf("%s:%d", ipAddress, config.SSHPort), nil
+	return ipAddress, nil
 }
 
 func sshConfig(state multistep.StateBag) (*gossh.ClientConfig, error) {
@@ -2,7 +2,6 @@ package vmware
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
 	"github.com/mitchellh/multistep"
 	"github.com/mitchellh/packer/packer"
@@ -76,7 +75,7 @@ func (s *stepShutdown) Run(state multistep.StateBag) multistep.StepAction {
 
 			select {
 			case <-shutdownTimer:
-				err := errors.New("Timeout while waiting for machine to shut down.")
+				err := &packer.TimeoutError{"Timeout while waiting for machine to shut down."}
 				state.Put("error", err)
 				ui.Error(err.Error())
 				return multistep.ActionHalt
@@ -0,0 +1,17 @@
+package vmware
+
+import (
+	"fmt"
+	"github.com/mitchellh/multistep"
+)
+
+func winrmAddress(state multistep.StateBag) (string, error) {
+	config := state.Get("config").(*config)
+
+	ipAddress, err := guestIPAddress(state)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s:%d", ipAddress, config.WinRMPort), nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"github.com/mitchellh/packer/builder/amazon/chroot"
+	"github.com/mitchellh/packer/builder/amazon/ebs"
+	"github.com/mitchellh/packer/builder/amazon/instance"
+	"github.com/mitchellh/packer/builder/digitalocean"
+	"github.com/mitchellh/packer/builder/openstack"
+	"github.com/mitchellh/packer/builder/virtualbox"
+	"github.com/mitchellh/packer/builder/vmware"
+	"github.com/mitchellh/packer/packer"
+	shelllocalpp "github.com/mitchellh/packer/post-processor/shell-local"
+	"github.com/mitchellh/packer/post-processor/vagrant"
+	"github.com/mitchellh/packer/provisioner/ansible"
+	"github.com/mitchellh/packer/provisioner/ansible-local"
+	"github.com/mitchellh/packer/provisioner/chef-solo"
+	"github.com/mitchellh/packer/provisioner/file"
+	"github.com/mitchellh/packer/provisioner/puppet-masterless"
+	"github.com/mitchellh/packer/provisioner/salt-masterless"
+	"github.com/mitchellh/packer/provisioner/shell"
+	"github.com/mitchellh/packer/provisioner/shell-local"
+)
+
+// This is the value used in the configuration for a component to use
+// the implementation that is compiled into Packer itself rather than
+// running a plugin binary.
+const builtinPlugin = "builtin"
+
+// These are the components that are compiled into Packer and can be
+// run in-process rather than as plugins.
+var builtinBuilders = map[string]func() packer.Builder{
+	"amazon-chroot":   func() packer.Builder { return new(chroot.Builder) },
+	"amazon-ebs":      func() packer.Builder { return new(ebs.Builder) },
+	"amazon-instance": func() packer.Builder { return new(instance.Builder) },
+	"digitalocean":    func() packer.Builder { return new(digitalocean.Builder) },
+	"openstack":       func() packer.Builder { return new(openstack.Builder) },
+	"virtualbox":      func() packer.Builder { return new(virtualbox.Builder) },
+	"vmware":          func() packer.Builder { return new(vmware.Builder) },
+}
+
+var builtinPostProcessors = map[string]func() packer.PostProcessor{
+	"shell-local": func() packer.PostProcessor { return new(shelllocalpp.PostProcessor) },
+	"vagrant":     func() packer.PostProcessor { return new(vagrant.PostProcessor) },
+}
+
+var builtinProvisioners = map[string]func() packer.Provisioner{
+	"ansible":           func() packer.Provisioner { return new(ansible.Provisioner) },
+	"ansible-local":     func() packer.Provisioner { return new(ansiblelocal.Provisioner) },
+	"chef-solo":         func() packer.Provisioner { return new(chefsolo.Provisioner) },
+	"file":              func() packer.Provisioner { return new(file.Provisioner) },
+	"puppet-masterless": func() packer.Provisioner { return new(puppetmasterless.Provisioner) },
+	"salt-masterless":   func() packer.Provisioner { return new(saltmasterless.Provisioner) },
+	"shell":             func() packer.Provisioner { return new(shell.Provisioner) },
+	"shell-local":       func() packer.Provisioner { return new(shelllocal.Provisioner) },
+}
@@ -9,6 +9,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -23,12 +24,14 @@ func (Command) Help() string {
 func (c Command) Run(env packer.Environment, args []string) int {
 	var cfgDebug bool
 	var cfgForce bool
+	var cfgSaveArtifacts string
 	buildOptions := new(cmdcommon.BuildOptions)
 
 	cmdFlags := flag.NewFlagSet("build", flag.ContinueOnError)
 	cmdFlags.Usage = func() { env.Ui().Say(c.Help()) }
 	cmdFlags.BoolVar(&cfgDebug, "debug", false, "debug mode for builds")
 	cmdFlags.BoolVar(&cfgForce, "force", false, "force a build if artifacts exist")
+	cmdFlags.StringVar(&cfgSaveArtifacts, "save-artifacts", "", "directory to save builder artifacts to")
 	cmdcommon.BuildOptionFlags(cmdFlags, buildOptions)
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
@@ -108,15 +111,27 @@ func (c Command) Run(env packer.Environment, args []string) int {
 	log.Printf("Build debug mode: %v", cfgDebug)
 	log.Printf("Force build: %v", cfgForce)
 
+	if cfgSaveArtifacts != "" {
+		log.Printf("Saving artifacts to: %s", cfgSaveArtifacts)
+		if err := os.MkdirAll(cfgSaveArtifacts, 0755); err != nil {
+			env.Ui().Error(fmt.Sprintf("Error creating artifact directory: %s", err))
+			return 1
+		}
+	}
+
 	// Set the debug and force mode and prepare all the builds
 	for _, b := range builds {
 		log.Printf("Preparing build: %s", b.Name())
 		b.SetDebug(cfgDebug)
 		b.SetForce(cfgForce)
+		if cfgSaveArtifacts != "" {
+			b.SetArtifactPath(filepath.Join(cfgSaveArtifacts, b.Name()+".json"))
+		}
+
 		err := b.Prepare(userVars)
 		if err != nil {
 			env.Ui().Error(err.Error())
-			return 1
+			return exitError
 		}
 	}
 
@@ -183,7 +198,7 @@ func (c Command) Run(env packer.Environment, args []string) int {
 
 	if interrupted {
 		env.Ui().Say("Cleanly cancelled builds after being interrupted.")
-		return 1
+		return exitCancelled
 	}
 
 	if len(errors) > 0 {
@@ -198,8 +213,16 @@ func (c Command) Run(env packer.Environment, args []string) int {
 			}
 
 			ui.Machine("error", err.Error())
-
-			env.Ui().Error(fmt.Sprintf("--> %s: %s", name, err))
+			ui.Machine("error-kind", errorKind(err))
+
+			switch errorKind(err) {
+			case "cancelled":
+				env.Ui().Error(fmt.Sprintf("--> %s: Cancelled: %s", name, err))
+			case "timeout":
+				env.Ui().Error(fmt.Sprintf("--> %s: Timed out: %s", name, err))
+			default:
+				env.Ui().Error(fmt.Sprintf("--> %s: %s", name, err))
+			}
 		}
 	}
 
@@ -252,13 +275,74 @@ func (c Command) Run(env packer.Environment, args []string) int {
 	}
 
 	if len(errors) > 0 {
-		// If any errors occurred, exit with a non-zero exit status
-		return 1
+		// If any errors occurred, exit with a non-zero exit status. If
+		// all the builds failed the same way, the status says how.
+		kind := ""
+		for _, err := range errors {
+			if kind == "" {
+				kind = errorKind(err)
+			} else if kind != errorKind(err) {
+				kind = "error"
+			}
+		}
+
+		return exitStatus(kind)
 	}
 
 	return 0
 }
 
+// The exit statuses of the build command for each kind of error.
+const (
+	exitError     = 1
+	exitCancelled = 2
+	exitTimeout   = 3
+)
+
+// errorKind returns the kind of the given error: "cancelled", "timeout",
+// "validation" or "error" for any other error. A MultiError has the kind
+// of its errors if they are all of the same kind.
+func errorKind(err error) string {
+	switch err := err.(type) {
+	case *packer.CancelledError:
+		return "cancelled"
+	case *packer.TimeoutError:
+		return "timeout"
+	case *packer.ValidationError:
+		return "validation"
+	case *packer.MultiError:
+		kind := ""
+		for _, child := range err.Errors {
+			childKind := errorKind(child)
+			if kind != "" && kind != childKind {
+				return "error"
+			}
+
+			kind = childKind
+		}
+
+		if kind == "" {
+			return "error"
+		}
+
+		return kind
+	default:
+		return "error"
+	}
+}
+
+// exitStatus returns the exit status for the given kind of error.
+func exitStatus(kind string) int {
+	switch kind {
+	case "cancelled":
+		return exitCancelled
+	case "timeout":
+		return exitTimeout
+	default:
+		return exitError
+	}
+}
+
 func (Command) Synopsis() string {
 	return "build image(s) from template"
 }
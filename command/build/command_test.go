@@ -3,6 +3,7 @@ package build
 import (
 	"bytes"
 	"cgl.tideland.biz/asserts"
+	"errors"
 	"github.com/mitchellh/packer/packer"
 	"testing"
 )
@@ -53,3 +54,45 @@ func TestCommand_Run_MissingFile(t *testing.T) {
 	result := command.Run(testEnvironment(), args)
 	assert.Equal(result, 1, "a non-existent file should error")
 }
+
+func TestErrorKind(t *testing.T) {
+	cases := []struct {
+		err      error
+		expected string
+	}{
+		{errors.New("foo"), "error"},
+		{&packer.CancelledError{Message: "foo"}, "cancelled"},
+		{&packer.TimeoutError{Message: "foo"}, "timeout"},
+		{&packer.ValidationError{Key: "foo", Message: "bar"}, "validation"},
+		{&packer.MultiError{}, "error"},
+		{&packer.MultiError{Errors: []error{
+			&packer.ValidationError{Key: "foo", Message: "bar"},
+			&packer.MultiError{Errors: []error{&packer.ValidationError{Key: "baz", Message: "bar"}}},
+		}}, "validation"},
+		{&packer.MultiError{Errors: []error{
+			&packer.ValidationError{Key: "foo", Message: "bar"},
+			&packer.TimeoutError{Message: "foo"},
+		}}, "error"},
+	}
+
+	for _, tc := range cases {
+		if actual := errorKind(tc.err); actual != tc.expected {
+			t.Fatalf("bad kind for %#v: %s", tc.err, actual)
+		}
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	cases := map[string]int{
+		"error":      exitError,
+		"cancelled":  exitCancelled,
+		"timeout":    exitTimeout,
+		"validation": exitError,
+	}
+
+	for kind, expected := range cases {
+		if actual := exitStatus(kind); actual != expected {
+			t.Fatalf("bad status for %s: %d", kind, actual)
+		}
+	}
+}
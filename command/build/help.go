@@ -11,6 +11,7 @@ Options:
   -debug                     Debug mode enabled for builds
   -force                     Force a build to continue if artifacts exist, deletes existing artifacts
   -machine-readable          Machine-readable output
+  -save-artifacts=path       Save builder artifacts to this directory for post-process
   -except=foo,bar,baz        Build all builds other than these
   -only=foo,bar,baz          Only build the given builds by name
   -var 'key=value'           Variable for templates, can be used multiple times.
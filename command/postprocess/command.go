@@ -0,0 +1,152 @@
+package postprocess
+
+import (
+	"flag"
+	"fmt"
+	cmdcommon "github.com/mitchellh/packer/common/command"
+	"github.com/mitchellh/packer/packer"
+	"log"
+	"strings"
+)
+
+type Command byte
+
+func (Command) Help() string {
+	return strings.TrimSpace(helpString)
+}
+
+func (c Command) Run(env packer.Environment, args []string) int {
+	buildOptions := new(cmdcommon.BuildOptions)
+
+	cmdFlags := flag.NewFlagSet("post-process", flag.ContinueOnError)
+	cmdFlags.Usage = func() { env.Ui().Say(c.Help()) }
+	cmdcommon.BuildOptionFlags(cmdFlags, buildOptions)
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	args = cmdFlags.Args()
+	if len(args) < 2 {
+		cmdFlags.Usage()
+		return 1
+	}
+
+	if err := buildOptions.Validate(); err != nil {
+		env.Ui().Error(err.Error())
+		env.Ui().Error("")
+		env.Ui().Error(c.Help())
+		return 1
+	}
+
+	userVars, err := buildOptions.AllUserVars()
+	if err != nil {
+		env.Ui().Error(fmt.Sprintf("Error compiling user variables: %s", err))
+		env.Ui().Error("")
+		env.Ui().Error(c.Help())
+		return 1
+	}
+
+	log.Printf("Reading template: %s", args[0])
+	tpl, err := packer.ParseTemplateFile(args[0])
+	if err != nil {
+		env.Ui().Error(fmt.Sprintf("Failed to parse template: %s", err))
+		return 1
+	}
+
+	// The component finder for our builds
+	components := &packer.ComponentFinder{
+		Builder:       env.Builder,
+		Hook:          env.Hook,
+		PostProcessor: env.PostProcessor,
+		Provisioner:   env.Provisioner,
+	}
+
+	// Load all the artifacts and prepare the builds that created them
+	// before running anything, so that we fail early.
+	saved := make([]*packer.SavedArtifact, 0, len(args)-1)
+	builds := make([]packer.Build, 0, len(args)-1)
+	for _, path := range args[1:] {
+		log.Printf("Loading artifact: %s", path)
+		artifact, err := packer.LoadArtifact(path)
+		if err != nil {
+			env.Ui().Error(fmt.Sprintf("Failed to load artifact '%s': %s", path, err))
+			return 1
+		}
+
+		if !buildSelected(buildOptions, artifact.BuildName) {
+			log.Printf("Skipping artifact of build '%s': %s", artifact.BuildName, path)
+			continue
+		}
+
+		b, err := tpl.Build(artifact.BuildName, components)
+		if err != nil {
+			env.Ui().Error(fmt.Sprintf(
+				"Failed to find build '%s' for artifact '%s': %s",
+				artifact.BuildName, path, err))
+			return 1
+		}
+
+		log.Printf("Preparing build: %s", b.Name())
+		if err := b.Prepare(userVars); err != nil {
+			env.Ui().Error(err.Error())
+			return 1
+		}
+
+		saved = append(saved, artifact)
+		builds = append(builds, b)
+	}
+
+	errors := 0
+	for i, b := range builds {
+		ui := &packer.TargettedUi{
+			Target: b.Name(),
+			Ui:     env.Ui(),
+		}
+
+		// The build targets its own output, so it gets the plain UI
+		artifacts, err := b.PostProcess(env.Ui(), saved[i])
+		if err != nil {
+			ui.Error(fmt.Sprintf("Post-processing errored: %s", err))
+			errors++
+			continue
+		}
+
+		for _, artifact := range artifacts {
+			if artifact != nil {
+				ui.Say(fmt.Sprintf("Artifact: %s", artifact.String()))
+			}
+		}
+	}
+
+	if errors > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// buildSelected returns true if the build with the given name was
+// selected with the -only and -except flags.
+func buildSelected(opts *cmdcommon.BuildOptions, name string) bool {
+	if len(opts.Only) > 0 {
+		for _, n := range opts.Only {
+			if n == name {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	for _, n := range opts.Except {
+		if n == name {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (Command) Synopsis() string {
+	return "run post-processors against saved artifacts"
+}
@@ -0,0 +1,74 @@
+package postprocess
+
+import (
+	"bytes"
+	"cgl.tideland.biz/asserts"
+	cmdcommon "github.com/mitchellh/packer/common/command"
+	"github.com/mitchellh/packer/packer"
+	"testing"
+)
+
+func testEnvironment() packer.Environment {
+	config := packer.DefaultEnvironmentConfig()
+	config.Ui = &packer.BasicUi{
+		Reader: new(bytes.Buffer),
+		Writer: new(bytes.Buffer),
+	}
+
+	env, err := packer.NewEnvironment(config)
+	if err != nil {
+		panic(err)
+	}
+
+	return env
+}
+
+func TestCommand_Implements(t *testing.T) {
+	assert := asserts.NewTestingAsserts(t, true)
+
+	var actual packer.Command
+	assert.Implementor(new(Command), &actual, "should be a Command")
+}
+
+func TestCommand_Run_NoArgs(t *testing.T) {
+	assert := asserts.NewTestingAsserts(t, true)
+	command := new(Command)
+	result := command.Run(testEnvironment(), make([]string, 0))
+	assert.Equal(result, 1, "no args should error")
+}
+
+func TestCommand_Run_NoArtifacts(t *testing.T) {
+	assert := asserts.NewTestingAsserts(t, true)
+	command := new(Command)
+
+	args := []string{"template.json"}
+	result := command.Run(testEnvironment(), args)
+	assert.Equal(result, 1, "no artifacts should fail")
+}
+
+func TestCommand_Run_MissingFile(t *testing.T) {
+	assert := asserts.NewTestingAsserts(t, true)
+	command := new(Command)
+
+	args := []string{"i-better-not-exist", "artifact.json"}
+	result := command.Run(testEnvironment(), args)
+	assert.Equal(result, 1, "a non-existent file should error")
+}
+
+func TestBuildSelected(t *testing.T) {
+	opts := new(cmdcommon.BuildOptions)
+	if !buildSelected(opts, "foo") {
+		t.Fatal("should be selected with no options")
+	}
+
+	opts.Only = []string{"foo"}
+	if !buildSelected(opts, "foo") || buildSelected(opts, "bar") {
+		t.Fatal("only should select only foo")
+	}
+
+	opts.Only = nil
+	opts.Except = []string{"foo"}
+	if buildSelected(opts, "foo") || !buildSelected(opts, "bar") {
+		t.Fatal("except should select everything but foo")
+	}
+}
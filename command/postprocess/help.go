@@ -0,0 +1,22 @@
+package postprocess
+
+const helpString = `
+Usage: packer post-process [options] TEMPLATE ARTIFACT...
+
+  Runs the post-processors of a template against artifacts that were
+  saved by an earlier run of "packer build -save-artifacts", without
+  running the builders again. Each artifact is run through the
+  post-processors of the build that created it.
+
+  When a post-processor fails during "packer build -save-artifacts", the
+  artifact of the builder is kept rather than destroyed, so that it can be
+  post-processed again with this command. The saved artifacts themselves are never
+  destroyed by this command, even if a post-processor fails.
+
+Options:
+
+  -except=foo,bar,baz    Post-process artifacts of all builds other than these
+  -only=foo,bar,baz      Only post-process artifacts of the given builds
+  -var 'key=value'       Variable for templates, can be used multiple times.
+  -var-file=path         JSON file containing user variables.
+`
@@ -0,0 +1,112 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mitchellh/packer/communicator/winrm"
+	"github.com/mitchellh/packer/packer"
+	"time"
+)
+
+// CommunicatorConfig contains the configuration keys shared by the
+// builders that choose how packer communicates with the machine, which is
+// either SSH or WinRM. Embed this structure into your configuration class
+// to get it.
+type CommunicatorConfig struct {
+	Communicator        string `mapstructure:"communicator"`
+	WinRMUser           string `mapstructure:"winrm_username"`
+	WinRMPassword       string `mapstructure:"winrm_password"`
+	WinRMPort           uint   `mapstructure:"winrm_port"`
+	WinRMUseSSL         bool   `mapstructure:"winrm_use_ssl"`
+	WinRMInsecure       bool   `mapstructure:"winrm_insecure"`
+	WinRMUseNTLM        bool   `mapstructure:"winrm_use_ntlm"`
+	RawWinRMWaitTimeout string `mapstructure:"winrm_wait_timeout"`
+
+	winrmWaitTimeout time.Duration
+}
+
+func (c *CommunicatorConfig) Prepare(t *packer.ConfigTemplate) []error {
+	if t == nil {
+		var err error
+		t, err = packer.NewConfigTemplate()
+		if err != nil {
+			return []error{err}
+		}
+	}
+
+	if c.Communicator == "" {
+		c.Communicator = "ssh"
+	}
+
+	if c.WinRMPort == 0 {
+		c.WinRMPort = 5985
+		if c.WinRMUseSSL {
+			c.WinRMPort = 5986
+		}
+	}
+
+	if c.RawWinRMWaitTimeout == "" {
+		c.RawWinRMWaitTimeout = "20m"
+	}
+
+	errs := make([]error, 0)
+	templates := map[string]*string{
+		"communicator":       &c.Communicator,
+		"winrm_username":     &c.WinRMUser,
+		"winrm_password":     &c.WinRMPassword,
+		"winrm_wait_timeout": &c.RawWinRMWaitTimeout,
+	}
+
+	for n, ptr := range templates {
+		var err error
+		*ptr, err = t.Process(*ptr, nil)
+		if err != nil {
+			errs = append(
+				errs, fmt.Errorf("Error processing %s: %s", n, err))
+		}
+	}
+
+	switch c.Communicator {
+	case "ssh":
+	case "winrm":
+		if c.WinRMUser == "" {
+			errs = append(errs, errors.New(
+				"A winrm_username must be specified with the winrm communicator"))
+		}
+	default:
+		errs = append(errs, errors.New("communicator must be one of: ssh, winrm"))
+	}
+
+	var err error
+	c.winrmWaitTimeout, err = time.ParseDuration(c.RawWinRMWaitTimeout)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("Failed parsing winrm_wait_timeout: %s", err))
+	}
+
+	return errs
+}
+
+// UseWinRM returns true if packer communicates with the machine over
+// WinRM rather than SSH.
+func (c *CommunicatorConfig) UseWinRM() bool {
+	return c.Communicator == "winrm"
+}
+
+// WinRMWaitTimeout returns the total time to wait for WinRM to become
+// available.
+func (c *CommunicatorConfig) WinRMWaitTimeout() time.Duration {
+	return c.winrmWaitTimeout
+}
+
+// WinRMConfig returns the configuration of the WinRM communicator for
+// the service at the given address.
+func (c *CommunicatorConfig) WinRMConfig(address string) *winrm.Config {
+	return &winrm.Config{
+		Host:     address,
+		Username: c.WinRMUser,
+		Password: c.WinRMPassword,
+		UseSSL:   c.WinRMUseSSL,
+		Insecure: c.WinRMInsecure,
+		UseNTLM:  c.WinRMUseNTLM,
+	}
+}
@@ -0,0 +1,65 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCommunicatorConfigPrepare(t *testing.T) {
+	c := new(CommunicatorConfig)
+	if errs := c.Prepare(nil); len(errs) > 0 {
+		t.Fatalf("err: %#v", errs)
+	}
+
+	if c.Communicator != "ssh" || c.UseWinRM() {
+		t.Fatalf("bad: %s", c.Communicator)
+	}
+
+	if c.WinRMPort != 5985 {
+		t.Fatalf("bad: %d", c.WinRMPort)
+	}
+
+	if c.WinRMWaitTimeout() != 20*time.Minute {
+		t.Fatalf("bad: %s", c.WinRMWaitTimeout())
+	}
+
+	c = &CommunicatorConfig{Communicator: "telnet"}
+	if errs := c.Prepare(nil); len(errs) != 1 {
+		t.Fatalf("should error: %#v", errs)
+	}
+
+	c = &CommunicatorConfig{RawWinRMWaitTimeout: "bad"}
+	if errs := c.Prepare(nil); len(errs) != 1 {
+		t.Fatalf("should error: %#v", errs)
+	}
+}
+
+func TestCommunicatorConfigPrepare_WinRM(t *testing.T) {
+	c := &CommunicatorConfig{Communicator: "winrm"}
+	if errs := c.Prepare(nil); len(errs) != 1 {
+		t.Fatalf("should require a username: %#v", errs)
+	}
+
+	c = &CommunicatorConfig{
+		Communicator:  "winrm",
+		WinRMUser:     "Administrator",
+		WinRMPassword: "vagrant",
+		WinRMUseSSL:   true,
+	}
+	if errs := c.Prepare(nil); len(errs) > 0 {
+		t.Fatalf("err: %#v", errs)
+	}
+
+	if !c.UseWinRM() {
+		t.Fatal("should use WinRM")
+	}
+
+	if c.WinRMPort != 5986 {
+		t.Fatalf("the HTTPS port should be the default: %d", c.WinRMPort)
+	}
+
+	config := c.WinRMConfig("127.0.0.1:5986")
+	if config.Host != "127.0.0.1:5986" || config.Username != "Administrator" || !config.UseSSL {
+		t.Fatalf("bad: %#v", config)
+	}
+}
@@ -0,0 +1,31 @@
+package common
+
+import (
+	"os"
+	"runtime"
+)
+
+// SaveDebugKey writes the private key of a temporary key pair to the given
+// path, readable only by the user, so that the machine can be connected
+// to while debugging a build.
+func SaveDebugKey(path string, key string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Write the key out
+	if _, err := f.Write([]byte(key)); err != nil {
+		return err
+	}
+
+	// Chmod it so that it is SSH ready
+	if runtime.GOOS != "windows" {
+		if err := f.Chmod(0600); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestSaveDebugKey(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	path := filepath.Join(td, "key.pem")
+	if err := SaveDebugKey(path, "secret"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil || string(data) != "secret" {
+		t.Fatalf("bad: %q %s", data, err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Fatalf("bad: %s", info.Mode())
+	}
+}
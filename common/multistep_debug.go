@@ -5,46 +5,318 @@ import (
 	"github.com/mitchellh/multistep"
 	"github.com/mitchellh/packer/packer"
 	"log"
+	"reflect"
+	"strings"
+	"sync"
 	"time"
 )
 
+const debugHelpText = `
+Commands available while paused:
+
+  (empty), c, continue    Continue to the next step
+  s, state                Show the keys and values in the state bag
+  ssh                     Show the SSH connection details
+  sh, shell               Run commands on the machine through the communicator
+  skip                    Skip the next step
+  abort                   Abort the build, running cleanup
+  abort-no-cleanup        Abort the build without running cleanup
+  h, help                 Show this help
+`
+
 // MultistepDebugFn will return a proper multistep.DebugPauseFn to
 // use for debugging if you're using multistep in your builder.
+//
+// The pause prompt accepts commands to inspect the state bag, show
+// the SSH connection details, run commands on the machine and abort
+// the build. Skipping steps and aborting without cleanup require the
+// steps to be wrapped, so use MultistepDebugRunner for those.
 func MultistepDebugFn(ui packer.Ui) multistep.DebugPauseFn {
-	return func(loc multistep.DebugLocation, name string, state multistep.StateBag) {
-		var locationString string
-		switch loc {
-		case multistep.DebugLocationAfterRun:
-			locationString = "after run of"
-		case multistep.DebugLocationBeforeCleanup:
-			locationString = "before cleanup of"
-		default:
-			locationString = "at"
+	d := &debugger{ui: ui}
+	return d.pause
+}
+
+// MultistepDebugRunner returns a multistep.DebugRunner for the given
+// steps that pauses between each step with the prompt described in
+// MultistepDebugFn, with support for skipping steps and aborting the
+// build without running cleanup.
+func MultistepDebugRunner(steps []multistep.Step, ui packer.Ui) *multistep.DebugRunner {
+	d := &debugger{ui: ui, wrapped: true}
+
+	wrapped := make([]multistep.Step, len(steps))
+	for i, step := range steps {
+		wrapped[i] = &debugStep{
+			Step:     step,
+			name:     reflect.Indirect(reflect.ValueOf(step)).Type().Name(),
+			debugger: d,
 		}
+	}
+
+	return &multistep.DebugRunner{
+		Steps:   wrapped,
+		PauseFn: d.pause,
+	}
+}
+
+// debugger holds the state of an interactive debugging session shared
+// between the pause function and any wrapped steps.
+type debugger struct {
+	ui packer.Ui
 
-		message := fmt.Sprintf(
-			"Pausing %s step '%s'. Press any key to continue.",
-			locationString, name)
+	// wrapped is true if the steps are wrapped in debugSteps, which
+	// is required to skip steps or abort without cleanup.
+	wrapped bool
 
-		result := make(chan string, 1)
-		go func() {
-			line, err := ui.Ask(message)
-			if err != nil {
-				log.Printf("Error asking for input: %s", err)
+	l         sync.Mutex
+	names     []string
+	skipNext  bool
+	noCleanup bool
+}
+
+func (d *debugger) pause(loc multistep.DebugLocation, name string, state multistep.StateBag) {
+	// If the steps are wrapped, the name given to us is that of the
+	// wrapper, so use the name of the step we're actually at.
+	if d.wrapped {
+		d.l.Lock()
+		if len(d.names) > 0 {
+			name = d.names[len(d.names)-1]
+		}
+		d.l.Unlock()
+	}
+
+	var locationString string
+	switch loc {
+	case multistep.DebugLocationAfterRun:
+		locationString = "after run of"
+	case multistep.DebugLocationBeforeCleanup:
+		locationString = "before cleanup of"
+	default:
+		locationString = "at"
+	}
+
+	message := fmt.Sprintf(
+		"Pausing %s step '%s'. Press enter to continue or type 'help' for commands.",
+		locationString, name)
+
+	for {
+		line, ok := d.ask(message, state)
+		if !ok {
+			return
+		}
+
+		switch strings.TrimSpace(line) {
+		case "", "c", "continue":
+			return
+		case "s", "state":
+			d.showState(state)
+		case "ssh":
+			d.showSSH(state)
+		case "sh", "shell":
+			d.shell(state)
+		case "skip":
+			if !d.wrapped {
+				d.ui.Error("Skipping steps is not supported by this builder.")
+				continue
 			}
 
-			result <- line
-		}()
-
-		for {
-			select {
-			case <-result:
-				return
-			case <-time.After(100 * time.Millisecond):
-				if _, ok := state.GetOk(multistep.StateCancelled); ok {
-					return
-				}
+			if loc != multistep.DebugLocationAfterRun {
+				d.ui.Error("Steps can only be skipped before they run.")
+				continue
+			}
+
+			d.l.Lock()
+			d.skipNext = true
+			d.l.Unlock()
+
+			d.ui.Say("The next step will be skipped.")
+			return
+		case "abort":
+			d.ui.Say("Aborting the build. Cleanup will run.")
+			state.Put(multistep.StateCancelled, true)
+			return
+		case "abort-no-cleanup":
+			if !d.wrapped {
+				d.ui.Error("Aborting without cleanup is not supported by this builder.")
+				continue
+			}
+
+			d.l.Lock()
+			d.noCleanup = true
+			d.l.Unlock()
+
+			d.ui.Say("Aborting the build. Cleanup will NOT run, so any resources " +
+				"created so far must be removed manually.")
+			state.Put(multistep.StateCancelled, true)
+			return
+		case "h", "help":
+			d.ui.Message(strings.TrimSpace(debugHelpText))
+		default:
+			d.ui.Error(fmt.Sprintf("Unknown command: %s", line))
+		}
+	}
+}
+
+// ask asks the given question, returning false if the build was
+// cancelled or aborted while waiting for the answer.
+func (d *debugger) ask(message string, state multistep.StateBag) (string, bool) {
+	if _, ok := state.GetOk(multistep.StateCancelled); ok {
+		return "", false
+	}
+
+	result := make(chan string, 1)
+	go func() {
+		line, err := d.ui.Ask(message)
+		if err != nil {
+			log.Printf("Error asking for input: %s", err)
+		}
+
+		result <- line
+	}()
+
+	for {
+		select {
+		case line := <-result:
+			return line, true
+		case <-time.After(100 * time.Millisecond):
+			if _, ok := state.GetOk(multistep.StateCancelled); ok {
+				return "", false
 			}
 		}
 	}
 }
+
+func (d *debugger) showState(state multistep.StateBag) {
+	keys := stateKeys(state)
+	if len(keys) == 0 {
+		d.ui.Message("The state bag is empty or its keys can't be listed.")
+		return
+	}
+
+	lines := make([]string, len(keys))
+	for i, key := range keys {
+		value := fmt.Sprintf("%#v", state.Get(key))
+		if len(value) > 100 {
+			value = value[:97] + "..."
+		}
+
+		lines[i] = fmt.Sprintf("%s: %s", key, value)
+	}
+
+	d.ui.Message(strings.Join(lines, "\n"))
+}
+
+func (d *debugger) showSSH(state multistep.StateBag) {
+	var lines []string
+	if address, ok := state.GetOk("ssh_address"); ok {
+		lines = append(lines, fmt.Sprintf("Address: %s", address))
+	}
+
+	if user, ok := state.GetOk("ssh_username"); ok {
+		lines = append(lines, fmt.Sprintf("Username: %s", user))
+	}
+
+	if path, ok := state.GetOk("ssh_debug_key_path"); ok {
+		lines = append(lines, fmt.Sprintf("Private key: %s", path))
+	}
+
+	if len(lines) == 0 {
+		d.ui.Message("No SSH connection has been made yet.")
+		return
+	}
+
+	d.ui.Message(strings.Join(lines, "\n"))
+}
+
+func (d *debugger) shell(state multistep.StateBag) {
+	raw, ok := state.GetOk("communicator")
+	if !ok {
+		d.ui.Error("No communicator is available yet.")
+		return
+	}
+
+	comm := raw.(packer.Communicator)
+	d.ui.Say("Commands are run on the machine one line at a time. Type 'exit' to return.")
+	for {
+		line, ok := d.ask("$", state)
+		if !ok {
+			return
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if line == "exit" {
+			return
+		}
+
+		cmd := &packer.RemoteCmd{Command: line}
+		if err := cmd.StartWithUi(comm, d.ui); err != nil {
+			d.ui.Error(fmt.Sprintf("Error running command: %s", err))
+			continue
+		}
+
+		if cmd.ExitStatus != 0 {
+			d.ui.Error(fmt.Sprintf("Exit status: %d", cmd.ExitStatus))
+		}
+	}
+}
+
+// stateKeys returns the sorted keys in the state bag, or nil if the
+// state bag can't list them. Builders use a StateBag so that it can.
+func stateKeys(state multistep.StateBag) []string {
+	lister, ok := state.(interface {
+		Keys() []string
+	})
+	if !ok {
+		return nil
+	}
+
+	return lister.Keys()
+}
+
+// debugStep wraps a step so that the debugger can skip it or prevent
+// its cleanup from running.
+type debugStep struct {
+	multistep.Step
+
+	name     string
+	debugger *debugger
+	skipped  bool
+}
+
+func (s *debugStep) Run(state multistep.StateBag) multistep.StepAction {
+	d := s.debugger
+
+	d.l.Lock()
+	d.names = append(d.names, s.name)
+	s.skipped = d.skipNext
+	d.skipNext = false
+	d.l.Unlock()
+
+	if s.skipped {
+		d.ui.Say(fmt.Sprintf("Skipping step '%s'.", s.name))
+		return multistep.ActionContinue
+	}
+
+	return s.Step.Run(state)
+}
+
+func (s *debugStep) Cleanup(state multistep.StateBag) {
+	d := s.debugger
+
+	d.l.Lock()
+	if len(d.names) > 0 {
+		d.names = d.names[:len(d.names)-1]
+	}
+	noCleanup := d.noCleanup
+	d.l.Unlock()
+
+	if s.skipped || noCleanup {
+		log.Printf("Not running cleanup for step: %s", s.name)
+		return
+	}
+
+	s.Step.Cleanup(state)
+}
@@ -0,0 +1,99 @@
+package common
+
+import (
+	"bytes"
+	"github.com/mitchellh/multistep"
+	"github.com/mitchellh/packer/packer"
+	"reflect"
+	"testing"
+)
+
+type debugTestStep struct {
+	runCalled     bool
+	cleanupCalled bool
+}
+
+func (s *debugTestStep) Run(multistep.StateBag) multistep.StepAction {
+	s.runCalled = true
+	return multistep.ActionContinue
+}
+
+func (s *debugTestStep) Cleanup(multistep.StateBag) {
+	s.cleanupCalled = true
+}
+
+func testDebugUi() *packer.BasicUi {
+	return &packer.BasicUi{
+		Reader: new(bytes.Buffer),
+		Writer: new(bytes.Buffer),
+	}
+}
+
+func TestDebugStep_Impl(t *testing.T) {
+	var raw interface{}
+	raw = new(debugStep)
+	if _, ok := raw.(multistep.Step); !ok {
+		t.Fatalf("debug step should be a step")
+	}
+}
+
+func TestDebugStep_skip(t *testing.T) {
+	d := &debugger{ui: testDebugUi(), wrapped: true, skipNext: true}
+	inner := new(debugTestStep)
+	step := &debugStep{Step: inner, name: "debugTestStep", debugger: d}
+
+	state := new(multistep.BasicStateBag)
+	if action := step.Run(state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %#v", action)
+	}
+
+	step.Cleanup(state)
+	if inner.runCalled || inner.cleanupCalled {
+		t.Fatal("skipped step should not run or clean up")
+	}
+
+	if d.skipNext {
+		t.Fatal("skip should only apply to a single step")
+	}
+}
+
+func TestDebugStep_noCleanup(t *testing.T) {
+	d := &debugger{ui: testDebugUi(), wrapped: true}
+	inner := new(debugTestStep)
+	step := &debugStep{Step: inner, name: "debugTestStep", debugger: d}
+
+	state := new(multistep.BasicStateBag)
+	step.Run(state)
+	if !inner.runCalled {
+		t.Fatal("run should be called")
+	}
+
+	d.noCleanup = true
+	step.Cleanup(state)
+	if inner.cleanupCalled {
+		t.Fatal("cleanup should not be called")
+	}
+}
+
+func TestMultistepDebugRunner_names(t *testing.T) {
+	runner := MultistepDebugRunner([]multistep.Step{new(debugTestStep)}, testDebugUi())
+	step := runner.Steps[0].(*debugStep)
+	if step.name != "debugTestStep" {
+		t.Fatalf("bad: %s", step.name)
+	}
+}
+
+func TestStateKeys(t *testing.T) {
+	if keys := stateKeys(new(multistep.BasicStateBag)); keys != nil {
+		t.Fatalf("bad: %#v", keys)
+	}
+
+	state := new(StateBag)
+	state.Put("foo", 1)
+	state.Put("bar", "baz")
+
+	expected := []string{"bar", "foo"}
+	if keys := stateKeys(state); !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("bad: %#v", keys)
+	}
+}
@@ -0,0 +1,146 @@
+// The shelllocal package contains the configuration and the running of
+// shell scripts on the machine Packer runs on, which the shell-local
+// provisioner and post-processor share.
+package shelllocal
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mitchellh/packer/packer"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Config is the configuration of the scripts to run and how to run them.
+type Config struct {
+	// An inline script to execute. Multiple strings are all executed
+	// in the context of a single shell.
+	Inline []string
+
+	// The shebang value used when running inline scripts.
+	InlineShebang string `mapstructure:"inline_shebang"`
+
+	// The local path of the shell script to execute.
+	Script string
+
+	// An array of multiple scripts to run.
+	Scripts []string
+
+	// An array of environment variables that will be injected before
+	// your command(s) are executed.
+	Vars []string `mapstructure:"environment_vars"`
+
+	// The command used to execute the script. The '{{ .Path }}' variable
+	// should be used to specify where the script is. The environment_vars
+	// are set in the environment of the command, and {{ .Vars }} holds
+	// them quoted for the shell, for commands that need them elsewhere.
+	ExecuteCommand string `mapstructure:"execute_command"`
+}
+
+func (c *Config) Prepare(t *packer.ConfigTemplate) []error {
+	if t == nil {
+		var err error
+		t, err = packer.NewConfigTemplate()
+		if err != nil {
+			return []error{err}
+		}
+	}
+
+	// Defaults
+	if c.ExecuteCommand == "" {
+		c.ExecuteCommand = "chmod +x {{.Path}}; {{.Path}}"
+	}
+
+	if c.Inline != nil && len(c.Inline) == 0 {
+		c.Inline = nil
+	}
+
+	if c.InlineShebang == "" {
+		c.InlineShebang = "/bin/sh"
+	}
+
+	errs := make([]error, 0)
+	if c.Script != "" && len(c.Scripts) > 0 {
+		errs = append(errs, errors.New("Only one of script or scripts can be specified."))
+	}
+
+	if c.Script != "" {
+		c.Scripts = []string{c.Script}
+	}
+
+	templates := map[string]*string{
+		"inline_shebang": &c.InlineShebang,
+	}
+
+	for n, ptr := range templates {
+		var err error
+		*ptr, err = t.Process(*ptr, nil)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Error processing %s: %s", n, err))
+		}
+	}
+
+	sliceTemplates := map[string][]string{
+		"inline":           c.Inline,
+		"scripts":          c.Scripts,
+		"environment_vars": c.Vars,
+	}
+
+	for n, slice := range sliceTemplates {
+		for i, elem := range slice {
+			var err error
+			slice[i], err = t.Process(elem, nil)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("Error processing %s[%d]: %s", n, i, err))
+			}
+		}
+	}
+
+	if err := t.Validate(c.ExecuteCommand); err != nil {
+		errs = append(errs, fmt.Errorf("Error parsing execute_command: %s", err))
+	}
+
+	if len(c.Scripts) == 0 && c.Inline == nil {
+		errs = append(errs, errors.New("Either a script file or inline script must be specified."))
+	} else if len(c.Scripts) > 0 && c.Inline != nil {
+		errs = append(errs, errors.New("Only a script file or an inline script can be specified, not both."))
+	}
+
+	for _, path := range c.Scripts {
+		if _, err := os.Stat(path); err != nil {
+			errs = append(errs, fmt.Errorf("Bad script '%s': %s", path, err))
+		}
+	}
+
+	// Do a check for bad environment variables, such as '=foo', 'foobar'
+	for _, kv := range c.Vars {
+		vs := strings.SplitN(kv, "=", 2)
+		if len(vs) != 2 || vs[0] == "" {
+			errs = append(errs, fmt.Errorf("Environment variable not in format 'key=value': %s", kv))
+		}
+	}
+
+	// The user variables are set as PACKER_VAR_ variables, so two names
+	// that are the same once sanitized would overwrite each other.
+	names := make([]string, 0, len(t.UserVars))
+	for name := range t.UserVars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	seen := make(map[string]string)
+	for _, name := range names {
+		env := envName(name)
+		if other, ok := seen[env]; ok {
+			errs = append(errs, fmt.Errorf(
+				"User variables '%s' and '%s' would both be set as PACKER_VAR_%s.",
+				other, name, env))
+			continue
+		}
+
+		seen[env] = name
+	}
+
+	return errs
+}
@@ -0,0 +1,112 @@
+package shelllocal
+
+import (
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func testConfig() *Config {
+	return &Config{
+		Inline: []string{"echo foo"},
+	}
+}
+
+func TestConfigPrepare_Defaults(t *testing.T) {
+	c := testConfig()
+	if err := c.Prepare(nil); len(err) > 0 {
+		t.Fatalf("err: %#v", err)
+	}
+
+	if c.ExecuteCommand != "chmod +x {{.Path}}; {{.Path}}" {
+		t.Fatalf("bad: %s", c.ExecuteCommand)
+	}
+
+	if c.InlineShebang != "/bin/sh" {
+		t.Fatalf("bad: %s", c.InlineShebang)
+	}
+}
+
+func TestConfigPrepare_InlineAndScripts(t *testing.T) {
+	tf, err := ioutil.TempFile("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	tf.Close()
+	defer os.Remove(tf.Name())
+
+	c := &Config{}
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("should have error: %#v", err)
+	}
+
+	c = testConfig()
+	c.Script = tf.Name()
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("should have error: %#v", err)
+	}
+
+	c = &Config{Script: tf.Name()}
+	if err := c.Prepare(nil); len(err) > 0 {
+		t.Fatalf("err: %#v", err)
+	}
+
+	if len(c.Scripts) != 1 || c.Scripts[0] != tf.Name() {
+		t.Fatalf("bad: %#v", c.Scripts)
+	}
+
+	c = &Config{Script: tf.Name(), Scripts: []string{tf.Name()}}
+	if err := c.Prepare(nil); len(err) == 0 {
+		t.Fatal("should have error")
+	}
+
+	c = &Config{Scripts: []string{"/i/dont/exist/i/think"}}
+	if err := c.Prepare(nil); len(err) == 0 {
+		t.Fatal("should have error")
+	}
+}
+
+func TestConfigPrepare_EnvironmentVars(t *testing.T) {
+	c := testConfig()
+	c.Vars = []string{"FOO=bar", "EMPTY="}
+	if err := c.Prepare(nil); len(err) > 0 {
+		t.Fatalf("err: %#v", err)
+	}
+
+	for _, kv := range []string{"FOO", "=bar"} {
+		c = testConfig()
+		c.Vars = []string{kv}
+		if err := c.Prepare(nil); len(err) == 0 {
+			t.Fatalf("should have error: %s", kv)
+		}
+	}
+}
+
+func TestConfigPrepare_UserVars(t *testing.T) {
+	tpl, err := packer.NewConfigTemplate()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	tpl.UserVars = map[string]string{"aws-region": "us-east-1", "version": "1"}
+	c := testConfig()
+	if err := c.Prepare(tpl); len(err) > 0 {
+		t.Fatalf("err: %#v", err)
+	}
+
+	// Both would be set as PACKER_VAR_aws_region
+	tpl.UserVars["aws_region"] = "us-west-2"
+	c = testConfig()
+	if err := c.Prepare(tpl); len(err) != 1 {
+		t.Fatalf("bad: %#v", err)
+	}
+}
+
+func TestConfigPrepare_ExecuteCommand(t *testing.T) {
+	c := testConfig()
+	c.ExecuteCommand = "{{.Path"
+	if err := c.Prepare(nil); len(err) == 0 {
+		t.Fatal("should have error")
+	}
+}
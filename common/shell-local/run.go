@@ -0,0 +1,133 @@
+package shelllocal
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/mitchellh/packer/common"
+	"github.com/mitchellh/packer/communicator/local"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+type ExecuteCommandTemplate struct {
+	Vars string
+	Path string
+}
+
+// BuildEnv returns the environment variables describing the build: its
+// name, the type of its builder and the user variables, each of which is
+// set as PACKER_VAR_ followed by its name. Characters of the name other
+// than letters, digits and underscores are replaced with underscores, so
+// that the variable can be used by the shell.
+func BuildEnv(config *common.PackerConfig) []string {
+	env := []string{
+		"PACKER_BUILD_NAME=" + config.PackerBuildName,
+		"PACKER_BUILDER_TYPE=" + config.PackerBuilderType,
+	}
+
+	names := make([]string, 0, len(config.PackerUserVars))
+	for name := range config.PackerUserVars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		env = append(env, fmt.Sprintf(
+			"PACKER_VAR_%s=%s", envName(name), config.PackerUserVars[name]))
+	}
+
+	return env
+}
+
+// envName returns the name with every character that can't be in the name
+// of a shell variable replaced with an underscore.
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+// Run runs the scripts of the configuration one after the other on the
+// local machine, with the given environment variables set as well as
+// those of the configuration. It stops at the first script that fails.
+func Run(ui packer.Ui, config *Config, tpl *packer.ConfigTemplate, env []string) error {
+	scripts := make([]string, len(config.Scripts))
+	copy(scripts, config.Scripts)
+
+	// If we have an inline script, then turn that into a temporary
+	// shell script and use that.
+	if config.Inline != nil {
+		tf, err := ioutil.TempFile("", "packer-shell")
+		if err != nil {
+			return fmt.Errorf("Error preparing shell script: %s", err)
+		}
+		defer tf.Close()
+		defer os.Remove(tf.Name())
+
+		// Set the path to the temporary file
+		scripts = append(scripts, tf.Name())
+
+		// Write our contents to it
+		writer := bufio.NewWriter(tf)
+		writer.WriteString(fmt.Sprintf("#!%s\n", config.InlineShebang))
+		for _, command := range config.Inline {
+			if _, err := writer.WriteString(command + "\n"); err != nil {
+				return fmt.Errorf("Error preparing shell script: %s", err)
+			}
+		}
+
+		if err := writer.Flush(); err != nil {
+			return fmt.Errorf("Error preparing shell script: %s", err)
+		}
+
+		tf.Close()
+	}
+
+	envVars := make([]string, 0, len(env)+len(config.Vars))
+	envVars = append(envVars, env...)
+	envVars = append(envVars, config.Vars...)
+
+	// Flatten the environment variables, quoting their values
+	quotedVars := make([]string, len(envVars))
+	for i, kv := range envVars {
+		vs := strings.SplitN(kv, "=", 2)
+		quotedVars[i] = vs[0] + "=" + packer.ShellQuote(vs[1])
+	}
+	flattendVars := strings.Join(quotedVars, " ")
+
+	comm := new(local.Communicator)
+	for _, path := range scripts {
+		ui.Say(fmt.Sprintf("Running local shell script: %s", path))
+
+		command, err := tpl.Process(config.ExecuteCommand, &ExecuteCommandTemplate{
+			Vars: flattendVars,
+			Path: packer.ShellQuote(path),
+		})
+		if err != nil {
+			return fmt.Errorf("Error processing command: %s", err)
+		}
+
+		cmd := &packer.RemoteCmd{
+			Command: command,
+			Env:     envVars,
+		}
+
+		if err := cmd.StartWithUi(comm, ui); err != nil {
+			return fmt.Errorf("Error running script: %s", err)
+		}
+
+		if cmd.ExitStatus != 0 {
+			return fmt.Errorf("Script exited with non-zero exit status: %d", cmd.ExitStatus)
+		}
+	}
+
+	return nil
+}
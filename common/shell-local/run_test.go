@@ -0,0 +1,102 @@
+package shelllocal
+
+import (
+	"bytes"
+	"github.com/mitchellh/packer/common"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestBuildEnv(t *testing.T) {
+	config := &common.PackerConfig{
+		PackerBuildName:   "foo",
+		PackerBuilderType: "virtualbox",
+		PackerUserVars:    map[string]string{"version": "1.0", "name": "a b", "aws-region.1": "us"},
+	}
+
+	expected := []string{
+		"PACKER_BUILD_NAME=foo",
+		"PACKER_BUILDER_TYPE=virtualbox",
+		"PACKER_VAR_aws_region_1=us",
+		"PACKER_VAR_name=a b",
+		"PACKER_VAR_version=1.0",
+	}
+
+	if env := BuildEnv(config); !reflect.DeepEqual(env, expected) {
+		t.Fatalf("bad: %#v", env)
+	}
+}
+
+func TestRun_inline(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("not supported on windows")
+	}
+
+	c := &Config{
+		Inline: []string{"echo $FOO $BAR", "echo done"},
+		Vars:   []string{"BAR=baz"},
+	}
+	if err := c.Prepare(nil); len(err) > 0 {
+		t.Fatalf("err: %#v", err)
+	}
+
+	tpl, err := packer.NewConfigTemplate()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var output bytes.Buffer
+	ui := &packer.BasicUi{Reader: new(bytes.Buffer), Writer: &output}
+	if err := Run(ui, c, tpl, []string{"FOO=foo"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !strings.Contains(output.String(), "foo baz\n") || !strings.Contains(output.String(), "done\n") {
+		t.Fatalf("bad: %q", output.String())
+	}
+}
+
+func TestRun_scripts(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("not supported on windows")
+	}
+
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	first := filepath.Join(td, "first script.sh")
+	second := filepath.Join(td, "second.sh")
+	ioutil.WriteFile(first, []byte("#!/bin/sh\necho first\nexit 3\n"), 0644)
+	ioutil.WriteFile(second, []byte("#!/bin/sh\necho second\n"), 0644)
+
+	c := &Config{Scripts: []string{first, second}}
+	if err := c.Prepare(nil); len(err) > 0 {
+		t.Fatalf("err: %#v", err)
+	}
+
+	tpl, err := packer.NewConfigTemplate()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The first script fails, so the second isn't run
+	var output bytes.Buffer
+	ui := &packer.BasicUi{Reader: new(bytes.Buffer), Writer: &output}
+	err = Run(ui, c, tpl, nil)
+	if err == nil || !strings.Contains(err.Error(), "exit status: 3") {
+		t.Fatalf("should have error: %s", err)
+	}
+
+	if !strings.Contains(output.String(), "first\n") || strings.Contains(output.String(), "second\n") {
+		t.Fatalf("bad: %q", output.String())
+	}
+}
@@ -0,0 +1,244 @@
+package common
+
+import (
+	gossh "code.google.com/p/go.crypto/ssh"
+	"errors"
+	"fmt"
+	"github.com/mitchellh/packer/communicator/ssh"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SSHConnectConfig contains the configuration keys shared by the builders
+// that control how the SSH connection to the machine is made. Embed this
+// structure into your configuration class to get it.
+type SSHConnectConfig struct {
+	SSHAgentAuth                 bool   `mapstructure:"ssh_agent_auth"`
+	SSHBastionHost               string `mapstructure:"ssh_bastion_host"`
+	SSHBastionHostKeyFingerprint string `mapstructure:"ssh_bastion_host_key_fingerprint"`
+	SSHBastionPort               uint   `mapstructure:"ssh_bastion_port"`
+	SSHBastionUsername           string `mapstructure:"ssh_bastion_username"`
+	SSHBastionPassword           string `mapstructure:"ssh_bastion_password"`
+	SSHBastionKeyPath            string `mapstructure:"ssh_bastion_private_key_file"`
+	SSHFileTransfer              string `mapstructure:"ssh_file_transfer_method"`
+	SSHHostKeyFingerprint        string `mapstructure:"ssh_host_key_fingerprint"`
+	SSHHostKeyPolicy             string `mapstructure:"ssh_host_key_policy"`
+	SSHKnownHostsFile            string `mapstructure:"ssh_known_hosts_file"`
+	RawSSHKeepAliveInterval      string `mapstructure:"ssh_keep_alive_interval"`
+
+	bastionHostKeyChecker *ssh.HostKeyChecker
+	sshKeepAliveInterval  time.Duration
+}
+
+func (c *SSHConnectConfig) Prepare(t *packer.ConfigTemplate) []error {
+	if t == nil {
+		var err error
+		t, err = packer.NewConfigTemplate()
+		if err != nil {
+			return []error{err}
+		}
+	}
+
+	if c.SSHBastionPort == 0 {
+		c.SSHBastionPort = 22
+	}
+
+	if c.SSHFileTransfer == "" {
+		c.SSHFileTransfer = "scp"
+	}
+
+	if c.SSHHostKeyPolicy == "" {
+		c.SSHHostKeyPolicy = ssh.HostKeyAccept
+	}
+
+	if c.RawSSHKeepAliveInterval == "" {
+		c.RawSSHKeepAliveInterval = "30s"
+	}
+
+	errs := make([]error, 0)
+	templates := map[string]*string{
+		"ssh_bastion_host":                 &c.SSHBastionHost,
+		"ssh_bastion_host_key_fingerprint": &c.SSHBastionHostKeyFingerprint,
+		"ssh_bastion_username":             &c.SSHBastionUsername,
+		"ssh_bastion_password":             &c.SSHBastionPassword,
+		"ssh_bastion_private_key_file":     &c.SSHBastionKeyPath,
+		"ssh_file_transfer_method":         &c.SSHFileTransfer,
+		"ssh_host_key_fingerprint":         &c.SSHHostKeyFingerprint,
+		"ssh_host_key_policy":              &c.SSHHostKeyPolicy,
+		"ssh_known_hosts_file":             &c.SSHKnownHostsFile,
+		"ssh_keep_alive_interval":          &c.RawSSHKeepAliveInterval,
+	}
+
+	for n, ptr := range templates {
+		var err error
+		*ptr, err = t.Process(*ptr, nil)
+		if err != nil {
+			errs = append(
+				errs, fmt.Errorf("Error processing %s: %s", n, err))
+		}
+	}
+
+	if c.SSHBastionHost != "" {
+		if c.SSHBastionUsername == "" {
+			errs = append(errs, errors.New(
+				"An ssh_bastion_username must be specified with ssh_bastion_host"))
+		}
+
+		if c.SSHBastionPassword == "" && c.SSHBastionKeyPath == "" && !c.SSHAgentAuth {
+			errs = append(errs, errors.New(
+				"An ssh_bastion_password or ssh_bastion_private_key_file must "+
+					"be specified with ssh_bastion_host, or ssh_agent_auth enabled"))
+		}
+
+		if c.SSHBastionKeyPath != "" {
+			if _, err := sshKeyFileToKeyring(c.SSHBastionKeyPath); err != nil {
+				errs = append(errs, fmt.Errorf(
+					"ssh_bastion_private_key_file is invalid: %s", err))
+			}
+		}
+
+		if c.SSHHostKeyPolicy == ssh.HostKeyFingerprint && c.SSHBastionHostKeyFingerprint == "" {
+			errs = append(errs, errors.New(
+				"ssh_bastion_host_key_fingerprint must be specified with ssh_bastion_host "+
+					"and the fingerprint ssh_host_key_policy"))
+		}
+	}
+
+	if c.SSHFileTransfer != "scp" && c.SSHFileTransfer != "sftp" {
+		errs = append(errs, errors.New("ssh_file_transfer_method must be one of: scp, sftp"))
+	}
+
+	switch c.SSHHostKeyPolicy {
+	case ssh.HostKeyAccept:
+	case ssh.HostKeyFingerprint:
+		if c.SSHHostKeyFingerprint == "" {
+			errs = append(errs, errors.New(
+				"ssh_host_key_fingerprint must be specified with the fingerprint ssh_host_key_policy"))
+		}
+	case ssh.HostKeyKnownHosts:
+		if c.SSHKnownHostsFile == "" {
+			c.SSHKnownHostsFile = filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts")
+		}
+
+		if _, err := os.Stat(c.SSHKnownHostsFile); err != nil {
+			errs = append(errs, fmt.Errorf("ssh_known_hosts_file is invalid: %s", err))
+		}
+	default:
+		errs = append(errs, errors.New(
+			"ssh_host_key_policy must be one of: accept, fingerprint, known_hosts"))
+	}
+
+	if c.SSHAgentAuth && os.Getenv("SSH_AUTH_SOCK") == "" {
+		errs = append(errs, errors.New(
+			"ssh_agent_auth requires a running ssh-agent, but SSH_AUTH_SOCK is not set"))
+	}
+
+	var err error
+	c.sshKeepAliveInterval, err = time.ParseDuration(c.RawSSHKeepAliveInterval)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("Failed parsing ssh_keep_alive_interval: %s", err))
+	} else if c.sshKeepAliveInterval < 0 {
+		errs = append(errs, errors.New("ssh_keep_alive_interval must not be negative"))
+	}
+
+	return errs
+}
+
+// UseSftp returns true if files should be transferred over SFTP.
+func (c *SSHConnectConfig) UseSftp() bool {
+	return c.SSHFileTransfer == "sftp"
+}
+
+// SSHKeepAliveInterval returns the interval to send TCP keepalives at on
+// the SSH connection. Zero means keepalives are disabled.
+func (c *SSHConnectConfig) SSHKeepAliveInterval() time.Duration {
+	return c.sshKeepAliveInterval
+}
+
+// ConnectFunc returns the function to use for the SSH communicator's
+// connection to the given address, going through the bastion host if
+// one is configured. If agentAuth isn't nil, it is also used to
+// authenticate with the bastion.
+func (c *SSHConnectConfig) ConnectFunc(address string, agentAuth gossh.ClientAuth) (func() (net.Conn, error), error) {
+	if c.SSHBastionHost == "" {
+		return ssh.KeepAliveConnectFunc(
+			ssh.ConnectFunc("tcp", address), c.sshKeepAliveInterval), nil
+	}
+
+	bastionAddress := fmt.Sprintf("%s:%d", c.SSHBastionHost, c.SSHBastionPort)
+	bastionConfig, err := c.bastionSSHConfig(bastionAddress, agentAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	bastion := ssh.KeepAliveConnectFunc(
+		ssh.ConnectFunc("tcp", bastionAddress), c.sshKeepAliveInterval)
+	return ssh.BastionConnectFunc(bastion, bastionConfig, "tcp", address), nil
+}
+
+// HostKeyChecker returns the checker that verifies the host key of the
+// machine at the given address according to the configured policy.
+func (c *SSHConnectConfig) HostKeyChecker(address string) *ssh.HostKeyChecker {
+	return &ssh.HostKeyChecker{
+		Host:           address,
+		Policy:         c.SSHHostKeyPolicy,
+		Fingerprint:    c.SSHHostKeyFingerprint,
+		KnownHostsPath: c.SSHKnownHostsFile,
+	}
+}
+
+// bastionSSHConfig returns the configuration for the connection to the
+// bastion host at the given address. Its host key is verified with the
+// same policy as the machine's, and it must stay the same across
+// connections.
+func (c *SSHConnectConfig) bastionSSHConfig(address string, agentAuth gossh.ClientAuth) (*gossh.ClientConfig, error) {
+	if c.bastionHostKeyChecker == nil {
+		c.bastionHostKeyChecker = c.HostKeyChecker(address)
+		c.bastionHostKeyChecker.Fingerprint = c.SSHBastionHostKeyFingerprint
+	}
+
+	auth := make([]gossh.ClientAuth, 0, 4)
+	if agentAuth != nil {
+		auth = append(auth, agentAuth)
+	}
+
+	if c.SSHBastionKeyPath != "" {
+		keyring, err := sshKeyFileToKeyring(c.SSHBastionKeyPath)
+		if err != nil {
+			return nil, err
+		}
+
+		auth = append(auth, gossh.ClientAuthKeyring(keyring))
+	}
+
+	if c.SSHBastionPassword != "" {
+		auth = append(auth,
+			gossh.ClientAuthPassword(ssh.Password(c.SSHBastionPassword)),
+			gossh.ClientAuthKeyboardInteractive(
+				ssh.PasswordKeyboardInteractive(c.SSHBastionPassword)))
+	}
+
+	return &gossh.ClientConfig{
+		User:           c.SSHBastionUsername,
+		Auth:           auth,
+		HostKeyChecker: c.bastionHostKeyChecker,
+	}, nil
+}
+
+func sshKeyFileToKeyring(path string) (gossh.ClientKeyring, error) {
+	keyBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keyring := new(ssh.SimpleKeychain)
+	if err := keyring.AddPEMKey(string(keyBytes)); err != nil {
+		return nil, err
+	}
+
+	return keyring, nil
+}
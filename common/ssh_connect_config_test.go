@@ -0,0 +1,211 @@
+package common
+
+import (
+	"github.com/mitchellh/packer/communicator/ssh"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSSHConnectConfigPrepare(t *testing.T) {
+	c := new(SSHConnectConfig)
+	if errs := c.Prepare(nil); len(errs) > 0 {
+		t.Fatalf("err: %#v", errs)
+	}
+
+	if c.SSHBastionPort != 22 {
+		t.Fatalf("bad: %d", c.SSHBastionPort)
+	}
+
+	if c.SSHKeepAliveInterval() != 30*time.Second {
+		t.Fatalf("bad: %s", c.SSHKeepAliveInterval())
+	}
+
+	if c.SSHHostKeyPolicy != "accept" {
+		t.Fatalf("bad: %s", c.SSHHostKeyPolicy)
+	}
+}
+
+func TestSSHConnectConfigPrepare_Bastion(t *testing.T) {
+	c := &SSHConnectConfig{SSHBastionHost: "bastion"}
+	if errs := c.Prepare(nil); len(errs) != 2 {
+		t.Fatalf("should require a username and credentials: %#v", errs)
+	}
+
+	c = &SSHConnectConfig{
+		SSHBastionHost:     "bastion",
+		SSHBastionUsername: "user",
+		SSHBastionPassword: "pass",
+	}
+	if errs := c.Prepare(nil); len(errs) > 0 {
+		t.Fatalf("err: %#v", errs)
+	}
+
+	if _, err := c.ConnectFunc("127.0.0.1:22", nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestSSHConnectConfigPrepare_BastionHostKey(t *testing.T) {
+	c := &SSHConnectConfig{
+		SSHBastionHost:        "bastion",
+		SSHBastionUsername:    "user",
+		SSHBastionPassword:    "pass",
+		SSHHostKeyPolicy:      "fingerprint",
+		SSHHostKeyFingerprint: "SHA256:machine",
+	}
+	if errs := c.Prepare(nil); len(errs) != 1 {
+		t.Fatalf("should require a bastion fingerprint: %#v", errs)
+	}
+
+	c.SSHBastionHostKeyFingerprint = "SHA256:bastion"
+	if errs := c.Prepare(nil); len(errs) > 0 {
+		t.Fatalf("err: %#v", errs)
+	}
+
+	config, err := c.bastionSSHConfig("bastion:22", nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	checker, ok := config.HostKeyChecker.(*ssh.HostKeyChecker)
+	if !ok {
+		t.Fatalf("bad: %#v", config.HostKeyChecker)
+	}
+
+	if checker.Host != "bastion:22" || checker.Fingerprint != "SHA256:bastion" {
+		t.Fatalf("bad: %#v", checker)
+	}
+}
+
+func TestSSHConnectConfigPrepare_AgentAuth(t *testing.T) {
+	defer os.Setenv("SSH_AUTH_SOCK", os.Getenv("SSH_AUTH_SOCK"))
+
+	os.Setenv("SSH_AUTH_SOCK", "")
+	c := &SSHConnectConfig{SSHAgentAuth: true}
+	if errs := c.Prepare(nil); len(errs) != 1 {
+		t.Fatalf("should require an agent: %#v", errs)
+	}
+
+	// The agent can authenticate with the bastion
+	os.Setenv("SSH_AUTH_SOCK", "/tmp/agent.sock")
+	c = &SSHConnectConfig{
+		SSHAgentAuth:       true,
+		SSHBastionHost:     "bastion",
+		SSHBastionUsername: "user",
+	}
+	if errs := c.Prepare(nil); len(errs) > 0 {
+		t.Fatalf("err: %#v", errs)
+	}
+}
+
+func TestSSHConnectConfigPrepare_BastionKey(t *testing.T) {
+	tf, err := ioutil.TempFile("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Remove(tf.Name())
+	tf.Write([]byte("not a key"))
+	tf.Close()
+
+	c := &SSHConnectConfig{
+		SSHBastionHost:     "bastion",
+		SSHBastionUsername: "user",
+		SSHBastionKeyPath:  tf.Name(),
+	}
+	if errs := c.Prepare(nil); len(errs) != 1 {
+		t.Fatalf("should reject an invalid key: %#v", errs)
+	}
+}
+
+func TestSSHConnectConfigPrepare_KeepAlive(t *testing.T) {
+	c := &SSHConnectConfig{RawSSHKeepAliveInterval: "0"}
+	if errs := c.Prepare(nil); len(errs) > 0 {
+		t.Fatalf("err: %#v", errs)
+	}
+
+	if c.SSHKeepAliveInterval() != 0 {
+		t.Fatalf("bad: %s", c.SSHKeepAliveInterval())
+	}
+
+	c = &SSHConnectConfig{RawSSHKeepAliveInterval: "bad"}
+	if errs := c.Prepare(nil); len(errs) != 1 {
+		t.Fatalf("should error: %#v", errs)
+	}
+
+	c = &SSHConnectConfig{RawSSHKeepAliveInterval: "-1s"}
+	if errs := c.Prepare(nil); len(errs) != 1 {
+		t.Fatalf("should error: %#v", errs)
+	}
+}
+
+func TestSSHConnectConfigPrepare_FileTransfer(t *testing.T) {
+	c := new(SSHConnectConfig)
+	if errs := c.Prepare(nil); len(errs) > 0 {
+		t.Fatalf("err: %#v", errs)
+	}
+
+	if c.SSHFileTransfer != "scp" || c.UseSftp() {
+		t.Fatalf("bad: %s", c.SSHFileTransfer)
+	}
+
+	c = &SSHConnectConfig{SSHFileTransfer: "sftp"}
+	if errs := c.Prepare(nil); len(errs) > 0 {
+		t.Fatalf("err: %#v", errs)
+	}
+
+	if !c.UseSftp() {
+		t.Fatal("should use sftp")
+	}
+
+	c = &SSHConnectConfig{SSHFileTransfer: "ftp"}
+	if errs := c.Prepare(nil); len(errs) != 1 {
+		t.Fatalf("should have error: %#v", errs)
+	}
+}
+
+func TestSSHConnectConfigPrepare_HostKeyPolicy(t *testing.T) {
+	c := &SSHConnectConfig{SSHHostKeyPolicy: "bad"}
+	if errs := c.Prepare(nil); len(errs) != 1 {
+		t.Fatalf("should error: %#v", errs)
+	}
+
+	c = &SSHConnectConfig{SSHHostKeyPolicy: "fingerprint"}
+	if errs := c.Prepare(nil); len(errs) != 1 {
+		t.Fatalf("should require a fingerprint: %#v", errs)
+	}
+
+	c = &SSHConnectConfig{
+		SSHHostKeyPolicy:      "fingerprint",
+		SSHHostKeyFingerprint: "SHA256:foo",
+	}
+	if errs := c.Prepare(nil); len(errs) > 0 {
+		t.Fatalf("err: %#v", errs)
+	}
+
+	checker := c.HostKeyChecker("127.0.0.1:22")
+	if checker.Host != "127.0.0.1:22" || checker.Fingerprint != "SHA256:foo" {
+		t.Fatalf("bad: %#v", checker)
+	}
+
+	c = &SSHConnectConfig{
+		SSHHostKeyPolicy:  "known_hosts",
+		SSHKnownHostsFile: "/i/dont/exist",
+	}
+	if errs := c.Prepare(nil); len(errs) != 1 {
+		t.Fatalf("should require the known_hosts file to exist: %#v", errs)
+	}
+
+	tf, err := ioutil.TempFile("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Remove(tf.Name())
+	tf.Close()
+
+	c.SSHKnownHostsFile = tf.Name()
+	if errs := c.Prepare(nil); len(errs) > 0 {
+		t.Fatalf("err: %#v", errs)
+	}
+}
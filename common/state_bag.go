@@ -0,0 +1,41 @@
+package common
+
+import (
+	"github.com/mitchellh/multistep"
+	"sort"
+	"sync"
+)
+
+// StateBag is a multistep.StateBag that also remembers the keys that were
+// put in it, so that they can be listed, such as by the debugger.
+type StateBag struct {
+	multistep.BasicStateBag
+
+	l    sync.Mutex
+	keys map[string]struct{}
+}
+
+func (b *StateBag) Put(k string, v interface{}) {
+	b.l.Lock()
+	if b.keys == nil {
+		b.keys = make(map[string]struct{})
+	}
+	b.keys[k] = struct{}{}
+	b.l.Unlock()
+
+	b.BasicStateBag.Put(k, v)
+}
+
+// Keys returns the sorted keys that were put in the state bag.
+func (b *StateBag) Keys() []string {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	keys := make([]string, 0, len(b.keys))
+	for k := range b.keys {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,31 @@
+package common
+
+import (
+	"github.com/mitchellh/multistep"
+	"reflect"
+	"testing"
+)
+
+func TestStateBag_Impl(t *testing.T) {
+	var raw interface{}
+	raw = new(StateBag)
+	if _, ok := raw.(multistep.StateBag); !ok {
+		t.Fatal("should be a StateBag")
+	}
+}
+
+func TestStateBag_Keys(t *testing.T) {
+	state := new(StateBag)
+	state.Put("foo", 1)
+	state.Put("bar", "baz")
+	state.Put("foo", 2)
+
+	expected := []string{"bar", "foo"}
+	if keys := state.Keys(); !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("bad: %#v", keys)
+	}
+
+	if v := state.Get("foo"); v != 2 {
+		t.Fatalf("bad: %#v", v)
+	}
+}
@@ -2,12 +2,12 @@ package common
 
 import (
 	gossh "code.google.com/p/go.crypto/ssh"
-	"errors"
 	"fmt"
 	"github.com/mitchellh/multistep"
 	"github.com/mitchellh/packer/communicator/ssh"
 	"github.com/mitchellh/packer/packer"
 	"log"
+	"net"
 	"strings"
 	"time"
 )
@@ -20,7 +20,11 @@ import (
 //   ui packer.Ui
 //
 // Produces:
-//   communicator packer.Communicator
+//   communicator  packer.Communicator
+//   ssh_address   string - The address SSH connected to
+//   ssh_username  string - The user SSH authenticated as
+//   ssh_host_keys map[string]string - The host key accepted for the
+//                 address SSH connected to, if ConnectConfig is set
 type StepConnectSSH struct {
 	// SSHAddress is a function that returns the TCP address to connect to
 	// for SSH. This is a function so that you can query information
@@ -37,7 +41,16 @@ type StepConnectSSH struct {
 	// NoPty, if true, will not request a Pty from the remote end.
 	NoPty bool
 
-	comm packer.Communicator
+	// ConnectConfig, if set, configures how the connection is made, such
+	// as through a bastion host, with keepalives and with ssh-agent
+	// authentication, and whether files are transferred over SFTP.
+	ConnectConfig *SSHConnectConfig
+
+	agentConn      net.Conn
+	comm           packer.Communicator
+	hostKeyChecker *ssh.HostKeyChecker
+	address        string
+	username       string
 }
 
 func (s *StepConnectSSH) Run(state multistep.StateBag) multistep.StepAction {
@@ -70,9 +83,16 @@ WaitLoop:
 			ui.Say("Connected to SSH!")
 			s.comm = comm
 			state.Put("communicator", comm)
+			state.Put("ssh_address", s.address)
+			state.Put("ssh_username", s.username)
+			if s.hostKeyChecker != nil {
+				state.Put("ssh_host_keys", map[string]string{
+					s.address: s.hostKeyChecker.HostKey(),
+				})
+			}
 			break WaitLoop
 		case <-timeout:
-			err := fmt.Errorf("Timeout waiting for SSH.")
+			err := &packer.TimeoutError{"Timeout waiting for SSH."}
 			state.Put("error", err)
 			ui.Error(err.Error())
 			close(cancel)
@@ -92,17 +112,34 @@ WaitLoop:
 }
 
 func (s *StepConnectSSH) Cleanup(multistep.StateBag) {
+	if s.agentConn != nil {
+		s.agentConn.Close()
+		s.agentConn = nil
+	}
 }
 
 func (s *StepConnectSSH) waitForSSH(state multistep.StateBag, cancel <-chan struct{}) (packer.Communicator, error) {
 	handshakeAttempts := 0
 
+	// Connect to the SSH agent up front, since the communicator keeps
+	// using it to authenticate whenever it reconnects.
+	var agentAuth gossh.ClientAuth
+	if s.ConnectConfig != nil && s.ConnectConfig.SSHAgentAuth {
+		var err error
+		s.agentConn, err = ssh.ConnectAgent()
+		if err != nil {
+			return nil, fmt.Errorf("Error connecting to SSH agent: %s", err)
+		}
+
+		agentAuth = gossh.ClientAuthAgent(gossh.NewAgentClient(s.agentConn))
+	}
+
 	var comm packer.Communicator
 	for {
 		select {
 		case <-cancel:
 			log.Println("SSH wait cancelled. Exiting loop.")
-			return nil, errors.New("SSH wait cancelled")
+			return nil, &packer.CancelledError{"SSH wait cancelled"}
 		case <-time.After(5 * time.Second):
 		}
 
@@ -120,8 +157,25 @@ func (s *StepConnectSSH) waitForSSH(state multistep.StateBag, cancel <-chan stru
 			continue
 		}
 
+		if agentAuth != nil {
+			sshConfig.Auth = append(sshConfig.Auth, agentAuth)
+		}
+
+		// The host key checker is only made once, so that once a host key
+		// is accepted it is the only one accepted from then on.
+		if s.ConnectConfig != nil && s.hostKeyChecker == nil {
+			s.hostKeyChecker = s.ConnectConfig.HostKeyChecker(address)
+		}
+
 		// Attempt to connect to SSH port
 		connFunc := ssh.ConnectFunc("tcp", address)
+		if s.ConnectConfig != nil {
+			connFunc, err = s.ConnectConfig.ConnectFunc(address, agentAuth)
+			if err != nil {
+				return nil, err
+			}
+		}
+
 		nc, err := connFunc()
 		if err != nil {
 			log.Printf("TCP connection to SSH ip/port failed: %s", err)
@@ -136,6 +190,14 @@ func (s *StepConnectSSH) waitForSSH(state multistep.StateBag, cancel <-chan stru
 			NoPty:      s.NoPty,
 		}
 
+		if s.ConnectConfig != nil {
+			config.UseSftp = s.ConnectConfig.UseSftp()
+		}
+
+		if s.hostKeyChecker != nil {
+			config.HostKeyChecker = s.hostKeyChecker
+		}
+
 		log.Println("Attempting SSH connection...")
 		comm, err = ssh.New(config)
 		if err != nil {
@@ -157,6 +219,8 @@ func (s *StepConnectSSH) waitForSSH(state multistep.StateBag, cancel <-chan stru
 			return nil, err
 		}
 
+		s.address = address
+		s.username = sshConfig.User
 		break
 	}
 
@@ -0,0 +1,136 @@
+package common
+
+import (
+	"fmt"
+	"github.com/mitchellh/multistep"
+	"github.com/mitchellh/packer/communicator/winrm"
+	"github.com/mitchellh/packer/packer"
+	"log"
+	"strings"
+	"time"
+)
+
+// StepConnectWinRM is a multistep Step implementation that waits for
+// WinRM to become available. It gets the connection information from a
+// single configuration when creating the step.
+//
+// Uses:
+//   ui packer.Ui
+//
+// Produces:
+//   communicator   packer.Communicator
+//   winrm_address  string - The address WinRM connected to
+//   winrm_username string - The user WinRM authenticated as
+type StepConnectWinRM struct {
+	// WinRMAddress is a function that returns the TCP address to connect
+	// to for WinRM. This is a function so that you can query information
+	// if necessary for this address.
+	WinRMAddress func(multistep.StateBag) (string, error)
+
+	// Config is the configuration of the WinRM connection, including the
+	// credentials and the total timeout to wait for it to become available.
+	Config *CommunicatorConfig
+
+	comm    packer.Communicator
+	address string
+}
+
+func (s *StepConnectWinRM) Run(state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packer.Ui)
+
+	var comm packer.Communicator
+	var err error
+
+	cancel := make(chan struct{})
+	waitDone := make(chan bool, 1)
+	go func() {
+		ui.Say("Waiting for WinRM to become available...")
+		comm, err = s.waitForWinRM(state, cancel)
+		waitDone <- true
+	}()
+
+	log.Printf("Waiting for WinRM, up to timeout: %s", s.Config.WinRMWaitTimeout())
+	timeout := time.After(s.Config.WinRMWaitTimeout())
+WaitLoop:
+	for {
+		// Wait for either WinRM to become available, a timeout to occur,
+		// or an interrupt to come through.
+		select {
+		case <-waitDone:
+			if err != nil {
+				ui.Error(fmt.Sprintf("Error waiting for WinRM: %s", err))
+				return multistep.ActionHalt
+			}
+
+			ui.Say("Connected to WinRM!")
+			s.comm = comm
+			state.Put("communicator", comm)
+			state.Put("winrm_address", s.address)
+			state.Put("winrm_username", s.Config.WinRMUser)
+			break WaitLoop
+		case <-timeout:
+			err := &packer.TimeoutError{"Timeout waiting for WinRM."}
+			state.Put("error", err)
+			ui.Error(err.Error())
+			close(cancel)
+			return multistep.ActionHalt
+		case <-time.After(1 * time.Second):
+			if _, ok := state.GetOk(multistep.StateCancelled); ok {
+				// The step sequence was cancelled, so cancel waiting for
+				// WinRM and just start the halting process.
+				close(cancel)
+				log.Println("Interrupt detected, quitting waiting for WinRM.")
+				return multistep.ActionHalt
+			}
+		}
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *StepConnectWinRM) Cleanup(multistep.StateBag) {}
+
+func (s *StepConnectWinRM) waitForWinRM(state multistep.StateBag, cancel <-chan struct{}) (packer.Communicator, error) {
+	authAttempts := 0
+
+	var comm packer.Communicator
+	for {
+		select {
+		case <-cancel:
+			log.Println("WinRM wait cancelled. Exiting loop.")
+			return nil, &packer.CancelledError{"WinRM wait cancelled"}
+		case <-time.After(5 * time.Second):
+		}
+
+		address, err := s.WinRMAddress(state)
+		if err != nil {
+			log.Printf("Error getting WinRM address: %s", err)
+			continue
+		}
+
+		log.Println("Attempting WinRM connection...")
+		comm, err = winrm.New(s.Config.WinRMConfig(address))
+		if err != nil {
+			log.Printf("WinRM connection err: %s", err)
+
+			// The service is often reachable before the user can log in
+			// while Windows is still setting up, so allow a handful of
+			// authentication failures before giving up.
+			if strings.Contains(err.Error(), "authenticate") {
+				log.Printf("Detected authentication error. Increasing auth attempts.")
+				authAttempts += 1
+			}
+
+			if authAttempts < 10 {
+				continue
+			}
+
+			return nil, err
+		}
+
+		s.address = address
+		break
+	}
+
+	return comm, nil
+}
@@ -0,0 +1,14 @@
+package common
+
+import (
+	"github.com/mitchellh/multistep"
+	"testing"
+)
+
+func TestStepConnectWinRM_Impl(t *testing.T) {
+	var raw interface{}
+	raw = new(StepConnectWinRM)
+	if _, ok := raw.(multistep.Step); !ok {
+		t.Fatalf("connect winrm should be a step")
+	}
+}
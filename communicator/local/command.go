@@ -1,7 +1,8 @@
-package chroot
+package local
 
 import (
 	"os/exec"
+	"runtime"
 )
 
 // CommandWrapper is a type that given a command, will possibly modify that
@@ -9,7 +10,11 @@ import (
 type CommandWrapper func(string) (string, error)
 
 // ShellCommand takes a command string and returns an *exec.Cmd to execute
-// it within the context of a shell (/bin/sh).
+// it within the context of a shell (/bin/sh, or cmd on Windows).
 func ShellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+
 	return exec.Command("/bin/sh", "-c", command)
 }
@@ -0,0 +1,346 @@
+package local
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/mitchellh/packer/packer"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// Communicator is a packer.Communicator that runs commands and copies
+// files on the machine packer is running on. It can be used by builders
+// and provisioners that work on the local machine rather than over SSH.
+type Communicator struct {
+	// Dir is the directory that commands are run in, and that relative
+	// paths are relative to. By default this is the current directory.
+	Dir string
+
+	// CommandWrapper, if set, wraps every command that is run, such as to
+	// run it with sudo or within a chroot. Files are then also copied with
+	// commands run through the wrapper, so that they can be copied to and
+	// from places the packer user can't access.
+	CommandWrapper CommandWrapper
+}
+
+func (c *Communicator) Start(cmd *packer.RemoteCmd) error {
+	// Commands run directly are given their environment and directory
+	// natively. The wrapper and sudo may not keep those, so otherwise the
+	// shell sets them. Commands can't be elevated on Windows.
+	native := c.CommandWrapper == nil && (!cmd.Elevated || runtime.GOOS == "windows")
+	command := cmd.Command
+	if !native {
+		command = cmd.ShellCommand()
+	}
+
+	command, err := c.wrap(command)
+	if err != nil {
+		return err
+	}
+
+	localCmd := ShellCommand(command)
+	localCmd.Dir = c.Dir
+	if native {
+		if cmd.Dir != "" {
+			localCmd.Dir = c.path(cmd.Dir)
+		}
+
+		if len(cmd.Env) > 0 {
+			localCmd.Env = append(os.Environ(), cmd.Env...)
+		}
+	}
+	localCmd.Stdin = cmd.Stdin
+	localCmd.Stdout = cmd.Stdout
+	localCmd.Stderr = cmd.Stderr
+	log.Printf("Executing: %s %#v", localCmd.Path, localCmd.Args)
+	if err := localCmd.Start(); err != nil {
+		return err
+	}
+
+	go func() {
+		exitStatus := 0
+		if err := localCmd.Wait(); err != nil {
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				exitStatus = 1
+
+				// There is no process-independent way to get the REAL
+				// exit status so we just try to go deeper.
+				if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+					exitStatus = status.ExitStatus()
+				}
+			}
+		}
+
+		log.Printf(
+			"Local execution exited with '%d': '%s'",
+			exitStatus, cmd.Command)
+		cmd.SetExited(exitStatus)
+	}()
+
+	return nil
+}
+
+func (c *Communicator) Upload(dst string, r io.Reader) error {
+	return c.UploadFile(dst, r, new(packer.UploadOptions))
+}
+
+// UploadFile uploads the file, setting its mode directly unless it is
+// copied through the wrapper. The owner and group are set with chown,
+// which is run with sudo.
+func (c *Communicator) UploadFile(dst string, r io.Reader, opts *packer.UploadOptions) error {
+	dst = c.path(dst)
+	log.Printf("Uploading to: %s", dst)
+
+	if c.CommandWrapper == nil {
+		if err := writeFile(dst, r, opts.Mode); err != nil {
+			return err
+		}
+
+		return c.setOwner(dst, opts)
+	}
+
+	tf, err := ioutil.TempFile("", "packer-local")
+	if err != nil {
+		return fmt.Errorf("Error preparing upload: %s", err)
+	}
+	defer os.Remove(tf.Name())
+
+	_, err = io.Copy(tf, r)
+	tf.Close()
+	if err != nil {
+		return err
+	}
+
+	// Each command is wrapped on its own, so that a wrapper such as sudo
+	// applies to all of them.
+	err = c.run(fmt.Sprintf("cp %s %s", packer.ShellQuote(tf.Name()), packer.ShellQuote(dst)), nil)
+	if err != nil {
+		return err
+	}
+
+	if cmd := opts.RemoteCmd(dst); cmd != nil {
+		return c.run(cmd.ShellCommand(), nil)
+	}
+
+	return nil
+}
+
+func (c *Communicator) UploadDir(dst string, src string, exclude []string) error {
+	// If the source doesn't end in a slash, the directory itself is
+	// copied rather than only its contents.
+	dst = c.path(dst)
+	if !strings.HasSuffix(src, "/") {
+		dst = filepath.Join(dst, filepath.Base(src))
+	}
+
+	log.Printf("Uploading directory '%s' to '%s'", src, dst)
+	if c.CommandWrapper == nil {
+		return copyDir(dst, src, exclude)
+	}
+
+	// Stage the files that aren't excluded, and copy those into place
+	// through the wrapper.
+	stage, err := ioutil.TempDir("", "packer-local")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stage)
+
+	if err := copyDir(stage, src, exclude); err != nil {
+		return err
+	}
+
+	if err := c.run(fmt.Sprintf("mkdir -p %s", packer.ShellQuote(dst)), nil); err != nil {
+		return err
+	}
+
+	return c.run(fmt.Sprintf("cp -R %s %s", packer.ShellQuote(stage+"/."), packer.ShellQuote(dst)), nil)
+}
+
+func (c *Communicator) Download(src string, w io.Writer) error {
+	src = c.path(src)
+	log.Printf("Downloading from: %s", src)
+
+	if c.CommandWrapper == nil {
+		f, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(w, f)
+		return err
+	}
+
+	return c.run(fmt.Sprintf("cat %s", packer.ShellQuote(src)), w)
+}
+
+func (c *Communicator) DownloadDir(src string, dst string, exclude []string) error {
+	// If the source doesn't end in a slash, the directory itself is
+	// copied rather than only its contents.
+	if !strings.HasSuffix(src, "/") {
+		dst = filepath.Join(dst, filepath.Base(src))
+	}
+
+	src = c.path(src)
+	log.Printf("Downloading directory '%s' to '%s'", src, dst)
+	if c.CommandWrapper == nil {
+		return copyDir(dst, src, exclude)
+	}
+
+	// Copy the directory out through the wrapper, and make it ours so
+	// that the files that aren't excluded can be copied into place.
+	stage, err := ioutil.TempDir("", "packer-local")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stage)
+
+	command := fmt.Sprintf("cp -R %s %s",
+		packer.ShellQuote(strings.TrimRight(src, "/")+"/."), packer.ShellQuote(stage))
+	if err := c.run(command, nil); err != nil {
+		return err
+	}
+
+	command = fmt.Sprintf("chown -R %d:%d %s", os.Getuid(), os.Getgid(), packer.ShellQuote(stage))
+	if err := c.run(command, nil); err != nil {
+		return err
+	}
+
+	return copyDir(dst, stage, exclude)
+}
+
+// setOwner changes the owner and group of the file to the ones in the
+// options, if they are set. Like other elevated commands, chown is run
+// with sudo.
+func (c *Communicator) setOwner(path string, opts *packer.UploadOptions) error {
+	if opts.Owner == "" && opts.Group == "" {
+		return nil
+	}
+
+	owner := &packer.UploadOptions{Owner: opts.Owner, Group: opts.Group}
+	return c.run(owner.RemoteCmd(path).ShellCommand(), nil)
+}
+
+// path returns the path on the local machine, relative to Dir.
+func (c *Communicator) path(path string) string {
+	if c.Dir == "" || filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(c.Dir, path)
+}
+
+// wrap returns the command wrapped by the CommandWrapper, if there is one.
+func (c *Communicator) wrap(command string) (string, error) {
+	if c.CommandWrapper == nil {
+		return command, nil
+	}
+
+	return c.CommandWrapper(command)
+}
+
+// run runs the command through the wrapper and waits for it to complete,
+// writing its output to stdout if it isn't nil.
+func (c *Communicator) run(command string, stdout io.Writer) error {
+	command, err := c.wrap(command)
+	if err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+	cmd := ShellCommand(command)
+	cmd.Dir = c.Dir
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	log.Printf("Executing: %s %#v", cmd.Path, cmd.Args)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf(
+			"Error executing command: %s\n\nStderr: %s", err, stderr.String())
+	}
+
+	return nil
+}
+
+// copyDir copies the contents of the src directory into dst, creating it
+// if it doesn't exist. The paths matching the exclude patterns, as matched
+// by packer.ExcludeMatch, are skipped.
+func copyDir(dst string, src string, exclude []string) error {
+	src = filepath.Clean(src)
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+
+		if rel != "." && packer.ExcludeMatch(filepath.ToSlash(rel), exclude) {
+			log.Printf("Skipping excluded path: %s", rel)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+
+			return os.Symlink(link, target)
+		default:
+			return copyFile(target, path, info.Mode().Perm())
+		}
+	})
+}
+
+func copyFile(dst string, src string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return writeFile(dst, in, mode)
+}
+
+// writeFile writes the contents of r to the file at path. If mode isn't
+// zero, it is set exactly, whatever the umask is and even if the file
+// already exists. Otherwise new files are created with mode 0644.
+func writeFile(path string, r io.Reader, mode os.FileMode) error {
+	createMode := mode.Perm()
+	if createMode == 0 {
+		createMode = 0644
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, createMode)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if mode != 0 {
+		if err := f.Chmod(mode.Perm()); err != nil {
+			return err
+		}
+	}
+
+	_, err = io.Copy(f, r)
+	return err
+}
@@ -0,0 +1,380 @@
+package local
+
+import (
+	"bytes"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// testWrappers are the wrappers that the tests run with: none, which
+// copies files natively, and one that doesn't change the command, which
+// copies them with commands.
+var testWrappers = map[string]CommandWrapper{
+	"native": nil,
+	"wrapped": func(command string) (string, error) {
+		return command, nil
+	},
+}
+
+func testDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return dir
+}
+
+func TestCommunicator_ImplementsCommunicator(t *testing.T) {
+	var raw interface{}
+	raw = &Communicator{}
+	if _, ok := raw.(packer.Communicator); !ok {
+		t.Fatalf("Communicator should be a communicator")
+	}
+
+	if _, ok := raw.(packer.FileUploader); !ok {
+		t.Fatalf("Communicator should be a file uploader")
+	}
+}
+
+func TestCommunicatorStart(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test commands need a POSIX shell")
+	}
+
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	var stdout bytes.Buffer
+	c := &Communicator{
+		Dir: dir,
+		CommandWrapper: func(command string) (string, error) {
+			return "echo wrapped && " + command, nil
+		},
+	}
+
+	cmd := &packer.RemoteCmd{
+		Command: "pwd && cat && exit 3",
+		Stdin:   strings.NewReader("input\n"),
+		Stdout:  &stdout,
+	}
+
+	if err := c.Start(cmd); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	cmd.Wait()
+
+	if cmd.ExitStatus != 3 {
+		t.Fatalf("bad exit status: %d", cmd.ExitStatus)
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 3 || lines[0] != "wrapped" || lines[2] != "input" {
+		t.Fatalf("bad output: %q", stdout.String())
+	}
+
+	// The temporary directory may be reached through a symlink
+	realDir, _ := filepath.EvalSymlinks(dir)
+	if lines[1] != dir && lines[1] != realDir {
+		t.Fatalf("should run in the directory: %s", lines[1])
+	}
+}
+
+func TestCommunicatorStart_envDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test commands need a POSIX shell")
+	}
+
+	for name, wrapper := range testWrappers {
+		dir := testDir(t)
+		defer os.RemoveAll(dir)
+
+		if err := os.Mkdir(filepath.Join(dir, "my dir"), 0755); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		var stdout bytes.Buffer
+		c := &Communicator{Dir: dir, CommandWrapper: wrapper}
+		cmd := &packer.RemoteCmd{
+			Command: `echo "$FOO" && basename "$(pwd)"`,
+			Env:     []string{"FOO=it's $HOME"},
+			Dir:     "my dir",
+			Stdout:  &stdout,
+		}
+
+		if err := c.Start(cmd); err != nil {
+			t.Fatalf("%s: err: %s", name, err)
+		}
+		cmd.Wait()
+
+		if cmd.ExitStatus != 0 {
+			t.Fatalf("%s: bad exit status: %d", name, cmd.ExitStatus)
+		}
+
+		if stdout.String() != "it's $HOME\nmy dir\n" {
+			t.Fatalf("%s: bad output: %q", name, stdout.String())
+		}
+	}
+}
+
+func TestCommunicatorUploadDownload(t *testing.T) {
+	for name, wrapper := range testWrappers {
+		dir := testDir(t)
+		defer os.RemoveAll(dir)
+
+		c := &Communicator{Dir: dir, CommandWrapper: wrapper}
+		if err := c.Upload("foo", strings.NewReader("data")); err != nil {
+			t.Fatalf("%s: err: %s", name, err)
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, "foo"))
+		if err != nil || string(data) != "data" {
+			t.Fatalf("%s: bad: %q %s", name, data, err)
+		}
+
+		var buf bytes.Buffer
+		if err := c.Download(filepath.Join(dir, "foo"), &buf); err != nil {
+			t.Fatalf("%s: err: %s", name, err)
+		}
+
+		if buf.String() != "data" {
+			t.Fatalf("%s: bad: %q", name, buf.String())
+		}
+
+		if err := c.Download("nope", &buf); err == nil {
+			t.Fatalf("%s: should error", name)
+		}
+	}
+}
+
+func TestCommunicatorUploadFile(t *testing.T) {
+	for name, wrapper := range testWrappers {
+		dir := testDir(t)
+		defer os.RemoveAll(dir)
+
+		// The mode is set on new files and existing ones
+		c := &Communicator{Dir: dir, CommandWrapper: wrapper}
+		for _, mode := range []os.FileMode{0755, 0600} {
+			opts := &packer.UploadOptions{Mode: mode}
+			if err := c.UploadFile("foo", strings.NewReader("data"), opts); err != nil {
+				t.Fatalf("%s: err: %s", name, err)
+			}
+
+			fi, err := os.Stat(filepath.Join(dir, "foo"))
+			if err != nil {
+				t.Fatalf("%s: err: %s", name, err)
+			}
+
+			if fi.Mode().Perm() != mode {
+				t.Fatalf("%s: bad mode: %s", name, fi.Mode())
+			}
+		}
+	}
+}
+
+func TestCommunicatorUploadDir(t *testing.T) {
+	src := testDir(t)
+	defer os.RemoveAll(src)
+
+	for _, dir := range []string{"sub", "skip"} {
+		if err := os.Mkdir(filepath.Join(src, dir), 0755); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	for _, name := range []string{"foo", filepath.Join("sub", "bar"), filepath.Join("skip", "baz")} {
+		if err := ioutil.WriteFile(filepath.Join(src, name), []byte(name), 0644); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	if err := os.Chmod(filepath.Join(src, "sub", "bar"), 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	for name, wrapper := range testWrappers {
+		dst := testDir(t)
+		defer os.RemoveAll(dst)
+
+		c := &Communicator{Dir: dst, CommandWrapper: wrapper}
+		if err := c.UploadDir("with", src, []string{"skip/"}); err != nil {
+			t.Fatalf("%s: err: %s", name, err)
+		}
+
+		root := filepath.Join(dst, "with", filepath.Base(src))
+		data, err := ioutil.ReadFile(filepath.Join(root, "sub", "bar"))
+		if err != nil || string(data) != filepath.Join("sub", "bar") {
+			t.Fatalf("%s: bad: %q %s", name, data, err)
+		}
+
+		// Executable files stay executable
+		fi, err := os.Stat(filepath.Join(root, "sub", "bar"))
+		if err != nil || fi.Mode().Perm()&0111 == 0 {
+			t.Fatalf("%s: should be executable: %#v %s", name, fi, err)
+		}
+
+		if _, err := os.Stat(filepath.Join(root, "skip")); !os.IsNotExist(err) {
+			t.Fatalf("%s: excluded directory should not exist: %s", name, err)
+		}
+
+		// With a trailing slash only the contents are uploaded
+		if err := c.UploadDir("contents", src+"/", nil); err != nil {
+			t.Fatalf("%s: err: %s", name, err)
+		}
+
+		data, err = ioutil.ReadFile(filepath.Join(dst, "contents", "skip", "baz"))
+		if err != nil || string(data) != filepath.Join("skip", "baz") {
+			t.Fatalf("%s: bad: %q %s", name, data, err)
+		}
+	}
+}
+
+func TestCommunicatorDownloadDir(t *testing.T) {
+	src := testDir(t)
+	defer os.RemoveAll(src)
+
+	for _, dir := range []string{"sub", "skip"} {
+		if err := os.Mkdir(filepath.Join(src, dir), 0755); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	for _, name := range []string{"foo", filepath.Join("sub", "bar"), filepath.Join("skip", "baz")} {
+		if err := ioutil.WriteFile(filepath.Join(src, name), []byte(name), 0644); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	for name, wrapper := range testWrappers {
+		dst := testDir(t)
+		defer os.RemoveAll(dst)
+
+		c := &Communicator{Dir: filepath.Dir(src), CommandWrapper: wrapper}
+		if err := c.DownloadDir(filepath.Base(src), dst, []string{"skip"}); err != nil {
+			t.Fatalf("%s: err: %s", name, err)
+		}
+
+		root := filepath.Join(dst, filepath.Base(src))
+		data, err := ioutil.ReadFile(filepath.Join(root, "sub", "bar"))
+		if err != nil || string(data) != filepath.Join("sub", "bar") {
+			t.Fatalf("%s: bad: %q %s", name, data, err)
+		}
+
+		if _, err := os.Stat(filepath.Join(root, "skip")); !os.IsNotExist(err) {
+			t.Fatalf("%s: excluded directory should not exist: %s", name, err)
+		}
+
+		// With a trailing slash only the contents are downloaded
+		if err := c.DownloadDir(src+"/", dst, nil); err != nil {
+			t.Fatalf("%s: err: %s", name, err)
+		}
+
+		data, err = ioutil.ReadFile(filepath.Join(dst, "foo"))
+		if err != nil || string(data) != "foo" {
+			t.Fatalf("%s: bad: %q %s", name, data, err)
+		}
+	}
+}
+
+func TestCommunicator_wrapsEachCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test commands need a POSIX shell")
+	}
+
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	// Paths with spaces must be quoted
+	src := filepath.Join(dir, "the source")
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(src, "foo"), []byte("foo"), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var commands []string
+	c := &Communicator{
+		Dir: dir,
+		CommandWrapper: func(command string) (string, error) {
+			commands = append(commands, command)
+			return command, nil
+		},
+	}
+
+	opts := &packer.UploadOptions{Mode: 0600}
+	if err := c.UploadFile("the file", strings.NewReader("foo"), opts); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := c.UploadDir("the upload", src, nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := c.DownloadDir(src, filepath.Join(dir, "the download"), nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var data bytes.Buffer
+	if err := c.Download("the file", &data); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if data.String() != "foo" {
+		t.Fatalf("bad: %q", data.String())
+	}
+
+	if len(commands) != 7 {
+		t.Fatalf("bad: %#v", commands)
+	}
+
+	// A wrapper such as sudo only applies to the first of chained commands
+	for _, command := range commands {
+		if strings.Contains(command, "&&") {
+			t.Fatalf("commands should be wrapped separately: %s", command)
+		}
+	}
+}
+
+func TestCommunicator_wrapsOwnerWithSudo(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test commands need a POSIX shell")
+	}
+
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	var commands []string
+	c := &Communicator{
+		Dir: dir,
+		CommandWrapper: func(command string) (string, error) {
+			commands = append(commands, command)
+
+			// Don't actually run sudo
+			if strings.HasPrefix(command, "sudo ") {
+				return "true", nil
+			}
+
+			return command, nil
+		},
+	}
+
+	opts := &packer.UploadOptions{Owner: "root"}
+	if err := c.UploadFile("the file", strings.NewReader("foo"), opts); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(commands) != 2 {
+		t.Fatalf("bad: %#v", commands)
+	}
+
+	if !strings.HasPrefix(commands[1], "sudo ") || !strings.Contains(commands[1], "chown") {
+		t.Fatalf("chown should be run with sudo: %s", commands[1])
+	}
+}
@@ -0,0 +1,26 @@
+package ssh
+
+import (
+	"errors"
+	"log"
+	"net"
+	"os"
+)
+
+// ConnectAgent connects to the running ssh-agent, found through the
+// SSH_AUTH_SOCK environment variable. The connection must be kept open
+// for as long as the agent is used to authenticate, including when the
+// communicator reconnects.
+//
+// The agent is only used to authenticate. It isn't forwarded to the remote
+// end, since the SSH client can't serve the agent channels that the remote
+// end would open.
+func ConnectAgent() (net.Conn, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, errors.New("SSH_AUTH_SOCK is not set. Is ssh-agent running?")
+	}
+
+	log.Printf("Connecting to SSH agent at %s", sock)
+	return net.Dial("unix", sock)
+}
@@ -0,0 +1,38 @@
+package ssh
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConnectAgent(t *testing.T) {
+	defer os.Setenv("SSH_AUTH_SOCK", os.Getenv("SSH_AUTH_SOCK"))
+
+	os.Setenv("SSH_AUTH_SOCK", "")
+	if _, err := ConnectAgent(); err == nil {
+		t.Fatal("should error without an agent")
+	}
+
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	sock := filepath.Join(td, "agent.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer l.Close()
+
+	os.Setenv("SSH_AUTH_SOCK", sock)
+	conn, err := ConnectAgent()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	conn.Close()
+}
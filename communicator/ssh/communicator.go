@@ -8,10 +8,14 @@ import (
 	"fmt"
 	"github.com/mitchellh/packer/packer"
 	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -34,6 +38,14 @@ type Config struct {
 
 	// NoPty, if true, will not request a pty from the remote end.
 	NoPty bool
+
+	// UseSftp, if true, transfers files over SFTP rather than SCP, for
+	// machines that don't have scp installed.
+	UseSftp bool
+
+	// HostKeyChecker, if set, verifies the host key of the remote end
+	// every time the communicator connects, including when it reconnects.
+	HostKeyChecker ssh.HostKeyChecker
 }
 
 // Creates a new packer.Communicator implementation over SSH. This takes
@@ -77,7 +89,7 @@ func (c *comm) Start(cmd *packer.RemoteCmd) (err error) {
 	}
 
 	log.Printf("starting remote command: %s", cmd.Command)
-	err = session.Start(cmd.Command + "\n")
+	err = session.Start(cmd.ShellCommand() + "\n")
 	if err != nil {
 		return
 	}
@@ -94,24 +106,42 @@ func (c *comm) Start(cmd *packer.RemoteCmd) (err error) {
 
 		err := session.Wait()
 		exitStatus := 0
+		disconnected := false
 		if err != nil {
 			exitErr, ok := err.(*ssh.ExitError)
 			if ok {
 				exitStatus = exitErr.ExitStatus()
+
+				// Commands are killed by a signal when the machine
+				// shuts down.
+				disconnected = exitErr.Signal() != ""
+			} else {
+				// The session ended without an exit status, so the
+				// connection was lost.
+				disconnected = true
 			}
 		}
 
 		sessionLock.Lock()
-		defer sessionLock.Unlock()
-
 		if timedOut {
 			// We timed out, so set the exit status to -1
 			exitStatus = -1
+			disconnected = true
+		}
+		close(doneCh)
+		sessionLock.Unlock()
+
+		if disconnected && cmd.ExpectDisconnect {
+			log.Printf("remote command disconnected as expected: %s", cmd.Command)
+			exitStatus = 0
+			if err := c.waitForReconnect(cmd.ReconnectTimeout); err != nil {
+				log.Printf("%s", err)
+				exitStatus = -1
+			}
 		}
 
 		log.Printf("remote command exited with '%d': %s", exitStatus, cmd.Command)
 		cmd.SetExited(exitStatus)
-		close(doneCh)
 	}()
 
 	go func() {
@@ -143,6 +173,14 @@ func (c *comm) Start(cmd *packer.RemoteCmd) (err error) {
 			sessionLock.Lock()
 			defer sessionLock.Unlock()
 
+			// The command may have finished while we were checking, in
+			// which case the connection may be a new one after a reboot.
+			select {
+			case <-doneCh:
+				return
+			default:
+			}
+
 			// Kill the connection and mark that we timed out.
 			log.Printf("Too many SSH connection failures. Killing it!")
 			c.conn.Close()
@@ -156,6 +194,13 @@ func (c *comm) Start(cmd *packer.RemoteCmd) (err error) {
 }
 
 func (c *comm) Upload(path string, input io.Reader) error {
+	return c.UploadFile(path, input, new(packer.UploadOptions))
+}
+
+// UploadFile uploads the file with the mode in the options, which both
+// SCP and SFTP set as part of the upload. The owner and group are set
+// afterwards with a command.
+func (c *comm) UploadFile(path string, input io.Reader, opts *packer.UploadOptions) error {
 	// The target directory and file for talking the SCP protocol
 	target_dir := filepath.Dir(path)
 	target_file := filepath.Base(path)
@@ -164,16 +209,63 @@ func (c *comm) Upload(path string, input io.Reader) error {
 	// This does not work when the target host is unix.  Switch to forward slash
 	// which works for unix and windows
 	target_dir = filepath.ToSlash(target_dir)
+	target := target_dir + "/" + target_file
+
+	mode := opts.Mode.Perm()
+	if mode == 0 {
+		mode = 0644
+	}
+
+	var err error
+	if c.config.UseSftp {
+		err = c.sftpSession(func(client *sftpClient) error {
+			if err := sftpUploadFile(client, target, input, mode); err != nil {
+				return err
+			}
+
+			// The mode given when creating the file is subject to the
+			// umask on the other side and isn't applied to existing files.
+			if opts.Mode != 0 {
+				return client.setstat(target, &sftpAttrs{Mode: mode})
+			}
+
+			return nil
+		})
+	} else {
+		// Preserving the mode makes scp set it exactly, even on an
+		// existing file, rather than only use it to create the file.
+		scpCommand := "scp -vt "
+		if opts.Mode != 0 {
+			scpCommand = "scp -pvt "
+		}
+
+		scpFunc := func(w io.Writer, stdoutR *bufio.Reader) error {
+			return scpUploadFile(target_file, input, mode, w, stdoutR)
+		}
+
+		err = c.scpSession(scpCommand+target_dir, scpFunc)
+	}
 
-	scpFunc := func(w io.Writer, stdoutR *bufio.Reader) error {
-		return scpUploadFile(target_file, input, w, stdoutR)
+	if err != nil || (opts.Owner == "" && opts.Group == "") {
+		return err
 	}
 
-	return c.scpSession("scp -vt "+target_dir, scpFunc)
+	return c.runCommand(opts.RemoteCmd(target).ShellCommand(), nil)
 }
 
 func (c *comm) UploadDir(dst string, src string, excl []string) error {
 	log.Printf("Upload dir '%s' to '%s'", src, dst)
+	return c.uploadDir(dst, src, excludeUpload(excl))
+}
+
+// uploadDir uploads the files of the local directory src that u includes.
+func (c *comm) uploadDir(dst string, src string, u *dirUpload) error {
+	if c.config.UseSftp {
+		return c.sftpSession(func(client *sftpClient) error {
+			return c.sftpUploadDir(client, dst, src, u)
+		})
+	}
+
 	scpFunc := func(w io.Writer, r *bufio.Reader) error {
 		uploadEntries := func() error {
 			f, err := os.Open(src)
@@ -187,23 +279,83 @@ func (c *comm) UploadDir(dst string, src string, excl []string) error {
 				return err
 			}
 
-			return scpUploadDir(src, entries, w, r)
+			return scpUploadDir(src, "", entries, u, w, r)
 		}
 
-		if src[len(src)-1] != '/' {
+		if !strings.HasSuffix(src, "/") {
 			log.Printf("No trailing slash, creating the source directory name")
-			return scpUploadDirProtocol(filepath.Base(src), w, r, uploadEntries)
+			info, err := os.Stat(src)
+			if err != nil {
+				return err
+			}
+
+			return scpUploadDirProtocol(filepath.Base(src), info.Mode(), w, r, uploadEntries)
 		} else {
 			// Trailing slash, so only upload the contents
 			return uploadEntries()
 		}
 	}
 
-	return c.scpSession("scp -rvt "+dst, scpFunc)
+	// Preserving the modes makes scp set them exactly, like SFTP does
+	return c.scpSession("scp -rpvt "+dst, scpFunc)
+}
+
+func (c *comm) Download(path string, output io.Writer) error {
+	if c.config.UseSftp {
+		return c.sftpSession(func(client *sftpClient) error {
+			return sftpDownloadFile(client, filepath.ToSlash(path), output)
+		})
+	}
+
+	scpFunc := func(w io.Writer, r *bufio.Reader) error {
+		return scpDownloadFile(output, w, r)
+	}
+
+	return c.scpSession("scp -vf "+filepath.ToSlash(path), scpFunc)
 }
 
-func (c *comm) Download(string, io.Writer) error {
-	panic("not implemented yet")
+func (c *comm) DownloadDir(src string, dst string, excl []string) error {
+	log.Printf("Download dir '%s' to '%s'", src, dst)
+	contentsOnly := strings.HasSuffix(src, "/")
+	if c.config.UseSftp {
+		return c.sftpSession(func(client *sftpClient) error {
+			return sftpDownloadDir(client, filepath.ToSlash(src), dst, contentsOnly, excl)
+		})
+	}
+
+	scpFunc := func(w io.Writer, r *bufio.Reader) error {
+		return scpDownloadDir(dst, contentsOnly, excl, w, r)
+	}
+
+	return c.scpSession("scp -rvf "+filepath.ToSlash(src), scpFunc)
+}
+
+func (c *comm) sftpUploadDir(client *sftpClient, dst string, src string, u *dirUpload) error {
+	dst = filepath.ToSlash(dst)
+	uploadEntries := func(dst string) error {
+		entries, err := readDir(src)
+		if err != nil {
+			return err
+		}
+
+		return sftpUploadDir(client, dst, src, "", entries, u)
+	}
+
+	if strings.HasSuffix(src, "/") {
+		// Trailing slash, so only upload the contents
+		return uploadEntries(dst)
+	}
+
+	log.Printf("No trailing slash, creating the source directory name")
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	target := dst + "/" + filepath.Base(src)
+	return sftpUploadDirProtocol(client, target, info.Mode(), func() error {
+		return uploadEntries(target)
+	})
 }
 
 func (c *comm) newSession() (session *ssh.Session, err error) {
@@ -226,6 +378,31 @@ func (c *comm) newSession() (session *ssh.Session, err error) {
 	return session, nil
 }
 
+// waitForReconnect reconnects to the machine after the connection was
+// lost because it restarted, retrying until the timeout.
+func (c *comm) waitForReconnect(timeout time.Duration) error {
+	if timeout == 0 {
+		timeout = packer.DefaultReconnectTimeout
+	}
+
+	log.Printf("Waiting up to %s for SSH to reconnect", timeout)
+	deadline := time.Now().Add(timeout)
+	for {
+		// Give the machine a moment to actually go down, so that we
+		// don't reconnect to it while it is still shutting down.
+		time.Sleep(5 * time.Second)
+
+		err := c.reconnect()
+		if err == nil {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Timeout waiting for SSH to reconnect: %s", err)
+		}
+	}
+}
+
 func (c *comm) reconnect() (err error) {
 	if c.conn != nil {
 		c.conn.Close()
@@ -242,8 +419,15 @@ func (c *comm) reconnect() (err error) {
 		return
 	}
 
+	sshConfig := c.config.SSHConfig
+	if c.config.HostKeyChecker != nil {
+		configCopy := *sshConfig
+		configCopy.HostKeyChecker = c.config.HostKeyChecker
+		sshConfig = &configCopy
+	}
+
 	log.Printf("handshaking with SSH")
-	c.client, err = ssh.Client(c.conn, c.config.SSHConfig)
+	c.client, err = ssh.Client(c.conn, sshConfig)
 	if err != nil {
 		log.Printf("handshake error: %s", err)
 	}
@@ -331,6 +515,64 @@ func (c *comm) scpSession(scpCommand string, f func(io.Writer, *bufio.Reader) er
 	return nil
 }
 
+func (c *comm) sftpSession(f func(*sftpClient) error) error {
+	session, err := c.newSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	stdinW, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	// We only want to close once, just like with SCP
+	defer func() {
+		if stdinW != nil {
+			stdinW.Close()
+		}
+	}()
+
+	stdoutR, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	stderr := new(bytes.Buffer)
+	session.Stderr = stderr
+
+	log.Println("Starting remote SFTP subsystem")
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		return fmt.Errorf(
+			"SFTP failed to start. This usually means that the SFTP subsystem\n"+
+				"is not enabled on the remote system: %s", err)
+	}
+
+	client, err := newSftpClient(stdinW, stdoutR)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Started SFTP session, beginning transfers...")
+	if err := f(client); err != nil {
+		return err
+	}
+
+	// Closing stdin makes the SFTP server exit
+	log.Println("SFTP session complete, closing stdin pipe.")
+	stdinW.Close()
+	stdinW = nil
+
+	log.Println("Waiting for SSH session to complete.")
+	if err := session.Wait(); err != nil {
+		return err
+	}
+
+	log.Printf("sftp stderr (length %d): %s", stderr.Len(), stderr.String())
+	return nil
+}
+
 // checkSCPStatus checks that a prior command sent to SCP completed
 // successfully. If it did not complete successfully, an error will
 // be returned.
@@ -353,7 +595,224 @@ func checkSCPStatus(r *bufio.Reader) error {
 	return nil
 }
 
-func scpUploadFile(dst string, src io.Reader, w io.Writer, r *bufio.Reader) error {
+// scpEntry is a file or directory record sent by a remote SCP that
+// is running in source mode.
+type scpEntry struct {
+	Type byte
+	Mode os.FileMode
+	Size int64
+	Name string
+}
+
+// readSCPEntry reads the next record sent by a remote SCP in source mode,
+// acknowledging any timestamp records along the way. It returns io.EOF
+// once the remote side has no more records to send.
+func readSCPEntry(w io.Writer, r *bufio.Reader) (*scpEntry, error) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line != "" {
+				err = io.ErrUnexpectedEOF
+			}
+
+			return nil, err
+		}
+
+		line = strings.TrimRight(line, "\n")
+		if line == "" {
+			return nil, errors.New("Empty SCP record")
+		}
+
+		switch line[0] {
+		case '\x01', '\x02':
+			return nil, errors.New(line[1:])
+		case 'T':
+			fmt.Fprint(w, "\x00")
+			continue
+		case 'E':
+			return &scpEntry{Type: 'E'}, nil
+		case 'C', 'D':
+		default:
+			return nil, fmt.Errorf("Unexpected SCP record: %q", line)
+		}
+
+		parts := strings.SplitN(line[1:], " ", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("Invalid SCP record: %q", line)
+		}
+
+		mode, err := strconv.ParseUint(parts[0], 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid mode in SCP record: %q", line)
+		}
+
+		size, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("Invalid size in SCP record: %q", line)
+		}
+
+		name := parts[2]
+		if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("Invalid file name in SCP record: %q", line)
+		}
+
+		return &scpEntry{
+			Type: line[0],
+			Mode: os.FileMode(mode).Perm(),
+			Size: size,
+			Name: name,
+		}, nil
+	}
+}
+
+// scpDownloadContents reads the contents of a file whose record was just
+// read from the remote SCP and writes them to dst.
+func scpDownloadContents(dst io.Writer, size int64, w io.Writer, r *bufio.Reader) error {
+	fmt.Fprint(w, "\x00")
+	if _, err := io.CopyN(dst, r, size); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return err
+	}
+
+	if err := checkSCPStatus(r); err != nil {
+		return err
+	}
+
+	fmt.Fprint(w, "\x00")
+	return nil
+}
+
+func scpDownloadFile(dst io.Writer, w io.Writer, r *bufio.Reader) error {
+	// Tell the source that we're ready for the file
+	log.Println("Beginning file download...")
+	fmt.Fprint(w, "\x00")
+
+	entry, err := readSCPEntry(w, r)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return err
+	}
+
+	if entry.Type != 'C' {
+		return errors.New("Remote path to download is not a file")
+	}
+
+	return scpDownloadContents(dst, entry.Size, w, r)
+}
+
+func scpDownloadDir(dst string, contentsOnly bool, excl []string, w io.Writer, r *bufio.Reader) error {
+	// The stack of directories we're in. Each directory knows its local
+	// path, its path relative to the destination, and whether it is
+	// excluded, in which case everything within it is skipped.
+	type dir struct {
+		path string
+		rel  string
+		skip bool
+	}
+	dirs := []dir{dir{path: dst}}
+
+	log.Println("Beginning directory download...")
+	fmt.Fprint(w, "\x00")
+
+	first := true
+	for {
+		entry, err := readSCPEntry(w, r)
+		if err == io.EOF {
+			if len(dirs) != 1 {
+				return io.ErrUnexpectedEOF
+			}
+
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		current := dirs[len(dirs)-1]
+
+		if entry.Type == 'E' {
+			if len(dirs) == 1 {
+				return errors.New("Unexpected end of directory from SCP")
+			}
+
+			dirs = dirs[:len(dirs)-1]
+			fmt.Fprint(w, "\x00")
+			continue
+		}
+
+		// The first directory is the source itself, which excludes are
+		// relative to. It is only created if there is no trailing slash.
+		if first && entry.Type == 'D' {
+			first = false
+			path := dst
+			if !contentsOnly {
+				path = filepath.Join(dst, entry.Name)
+			}
+
+			if err := os.MkdirAll(path, entry.Mode|0700); err != nil {
+				return err
+			}
+
+			dirs = append(dirs, dir{path: path})
+			fmt.Fprint(w, "\x00")
+			continue
+		}
+		first = false
+
+		rel := entry.Name
+		if current.rel != "" {
+			rel = current.rel + "/" + entry.Name
+		}
+
+		path := filepath.Join(current.path, entry.Name)
+		skip := current.skip || packer.ExcludeMatch(rel, excl)
+
+		if entry.Type == 'D' {
+			if !skip {
+				if err := os.MkdirAll(path, entry.Mode|0700); err != nil {
+					return err
+				}
+			}
+
+			dirs = append(dirs, dir{path: path, rel: rel, skip: skip})
+			fmt.Fprint(w, "\x00")
+			continue
+		}
+
+		if skip {
+			log.Printf("SCP: skipping excluded file: %s", rel)
+			if err := scpDownloadContents(ioutil.Discard, entry.Size, w, r); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := os.MkdirAll(current.path, 0755); err != nil {
+			return err
+		}
+
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, entry.Mode)
+		if err != nil {
+			return err
+		}
+
+		err = scpDownloadContents(f, entry.Size, w, r)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func scpUploadFile(dst string, src io.Reader, mode os.FileMode, w io.Writer, r *bufio.Reader) error {
 	// Determine the length of the upload content by copying it
 	// into an in-memory buffer. Note that this means what we upload
 	// must fit into memory.
@@ -365,7 +824,7 @@ func scpUploadFile(dst string, src io.Reader, w io.Writer, r *bufio.Reader) erro
 
 	// Start the protocol
 	log.Println("Beginning file upload...")
-	fmt.Fprintln(w, "C0644", inputBuf.Len(), dst)
+	fmt.Fprintf(w, "C%04o %d %s\n", uint32(mode.Perm()), inputBuf.Len(), dst)
 	err := checkSCPStatus(r)
 	if err != nil {
 		return err
@@ -384,9 +843,16 @@ func scpUploadFile(dst string, src io.Reader, w io.Writer, r *bufio.Reader) erro
 	return nil
 }
 
-func scpUploadDirProtocol(name string, w io.Writer, r *bufio.Reader, f func() error) error {
+// scpUploadTimes sets the modification time of the file that is uploaded
+// next.
+func scpUploadTimes(mtime time.Time, w io.Writer, r *bufio.Reader) error {
+	fmt.Fprintf(w, "T%d 0 %d 0\n", mtime.Unix(), mtime.Unix())
+	return checkSCPStatus(r)
+}
+
+func scpUploadDirProtocol(name string, mode os.FileMode, w io.Writer, r *bufio.Reader, f func() error) error {
 	log.Printf("SCP: starting directory upload: %s", name)
-	fmt.Fprintln(w, "D0755 0", name)
+	fmt.Fprintf(w, "D%04o 0 %s\n", uint32(mode.Perm()), name)
 	err := checkSCPStatus(r)
 	if err != nil {
 		return err
@@ -404,15 +870,42 @@ func scpUploadDirProtocol(name string, w io.Writer, r *bufio.Reader, f func() er
 	return nil
 }
 
-func scpUploadDir(root string, fs []os.FileInfo, w io.Writer, r *bufio.Reader) error {
+// dirUpload decides which of the files in a local directory are uploaded.
+type dirUpload struct {
+	// include returns true if the file or directory, at the given path
+	// relative to the directory being uploaded, is uploaded.
+	include func(rel string, dir bool) bool
+
+	// times, if true, preserves the modification times of the files.
+	times bool
+}
+
+// excludeUpload returns a dirUpload that uploads everything except the
+// paths matching the exclude patterns.
+func excludeUpload(excl []string) *dirUpload {
+	return &dirUpload{
+		include: func(rel string, dir bool) bool {
+			if packer.ExcludeMatch(rel, excl) {
+				log.Printf("Skipping excluded path: %s", rel)
+				return false
+			}
+
+			return true
+		},
+	}
+}
+
+func scpUploadDir(root string, rel string, fs []os.FileInfo, u *dirUpload, w io.Writer, r *bufio.Reader) error {
 	for _, fi := range fs {
 		realPath := filepath.Join(root, fi.Name())
+		entryRel := path.Join(rel, fi.Name())
 
 		// Track if this is actually a symlink to a directory. If it is
 		// a symlink to a file we don't do any special behavior because uploading
 		// a file just works. If it is a directory, we need to know so we
 		// treat it as such.
 		isSymlinkToDir := false
+		dirMode := fi.Mode()
 		if fi.Mode()&os.ModeSymlink == os.ModeSymlink {
 			symPath, err := filepath.EvalSymlinks(realPath)
 			if err != nil {
@@ -425,6 +918,11 @@ func scpUploadDir(root string, fs []os.FileInfo, w io.Writer, r *bufio.Reader) e
 			}
 
 			isSymlinkToDir = symFi.IsDir()
+			dirMode = symFi.Mode()
+		}
+
+		if !u.include(entryRel, fi.IsDir() || isSymlinkToDir) {
+			continue
 		}
 
 		if !fi.IsDir() && !isSymlinkToDir {
@@ -436,7 +934,18 @@ func scpUploadDir(root string, fs []os.FileInfo, w io.Writer, r *bufio.Reader) e
 
 			err = func() error {
 				defer f.Close()
-				return scpUploadFile(fi.Name(), f, w, r)
+				info, err := f.Stat()
+				if err != nil {
+					return err
+				}
+
+				if u.times {
+					if err := scpUploadTimes(info.ModTime(), w, r); err != nil {
+						return err
+					}
+				}
+
+				return scpUploadFile(fi.Name(), f, info.Mode(), w, r)
 			}()
 
 			if err != nil {
@@ -447,7 +956,7 @@ func scpUploadDir(root string, fs []os.FileInfo, w io.Writer, r *bufio.Reader) e
 		}
 
 		// It is a directory, recursively upload
-		err := scpUploadDirProtocol(fi.Name(), w, r, func() error {
+		err := scpUploadDirProtocol(fi.Name(), dirMode, w, r, func() error {
 			f, err := os.Open(realPath)
 			if err != nil {
 				return err
@@ -459,7 +968,7 @@ func scpUploadDir(root string, fs []os.FileInfo, w io.Writer, r *bufio.Reader) e
 				return err
 			}
 
-			return scpUploadDir(realPath, entries, w, r)
+			return scpUploadDir(realPath, entryRel, entries, u, w, r)
 		})
 		if err != nil {
 			return err
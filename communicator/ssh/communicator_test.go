@@ -3,10 +3,16 @@
 package ssh
 
 import (
+	"bufio"
 	"bytes"
 	"code.google.com/p/go.crypto/ssh"
 	"github.com/mitchellh/packer/packer"
+	"io"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -107,6 +113,14 @@ func TestCommIsCommunicator(t *testing.T) {
 	if _, ok := raw.(packer.Communicator); !ok {
 		t.Fatalf("comm must be a communicator")
 	}
+
+	if _, ok := raw.(packer.DirSyncer); !ok {
+		t.Fatalf("comm must be a dir syncer")
+	}
+
+	if _, ok := raw.(packer.FileUploader); !ok {
+		t.Fatalf("comm must be a file uploader")
+	}
 }
 
 func TestNew_Invalid(t *testing.T) {
@@ -169,3 +183,177 @@ func TestStart(t *testing.T) {
 
 	client.Start(&cmd)
 }
+
+func TestScpDownloadFile(t *testing.T) {
+	source := "T1234 0 1234 0\nC0644 4 foo\nfoo\n\x00"
+	r := bufio.NewReader(strings.NewReader(source))
+	w := new(bytes.Buffer)
+	dst := new(bytes.Buffer)
+
+	if err := scpDownloadFile(dst, w, r); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if dst.String() != "foo\n" {
+		t.Fatalf("bad: %q", dst.String())
+	}
+
+	// Ready, timestamp, file record, and the file contents are acked
+	if w.String() != "\x00\x00\x00\x00" {
+		t.Fatalf("bad: %q", w.String())
+	}
+}
+
+func TestScpDownloadFile_error(t *testing.T) {
+	source := "\x01scp: /foo: No such file or directory\n"
+	r := bufio.NewReader(strings.NewReader(source))
+
+	err := scpDownloadFile(new(bytes.Buffer), new(bytes.Buffer), r)
+	if err == nil {
+		t.Fatal("should error")
+	}
+
+	if err.Error() != "scp: /foo: No such file or directory" {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestScpDownloadFile_truncated(t *testing.T) {
+	source := "C0644 10 foo\nfoo\n"
+	r := bufio.NewReader(strings.NewReader(source))
+
+	err := scpDownloadFile(new(bytes.Buffer), new(bytes.Buffer), r)
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("bad: %#v", err)
+	}
+}
+
+func TestScpDownloadDir(t *testing.T) {
+	source := "D0755 0 data\n" +
+		"C0644 4 foo\nfoo\n\x00" +
+		"D0755 0 sub\n" +
+		"C0600 4 bar\nbar\n\x00" +
+		"E\n" +
+		"D0755 0 skip\n" +
+		"C0644 4 baz\nbaz\n\x00" +
+		"E\n" +
+		"E\n"
+
+	cases := []struct {
+		ContentsOnly bool
+		Root         string
+	}{
+		{false, "data"},
+		{true, ""},
+	}
+
+	for _, tc := range cases {
+		dst, err := ioutil.TempDir("", "packer")
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		defer os.RemoveAll(dst)
+
+		r := bufio.NewReader(strings.NewReader(source))
+		err = scpDownloadDir(dst, tc.ContentsOnly, []string{"skip"}, new(bytes.Buffer), r)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		root := filepath.Join(dst, tc.Root)
+		data, err := ioutil.ReadFile(filepath.Join(root, "sub", "bar"))
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if string(data) != "bar\n" {
+			t.Fatalf("bad: %q", data)
+		}
+
+		fi, err := os.Stat(filepath.Join(root, "sub", "bar"))
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if fi.Mode().Perm() != 0600 {
+			t.Fatalf("bad mode: %s", fi.Mode())
+		}
+
+		if _, err := os.Stat(filepath.Join(root, "foo")); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if _, err := os.Stat(filepath.Join(root, "skip")); !os.IsNotExist(err) {
+			t.Fatalf("excluded directory should not exist: %s", err)
+		}
+	}
+}
+
+func TestScpDownloadDir_invalidName(t *testing.T) {
+	dst, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dst)
+
+	source := "D0755 0 data\nC0644 4 ../foo\nfoo\n\x00E\n"
+	r := bufio.NewReader(strings.NewReader(source))
+	err = scpDownloadDir(dst, false, nil, new(bytes.Buffer), r)
+	if err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestScpUploadFile(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("\x00\x00"))
+	w := new(bytes.Buffer)
+	if err := scpUploadFile("foo.sh", strings.NewReader("foo\n"), 0755, w, r); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if w.String() != "C0755 4 foo.sh\nfoo\n\x00" {
+		t.Fatalf("bad: %q", w.String())
+	}
+}
+
+func TestScpUploadDir_modes(t *testing.T) {
+	src, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(src)
+
+	if err := os.Mkdir(filepath.Join(src, "sub"), 0700); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	script := filepath.Join(src, "sub", "run.sh")
+	if err := ioutil.WriteFile(script, []byte("foo\n"), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Set the modes exactly, whatever the umask is
+	if err := os.Chmod(filepath.Join(src, "sub"), 0700); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := os.Chmod(script, 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	entries, err := readDir(src)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	r := bufio.NewReader(strings.NewReader(strings.Repeat("\x00", 3)))
+	w := new(bytes.Buffer)
+	if err := scpUploadDir(src, "", entries, excludeUpload(nil), w, r); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := "D0700 0 sub\nC0755 4 run.sh\nfoo\n\x00E\n"
+	if w.String() != expected {
+		t.Fatalf("bad: %q", w.String())
+	}
+}
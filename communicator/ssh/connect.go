@@ -1,6 +1,8 @@
 package ssh
 
 import (
+	"code.google.com/p/go.crypto/ssh"
+	"fmt"
 	"log"
 	"net"
 	"time"
@@ -15,3 +17,65 @@ func ConnectFunc(network, addr string) func() (net.Conn, error) {
 		return net.DialTimeout(network, addr, 15*time.Second)
 	}
 }
+
+// BastionConnectFunc returns a function that connects to the remote end
+// through an SSH connection to a bastion host, for machines that can't
+// be reached directly. The connection to the bastion is made with the
+// bastion function and authenticated with the given configuration.
+func BastionConnectFunc(bastion func() (net.Conn, error), config *ssh.ClientConfig, network, addr string) func() (net.Conn, error) {
+	return func() (net.Conn, error) {
+		conn, err := bastion()
+		if err != nil {
+			return nil, fmt.Errorf("Error connecting to bastion: %s", err)
+		}
+
+		log.Printf("Handshaking with SSH bastion")
+		client, err := ssh.Client(conn, config)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Error connecting to bastion: %s", err)
+		}
+
+		log.Printf("Opening conn for SSH to %s %s through bastion", network, addr)
+		target, err := client.Dial(network, addr)
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+
+		return &bastionConn{target, client}, nil
+	}
+}
+
+// KeepAliveConnectFunc wraps a function that makes TCP connections so
+// that TCP keepalives are sent on them at the given interval. This keeps
+// idle connections through firewalls, NAT and bastion hosts from being
+// dropped during long running commands.
+func KeepAliveConnectFunc(connect func() (net.Conn, error), interval time.Duration) func() (net.Conn, error) {
+	return func() (net.Conn, error) {
+		conn, err := connect()
+		if err != nil {
+			return nil, err
+		}
+
+		if tcpConn, ok := conn.(*net.TCPConn); ok && interval > 0 {
+			tcpConn.SetKeepAlive(true)
+			tcpConn.SetKeepAlivePeriod(interval)
+		}
+
+		return conn, nil
+	}
+}
+
+// bastionConn is a connection made through a bastion host. Closing it
+// also closes the connection to the bastion.
+type bastionConn struct {
+	net.Conn
+	client *ssh.ClientConn
+}
+
+func (c *bastionConn) Close() error {
+	err := c.Conn.Close()
+	c.client.Close()
+	return err
+}
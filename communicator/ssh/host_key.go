@@ -0,0 +1,301 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// The policies a HostKeyChecker can verify host keys with.
+const (
+	// HostKeyAccept accepts whatever host key is presented first.
+	HostKeyAccept = "accept"
+
+	// HostKeyFingerprint only accepts a host key with a given fingerprint.
+	HostKeyFingerprint = "fingerprint"
+
+	// HostKeyKnownHosts only accepts a host key that is listed for the
+	// host in a known_hosts file.
+	HostKeyKnownHosts = "known_hosts"
+)
+
+// HostKeyChecker verifies the host key presented by the remote end
+// according to a policy. The first host key that is accepted is recorded,
+// and every later connection, such as a reconnect after the machine
+// reboots, must present that same key.
+//
+// HostKeyChecker implements the ssh.HostKeyChecker interface.
+type HostKeyChecker struct {
+	// Host is the address being connected to, as "host:port". This
+	// is the host that is looked up in the known_hosts file.
+	Host string
+
+	// Policy is one of the HostKeyAccept, HostKeyFingerprint or
+	// HostKeyKnownHosts constants. It defaults to HostKeyAccept.
+	Policy string
+
+	// Fingerprint is the fingerprint the host key must have with the
+	// HostKeyFingerprint policy. This is either the MD5 fingerprint,
+	// such as "43:51:43:a1:...", or the SHA256 fingerprint, such as
+	// "SHA256:nThbg6k...".
+	Fingerprint string
+
+	// KnownHostsPath is the path to the known_hosts file to use with
+	// the HostKeyKnownHosts policy.
+	KnownHostsPath string
+
+	l         sync.Mutex
+	algorithm string
+	key       []byte
+}
+
+func (h *HostKeyChecker) Check(addr string, remote net.Addr, algorithm string, key []byte) error {
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	if h.key != nil {
+		if !bytes.Equal(h.key, key) {
+			return fmt.Errorf(
+				"Host key for %s changed since it was first accepted. It was %s, "+
+					"and is now %s. This could mean the connection was made to a "+
+					"different machine.",
+				h.Host, FingerprintMD5(h.key), FingerprintMD5(key))
+		}
+
+		return nil
+	}
+
+	switch h.Policy {
+	case "", HostKeyAccept:
+	case HostKeyFingerprint:
+		if !fingerprintMatches(h.Fingerprint, key) {
+			return fmt.Errorf(
+				"Host key for %s has fingerprint %s, expected %s",
+				h.Host, FingerprintMD5(key), h.Fingerprint)
+		}
+	case HostKeyKnownHosts:
+		if err := knownHostsCheck(h.KnownHostsPath, h.Host, key); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Unknown host key policy: %s", h.Policy)
+	}
+
+	log.Printf("Accepted %s host key for %s: %s", algorithm, h.Host, FingerprintMD5(key))
+	h.algorithm = algorithm
+	h.key = make([]byte, len(key))
+	copy(h.key, key)
+	return nil
+}
+
+// HostKey returns the host key that was accepted in the format used by
+// known_hosts files, without the host, such as "ssh-rsa AAAAB3Nza...".
+// An empty string is returned if no host key was accepted yet.
+func (h *HostKeyChecker) HostKey() string {
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	if h.key == nil {
+		return ""
+	}
+
+	return fmt.Sprintf(
+		"%s %s", h.algorithm, base64.StdEncoding.EncodeToString(h.key))
+}
+
+// FingerprintMD5 returns the MD5 fingerprint of a public key in the
+// format ssh-keygen shows it, such as "43:51:43:a1:b5:fc:8b:b7:...".
+func FingerprintMD5(key []byte) string {
+	sum := md5.Sum(key)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02x", b)
+	}
+
+	return strings.Join(parts, ":")
+}
+
+// FingerprintSHA256 returns the SHA256 fingerprint of a public key in
+// the format ssh-keygen shows it, such as "SHA256:nThbg6kXUpJWGl7E1I...".
+func FingerprintSHA256(key []byte) string {
+	sum := sha256.Sum256(key)
+	return "SHA256:" + strings.TrimRight(
+		base64.StdEncoding.EncodeToString(sum[:]), "=")
+}
+
+func fingerprintMatches(fingerprint string, key []byte) bool {
+	if strings.HasPrefix(fingerprint, "SHA256:") {
+		return strings.TrimRight(fingerprint, "=") == FingerprintSHA256(key)
+	}
+
+	fingerprint = strings.TrimPrefix(fingerprint, "MD5:")
+	return strings.ToLower(fingerprint) == FingerprintMD5(key)
+}
+
+// knownHostsCheck verifies that the known_hosts file at the given path
+// lists the key for the host, which is given as "host:port".
+func knownHostsCheck(knownHostsPath string, host string, key []byte) error {
+	name, err := knownHostsName(host)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(knownHostsPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	found := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		marker := ""
+		if fields[0][0] == '@' {
+			marker = fields[0]
+			fields = fields[1:]
+		}
+
+		if len(fields) < 3 || marker == "@cert-authority" {
+			continue
+		}
+
+		if !knownHostsMatch(fields[0], name) {
+			continue
+		}
+
+		lineKey, err := base64.StdEncoding.DecodeString(fields[2])
+		if err != nil || !bytes.Equal(lineKey, key) {
+			continue
+		}
+
+		if marker == "@revoked" {
+			return fmt.Errorf("Host key for %s is revoked in %s", host, knownHostsPath)
+		}
+
+		found = true
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if !found {
+		return fmt.Errorf(
+			"Host key for %s with fingerprint %s isn't in %s",
+			host, FingerprintMD5(key), knownHostsPath)
+	}
+
+	return nil
+}
+
+// knownHostsName returns the name a host is listed as in known_hosts
+// files. Hosts on the default port are listed by just their name, and
+// hosts on other ports as "[host]:port".
+func knownHostsName(host string) (string, error) {
+	hostname, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return "", err
+	}
+
+	hostname = strings.ToLower(hostname)
+	if port == "22" {
+		return hostname, nil
+	}
+
+	return fmt.Sprintf("[%s]:%s", hostname, port), nil
+}
+
+// knownHostsMatch returns true if the host patterns of a known_hosts
+// line match the host name. The patterns are either a comma separated
+// list with "*" and "?" wildcards and "!" negations, or a single hashed
+// name such as "|1|salt|hash".
+func knownHostsMatch(patterns string, name string) bool {
+	if strings.HasPrefix(patterns, "|1|") {
+		parts := strings.Split(patterns[3:], "|")
+		if len(parts) != 2 {
+			return false
+		}
+
+		salt, err := base64.StdEncoding.DecodeString(parts[0])
+		if err != nil {
+			return false
+		}
+
+		hash, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return false
+		}
+
+		mac := hmac.New(sha1.New, salt)
+		mac.Write([]byte(name))
+		return hmac.Equal(mac.Sum(nil), hash)
+	}
+
+	matched := false
+	for _, pattern := range strings.Split(patterns, ",") {
+		negate := strings.HasPrefix(pattern, "!")
+		if negate {
+			pattern = pattern[1:]
+		}
+
+		if !wildcardMatch(strings.ToLower(pattern), name) {
+			continue
+		}
+
+		if negate {
+			return false
+		}
+
+		matched = true
+	}
+
+	return matched
+}
+
+// wildcardMatch matches a name against a known_hosts pattern, where "*"
+// matches any number of characters and "?" matches exactly one. Unlike
+// with path.Match, brackets have no special meaning, since they're used
+// for hosts on non-default ports.
+func wildcardMatch(pattern string, name string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(name); i >= 0; i-- {
+				if wildcardMatch(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+
+			return false
+		case '?':
+			if len(name) == 0 {
+				return false
+			}
+		default:
+			if len(name) == 0 || pattern[0] != name[0] {
+				return false
+			}
+		}
+
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+
+	return len(name) == 0
+}
@@ -0,0 +1,160 @@
+package ssh
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+var testHostKey = []byte("\x00\x00\x00\x07ssh-rsa\x00\x00\x00\x01\x23\x00\x00\x00\x03abc")
+var testOtherHostKey = []byte("\x00\x00\x00\x07ssh-rsa\x00\x00\x00\x01\x23\x00\x00\x00\x03xyz")
+
+func testKnownHosts(t *testing.T, lines ...string) string {
+	tf, err := ioutil.TempFile("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer tf.Close()
+
+	if _, err := tf.Write([]byte(strings.Join(lines, "\n"))); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return tf.Name()
+}
+
+func TestHostKeyChecker_accept(t *testing.T) {
+	h := &HostKeyChecker{Host: "example.com:22"}
+	if h.HostKey() != "" {
+		t.Fatalf("bad: %s", h.HostKey())
+	}
+
+	if err := h.Check("", nil, "ssh-rsa", testHostKey); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := "ssh-rsa " + base64.StdEncoding.EncodeToString(testHostKey)
+	if h.HostKey() != expected {
+		t.Fatalf("bad: %s", h.HostKey())
+	}
+
+	// Reconnecting with the same key is fine
+	if err := h.Check("", nil, "ssh-rsa", testHostKey); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// A different key is never accepted once one is recorded
+	if err := h.Check("", nil, "ssh-rsa", testOtherHostKey); err == nil {
+		t.Fatal("should error on a changed host key")
+	}
+
+	if h.HostKey() != expected {
+		t.Fatalf("bad: %s", h.HostKey())
+	}
+}
+
+func TestHostKeyChecker_fingerprint(t *testing.T) {
+	fingerprints := []string{
+		FingerprintMD5(testHostKey),
+		"MD5:" + strings.ToUpper(FingerprintMD5(testHostKey)),
+		FingerprintSHA256(testHostKey),
+	}
+
+	for _, fingerprint := range fingerprints {
+		h := &HostKeyChecker{
+			Host:        "example.com:22",
+			Policy:      HostKeyFingerprint,
+			Fingerprint: fingerprint,
+		}
+
+		if err := h.Check("", nil, "ssh-rsa", testOtherHostKey); err == nil {
+			t.Fatalf("should not match: %s", fingerprint)
+		}
+
+		if err := h.Check("", nil, "ssh-rsa", testHostKey); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+}
+
+func TestHostKeyChecker_knownHosts(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(testHostKey)
+	otherKey := base64.StdEncoding.EncodeToString(testOtherHostKey)
+
+	salt := []byte("0123456789abcdefghij")
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte("[hashed.com]:2222"))
+	hashed := fmt.Sprintf("|1|%s|%s",
+		base64.StdEncoding.EncodeToString(salt),
+		base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+
+	path := testKnownHosts(t,
+		"# comment",
+		"",
+		"foo.com,bar.com ssh-rsa "+key+" comment",
+		"[foo.com]:2222 ssh-rsa "+otherKey,
+		"*.example.com,!bad.example.com ssh-rsa "+key,
+		hashed+" ssh-rsa "+key,
+		"revoked.com ssh-rsa "+key,
+		"@revoked revoked.com ssh-rsa "+key)
+	defer os.Remove(path)
+
+	cases := []struct {
+		Host string
+		Key  []byte
+		Ok   bool
+	}{
+		{"foo.com:22", testHostKey, true},
+		{"BAR.com:22", testHostKey, true},
+		{"foo.com:22", testOtherHostKey, false},
+		{"foo.com:2222", testOtherHostKey, true},
+		{"foo.com:2222", testHostKey, false},
+		{"baz.com:22", testHostKey, false},
+		{"a.example.com:22", testHostKey, true},
+		{"bad.example.com:22", testHostKey, false},
+		{"hashed.com:2222", testHostKey, true},
+		{"hashed.com:22", testHostKey, false},
+		{"revoked.com:22", testHostKey, false},
+	}
+
+	for _, tc := range cases {
+		h := &HostKeyChecker{
+			Host:           tc.Host,
+			Policy:         HostKeyKnownHosts,
+			KnownHostsPath: path,
+		}
+
+		err := h.Check("", nil, "ssh-rsa", tc.Key)
+		if (err == nil) != tc.Ok {
+			t.Fatalf("bad: %s %#v: %s", tc.Host, tc.Ok, err)
+		}
+	}
+}
+
+func TestWildcardMatch(t *testing.T) {
+	cases := []struct {
+		Pattern string
+		Name    string
+		Match   bool
+	}{
+		{"foo.com", "foo.com", true},
+		{"foo.com", "foo.co", false},
+		{"*.com", "foo.com", true},
+		{"*", "", true},
+		{"f?o.com", "foo.com", true},
+		{"f?o.com", "fo.com", false},
+		{"[10.0.0.?]:2222", "[10.0.0.1]:2222", true},
+		{"[10.0.0.?]:2222", "10.0.0.1", false},
+	}
+
+	for _, tc := range cases {
+		if wildcardMatch(tc.Pattern, tc.Name) != tc.Match {
+			t.Fatalf("bad: %#v", tc)
+		}
+	}
+}
@@ -0,0 +1,710 @@
+package ssh
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/mitchellh/packer/packer"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// The version of the SFTP protocol that we speak. Version 3 is the one
+// implemented by OpenSSH and most other servers.
+const sftpVersion = 3
+
+// The size of the chunks that files are read and written in. All
+// servers must support packets of at least 32KB.
+const sftpChunkSize = 32 * 1024
+
+// The largest packet we accept from the server, to protect against
+// allocating huge buffers for a corrupt length.
+const sftpMaxPacket = 256 * 1024
+
+// SFTP packet types
+const (
+	sftpPacketInit    = 1
+	sftpPacketVersion = 2
+	sftpPacketOpen    = 3
+	sftpPacketClose   = 4
+	sftpPacketRead    = 5
+	sftpPacketWrite   = 6
+	sftpPacketSetstat = 9
+	sftpPacketOpendir = 11
+	sftpPacketReaddir = 12
+	sftpPacketRemove  = 13
+	sftpPacketMkdir   = 14
+	sftpPacketRmdir   = 15
+	sftpPacketStat    = 17
+	sftpPacketStatus  = 101
+	sftpPacketHandle  = 102
+	sftpPacketData    = 103
+	sftpPacketName    = 104
+	sftpPacketAttrs   = 105
+)
+
+// SFTP status codes
+const (
+	sftpStatusOk  = 0
+	sftpStatusEOF = 1
+)
+
+// Flags for opening files
+const (
+	sftpOpenRead  = 0x01
+	sftpOpenWrite = 0x02
+	sftpOpenCreat = 0x08
+	sftpOpenTrunc = 0x10
+)
+
+// Flags for the fields present in file attributes
+const (
+	sftpAttrSize        = 0x00000001
+	sftpAttrUidGid      = 0x00000002
+	sftpAttrPermissions = 0x00000004
+	sftpAttrAcModTime   = 0x00000008
+	sftpAttrExtended    = 0x80000000
+)
+
+// sftpStatusError is an error status returned by the SFTP server.
+type sftpStatusError struct {
+	Code    uint32
+	Message string
+}
+
+func (e *sftpStatusError) Error() string {
+	return fmt.Sprintf("SFTP error (code %d): %s", e.Code, e.Message)
+}
+
+// sftpAttrs are the attributes of a remote file that we care about.
+type sftpAttrs struct {
+	Size uint64
+	Mode os.FileMode
+
+	// Mtime is the modification time of the file in seconds since the
+	// epoch. When setting attributes, it is only set if it isn't zero.
+	Mtime uint32
+}
+
+// sftpEntry is a single entry read from a remote directory.
+type sftpEntry struct {
+	Name  string
+	Attrs *sftpAttrs
+}
+
+// sftpClient is a minimal client for version 3 of the SFTP protocol,
+// talking to an SFTP server over the given reader and writer. Requests
+// are made one at a time.
+type sftpClient struct {
+	w      io.Writer
+	r      io.Reader
+	nextId uint32
+}
+
+func newSftpClient(w io.Writer, r io.Reader) (*sftpClient, error) {
+	c := &sftpClient{w: w, r: r}
+	if err := c.writePacket(sftpPacketInit, uint32(sftpVersion)); err != nil {
+		return nil, err
+	}
+
+	typ, d, err := c.readPacket()
+	if err != nil {
+		return nil, err
+	}
+
+	if typ != sftpPacketVersion {
+		return nil, fmt.Errorf("Unexpected SFTP packet type: %d", typ)
+	}
+
+	version := d.uint32()
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	if version < sftpVersion {
+		return nil, fmt.Errorf("Unsupported SFTP version: %d", version)
+	}
+
+	return c, nil
+}
+
+func (c *sftpClient) open(path string, flags uint32, attrs *sftpAttrs) (string, error) {
+	return c.handle(c.request(sftpPacketOpen, path, flags, attrs))
+}
+
+func (c *sftpClient) opendir(path string) (string, error) {
+	return c.handle(c.request(sftpPacketOpendir, path))
+}
+
+func (c *sftpClient) close(handle string) error {
+	return c.status(c.request(sftpPacketClose, handle))
+}
+
+// read reads up to n bytes from the given offset of an open file. It
+// returns io.EOF once the end of the file is reached.
+func (c *sftpClient) read(handle string, offset uint64, n uint32) ([]byte, error) {
+	typ, d, err := c.request(sftpPacketRead, handle, offset, n)
+	if err != nil {
+		return nil, err
+	}
+
+	if typ != sftpPacketData {
+		return nil, c.status(typ, d, nil)
+	}
+
+	data := d.string()
+	return []byte(data), d.err
+}
+
+func (c *sftpClient) write(handle string, offset uint64, data []byte) error {
+	return c.status(c.request(sftpPacketWrite, handle, offset, data))
+}
+
+func (c *sftpClient) stat(path string) (*sftpAttrs, error) {
+	typ, d, err := c.request(sftpPacketStat, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if typ != sftpPacketAttrs {
+		return nil, c.status(typ, d, nil)
+	}
+
+	attrs := d.attrs()
+	return attrs, d.err
+}
+
+func (c *sftpClient) setstat(path string, attrs *sftpAttrs) error {
+	return c.status(c.request(sftpPacketSetstat, path, attrs))
+}
+
+func (c *sftpClient) mkdir(path string, attrs *sftpAttrs) error {
+	return c.status(c.request(sftpPacketMkdir, path, attrs))
+}
+
+func (c *sftpClient) remove(path string) error {
+	return c.status(c.request(sftpPacketRemove, path))
+}
+
+func (c *sftpClient) rmdir(path string) error {
+	return c.status(c.request(sftpPacketRmdir, path))
+}
+
+// readdir reads the next batch of entries from an open directory. It
+// returns io.EOF once there are no more entries.
+func (c *sftpClient) readdir(handle string) ([]sftpEntry, error) {
+	typ, d, err := c.request(sftpPacketReaddir, handle)
+	if err != nil {
+		return nil, err
+	}
+
+	if typ != sftpPacketName {
+		return nil, c.status(typ, d, nil)
+	}
+
+	count := d.uint32()
+	result := make([]sftpEntry, 0, count)
+	for i := uint32(0); i < count && d.err == nil; i++ {
+		name := d.string()
+		d.string() // The long name, which is only for humans
+		result = append(result, sftpEntry{Name: name, Attrs: d.attrs()})
+	}
+
+	return result, d.err
+}
+
+// request sends a request and reads the response to it, returning the
+// type of the response and a decoder for the rest of it.
+func (c *sftpClient) request(typ byte, args ...interface{}) (byte, *sftpDecoder, error) {
+	id := c.nextId
+	c.nextId++
+
+	args = append([]interface{}{id}, args...)
+	if err := c.writePacket(typ, args...); err != nil {
+		return 0, nil, err
+	}
+
+	respTyp, d, err := c.readPacket()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if respId := d.uint32(); d.err == nil && respId != id {
+		return 0, nil, fmt.Errorf("Unexpected SFTP response ID: %d", respId)
+	}
+
+	return respTyp, d, d.err
+}
+
+// handle turns the response to a request that opens something into
+// the handle that was opened.
+func (c *sftpClient) handle(typ byte, d *sftpDecoder, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+
+	if typ != sftpPacketHandle {
+		return "", c.status(typ, d, nil)
+	}
+
+	handle := d.string()
+	return handle, d.err
+}
+
+// status turns a status response into an error, which is nil if the
+// request succeeded and io.EOF if the end of a file or directory was
+// reached.
+func (c *sftpClient) status(typ byte, d *sftpDecoder, err error) error {
+	if err != nil {
+		return err
+	}
+
+	if typ != sftpPacketStatus {
+		return fmt.Errorf("Unexpected SFTP packet type: %d", typ)
+	}
+
+	code := d.uint32()
+	message := d.string()
+	if d.err != nil {
+		return d.err
+	}
+
+	switch code {
+	case sftpStatusOk:
+		return nil
+	case sftpStatusEOF:
+		return io.EOF
+	default:
+		return &sftpStatusError{code, message}
+	}
+}
+
+func (c *sftpClient) writePacket(typ byte, args ...interface{}) error {
+	data := []byte{0, 0, 0, 0, typ}
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case uint32:
+			data = appendUint32(data, v)
+		case uint64:
+			data = appendUint32(data, uint32(v>>32))
+			data = appendUint32(data, uint32(v))
+		case string:
+			data = appendUint32(data, uint32(len(v)))
+			data = append(data, v...)
+		case []byte:
+			data = appendUint32(data, uint32(len(v)))
+			data = append(data, v...)
+		case *sftpAttrs:
+			// We only ever set the permissions and times of files
+			switch {
+			case v == nil:
+				data = appendUint32(data, 0)
+			case v.Mtime == 0:
+				data = appendUint32(data, sftpAttrPermissions)
+				data = appendUint32(data, uint32(v.Mode.Perm()))
+			default:
+				data = appendUint32(data, sftpAttrPermissions|sftpAttrAcModTime)
+				data = appendUint32(data, uint32(v.Mode.Perm()))
+				data = appendUint32(data, v.Mtime)
+				data = appendUint32(data, v.Mtime)
+			}
+		default:
+			panic(fmt.Sprintf("unknown SFTP argument type: %T", arg))
+		}
+	}
+
+	binary.BigEndian.PutUint32(data, uint32(len(data)-4))
+	_, err := c.w.Write(data)
+	return err
+}
+
+func (c *sftpClient) readPacket() (byte, *sftpDecoder, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[:4])
+	if length < 1 || length > sftpMaxPacket {
+		return 0, nil, fmt.Errorf("Invalid SFTP packet length: %d", length)
+	}
+
+	data := make([]byte, length-1)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return 0, nil, err
+	}
+
+	return header[4], &sftpDecoder{data: data}, nil
+}
+
+func appendUint32(data []byte, v uint32) []byte {
+	return append(data, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// sftpDecoder decodes the fields of a packet. Once a field can't be
+// decoded, err is set and all further fields decode as zero values.
+type sftpDecoder struct {
+	data []byte
+	err  error
+}
+
+var errSftpShortPacket = errors.New("SFTP packet is too short")
+
+func (d *sftpDecoder) uint32() uint32 {
+	if d.err != nil || len(d.data) < 4 {
+		if d.err == nil {
+			d.err = errSftpShortPacket
+		}
+
+		return 0
+	}
+
+	v := binary.BigEndian.Uint32(d.data)
+	d.data = d.data[4:]
+	return v
+}
+
+func (d *sftpDecoder) uint64() uint64 {
+	return uint64(d.uint32())<<32 | uint64(d.uint32())
+}
+
+func (d *sftpDecoder) string() string {
+	n := d.uint32()
+	if d.err != nil || uint32(len(d.data)) < n {
+		if d.err == nil {
+			d.err = errSftpShortPacket
+		}
+
+		return ""
+	}
+
+	v := string(d.data[:n])
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *sftpDecoder) attrs() *sftpAttrs {
+	result := new(sftpAttrs)
+	flags := d.uint32()
+	if flags&sftpAttrSize != 0 {
+		result.Size = d.uint64()
+	}
+
+	if flags&sftpAttrUidGid != 0 {
+		d.uint32()
+		d.uint32()
+	}
+
+	if flags&sftpAttrPermissions != 0 {
+		result.Mode = sftpFileMode(d.uint32())
+	}
+
+	if flags&sftpAttrAcModTime != 0 {
+		d.uint32()
+		result.Mtime = d.uint32()
+	}
+
+	if flags&sftpAttrExtended != 0 {
+		count := d.uint32()
+		for i := uint32(0); i < count && d.err == nil; i++ {
+			d.string()
+			d.string()
+		}
+	}
+
+	return result
+}
+
+// sftpFileMode converts the Unix mode bits sent by the server into an
+// os.FileMode.
+func sftpFileMode(mode uint32) os.FileMode {
+	result := os.FileMode(mode & 0777)
+	switch mode & 0170000 {
+	case 0040000:
+		result |= os.ModeDir
+	case 0120000:
+		result |= os.ModeSymlink
+	case 0010000:
+		result |= os.ModeNamedPipe
+	case 0140000:
+		result |= os.ModeSocket
+	case 0020000:
+		result |= os.ModeDevice | os.ModeCharDevice
+	case 0060000:
+		result |= os.ModeDevice
+	}
+
+	return result
+}
+
+func sftpUploadFile(c *sftpClient, dst string, src io.Reader, mode os.FileMode) error {
+	log.Printf("SFTP: uploading file: %s", dst)
+	handle, err := c.open(dst, sftpOpenWrite|sftpOpenCreat|sftpOpenTrunc, &sftpAttrs{Mode: mode})
+	if err != nil {
+		return err
+	}
+
+	var offset uint64
+	buf := make([]byte, sftpChunkSize)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if werr := c.write(handle, offset, buf[:n]); werr != nil {
+				c.close(handle)
+				return werr
+			}
+
+			offset += uint64(n)
+		}
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			c.close(handle)
+			return err
+		}
+	}
+
+	return c.close(handle)
+}
+
+// sftpUploadDir uploads the entries of the local directory root that u
+// includes into the remote directory dst, preserving their modes. The
+// path of root relative to the directory being uploaded is rel. Symlinks
+// are followed, just like when uploading with SCP.
+func sftpUploadDir(c *sftpClient, dst string, root string, rel string, fs []os.FileInfo, u *dirUpload) error {
+	for _, fi := range fs {
+		realPath := filepath.Join(root, fi.Name())
+		remotePath := dst + "/" + fi.Name()
+		entryRel := path.Join(rel, fi.Name())
+
+		if fi.Mode()&os.ModeSymlink == os.ModeSymlink {
+			symFi, err := os.Stat(realPath)
+			if err != nil {
+				return err
+			}
+
+			fi = symFi
+		}
+
+		if !u.include(entryRel, fi.IsDir()) {
+			continue
+		}
+
+		if !fi.IsDir() {
+			f, err := os.Open(realPath)
+			if err != nil {
+				return err
+			}
+
+			err = sftpUploadFile(c, remotePath, f, fi.Mode().Perm())
+			f.Close()
+			if err != nil {
+				return err
+			}
+
+			// The mode given when creating the file is subject to the
+			// umask on the other side and isn't applied to existing files.
+			attrs := &sftpAttrs{Mode: fi.Mode()}
+			if u.times {
+				attrs.Mtime = uint32(fi.ModTime().Unix())
+			}
+
+			if err := c.setstat(remotePath, attrs); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		err := sftpUploadDirProtocol(c, remotePath, fi.Mode(), func() error {
+			entries, err := readDir(realPath)
+			if err != nil {
+				return err
+			}
+
+			return sftpUploadDir(c, remotePath, realPath, entryRel, entries, u)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sftpUploadDirProtocol creates the remote directory dst, calls f to
+// upload its contents, and then sets the mode of the directory. The
+// mode is set last so that a read-only directory can still be filled.
+func sftpUploadDirProtocol(c *sftpClient, dst string, mode os.FileMode, f func() error) error {
+	log.Printf("SFTP: starting directory upload: %s", dst)
+	if err := c.mkdir(dst, &sftpAttrs{Mode: mode | 0700}); err != nil {
+		// It is fine for the directory to already exist
+		attrs, serr := c.stat(dst)
+		if serr != nil || !attrs.Mode.IsDir() {
+			return err
+		}
+	}
+
+	if err := f(); err != nil {
+		return err
+	}
+
+	return c.setstat(dst, &sftpAttrs{Mode: mode})
+}
+
+func sftpDownloadFile(c *sftpClient, src string, dst io.Writer) error {
+	log.Printf("SFTP: downloading file: %s", src)
+	handle, err := c.open(src, sftpOpenRead, nil)
+	if err != nil {
+		return err
+	}
+
+	var offset uint64
+	for {
+		data, err := c.read(handle, offset, sftpChunkSize)
+		if err == io.EOF {
+			break
+		}
+
+		if err == nil {
+			_, err = dst.Write(data)
+		}
+
+		if err != nil {
+			c.close(handle)
+			return err
+		}
+
+		offset += uint64(len(data))
+	}
+
+	return c.close(handle)
+}
+
+// sftpDownloadDir downloads the remote directory src into the local
+// directory dst, following symlinks just like SCP does. Paths in excl
+// are relative to src and are skipped.
+func sftpDownloadDir(c *sftpClient, src string, dst string, contentsOnly bool, excl []string) error {
+	attrs, err := c.stat(src)
+	if err != nil {
+		return err
+	}
+
+	if !attrs.Mode.IsDir() {
+		return errors.New("Remote path to download is not a directory")
+	}
+
+	if !contentsOnly {
+		dst = filepath.Join(dst, path.Base(src))
+	}
+
+	if err := os.MkdirAll(dst, attrs.Mode.Perm()|0700); err != nil {
+		return err
+	}
+
+	return sftpDownloadDirContents(c, strings.TrimRight(src, "/"), dst, "", excl)
+}
+
+func sftpDownloadDirContents(c *sftpClient, src string, dst string, rel string, excl []string) error {
+	handle, err := c.opendir(src)
+	if err != nil {
+		return err
+	}
+
+	var entries []sftpEntry
+	for {
+		batch, err := c.readdir(handle)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			c.close(handle)
+			return err
+		}
+
+		entries = append(entries, batch...)
+	}
+
+	if err := c.close(handle); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.Name == "." || entry.Name == ".." {
+			continue
+		}
+
+		if strings.Contains(entry.Name, "/") {
+			return fmt.Errorf("Invalid file name from SFTP: %q", entry.Name)
+		}
+
+		entryRel := entry.Name
+		if rel != "" {
+			entryRel = rel + "/" + entry.Name
+		}
+
+		if packer.ExcludeMatch(entryRel, excl) {
+			log.Printf("SFTP: skipping excluded path: %s", entryRel)
+			continue
+		}
+
+		remotePath := src + "/" + entry.Name
+		localPath := filepath.Join(dst, entry.Name)
+
+		attrs := entry.Attrs
+		if attrs.Mode&os.ModeSymlink != 0 {
+			if attrs, err = c.stat(remotePath); err != nil {
+				return err
+			}
+		}
+
+		switch {
+		case attrs.Mode.IsDir():
+			if err := os.MkdirAll(localPath, attrs.Mode.Perm()|0700); err != nil {
+				return err
+			}
+
+			err := sftpDownloadDirContents(c, remotePath, localPath, entryRel, excl)
+			if err != nil {
+				return err
+			}
+		case attrs.Mode.IsRegular():
+			f, err := os.OpenFile(localPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, attrs.Mode.Perm())
+			if err != nil {
+				return err
+			}
+
+			err = sftpDownloadFile(c, remotePath, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		default:
+			log.Printf("SFTP: skipping special file: %s", remotePath)
+		}
+	}
+
+	return nil
+}
+
+func readDir(path string) ([]os.FileInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return f.Readdir(-1)
+}
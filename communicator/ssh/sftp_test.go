@@ -0,0 +1,369 @@
+package ssh
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSftpServer is a minimal SFTP server serving the local filesystem,
+// just enough to test the client against.
+type testSftpServer struct {
+	r      io.Reader
+	w      io.Writer
+	files  map[string]*os.File
+	dirs   map[string][]os.FileInfo
+	nextFd int
+}
+
+func newTestSftpClient(t *testing.T) *sftpClient {
+	clientR, serverW := io.Pipe()
+	serverR, clientW := io.Pipe()
+
+	server := &testSftpServer{
+		r:     serverR,
+		w:     serverW,
+		files: make(map[string]*os.File),
+		dirs:  make(map[string][]os.FileInfo),
+	}
+	go server.serve()
+
+	client, err := newSftpClient(clientW, clientR)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return client
+}
+
+func (s *testSftpServer) serve() {
+	c := &sftpClient{w: s.w, r: s.r}
+	for {
+		typ, d, err := c.readPacket()
+		if err != nil {
+			return
+		}
+
+		if typ == sftpPacketInit {
+			s.writePacket(sftpPacketVersion, uint32(sftpVersion))
+			continue
+		}
+
+		id := d.uint32()
+		respTyp, resp := s.handle(typ, d)
+		s.writePacket(respTyp, append([]interface{}{id}, resp...)...)
+	}
+}
+
+// testSftpRaw is data that is sent as-is rather than as a string.
+type testSftpRaw []byte
+
+func (s *testSftpServer) writePacket(typ byte, args ...interface{}) {
+	data := []byte{0, 0, 0, 0, typ}
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case uint32:
+			data = appendUint32(data, v)
+		case string:
+			data = appendUint32(data, uint32(len(v)))
+			data = append(data, v...)
+		case []byte:
+			data = appendUint32(data, uint32(len(v)))
+			data = append(data, v...)
+		case testSftpRaw:
+			data = append(data, v...)
+		}
+	}
+
+	binary.BigEndian.PutUint32(data, uint32(len(data)-4))
+	s.w.Write(data)
+}
+
+func (s *testSftpServer) handle(typ byte, d *sftpDecoder) (byte, []interface{}) {
+	switch typ {
+	case sftpPacketOpen:
+		path := d.string()
+		flags := d.uint32()
+		attrs := d.attrs()
+
+		osFlags := os.O_RDONLY
+		if flags&sftpOpenWrite != 0 {
+			osFlags = os.O_WRONLY
+		}
+		if flags&sftpOpenCreat != 0 {
+			osFlags |= os.O_CREATE
+		}
+		if flags&sftpOpenTrunc != 0 {
+			osFlags |= os.O_TRUNC
+		}
+
+		f, err := os.OpenFile(path, osFlags, attrs.Mode.Perm())
+		if err != nil {
+			return s.status(err)
+		}
+
+		s.nextFd++
+		handle := fmt.Sprintf("%d", s.nextFd)
+		s.files[handle] = f
+		return sftpPacketHandle, []interface{}{handle}
+	case sftpPacketOpendir:
+		path := d.string()
+		entries, err := readDir(path)
+		if err != nil {
+			return s.status(err)
+		}
+
+		s.nextFd++
+		handle := fmt.Sprintf("%d", s.nextFd)
+		s.dirs[handle] = entries
+		return sftpPacketHandle, []interface{}{handle}
+	case sftpPacketClose:
+		handle := d.string()
+		if f, ok := s.files[handle]; ok {
+			f.Close()
+		}
+
+		delete(s.files, handle)
+		delete(s.dirs, handle)
+		return s.status(nil)
+	case sftpPacketRead:
+		f := s.files[d.string()]
+		offset := d.uint64()
+		data := make([]byte, d.uint32())
+		n, err := f.ReadAt(data, int64(offset))
+		if n == 0 && err != nil {
+			return s.status(err)
+		}
+
+		return sftpPacketData, []interface{}{data[:n]}
+	case sftpPacketWrite:
+		f := s.files[d.string()]
+		offset := d.uint64()
+		data := d.string()
+		_, err := f.WriteAt([]byte(data), int64(offset))
+		return s.status(err)
+	case sftpPacketReaddir:
+		handle := d.string()
+		entries := s.dirs[handle]
+		if len(entries) == 0 {
+			return s.status(io.EOF)
+		}
+
+		// Send one entry at a time to test reading multiple batches
+		s.dirs[handle] = entries[1:]
+		return sftpPacketName, []interface{}{
+			uint32(1), entries[0].Name(), "", testSftpAttrs(entries[0])}
+	case sftpPacketStat:
+		fi, err := os.Stat(d.string())
+		if err != nil {
+			return s.status(err)
+		}
+
+		return sftpPacketAttrs, []interface{}{testSftpAttrs(fi)}
+	case sftpPacketSetstat:
+		path := d.string()
+		attrs := d.attrs()
+		if attrs.Mtime != 0 {
+			mtime := time.Unix(int64(attrs.Mtime), 0)
+			if err := os.Chtimes(path, mtime, mtime); err != nil {
+				return s.status(err)
+			}
+		}
+
+		return s.status(os.Chmod(path, attrs.Mode.Perm()))
+	case sftpPacketMkdir:
+		path := d.string()
+		attrs := d.attrs()
+		return s.status(os.Mkdir(path, attrs.Mode.Perm()))
+	case sftpPacketRemove:
+		return s.status(os.Remove(d.string()))
+	case sftpPacketRmdir:
+		return s.status(os.Remove(d.string()))
+	}
+
+	return sftpPacketStatus, []interface{}{uint32(8), "unsupported", ""}
+}
+
+func (s *testSftpServer) status(err error) (byte, []interface{}) {
+	switch {
+	case err == nil:
+		return sftpPacketStatus, []interface{}{uint32(sftpStatusOk), "", ""}
+	case err == io.EOF:
+		return sftpPacketStatus, []interface{}{uint32(sftpStatusEOF), "", ""}
+	default:
+		return sftpPacketStatus, []interface{}{uint32(4), err.Error(), ""}
+	}
+}
+
+// testSftpAttrs encodes the attributes of a file the way a server
+// sends them, with the Unix file type bits.
+func testSftpAttrs(fi os.FileInfo) testSftpRaw {
+	mode := uint32(fi.Mode().Perm())
+	switch {
+	case fi.IsDir():
+		mode |= 0040000
+	case fi.Mode()&os.ModeSymlink != 0:
+		mode |= 0120000
+	default:
+		mode |= 0100000
+	}
+
+	data := make(testSftpRaw, 24)
+	binary.BigEndian.PutUint32(data, sftpAttrSize|sftpAttrPermissions|sftpAttrAcModTime)
+	binary.BigEndian.PutUint32(data[4:], uint32(uint64(fi.Size())>>32))
+	binary.BigEndian.PutUint32(data[8:], uint32(fi.Size()))
+	binary.BigEndian.PutUint32(data[12:], mode)
+	binary.BigEndian.PutUint32(data[16:], uint32(fi.ModTime().Unix()))
+	binary.BigEndian.PutUint32(data[20:], uint32(fi.ModTime().Unix()))
+	return data
+}
+
+func TestSftpClient_file(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	client := newTestSftpClient(t)
+	path := filepath.Join(td, "foo")
+
+	// Make it larger than a single chunk
+	data := strings.Repeat("0123456789abcdef", sftpChunkSize/8)
+	if err := sftpUploadFile(client, path, strings.NewReader(data), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	actual, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if string(actual) != data {
+		t.Fatalf("bad length: %d", len(actual))
+	}
+
+	var buf bytes.Buffer
+	if err := sftpDownloadFile(client, path, &buf); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if buf.String() != data {
+		t.Fatalf("bad length: %d", buf.Len())
+	}
+
+	err = sftpDownloadFile(client, filepath.Join(td, "nope"), &buf)
+	if _, ok := err.(*sftpStatusError); !ok {
+		t.Fatalf("bad: %#v", err)
+	}
+}
+
+func TestSftpClient_dir(t *testing.T) {
+	src, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(src)
+
+	if err := os.MkdirAll(filepath.Join(src, "sub", "skip"), 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	files := map[string]os.FileMode{
+		"foo":                               0644,
+		"debug.log":                         0644,
+		filepath.Join("sub", "script"):      0755,
+		filepath.Join("sub", "skip", "baz"): 0600,
+	}
+
+	for name, mode := range files {
+		if err := ioutil.WriteFile(filepath.Join(src, name), []byte(name), mode); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	// Symlinks are followed
+	if err := os.Symlink(filepath.Join(src, "foo"), filepath.Join(src, "link")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	remote, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(remote)
+
+	client := newTestSftpClient(t)
+	entries, err := readDir(src)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = sftpUploadDir(client, remote, src, "", entries, excludeUpload([]string{"*.log"}))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(remote, "debug.log")); !os.IsNotExist(err) {
+		t.Fatalf("excluded file should not be uploaded: %s", err)
+	}
+
+	fi, err := os.Lstat(filepath.Join(remote, "sub", "script"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if fi.Mode().Perm() != 0755 {
+		t.Fatalf("bad mode: %s", fi.Mode())
+	}
+
+	fi, err = os.Lstat(filepath.Join(remote, "link"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !fi.Mode().IsRegular() {
+		t.Fatalf("symlink should be uploaded as a file: %s", fi.Mode())
+	}
+
+	dst, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dst)
+
+	err = sftpDownloadDir(client, remote, dst, false, []string{"sub/skip"})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	root := filepath.Join(dst, filepath.Base(remote))
+	data, err := ioutil.ReadFile(filepath.Join(root, "sub", "script"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if string(data) != filepath.Join("sub", "script") {
+		t.Fatalf("bad: %s", data)
+	}
+
+	fi, err = os.Stat(filepath.Join(root, "sub", "script"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if fi.Mode().Perm() != 0755 {
+		t.Fatalf("bad mode: %s", fi.Mode())
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "sub", "skip")); !os.IsNotExist(err) {
+		t.Fatalf("excluded directory should not exist: %s", err)
+	}
+}
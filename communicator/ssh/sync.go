@@ -0,0 +1,471 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/mitchellh/packer/packer"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// remoteFile is a file or directory in the remote directory being synced.
+type remoteFile struct {
+	Dir   bool
+	Size  int64
+	Mtime int64
+}
+
+// The script that lists the remote directory being synced, with a line
+// for every directory and file. It supports the stat of both GNU and BSD.
+const listRemoteScript = `cd %s 2>/dev/null || exit 0
+find . -type d | sed 's/^/D 0 0 /'
+if stat -c %%s . >/dev/null 2>&1; then
+  find . -type f -exec stat -c 'F %%s %%Y %%n' {} +
+else
+  find . -type f -exec stat -f 'F %%z %%m %%N' {} +
+fi`
+
+// SyncDir uploads the directory like UploadDir, but only the files that
+// are missing on the remote side or differ from the local ones. Files are
+// compared by size and modification time, and by checksum if only the
+// modification time differs.
+func (c *comm) SyncDir(dst string, src string, opts *packer.SyncDirOptions) error {
+	log.Printf("Sync dir '%s' to '%s'", src, dst)
+	target := filepath.ToSlash(dst)
+	if !strings.HasSuffix(src, "/") {
+		target = path.Join(target, filepath.Base(src))
+	}
+
+	local := make(map[string]os.FileInfo)
+	if err := listLocalDir(src, "", opts.Exclude, local); err != nil {
+		return err
+	}
+
+	var remote map[string]*remoteFile
+	var err error
+	if c.config.UseSftp {
+		err = c.sftpSession(func(client *sftpClient) error {
+			remote, err = sftpListDir(client, target, opts.Exclude)
+			return err
+		})
+	} else {
+		remote, err = c.listRemoteDir(target, opts.Exclude)
+	}
+	if err != nil {
+		return fmt.Errorf("Error listing remote directory: %s", err)
+	}
+
+	// Find the files that need to be uploaded, and those that need to be
+	// checksummed to know whether they do.
+	changed := make(map[string]bool)
+	var verify []string
+	for rel, fi := range local {
+		r, ok := remote[rel]
+		switch {
+		case fi.IsDir():
+		case !ok || r.Dir || r.Size != fi.Size():
+			changed[rel] = true
+		case r.Mtime != fi.ModTime().Unix():
+			verify = append(verify, rel)
+		}
+	}
+
+	if len(verify) > 0 {
+		if err := c.verifyFiles(src, target, verify, local, changed); err != nil {
+			return err
+		}
+	}
+
+	// The directories that need to be uploaded are the missing ones and
+	// the ones containing changed files.
+	dirs := make(map[string]bool)
+	for rel, fi := range local {
+		if r, ok := remote[rel]; changed[rel] || (fi.IsDir() && (!ok || !r.Dir)) {
+			for dir := rel; dir != "."; dir = path.Dir(dir) {
+				dirs[dir] = true
+			}
+		}
+	}
+
+	if opts.Delete {
+		var deleted []string
+		for rel, r := range remote {
+			if fi, ok := local[rel]; !ok || fi.IsDir() != r.Dir {
+				deleted = append(deleted, rel)
+			}
+		}
+
+		if len(deleted) > 0 {
+			if err := c.deleteFiles(target, deleted, remote); err != nil {
+				return fmt.Errorf("Error deleting remote files: %s", err)
+			}
+		}
+	}
+
+	log.Printf("Syncing %d changed files of %d", len(changed), len(local))
+	if remote != nil && len(changed) == 0 && len(dirs) == 0 {
+		return nil
+	}
+
+	return c.uploadDir(dst, src, &dirUpload{
+		include: func(rel string, dir bool) bool {
+			if dir {
+				return dirs[rel]
+			}
+
+			return changed[rel]
+		},
+		times: true,
+	})
+}
+
+// listRemoteDir lists the remote directory with a shell command. It
+// returns nil if the directory doesn't exist.
+func (c *comm) listRemoteDir(dir string, excl []string) (map[string]*remoteFile, error) {
+	var stdout bytes.Buffer
+	command := fmt.Sprintf(listRemoteScript, packer.ShellQuote(dir))
+	if err := c.runCommand(command, &stdout); err != nil {
+		return nil, err
+	}
+
+	var result map[string]*remoteFile
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), " ", 4)
+		if len(parts) != 4 {
+			continue
+		}
+
+		size, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		mtime, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		if result == nil {
+			result = make(map[string]*remoteFile)
+		}
+
+		rel := strings.TrimPrefix(parts[3], "./")
+		if rel == "." || packer.ExcludeMatch(rel, excl) {
+			continue
+		}
+
+		result[rel] = &remoteFile{Dir: parts[0] == "D", Size: size, Mtime: mtime}
+	}
+
+	return result, scanner.Err()
+}
+
+// verifyFiles compares the checksums of the local and remote files whose
+// modification times differ, marking the ones that differ as changed. The
+// remote files that match are given the local modification times, so
+// they don't have to be checksummed again on the next sync.
+func (c *comm) verifyFiles(src string, dir string, rels []string, local map[string]os.FileInfo, changed map[string]bool) error {
+	sums := make(map[string]uint32)
+	for _, rel := range rels {
+		sum, err := cksumFile(filepath.Join(src, filepath.FromSlash(rel)))
+		if err != nil {
+			return err
+		}
+
+		sums[rel] = sum
+	}
+
+	// Over SFTP, the remote files are read to checksum them
+	if c.config.UseSftp {
+		return c.sftpSession(func(client *sftpClient) error {
+			for _, rel := range rels {
+				remotePath := dir + "/" + rel
+				h := new(cksumHash)
+				if err := sftpDownloadFile(client, remotePath, h); err != nil {
+					return fmt.Errorf("Error checksumming remote files: %s", err)
+				}
+
+				if h.Sum32() != sums[rel] {
+					changed[rel] = true
+					continue
+				}
+
+				fi := local[rel]
+				attrs := &sftpAttrs{Mode: fi.Mode(), Mtime: uint32(fi.ModTime().Unix())}
+				if err := client.setstat(remotePath, attrs); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	}
+
+	quoted := make([]string, len(rels))
+	for i, rel := range rels {
+		quoted[i] = packer.ShellQuote("./" + rel)
+	}
+
+	var stdout bytes.Buffer
+	command := fmt.Sprintf("cd %s && cksum %s", packer.ShellQuote(dir), strings.Join(quoted, " "))
+	if err := c.runCommand(command, &stdout); err != nil {
+		return fmt.Errorf("Error checksumming remote files: %s", err)
+	}
+
+	remoteSums := make(map[string]string)
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), " ", 3)
+		if len(parts) == 3 {
+			remoteSums[strings.TrimPrefix(parts[2], "./")] = parts[0]
+		}
+	}
+
+	// The times are set with touch, which takes them in the local time
+	// zone, so the remote time zone is set to UTC.
+	var touches []string
+	for i, rel := range rels {
+		if remoteSums[rel] != strconv.FormatUint(uint64(sums[rel]), 10) {
+			changed[rel] = true
+			continue
+		}
+
+		stamp := local[rel].ModTime().UTC().Format("200601021504.05")
+		touches = append(touches, fmt.Sprintf("touch -m -t %s %s", stamp, quoted[i]))
+	}
+
+	if len(touches) == 0 {
+		return nil
+	}
+
+	command = fmt.Sprintf("cd %s && TZ=UTC && export TZ && %s",
+		packer.ShellQuote(dir), strings.Join(touches, " && "))
+	if err := c.runCommand(command, nil); err != nil {
+		return fmt.Errorf("Error setting remote modification times: %s", err)
+	}
+
+	return nil
+}
+
+// deleteFiles deletes the given files and directories from the remote
+// directory.
+func (c *comm) deleteFiles(dir string, rels []string, remote map[string]*remoteFile) error {
+	// Sorted in reverse, everything in a directory comes before it
+	sort.Sort(sort.Reverse(sort.StringSlice(rels)))
+	for _, rel := range rels {
+		log.Printf("Deleting remote path: %s", rel)
+	}
+
+	if c.config.UseSftp {
+		return c.sftpSession(func(client *sftpClient) error {
+			for _, rel := range rels {
+				remove := client.remove
+				if remote[rel].Dir {
+					remove = client.rmdir
+				}
+
+				if err := remove(dir + "/" + rel); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	}
+
+	quoted := make([]string, len(rels))
+	for i, rel := range rels {
+		quoted[i] = packer.ShellQuote("./" + rel)
+	}
+
+	command := fmt.Sprintf("cd %s && rm -rf %s", packer.ShellQuote(dir), strings.Join(quoted, " "))
+	return c.runCommand(command, nil)
+}
+
+// runCommand runs the command in a new session and waits for it to
+// complete, writing its output to stdout if it isn't nil.
+func (c *comm) runCommand(command string, stdout io.Writer) error {
+	session, err := c.newSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	var stderr bytes.Buffer
+	session.Stdout = stdout
+	session.Stderr = &stderr
+	log.Printf("Executing: %s", command)
+	if err := session.Run(command); err != nil {
+		return fmt.Errorf("%s\n\nStderr: %s", err, stderr.String())
+	}
+
+	return nil
+}
+
+// listLocalDir adds the files in the local directory root, which is at
+// the path rel relative to the directory being synced, to files. Symlinks
+// are followed, just like when uploading.
+func listLocalDir(root string, rel string, excl []string, files map[string]os.FileInfo) error {
+	entries, err := readDir(root)
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range entries {
+		realPath := filepath.Join(root, fi.Name())
+		entryRel := path.Join(rel, fi.Name())
+		if packer.ExcludeMatch(entryRel, excl) {
+			continue
+		}
+
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if fi, err = os.Stat(realPath); err != nil {
+				return err
+			}
+		}
+
+		files[entryRel] = fi
+		if fi.IsDir() {
+			if err := listLocalDir(realPath, entryRel, excl, files); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// sftpListDir lists the remote directory over SFTP. It returns nil if the
+// directory doesn't exist.
+func sftpListDir(c *sftpClient, dir string, excl []string) (map[string]*remoteFile, error) {
+	if _, err := c.stat(dir); err != nil {
+		return nil, nil
+	}
+
+	result := make(map[string]*remoteFile)
+	return result, sftpListDirContents(c, dir, "", excl, result)
+}
+
+func sftpListDirContents(c *sftpClient, dir string, rel string, excl []string, files map[string]*remoteFile) error {
+	handle, err := c.opendir(dir)
+	if err != nil {
+		return err
+	}
+
+	var entries []sftpEntry
+	for {
+		batch, err := c.readdir(handle)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			c.close(handle)
+			return err
+		}
+
+		entries = append(entries, batch...)
+	}
+
+	if err := c.close(handle); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.Name == "." || entry.Name == ".." || strings.Contains(entry.Name, "/") {
+			continue
+		}
+
+		entryRel := path.Join(rel, entry.Name)
+		if packer.ExcludeMatch(entryRel, excl) {
+			continue
+		}
+
+		attrs := entry.Attrs
+		switch {
+		case attrs.Mode.IsDir():
+			files[entryRel] = &remoteFile{Dir: true}
+			err := sftpListDirContents(c, dir+"/"+entry.Name, entryRel, excl, files)
+			if err != nil {
+				return err
+			}
+		case attrs.Mode.IsRegular():
+			files[entryRel] = &remoteFile{
+				Size:  int64(attrs.Size),
+				Mtime: int64(attrs.Mtime),
+			}
+		}
+	}
+
+	return nil
+}
+
+// cksumTable is the table for the CRC computed by cksum(1).
+var cksumTable [256]uint32
+
+func init() {
+	for i := range cksumTable {
+		crc := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+
+		cksumTable[i] = crc
+	}
+}
+
+// cksumHash computes the checksum of the data written to it, like the
+// POSIX cksum(1) command does, so that it can be compared to remote files.
+type cksumHash struct {
+	crc    uint32
+	length uint64
+}
+
+func (h *cksumHash) Write(p []byte) (int, error) {
+	for _, b := range p {
+		h.crc = h.crc<<8 ^ cksumTable[byte(h.crc>>24)^b]
+	}
+
+	h.length += uint64(len(p))
+	return len(p), nil
+}
+
+// Sum32 returns the checksum of the data written so far.
+func (h *cksumHash) Sum32() uint32 {
+	// The length of the data is included, least significant byte first
+	crc := h.crc
+	for length := h.length; length != 0; length >>= 8 {
+		crc = crc<<8 ^ cksumTable[byte(crc>>24)^byte(length)]
+	}
+
+	return ^crc
+}
+
+// cksumFile returns the checksum of the local file as computed by the
+// POSIX cksum(1) command, so that it can be compared to remote files.
+func cksumFile(path string) (uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	h := new(cksumHash)
+	if _, err := io.Copy(h, f); err != nil {
+		return 0, err
+	}
+
+	return h.Sum32(), nil
+}
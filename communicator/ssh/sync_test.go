@@ -0,0 +1,142 @@
+package ssh
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCksumFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Remove(f.Name())
+
+	// The value from cksum(1), which is also the CRC's check value
+	f.WriteString("123456789")
+	f.Close()
+
+	sum, err := cksumFile(f.Name())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if sum != 930766865 {
+		t.Fatalf("bad: %d", sum)
+	}
+}
+
+func TestCksumHash(t *testing.T) {
+	// Written in pieces, the result is the same
+	h := new(cksumHash)
+	h.Write([]byte("1234"))
+	h.Write([]byte("56789"))
+	if h.Sum32() != 930766865 {
+		t.Fatalf("bad: %d", h.Sum32())
+	}
+
+	if new(cksumHash).Sum32() != 4294967295 {
+		t.Fatal("empty checksum should match cksum(1)")
+	}
+}
+
+func TestSftpSync(t *testing.T) {
+	src, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(src)
+
+	if err := os.Mkdir(filepath.Join(src, "sub"), 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	for _, name := range []string{"foo", "debug.log", filepath.Join("sub", "bar")} {
+		if err := ioutil.WriteFile(filepath.Join(src, name), []byte(name), 0644); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	excl := []string{"*.log"}
+	local := make(map[string]os.FileInfo)
+	if err := listLocalDir(src, "", excl, local); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(local) != 3 || local["sub/bar"] == nil || !local["sub"].IsDir() {
+		t.Fatalf("bad: %#v", local)
+	}
+
+	remote, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(remote)
+
+	client := newTestSftpClient(t)
+	files, err := sftpListDir(client, filepath.Join(remote, "nope"), excl)
+	if err != nil || files != nil {
+		t.Fatalf("missing directory should list as nil: %#v %s", files, err)
+	}
+
+	// Upload with the modification times preserved
+	entries, err := readDir(src)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	u := excludeUpload(excl)
+	u.times = true
+	if err := sftpUploadDir(client, remote, src, "", entries, u); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(remote, "stale"), nil, 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	files, err = sftpListDir(client, remote, excl)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(files) != 4 || !files["sub"].Dir || files["stale"] == nil {
+		t.Fatalf("bad: %#v", files)
+	}
+
+	for rel, fi := range local {
+		if fi.IsDir() {
+			continue
+		}
+
+		f := files[rel]
+		if f == nil || f.Size != fi.Size() || f.Mtime != fi.ModTime().Unix() {
+			t.Fatalf("%s should match: %#v", rel, f)
+		}
+	}
+
+	// The remote files are checksummed by reading them
+	h := new(cksumHash)
+	if err := sftpDownloadFile(client, filepath.Join(remote, "foo"), h); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	sum, err := cksumFile(filepath.Join(src, "foo"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if h.Sum32() != sum {
+		t.Fatalf("bad: %d != %d", h.Sum32(), sum)
+	}
+
+	if err := client.remove(filepath.Join(remote, "stale")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(remote, "stale")); !os.IsNotExist(err) {
+		t.Fatalf("file should be removed: %s", err)
+	}
+}
@@ -0,0 +1,438 @@
+package winrm
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	soapContentType = "application/soap+xml;charset=UTF-8"
+
+	resourceURICmd = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/cmd"
+
+	actionCreate  = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Create"
+	actionDelete  = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Delete"
+	actionCommand = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Command"
+	actionReceive = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Receive"
+	actionSend    = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Send"
+	actionSignal  = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Signal"
+
+	commandStateDone  = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandState/Done"
+	signalTerminate   = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/signal/terminate"
+	faultCodeTimedOut = "2150858793"
+)
+
+// client is a WS-Management client that runs commands in remote shells
+// with the Windows Remote Shell protocol.
+type client struct {
+	config   *Config
+	endpoint string
+	http     *http.Client
+}
+
+// soapFault is the error returned when the service responds with a fault.
+type soapFault struct {
+	Code   string
+	Reason string
+}
+
+func (f *soapFault) Error() string {
+	return fmt.Sprintf("WinRM fault %s: %s", f.Code, f.Reason)
+}
+
+// envelope is the part of the SOAP responses the client reads.
+type envelope struct {
+	Body struct {
+		ShellId      string           `xml:"Shell>ShellId"`
+		CommandId    string           `xml:"CommandResponse>CommandId"`
+		Streams      []responseStream `xml:"ReceiveResponse>Stream"`
+		CommandState *struct {
+			State    string `xml:"State,attr"`
+			ExitCode int    `xml:"ExitCode"`
+		} `xml:"ReceiveResponse>CommandState"`
+		Fault *struct {
+			Reason string `xml:"Reason>Text"`
+			Detail struct {
+				WSManFault struct {
+					Code    string `xml:"Code,attr"`
+					Message string `xml:"Message"`
+				}
+			}
+		} `xml:"Fault"`
+	}
+}
+
+type responseStream struct {
+	Name string `xml:"Name,attr"`
+	Data string `xml:",chardata"`
+}
+
+func newClient(config *Config) *client {
+	scheme := "http"
+	if config.UseSSL {
+		scheme = "https"
+	}
+
+	c := &client{
+		config:   config,
+		endpoint: fmt.Sprintf("%s://%s/wsman", scheme, config.Host),
+	}
+
+	c.http = &http.Client{Transport: c.newTransport()}
+	return c
+}
+
+func (c *client) newTransport() *http.Transport {
+	return &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			return net.DialTimeout(network, addr, 15*time.Second)
+		},
+		ResponseHeaderTimeout: c.config.timeout() + 30*time.Second,
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: c.config.Insecure,
+		},
+	}
+}
+
+// CreateShell creates a new remote shell and returns its ID. Commands in
+// the shell run in the directory dir, if it isn't empty, with the
+// environment variables in env, in "key=value" form, set.
+func (c *client) CreateShell(dir string, env []string) (string, error) {
+	options := map[string]string{
+		"WINRS_NOPROFILE": "FALSE",
+		"WINRS_CODEPAGE":  "65001",
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("<rsp:Shell>")
+	if len(env) > 0 {
+		buf.WriteString("<rsp:Environment>")
+		for _, kv := range env {
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) == 1 {
+				parts = append(parts, "")
+			}
+
+			buf.WriteString(`<rsp:Variable Name="`)
+			xml.EscapeText(&buf, []byte(parts[0]))
+			buf.WriteString(`">`)
+			xml.EscapeText(&buf, []byte(parts[1]))
+			buf.WriteString("</rsp:Variable>")
+		}
+		buf.WriteString("</rsp:Environment>")
+	}
+
+	if dir != "" {
+		buf.WriteString("<rsp:WorkingDirectory>")
+		xml.EscapeText(&buf, []byte(dir))
+		buf.WriteString("</rsp:WorkingDirectory>")
+	}
+
+	buf.WriteString("<rsp:InputStreams>stdin</rsp:InputStreams>")
+	buf.WriteString("<rsp:OutputStreams>stdout stderr</rsp:OutputStreams>")
+	buf.WriteString("</rsp:Shell>")
+
+	resp, err := c.request(actionCreate, "", options, buf.String())
+	if err != nil {
+		return "", err
+	}
+
+	if resp.Body.ShellId == "" {
+		return "", errors.New("WinRM didn't return a shell ID")
+	}
+
+	return resp.Body.ShellId, nil
+}
+
+// DeleteShell deletes the remote shell.
+func (c *client) DeleteShell(shellId string) error {
+	_, err := c.request(actionDelete, shellId, nil, "")
+	return err
+}
+
+// Command starts the command in the remote shell and returns its ID.
+func (c *client) Command(shellId string, command string) (string, error) {
+	options := map[string]string{
+		"WINRS_CONSOLEMODE_STDIN": "TRUE",
+		"WINRS_SKIP_CMD_SHELL":    "FALSE",
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("<rsp:CommandLine><rsp:Command>")
+	xml.EscapeText(&buf, []byte(command))
+	buf.WriteString("</rsp:Command></rsp:CommandLine>")
+
+	resp, err := c.request(actionCommand, shellId, options, buf.String())
+	if err != nil {
+		return "", err
+	}
+
+	if resp.Body.CommandId == "" {
+		return "", errors.New("WinRM didn't return a command ID")
+	}
+
+	return resp.Body.CommandId, nil
+}
+
+// Send sends the data to the standard input of the command. If end is
+// true, standard input is closed after the data.
+func (c *client) Send(shellId, commandId string, data []byte, end bool) error {
+	endAttr := ""
+	if end {
+		endAttr = ` End="true"`
+	}
+
+	body := fmt.Sprintf(
+		`<rsp:Send><rsp:Stream Name="stdin" CommandId="%s"%s>%s</rsp:Stream></rsp:Send>`,
+		commandId, endAttr, base64.StdEncoding.EncodeToString(data))
+	_, err := c.request(actionSend, shellId, nil, body)
+	return err
+}
+
+// Receive copies the output of the command until it exits, and returns
+// its exit code.
+func (c *client) Receive(shellId, commandId string, stdout, stderr io.Writer) (int, error) {
+	body := fmt.Sprintf(
+		`<rsp:Receive><rsp:DesiredStream CommandId="%s">stdout stderr</rsp:DesiredStream></rsp:Receive>`,
+		commandId)
+
+	for {
+		resp, err := c.request(actionReceive, shellId, nil, body)
+		if err != nil {
+			// The service times out the request if there was no output
+			// within the operation timeout, so just ask again.
+			if fault, ok := err.(*soapFault); ok && fault.Code == faultCodeTimedOut {
+				continue
+			}
+
+			return 0, err
+		}
+
+		for _, stream := range resp.Body.Streams {
+			data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(stream.Data))
+			if err != nil {
+				return 0, fmt.Errorf("Error decoding %s: %s", stream.Name, err)
+			}
+
+			w := stdout
+			if stream.Name == "stderr" {
+				w = stderr
+			}
+
+			if _, err := w.Write(data); err != nil {
+				return 0, err
+			}
+		}
+
+		if state := resp.Body.CommandState; state != nil && state.State == commandStateDone {
+			return state.ExitCode, nil
+		}
+	}
+}
+
+// Signal terminates the command.
+func (c *client) Signal(shellId, commandId string) error {
+	body := fmt.Sprintf(
+		`<rsp:Signal CommandId="%s"><rsp:Code>%s</rsp:Code></rsp:Signal>`,
+		commandId, signalTerminate)
+	_, err := c.request(actionSignal, shellId, nil, body)
+	return err
+}
+
+// request sends a request with the given action and body on the shell
+// resource and parses the response.
+func (c *client) request(action, shellId string, options map[string]string, body string) (*envelope, error) {
+	messageId, err := uuid()
+	if err != nil {
+		return nil, err
+	}
+
+	var header bytes.Buffer
+	fmt.Fprintf(&header, `<a:To>%s</a:To>`, c.endpoint)
+	header.WriteString(`<a:ReplyTo><a:Address env:mustUnderstand="true">` +
+		`http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous` +
+		`</a:Address></a:ReplyTo>`)
+	header.WriteString(`<w:MaxEnvelopeSize env:mustUnderstand="true">153600</w:MaxEnvelopeSize>`)
+	fmt.Fprintf(&header, `<a:MessageID>uuid:%s</a:MessageID>`, messageId)
+	header.WriteString(`<w:Locale env:mustUnderstand="false" xml:lang="en-US"/>`)
+	fmt.Fprintf(&header, `<w:OperationTimeout>PT%dS</w:OperationTimeout>`,
+		int(c.config.timeout().Seconds()))
+	fmt.Fprintf(&header, `<w:ResourceURI env:mustUnderstand="true">%s</w:ResourceURI>`, resourceURICmd)
+	fmt.Fprintf(&header, `<a:Action env:mustUnderstand="true">%s</a:Action>`, action)
+	if shellId != "" {
+		fmt.Fprintf(&header, `<w:SelectorSet><w:Selector Name="ShellId">%s</w:Selector></w:SelectorSet>`, shellId)
+	}
+
+	if len(options) > 0 {
+		header.WriteString(`<w:OptionSet>`)
+		for name, value := range options {
+			fmt.Fprintf(&header, `<w:Option Name="%s">%s</w:Option>`, name, value)
+		}
+		header.WriteString(`</w:OptionSet>`)
+	}
+
+	message := `<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope" ` +
+		`xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" ` +
+		`xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" ` +
+		`xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell">` +
+		`<env:Header>` + header.String() + `</env:Header>` +
+		`<env:Body>` + body + `</env:Body>` +
+		`</env:Envelope>`
+
+	data, err := c.post([]byte(message))
+	if err != nil {
+		return nil, err
+	}
+
+	var result envelope
+	if err := xml.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("Error parsing WinRM response: %s", err)
+	}
+
+	if fault := result.Body.Fault; fault != nil {
+		reason := strings.TrimSpace(fault.Reason)
+		if reason == "" {
+			reason = strings.TrimSpace(fault.Detail.WSManFault.Message)
+		}
+
+		return nil, &soapFault{
+			Code:   fault.Detail.WSManFault.Code,
+			Reason: reason,
+		}
+	}
+
+	return &result, nil
+}
+
+// post sends the SOAP message to the service, authenticating as
+// configured, and returns the response body.
+func (c *client) post(message []byte) ([]byte, error) {
+	var resp *http.Response
+	var err error
+	if c.config.UseNTLM {
+		resp, err = c.postNTLM(message)
+	} else {
+		var req *http.Request
+		req, err = c.newRequest(message)
+		if err != nil {
+			return nil, err
+		}
+
+		req.SetBasicAuth(c.config.Username, c.config.Password)
+		resp, err = c.http.Do(req)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return data, nil
+	case http.StatusUnauthorized:
+		return nil, errors.New("WinRM failed to authenticate: the username or password is wrong")
+	default:
+		// Faults are returned with an error status, so let the caller
+		// parse them out of the response.
+		if strings.Contains(resp.Header.Get("Content-Type"), "soap+xml") {
+			return data, nil
+		}
+
+		return nil, fmt.Errorf("WinRM responded with status %s", resp.Status)
+	}
+}
+
+// postNTLM sends the message authenticated with NTLM. NTLM authenticates
+// the connection rather than the request, so every request is made on
+// its own connection to keep concurrent requests apart.
+func (c *client) postNTLM(message []byte) (*http.Response, error) {
+	transport := c.newTransport()
+	defer transport.CloseIdleConnections()
+	httpClient := &http.Client{Transport: transport}
+
+	req, err := c.newRequest(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Negotiate "+
+		base64.StdEncoding.EncodeToString(ntlmNegotiateMessage()))
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	var challengeData []byte
+	for _, value := range resp.Header["Www-Authenticate"] {
+		if strings.HasPrefix(value, "Negotiate ") {
+			challengeData, err = base64.StdEncoding.DecodeString(value[len("Negotiate "):])
+			if err != nil {
+				return nil, fmt.Errorf("Error decoding NTLM challenge: %s", err)
+			}
+		}
+	}
+
+	if resp.StatusCode != http.StatusUnauthorized || challengeData == nil {
+		return nil, fmt.Errorf(
+			"WinRM didn't respond with an NTLM challenge, status %s", resp.Status)
+	}
+
+	challenge, err := ntlmParseChallenge(challengeData)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := ntlmAuthenticateMessage(challenge, c.config.Username, c.config.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err = c.newRequest(message)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Negotiate "+base64.StdEncoding.EncodeToString(auth))
+	return httpClient.Do(req)
+}
+
+func (c *client) newRequest(message []byte) (*http.Request, error) {
+	req, err := http.NewRequest("POST", c.endpoint, bytes.NewReader(message))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", soapContentType)
+	return req, nil
+}
+
+// uuid returns a random (version 4) UUID.
+func uuid() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
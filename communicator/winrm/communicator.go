@@ -0,0 +1,511 @@
+package winrm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"github.com/mitchellh/packer/packer"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// uploadChunkSize is the number of base64 characters uploaded with each
+// command. Commands are limited to 8191 characters by cmd.exe.
+const uploadChunkSize = 6000
+
+// reconnectInterval is how often to try reconnecting to the WinRM service
+// after a command disconnected as expected.
+var reconnectInterval = 5 * time.Second
+
+type comm struct {
+	client *client
+	config *Config
+}
+
+// Config is the structure used to configure the WinRM communicator.
+type Config struct {
+	// Host is the address of the WinRM service, as "host:port".
+	Host string
+
+	// The credentials to authenticate with.
+	Username string
+	Password string
+
+	// UseSSL, if true, connects to the service over HTTPS. Insecure, if
+	// true, skips verifying the certificate of the service.
+	UseSSL   bool
+	Insecure bool
+
+	// UseNTLM, if true, authenticates with NTLM rather than with basic
+	// authentication.
+	UseNTLM bool
+
+	// Timeout is how long the service can take to respond to a single
+	// operation. Commands can run for longer than this. This defaults to
+	// 60 seconds.
+	Timeout time.Duration
+}
+
+func (c *Config) timeout() time.Duration {
+	if c.Timeout == 0 {
+		return 60 * time.Second
+	}
+
+	return c.Timeout
+}
+
+// Creates a new packer.Communicator implementation over WinRM. This
+// creates and deletes a remote shell to verify the service is available
+// and the credentials are accepted.
+func New(config *Config) (*comm, error) {
+	result := &comm{
+		client: newClient(config),
+		config: config,
+	}
+
+	shellId, err := result.client.CreateShell("", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := result.client.DeleteShell(shellId); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (c *comm) Start(cmd *packer.RemoteCmd) error {
+	// Commands already run with the privileges of the WinRM user, and
+	// there is no sudo to elevate them further with.
+	if cmd.Elevated {
+		log.Printf("WinRM commands can't be elevated, running as the WinRM user")
+	}
+
+	shellId, err := c.client.CreateShell(cmd.Dir, cmd.Env)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("starting remote command: %s", cmd.Command)
+	commandId, err := c.client.Command(shellId, cmd.Command)
+	if err != nil {
+		c.client.DeleteShell(shellId)
+		return err
+	}
+
+	stdout, stderr := cmd.Stdout, cmd.Stderr
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+	if stderr == nil {
+		stderr = ioutil.Discard
+	}
+
+	if cmd.Stdin != nil {
+		go c.sendStdin(shellId, commandId, cmd.Stdin)
+	}
+
+	go func() {
+		exitStatus, err := c.client.Receive(shellId, commandId, stdout, stderr)
+		if err != nil && cmd.ExpectDisconnect {
+			log.Printf("remote command disconnected as expected: %s", err)
+			exitStatus = 0
+			if err := c.waitForReconnect(cmd.ReconnectTimeout); err != nil {
+				log.Printf("%s", err)
+				exitStatus = -1
+			}
+		} else if err != nil {
+			log.Printf("error receiving remote command output: %s", err)
+			c.client.Signal(shellId, commandId)
+			exitStatus = -1
+		}
+
+		c.client.DeleteShell(shellId)
+		log.Printf("remote command exited with '%d': %s", exitStatus, cmd.Command)
+		cmd.SetExited(exitStatus)
+	}()
+
+	return nil
+}
+
+// waitForReconnect waits for the WinRM service to come back after the
+// connection was lost because the machine restarted, retrying until the
+// timeout.
+func (c *comm) waitForReconnect(timeout time.Duration) error {
+	if timeout == 0 {
+		timeout = packer.DefaultReconnectTimeout
+	}
+
+	log.Printf("Waiting up to %s for WinRM to reconnect", timeout)
+	deadline := time.Now().Add(timeout)
+	for {
+		// Give the machine a moment to actually go down, so that we
+		// don't reconnect to it while it is still shutting down.
+		time.Sleep(reconnectInterval)
+
+		shellId, err := c.client.CreateShell("", nil)
+		if err == nil {
+			c.client.DeleteShell(shellId)
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Timeout waiting for WinRM to reconnect: %s", err)
+		}
+	}
+}
+
+// UploadFile uploads the file like Upload. Windows files don't have Unix
+// modes and owners, so those in the options are ignored rather than set
+// with commands that don't exist on Windows.
+func (c *comm) UploadFile(path string, input io.Reader, opts *packer.UploadOptions) error {
+	if opts.Mode != 0 || opts.Owner != "" || opts.Group != "" {
+		log.Printf("WinRM can't set the mode and owner of files, ignoring them for '%s'", path)
+	}
+
+	return c.Upload(path, input)
+}
+
+func (c *comm) Upload(path string, input io.Reader) error {
+	log.Printf("Uploading file to '%s'", path)
+
+	// The file is uploaded in base64 chunks appended to a temporary file
+	// with echo, and then decoded into place with PowerShell.
+	id, err := uuid()
+	if err != nil {
+		return err
+	}
+	tempName := fmt.Sprintf("packer-%s.tmp", id)
+
+	shellId, err := c.client.CreateShell("", nil)
+	if err != nil {
+		return err
+	}
+	defer c.client.DeleteShell(shellId)
+
+	r := bufio.NewReader(input)
+	chunk := make([]byte, uploadChunkSize/4*3)
+	for {
+		n, err := io.ReadFull(r, chunk)
+		if n > 0 {
+			command := fmt.Sprintf(`echo %s>> "%%TEMP%%\%s"`,
+				base64.StdEncoding.EncodeToString(chunk[:n]), tempName)
+			if err := c.runShellCommand(shellId, command, nil); err != nil {
+				return fmt.Errorf("Error uploading %s: %s", path, err)
+			}
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	script := fmt.Sprintf(`
+$tmp = Join-Path $env:TEMP %s
+$dst = $ExecutionContext.SessionState.Path.GetUnresolvedProviderPathFromPSPath(%s)
+$dir = [IO.Path]::GetDirectoryName($dst)
+if ($dir -and -not (Test-Path -LiteralPath $dir)) {
+	New-Item -ItemType Directory -Force -Path $dir | Out-Null
+}
+$data = ''
+if (Test-Path -LiteralPath $tmp) {
+	$data = [IO.File]::ReadAllText($tmp) -replace '\s', ''
+	Remove-Item -LiteralPath $tmp
+}
+[IO.File]::WriteAllBytes($dst, [Convert]::FromBase64String($data))
+`, psQuote(tempName), psQuote(path))
+
+	if err := c.runShellCommand(shellId, powershell(script), nil); err != nil {
+		return fmt.Errorf("Error uploading %s: %s", path, err)
+	}
+
+	return nil
+}
+
+func (c *comm) UploadDir(dst string, src string, excl []string) error {
+	log.Printf("Upload dir '%s' to '%s'", src, dst)
+	if !strings.HasSuffix(src, "/") {
+		log.Printf("No trailing slash, creating the source directory name")
+		dst = remoteJoin(dst, filepath.Base(src))
+	}
+
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+
+		rel = filepath.ToSlash(rel)
+		if rel != "." && packer.ExcludeMatch(rel, excl) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		target := dst
+		if rel != "." {
+			target = remoteJoin(dst, rel)
+		}
+
+		// Follow symlinks, uploading what they point to
+		if info.Mode()&os.ModeSymlink != 0 {
+			info, err = os.Stat(path)
+			if err != nil {
+				return err
+			}
+		}
+
+		if info.IsDir() {
+			script := fmt.Sprintf(
+				`New-Item -ItemType Directory -Force -Path %s | Out-Null`, psQuote(target))
+			return c.runCommand(powershell(script), nil)
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		return c.Upload(target, f)
+	})
+}
+
+func (c *comm) Download(path string, output io.Writer) error {
+	log.Printf("Downloading file from '%s'", path)
+
+	// The file is read in chunks that are written as base64 lines
+	script := fmt.Sprintf(`
+$path = $ExecutionContext.SessionState.Path.GetUnresolvedProviderPathFromPSPath(%s)
+$f = [IO.File]::OpenRead($path)
+try {
+	$buf = New-Object byte[] 49152
+	while (($n = $f.Read($buf, 0, $buf.Length)) -gt 0) {
+		[Console]::Out.WriteLine([Convert]::ToBase64String($buf, 0, $n))
+	}
+} finally {
+	$f.Close()
+}
+`, psQuote(path))
+
+	w := &base64LineWriter{w: output}
+	if err := c.runCommand(powershell(script), w); err != nil {
+		return fmt.Errorf("Error downloading %s: %s", path, err)
+	}
+
+	return w.Close()
+}
+
+func (c *comm) DownloadDir(src string, dst string, excl []string) error {
+	log.Printf("Download dir '%s' to '%s'", src, dst)
+	if !strings.HasSuffix(src, "/") && !strings.HasSuffix(src, `\`) {
+		dst = filepath.Join(dst, remoteBase(src))
+	}
+
+	// List everything in the directory as "D path" or "F path" lines,
+	// with the paths relative to the directory.
+	script := fmt.Sprintf(`
+$root = $ExecutionContext.SessionState.Path.GetUnresolvedProviderPathFromPSPath(%s).TrimEnd('\', '/')
+Get-ChildItem -LiteralPath $root -Recurse -Force | ForEach-Object {
+	$type = 'F'
+	if ($_.PSIsContainer) { $type = 'D' }
+	[Console]::Out.WriteLine($type + ' ' + $_.FullName.Substring($root.Length + 1))
+}
+`, psQuote(src))
+
+	var listing bytes.Buffer
+	if err := c.runCommand(powershell(script), &listing); err != nil {
+		return fmt.Errorf("Error listing %s: %s", src, err)
+	}
+
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(listing.String(), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if len(line) < 3 {
+			continue
+		}
+
+		rel := strings.Replace(line[2:], `\`, "/", -1)
+		if packer.ExcludeMatch(rel, excl) {
+			continue
+		}
+
+		target := filepath.Join(dst, filepath.FromSlash(rel))
+		if line[0] == 'D' {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+
+		f, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+
+		err = c.Download(remoteJoin(src, rel), f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *comm) sendStdin(shellId, commandId string, stdin io.Reader) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := stdin.Read(buf)
+		end := err != nil
+		if n > 0 || end {
+			if err := c.client.Send(shellId, commandId, buf[:n], end); err != nil {
+				log.Printf("error sending stdin: %s", err)
+				return
+			}
+		}
+
+		if end {
+			return
+		}
+	}
+}
+
+// runCommand runs the command in a new shell. The command must exit
+// successfully. Its output is written to stdout, if it isn't nil.
+func (c *comm) runCommand(command string, stdout io.Writer) error {
+	shellId, err := c.client.CreateShell("", nil)
+	if err != nil {
+		return err
+	}
+	defer c.client.DeleteShell(shellId)
+
+	return c.runShellCommand(shellId, command, stdout)
+}
+
+// runShellCommand runs the command in an existing shell. The command must
+// exit successfully. Its output is written to stdout, if it isn't nil.
+func (c *comm) runShellCommand(shellId, command string, stdout io.Writer) error {
+	commandId, err := c.client.Command(shellId, command)
+	if err != nil {
+		return err
+	}
+
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+
+	var stderr bytes.Buffer
+	exitStatus, err := c.client.Receive(shellId, commandId, stdout, &stderr)
+	if err != nil {
+		return err
+	}
+
+	if exitStatus != 0 {
+		return fmt.Errorf(
+			"command exited with status %d: %s",
+			exitStatus, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+// base64LineWriter decodes the base64 lines written to it into w.
+type base64LineWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (b *base64LineWriter) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	for {
+		i := bytes.IndexByte(b.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+
+		if err := b.writeLine(b.buf[:i]); err != nil {
+			return 0, err
+		}
+
+		b.buf = b.buf[i+1:]
+	}
+}
+
+// Close decodes any last line that didn't end with a newline.
+func (b *base64LineWriter) Close() error {
+	err := b.writeLine(b.buf)
+	b.buf = nil
+	return err
+}
+
+func (b *base64LineWriter) writeLine(line []byte) error {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return nil
+	}
+
+	data := make([]byte, base64.StdEncoding.DecodedLen(len(line)))
+	n, err := base64.StdEncoding.Decode(data, line)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.w.Write(data[:n])
+	return err
+}
+
+// powershell returns the command to run the PowerShell script. The script
+// is encoded so that it doesn't have to be escaped for cmd.exe.
+func powershell(script string) string {
+	return "powershell -NoProfile -NonInteractive -ExecutionPolicy Bypass " +
+		"-EncodedCommand " + base64.StdEncoding.EncodeToString(utf16le(
+		"$ProgressPreference = 'SilentlyContinue'\n"+
+			"$ErrorActionPreference = 'Stop'\n"+
+			"try {\n"+script+"\n} catch {\n"+
+			"[Console]::Error.WriteLine($_.Exception.Message)\nexit 1\n}"))
+}
+
+// psQuote quotes the string for use in a PowerShell script.
+func psQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// remoteJoin joins a relative path with slashes to a remote path.
+func remoteJoin(dir string, rel string) string {
+	return strings.TrimRight(dir, `/\`) + `\` + strings.Replace(rel, "/", `\`, -1)
+}
+
+// remoteBase returns the last element of a remote path, which can be
+// separated with slashes or backslashes.
+func remoteBase(path string) string {
+	path = strings.TrimRight(path, `/\`)
+	if i := strings.LastIndexAny(path, `/\`); i >= 0 {
+		return path[i+1:]
+	}
+
+	return path
+}
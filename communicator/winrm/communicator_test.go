@@ -0,0 +1,690 @@
+package winrm
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// testServer is a stand-in for the WinRM service. It understands the
+// commands the communicator runs to transfer files, keeping the files in
+// memory, and runs other commands with the run function.
+type testServer struct {
+	*httptest.Server
+
+	// ntlm, if true, requires NTLM authentication rather than basic.
+	ntlm bool
+
+	// run runs commands the server doesn't know itself.
+	run func(command string, stdin []byte) (stdout, stderr string, exitStatus int)
+
+	l        sync.Mutex
+	files    map[string][]byte
+	dirs     map[string]bool
+	shells   map[string]bool
+	commands map[string]*testCommand
+	nextId   int
+	restarts int
+
+	// shellEnv and shellDir are the environment and working directory of
+	// the last shell created
+	shellEnv []string
+	shellDir string
+
+	// ntlmConns are the connections that were sent a challenge
+	ntlmConns map[string]bool
+}
+
+type testCommand struct {
+	command   string
+	stdin     []byte
+	stdinDone bool
+}
+
+type testRequest struct {
+	Header struct {
+		Action   string `xml:"Action"`
+		Selector string `xml:"SelectorSet>Selector"`
+	}
+	Body struct {
+		Shell struct {
+			Env []struct {
+				Name  string `xml:"Name,attr"`
+				Value string `xml:",chardata"`
+			} `xml:"Environment>Variable"`
+			Dir string `xml:"WorkingDirectory"`
+		} `xml:"Shell"`
+		Command string `xml:"CommandLine>Command"`
+		Send    struct {
+			CommandId string `xml:"CommandId,attr"`
+			End       string `xml:"End,attr"`
+			Data      string `xml:",chardata"`
+		} `xml:"Send>Stream"`
+		Receive struct {
+			CommandId string `xml:"CommandId,attr"`
+		} `xml:"Receive>DesiredStream"`
+		Signal struct {
+			CommandId string `xml:"CommandId,attr"`
+		} `xml:"Signal"`
+	}
+}
+
+func newTestServer(t *testing.T) *testServer {
+	s := &testServer{
+		files:     make(map[string][]byte),
+		dirs:      make(map[string]bool),
+		shells:    make(map[string]bool),
+		commands:  make(map[string]*testCommand),
+		ntlmConns: make(map[string]bool),
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+func (s *testServer) comm(t *testing.T) *comm {
+	c, err := New(&Config{
+		Host:     strings.TrimPrefix(s.URL, "http://"),
+		Username: `DOMAIN\user`,
+		Password: "password",
+		UseNTLM:  s.ntlm,
+		Timeout:  5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return c
+}
+
+func (s *testServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/wsman" || r.Header.Get("Content-Type") != soapContentType {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	if !s.authenticate(w, r) {
+		return
+	}
+
+	var req testRequest
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	body := ""
+	switch req.Header.Action {
+	case actionCreate:
+		s.nextId++
+		shellId := fmt.Sprintf("shell-%d", s.nextId)
+		s.shells[shellId] = true
+		s.shellDir = req.Body.Shell.Dir
+		s.shellEnv = nil
+		for _, v := range req.Body.Shell.Env {
+			s.shellEnv = append(s.shellEnv, v.Name+"="+v.Value)
+		}
+		body = fmt.Sprintf(`<rsp:Shell><rsp:ShellId>%s</rsp:ShellId></rsp:Shell>`, shellId)
+	case actionDelete:
+		delete(s.shells, req.Header.Selector)
+	case actionCommand:
+		if !s.shells[req.Header.Selector] {
+			s.fault(w, "1", "unknown shell")
+			return
+		}
+
+		s.nextId++
+		commandId := fmt.Sprintf("command-%d", s.nextId)
+		s.commands[commandId] = &testCommand{command: req.Body.Command}
+		body = fmt.Sprintf(
+			`<rsp:CommandResponse><rsp:CommandId>%s</rsp:CommandId></rsp:CommandResponse>`,
+			commandId)
+	case actionSend:
+		cmd := s.commands[req.Body.Send.CommandId]
+		data, _ := base64.StdEncoding.DecodeString(req.Body.Send.Data)
+		cmd.stdin = append(cmd.stdin, data...)
+		cmd.stdinDone = req.Body.Send.End == "true"
+	case actionReceive:
+		commandId := req.Body.Receive.CommandId
+		cmd := s.commands[commandId]
+
+		// Restarting the machine drops the connection
+		if cmd.command == "restart" {
+			delete(s.commands, commandId)
+			s.restarts++
+			hj, _ := w.(http.Hijacker)
+			conn, _, _ := hj.Hijack()
+			conn.Close()
+			return
+		}
+
+		// Commands reading standard input wait for all of it. Time out
+		// the request until then, like the real service does when there
+		// is no output.
+		if strings.HasPrefix(cmd.command, "more") && !cmd.stdinDone {
+			s.fault(w, faultCodeTimedOut, "The WS-Management service cannot complete the operation within the time specified in OperationTimeout.")
+			return
+		}
+
+		stdout, stderr, exitStatus := s.runCommand(cmd)
+		delete(s.commands, commandId)
+		body = fmt.Sprintf(`<rsp:ReceiveResponse>`+
+			`<rsp:Stream Name="stdout" CommandId="%s">%s</rsp:Stream>`+
+			`<rsp:Stream Name="stderr" CommandId="%s">%s</rsp:Stream>`+
+			`<rsp:Stream Name="stdout" CommandId="%s" End="true"></rsp:Stream>`+
+			`<rsp:CommandState CommandId="%s" State="%s"><rsp:ExitCode>%d</rsp:ExitCode></rsp:CommandState>`+
+			`</rsp:ReceiveResponse>`,
+			commandId, base64.StdEncoding.EncodeToString([]byte(stdout)),
+			commandId, base64.StdEncoding.EncodeToString([]byte(stderr)),
+			commandId, commandId, commandStateDone, exitStatus)
+	case actionSignal:
+		delete(s.commands, req.Body.Signal.CommandId)
+	default:
+		s.fault(w, "1", "unknown action")
+		return
+	}
+
+	w.Header().Set("Content-Type", soapContentType)
+	fmt.Fprintf(w, `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" `+
+		`xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell">`+
+		`<s:Header/><s:Body>%s</s:Body></s:Envelope>`, body)
+}
+
+func (s *testServer) fault(w http.ResponseWriter, code, reason string) {
+	w.Header().Set("Content-Type", soapContentType)
+	w.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprintf(w, `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" `+
+		`xmlns:f="http://schemas.microsoft.com/wbem/wsman/1/wsmanfault">`+
+		`<s:Body><s:Fault><s:Code><s:Value>s:Receiver</s:Value></s:Code>`+
+		`<s:Reason><s:Text xml:lang="en-US">%s</s:Text></s:Reason>`+
+		`<s:Detail><f:WSManFault Code="%s"><f:Message>%s</f:Message></f:WSManFault></s:Detail>`+
+		`</s:Fault></s:Body></s:Envelope>`, reason, code, reason)
+}
+
+// authenticate checks the credentials of the request, and returns false
+// if the request was answered because they weren't accepted.
+func (s *testServer) authenticate(w http.ResponseWriter, r *http.Request) bool {
+	if !s.ntlm {
+		if r.Header.Get("Authorization") != "Basic "+
+			base64.StdEncoding.EncodeToString([]byte(`DOMAIN\user:password`)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return false
+		}
+
+		return true
+	}
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Negotiate ") {
+		w.Header().Set("WWW-Authenticate", "Negotiate")
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+
+	msg, err := base64.StdEncoding.DecodeString(auth[len("Negotiate "):])
+	if err != nil || len(msg) < 12 {
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	switch binary.LittleEndian.Uint32(msg[8:]) {
+	case 1:
+		// Remember the connection, since NTLM authenticates it
+		s.ntlmConns[r.RemoteAddr] = true
+
+		targetInfo := []byte{ntlmAvTimestamp, 0, 8, 0, 1, 2, 3, 4, 5, 6, 7, 8, 0, 0, 0, 0}
+		challenge := make([]byte, 48)
+		copy(challenge, ntlmSignature)
+		binary.LittleEndian.PutUint32(challenge[8:], 2)
+		binary.LittleEndian.PutUint32(challenge[20:], ntlmNegotiateFlags|ntlmNegotiateTargetInfo)
+		copy(challenge[24:], "12345678")
+		binary.LittleEndian.PutUint16(challenge[40:], uint16(len(targetInfo)))
+		binary.LittleEndian.PutUint16(challenge[42:], uint16(len(targetInfo)))
+		binary.LittleEndian.PutUint32(challenge[44:], 48)
+		challenge = append(challenge, targetInfo...)
+
+		w.Header().Set("WWW-Authenticate", "Negotiate "+base64.StdEncoding.EncodeToString(challenge))
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	case 3:
+		field := func(i int) []byte {
+			length := binary.LittleEndian.Uint16(msg[12+i*8:])
+			offset := binary.LittleEndian.Uint32(msg[16+i*8:])
+			return msg[offset : offset+uint32(length)]
+		}
+
+		ntResponse := field(1)
+		key := ntowfv2("user", "password", "DOMAIN")
+		expected := ntlmv2Response(key, []byte("12345678"), ntResponse[32:40], ntResponse[24:32], ntResponse[44:len(ntResponse)-4])
+		if !s.ntlmConns[r.RemoteAddr] || !bytes.Equal(expected, ntResponse) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return false
+		}
+
+		return true
+	}
+
+	w.WriteHeader(http.StatusUnauthorized)
+	return false
+}
+
+var (
+	testUploadTempRe = regexp.MustCompile(`Join-Path \$env:TEMP '((?:[^']|'')*)'`)
+	testPathRe       = regexp.MustCompile(`FromPSPath\('((?:[^']|'')*)'\)`)
+	testMkdirRe      = regexp.MustCompile(`-Path '((?:[^']|'')*)' \| Out-Null`)
+	testEchoRe       = regexp.MustCompile(`^echo (\S+)>> "%TEMP%\\(.+)"$`)
+)
+
+// runCommand runs the command. The lock must be held.
+func (s *testServer) runCommand(cmd *testCommand) (string, string, int) {
+	if m := testEchoRe.FindStringSubmatch(cmd.command); m != nil {
+		s.files[`%TEMP%\`+m[2]] = append(s.files[`%TEMP%\`+m[2]], m[1]+"\r\n"...)
+		return "", "", 0
+	}
+
+	prefix := "powershell -NoProfile -NonInteractive -ExecutionPolicy Bypass -EncodedCommand "
+	if !strings.HasPrefix(cmd.command, prefix) {
+		if s.run == nil {
+			return "", "unknown command", 1
+		}
+
+		return s.run(cmd.command, cmd.stdin)
+	}
+
+	encoded, _ := base64.StdEncoding.DecodeString(cmd.command[len(prefix):])
+	chars := make([]uint16, len(encoded)/2)
+	for i := range chars {
+		chars[i] = binary.LittleEndian.Uint16(encoded[i*2:])
+	}
+	script := string(utf16.Decode(chars))
+
+	unquote := func(re *regexp.Regexp) string {
+		m := re.FindStringSubmatch(script)
+		if m == nil {
+			return ""
+		}
+
+		return strings.Replace(m[1], "''", "'", -1)
+	}
+
+	switch {
+	case strings.Contains(script, "WriteAllBytes"):
+		tmp := `%TEMP%\` + unquote(testUploadTempRe)
+		data := strings.Join(strings.Fields(string(s.files[tmp])), "")
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return "", err.Error(), 1
+		}
+
+		delete(s.files, tmp)
+		s.files[unquote(testPathRe)] = decoded
+	case strings.Contains(script, "OpenRead"):
+		data, ok := s.files[unquote(testPathRe)]
+		if !ok {
+			return "", "file not found", 1
+		}
+
+		// Use small chunks to test decoding many lines
+		var stdout bytes.Buffer
+		for len(data) > 0 {
+			n := 5
+			if n > len(data) {
+				n = len(data)
+			}
+
+			stdout.WriteString(base64.StdEncoding.EncodeToString(data[:n]) + "\r\n")
+			data = data[n:]
+		}
+
+		return stdout.String(), "", 0
+	case strings.Contains(script, "Get-ChildItem"):
+		root := strings.TrimRight(unquote(testPathRe), `\/`) + `\`
+		entries := make(map[string]string)
+		for path := range s.files {
+			if !strings.HasPrefix(path, root) {
+				continue
+			}
+
+			rel := path[len(root):]
+			entries[rel] = "F"
+			for i := strings.LastIndex(rel, `\`); i > 0; i = strings.LastIndex(rel[:i], `\`) {
+				entries[rel[:i]] = "D"
+			}
+		}
+
+		lines := make([]string, 0, len(entries))
+		for rel, typ := range entries {
+			lines = append(lines, typ+" "+rel)
+		}
+		sort.Strings(lines)
+
+		return strings.Join(lines, "\r\n") + "\r\n", "", 0
+	case strings.Contains(script, "-ItemType Directory"):
+		s.dirs[unquote(testMkdirRe)] = true
+	default:
+		return "", "unknown script", 1
+	}
+
+	return "", "", 0
+}
+
+func TestCommunicator_Impl(t *testing.T) {
+	var raw interface{}
+	raw = &comm{}
+	if _, ok := raw.(packer.Communicator); !ok {
+		t.Fatal("should be a communicator")
+	}
+
+	if _, ok := raw.(packer.FileUploader); !ok {
+		t.Fatal("should be a file uploader")
+	}
+}
+
+func TestNew_badCredentials(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	_, err := New(&Config{
+		Host:     strings.TrimPrefix(s.URL, "http://"),
+		Username: "user",
+		Password: "wrong",
+	})
+	if err == nil || !strings.Contains(err.Error(), "authenticate") {
+		t.Fatalf("should fail to authenticate: %s", err)
+	}
+}
+
+func TestCommunicatorStart(t *testing.T) {
+	for _, ntlm := range []bool{false, true} {
+		s := newTestServer(t)
+		s.ntlm = ntlm
+		s.run = func(command string, stdin []byte) (string, string, int) {
+			switch command {
+			case "hostname":
+				return "WIN-PACKER\r\n", "", 0
+			case "more":
+				return string(stdin), "", 0
+			default:
+				return "", "'" + command + "' is not recognized", 9009
+			}
+		}
+
+		c := s.comm(t)
+
+		var stdout, stderr bytes.Buffer
+		cmd := &packer.RemoteCmd{
+			Command: "hostname",
+			Stdout:  &stdout,
+		}
+
+		if err := c.Start(cmd); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		cmd.Wait()
+
+		if cmd.ExitStatus != 0 || stdout.String() != "WIN-PACKER\r\n" {
+			t.Fatalf("bad: %d %q", cmd.ExitStatus, stdout.String())
+		}
+
+		stdout.Reset()
+		cmd = &packer.RemoteCmd{
+			Command: "more",
+			Stdin:   strings.NewReader("hello"),
+			Stdout:  &stdout,
+		}
+
+		if err := c.Start(cmd); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		cmd.Wait()
+
+		if cmd.ExitStatus != 0 || stdout.String() != "hello" {
+			t.Fatalf("bad: %d %q", cmd.ExitStatus, stdout.String())
+		}
+
+		cmd = &packer.RemoteCmd{
+			Command: "nope",
+			Stderr:  &stderr,
+		}
+
+		if err := c.Start(cmd); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		cmd.Wait()
+
+		if cmd.ExitStatus != 9009 || !strings.Contains(stderr.String(), "not recognized") {
+			t.Fatalf("bad: %d %q", cmd.ExitStatus, stderr.String())
+		}
+
+		if len(s.shells) != 0 {
+			t.Fatalf("shells should be deleted: %#v", s.shells)
+		}
+
+		s.Close()
+	}
+}
+
+func TestCommunicatorStart_envDir(t *testing.T) {
+	s := newTestServer(t)
+	s.run = func(command string, stdin []byte) (string, string, int) {
+		return "", "", 0
+	}
+
+	c := s.comm(t)
+	cmd := &packer.RemoteCmd{
+		Command:  "hostname",
+		Env:      []string{"FOO=<it's & bar>", "EMPTY"},
+		Dir:      `C:\Program Files`,
+		Elevated: true,
+	}
+
+	if err := c.Start(cmd); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	cmd.Wait()
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	expected := []string{"FOO=<it's & bar>", "EMPTY="}
+	if !reflect.DeepEqual(s.shellEnv, expected) {
+		t.Fatalf("bad: %#v", s.shellEnv)
+	}
+
+	if s.shellDir != `C:\Program Files` {
+		t.Fatalf("bad: %s", s.shellDir)
+	}
+}
+
+func TestCommunicatorStart_expectDisconnect(t *testing.T) {
+	defer func(old time.Duration) { reconnectInterval = old }(reconnectInterval)
+	reconnectInterval = 10 * time.Millisecond
+
+	s := newTestServer(t)
+	defer s.Close()
+	c := s.comm(t)
+
+	cmd := &packer.RemoteCmd{Command: "restart"}
+	if err := c.Start(cmd); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	cmd.Wait()
+
+	if cmd.ExitStatus != -1 {
+		t.Fatalf("losing the connection should fail: %d", cmd.ExitStatus)
+	}
+
+	cmd = &packer.RemoteCmd{
+		Command:          "restart",
+		ExpectDisconnect: true,
+		ReconnectTimeout: time.Second,
+	}
+	if err := c.Start(cmd); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	cmd.Wait()
+
+	if cmd.ExitStatus != 0 || s.restarts != 2 {
+		t.Fatalf("bad: %d %d", cmd.ExitStatus, s.restarts)
+	}
+
+	// Without the service coming back, it times out
+	s.Close()
+	if err := c.waitForReconnect(50 * time.Millisecond); err == nil {
+		t.Fatal("should time out")
+	}
+}
+
+func TestCommunicatorUploadDownload(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	c := s.comm(t)
+
+	// Make it span several upload chunks
+	data := bytes.Repeat([]byte("0123456789abcdef"), uploadChunkSize/4)
+	if err := c.Upload(`C:\Windows\Temp\it's.txt`, bytes.NewReader(data)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !bytes.Equal(s.files[`C:\Windows\Temp\it's.txt`], data) {
+		t.Fatalf("bad: %d bytes", len(s.files[`C:\Windows\Temp\it's.txt`]))
+	}
+
+	if len(s.files) != 1 {
+		t.Fatalf("temporary file should be removed: %#v", s.files)
+	}
+
+	var buf bytes.Buffer
+	if err := c.Download(`C:\Windows\Temp\it's.txt`, &buf); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("bad: %d bytes", buf.Len())
+	}
+
+	// Empty files work too
+	if err := c.Upload(`C:\empty`, new(bytes.Buffer)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if data, ok := s.files[`C:\empty`]; !ok || len(data) != 0 {
+		t.Fatalf("bad: %#v", data)
+	}
+
+	err := c.Download(`C:\nope`, &buf)
+	if err == nil || !strings.Contains(err.Error(), "file not found") {
+		t.Fatalf("should error: %s", err)
+	}
+}
+
+func TestCommunicatorUploadDir(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	c := s.comm(t)
+
+	src, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(src)
+
+	for _, dir := range []string{"empty", "sub", "skip"} {
+		if err := os.Mkdir(filepath.Join(src, dir), 0755); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	for _, name := range []string{"foo", filepath.Join("sub", "bar"), filepath.Join("skip", "baz")} {
+		if err := ioutil.WriteFile(filepath.Join(src, name), []byte(name), 0644); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	if err := c.UploadDir(`C:\dst`, src, []string{"skip"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	root := `C:\dst\` + filepath.Base(src)
+	if string(s.files[root+`\sub\bar`]) != filepath.Join("sub", "bar") {
+		t.Fatalf("bad: %#v", s.files)
+	}
+
+	if !s.dirs[root+`\empty`] {
+		t.Fatalf("empty directory should be created: %#v", s.dirs)
+	}
+
+	if _, ok := s.files[root+`\skip\baz`]; ok {
+		t.Fatal("excluded files should not be uploaded")
+	}
+
+	// With a trailing slash only the contents are uploaded
+	if err := c.UploadDir(`C:\contents`, src+"/", nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if string(s.files[`C:\contents\foo`]) != "foo" {
+		t.Fatalf("bad: %#v", s.files)
+	}
+}
+
+func TestCommunicatorDownloadDir(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	c := s.comm(t)
+
+	s.files[`C:\src\foo`] = []byte("foo")
+	s.files[`C:\src\sub\bar`] = []byte("bar")
+	s.files[`C:\src\skip\baz`] = []byte("baz")
+
+	dst, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dst)
+
+	if err := c.DownloadDir(`C:\src`, dst, []string{"skip"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dst, "src", "sub", "bar"))
+	if err != nil || string(data) != "bar" {
+		t.Fatalf("bad: %s %s", data, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dst, "src", "skip")); !os.IsNotExist(err) {
+		t.Fatalf("excluded directory should not exist: %s", err)
+	}
+
+	// With a trailing slash only the contents are downloaded
+	if err := c.DownloadDir(`C:\src\`, dst, nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	data, err = ioutil.ReadFile(filepath.Join(dst, "foo"))
+	if err != nil || string(data) != "foo" {
+		t.Fatalf("bad: %s %s", data, err)
+	}
+}
@@ -0,0 +1,202 @@
+package winrm
+
+import (
+	"bytes"
+	"code.google.com/p/go.crypto/md4"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// This file implements the client side of NTLMv2 authentication, as
+// described in [MS-NLMP]. Only authentication is implemented, not message
+// signing or sealing, so the WinRM service must either be reached over
+// HTTPS or allow unencrypted traffic.
+
+const (
+	ntlmNegotiateUnicode          = 0x00000001
+	ntlmRequestTarget             = 0x00000004
+	ntlmNegotiateNTLM             = 0x00000200
+	ntlmNegotiateAlwaysSign       = 0x00008000
+	ntlmNegotiateExtendedSecurity = 0x00080000
+	ntlmNegotiateTargetInfo       = 0x00800000
+	ntlmNegotiate128              = 0x20000000
+	ntlmNegotiate56               = 0x80000000
+
+	ntlmNegotiateFlags = ntlmNegotiateUnicode | ntlmRequestTarget |
+		ntlmNegotiateNTLM | ntlmNegotiateAlwaysSign |
+		ntlmNegotiateExtendedSecurity | ntlmNegotiate128 | ntlmNegotiate56
+
+	// ntlmAvTimestamp is the ID of the AV pair in the target info of the
+	// challenge that contains the server time.
+	ntlmAvTimestamp = 7
+)
+
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+// ntlmChallenge is the parsed CHALLENGE_MESSAGE sent by the server.
+type ntlmChallenge struct {
+	Flags           uint32
+	ServerChallenge []byte
+	TargetInfo      []byte
+}
+
+// ntlmNegotiateMessage returns the NEGOTIATE_MESSAGE that starts the
+// authentication.
+func ntlmNegotiateMessage() []byte {
+	msg := make([]byte, 32)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 1)
+	binary.LittleEndian.PutUint32(msg[12:], ntlmNegotiateFlags)
+	return msg
+}
+
+// ntlmParseChallenge parses the CHALLENGE_MESSAGE from the server.
+func ntlmParseChallenge(msg []byte) (*ntlmChallenge, error) {
+	if len(msg) < 48 || !bytes.Equal(msg[:8], ntlmSignature) {
+		return nil, errors.New("invalid NTLM challenge message")
+	}
+
+	if binary.LittleEndian.Uint32(msg[8:]) != 2 {
+		return nil, errors.New("NTLM message is not a challenge")
+	}
+
+	targetInfoLen := int(binary.LittleEndian.Uint16(msg[40:]))
+	targetInfoOffset := int(binary.LittleEndian.Uint32(msg[44:]))
+	if targetInfoOffset+targetInfoLen > len(msg) {
+		return nil, errors.New("invalid NTLM challenge target info")
+	}
+
+	return &ntlmChallenge{
+		Flags:           binary.LittleEndian.Uint32(msg[20:]),
+		ServerChallenge: msg[24:32],
+		TargetInfo:      msg[targetInfoOffset : targetInfoOffset+targetInfoLen],
+	}, nil
+}
+
+// ntlmAuthenticateMessage returns the AUTHENTICATE_MESSAGE answering the
+// challenge for the given user. The user can include a domain, as in
+// "DOMAIN\user".
+func ntlmAuthenticateMessage(c *ntlmChallenge, user, password string) ([]byte, error) {
+	domain := ""
+	if i := strings.Index(user, `\`); i >= 0 {
+		domain = user[:i]
+		user = user[i+1:]
+	}
+
+	clientChallenge := make([]byte, 8)
+	if _, err := rand.Read(clientChallenge); err != nil {
+		return nil, err
+	}
+
+	// Prefer the server's time, since the response is rejected if the
+	// clocks are too far apart.
+	timestamp, ok := ntlmTargetInfoTimestamp(c.TargetInfo)
+	if !ok {
+		timestamp = make([]byte, 8)
+		binary.LittleEndian.PutUint64(timestamp, ntlmFileTime(time.Now()))
+	}
+
+	key := ntowfv2(user, password, domain)
+	ntResponse := ntlmv2Response(key, c.ServerChallenge, clientChallenge, timestamp, c.TargetInfo)
+
+	// The LMv2 response must be empty when the server sent a timestamp
+	var lmResponse []byte
+	if ok {
+		lmResponse = make([]byte, 24)
+	} else {
+		mac := hmac.New(md5.New, key)
+		mac.Write(c.ServerChallenge)
+		mac.Write(clientChallenge)
+		lmResponse = append(mac.Sum(nil), clientChallenge...)
+	}
+
+	payloads := [][]byte{
+		lmResponse,
+		ntResponse,
+		utf16le(domain),
+		utf16le(user),
+		nil, // Workstation
+		nil, // EncryptedRandomSessionKey
+	}
+
+	msg := make([]byte, 64)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 3)
+	for i, payload := range payloads {
+		field := msg[12+i*8:]
+		binary.LittleEndian.PutUint16(field[0:], uint16(len(payload)))
+		binary.LittleEndian.PutUint16(field[2:], uint16(len(payload)))
+		binary.LittleEndian.PutUint32(field[4:], uint32(len(msg)))
+		msg = append(msg, payload...)
+	}
+
+	binary.LittleEndian.PutUint32(msg[60:], c.Flags&ntlmNegotiateFlags)
+	return msg, nil
+}
+
+// ntowfv2 returns the NTLMv2 response key for the user.
+func ntowfv2(user, password, domain string) []byte {
+	hash := md4.New()
+	hash.Write(utf16le(password))
+	mac := hmac.New(md5.New, hash.Sum(nil))
+	mac.Write(utf16le(strings.ToUpper(user) + domain))
+	return mac.Sum(nil)
+}
+
+// ntlmv2Response returns the NtChallengeResponse, which is the
+// NTProofStr followed by the client's blob it was computed over.
+func ntlmv2Response(key, serverChallenge, clientChallenge, timestamp, targetInfo []byte) []byte {
+	blob := []byte{1, 1, 0, 0, 0, 0, 0, 0}
+	blob = append(blob, timestamp...)
+	blob = append(blob, clientChallenge...)
+	blob = append(blob, 0, 0, 0, 0)
+	blob = append(blob, targetInfo...)
+	blob = append(blob, 0, 0, 0, 0)
+
+	mac := hmac.New(md5.New, key)
+	mac.Write(serverChallenge)
+	mac.Write(blob)
+	return append(mac.Sum(nil), blob...)
+}
+
+// ntlmTargetInfoTimestamp returns the server time from the target info
+// of the challenge, if it has one.
+func ntlmTargetInfoTimestamp(targetInfo []byte) ([]byte, bool) {
+	for len(targetInfo) >= 4 {
+		id := binary.LittleEndian.Uint16(targetInfo)
+		length := int(binary.LittleEndian.Uint16(targetInfo[2:]))
+		if id == 0 || len(targetInfo) < 4+length {
+			break
+		}
+
+		if id == ntlmAvTimestamp && length == 8 {
+			return targetInfo[4:12], true
+		}
+
+		targetInfo = targetInfo[4+length:]
+	}
+
+	return nil, false
+}
+
+// ntlmFileTime converts the time to a Windows FILETIME, which is the
+// number of 100 nanosecond intervals since January 1, 1601.
+func ntlmFileTime(t time.Time) uint64 {
+	return uint64(t.UnixNano()/100) + 116444736000000000
+}
+
+func utf16le(s string) []byte {
+	encoded := utf16.Encode([]rune(s))
+	result := make([]byte, len(encoded)*2)
+	for i, c := range encoded {
+		binary.LittleEndian.PutUint16(result[i*2:], c)
+	}
+
+	return result
+}
@@ -0,0 +1,82 @@
+package winrm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+// The expected values in these tests are from section 4.2.4 of [MS-NLMP].
+
+func TestNTOWFv2(t *testing.T) {
+	key := ntowfv2("User", "Password", "Domain")
+	if hex.EncodeToString(key) != "0c868a403bfd7a93a3001ef22ef02e3f" {
+		t.Fatalf("bad: %x", key)
+	}
+}
+
+func TestNTLMv2Response(t *testing.T) {
+	serverChallenge, _ := hex.DecodeString("0123456789abcdef")
+	clientChallenge := bytes.Repeat([]byte{0xaa}, 8)
+	timestamp := make([]byte, 8)
+	targetInfo := []byte{}
+	targetInfo = append(targetInfo, 2, 0, 12, 0)
+	targetInfo = append(targetInfo, utf16le("Domain")...)
+	targetInfo = append(targetInfo, 1, 0, 12, 0)
+	targetInfo = append(targetInfo, utf16le("Server")...)
+	targetInfo = append(targetInfo, 0, 0, 0, 0)
+
+	key := ntowfv2("User", "Password", "Domain")
+	response := ntlmv2Response(key, serverChallenge, clientChallenge, timestamp, targetInfo)
+	if hex.EncodeToString(response[:16]) != "68cd0ab851e51c96aabc927bebef6a1c" {
+		t.Fatalf("bad: %x", response[:16])
+	}
+}
+
+func TestNTLMAuthenticateMessage(t *testing.T) {
+	timestamp := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	targetInfo := append([]byte{ntlmAvTimestamp, 0, 8, 0}, timestamp...)
+	targetInfo = append(targetInfo, 0, 0, 0, 0)
+
+	challenge := &ntlmChallenge{
+		Flags:           ntlmNegotiateFlags | ntlmNegotiateTargetInfo,
+		ServerChallenge: []byte("12345678"),
+		TargetInfo:      targetInfo,
+	}
+
+	msg, err := ntlmAuthenticateMessage(challenge, `DOMAIN\user`, "password")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	field := func(i int) []byte {
+		length := binary.LittleEndian.Uint16(msg[12+i*8:])
+		offset := binary.LittleEndian.Uint32(msg[16+i*8:])
+		return msg[offset : offset+uint32(length)]
+	}
+
+	if !bytes.Equal(field(0), make([]byte, 24)) {
+		t.Fatalf("LM response should be empty with a timestamp: %x", field(0))
+	}
+
+	if !bytes.Equal(field(2), utf16le("DOMAIN")) {
+		t.Fatalf("bad domain: %x", field(2))
+	}
+
+	if !bytes.Equal(field(3), utf16le("user")) {
+		t.Fatalf("bad user: %x", field(3))
+	}
+
+	// The blob must use the server's timestamp
+	ntResponse := field(1)
+	if !bytes.Equal(ntResponse[24:32], timestamp) {
+		t.Fatalf("bad timestamp: %x", ntResponse[24:32])
+	}
+
+	key := ntowfv2("user", "password", "DOMAIN")
+	expected := ntlmv2Response(key, challenge.ServerChallenge, ntResponse[32:40], timestamp, targetInfo)
+	if !bytes.Equal(ntResponse, expected) {
+		t.Fatalf("bad response: %x", ntResponse)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/mitchellh/osext"
 	"github.com/mitchellh/packer/packer"
 	"github.com/mitchellh/packer/packer/plugin"
@@ -12,39 +13,42 @@ import (
 )
 
 // This is the default, built-in configuration that ships with
-// Packer.
+// Packer. Components configured as "builtin" run inside the Packer
+// process itself rather than as plugins.
 const defaultConfig = `
 {
-	"plugin_min_port": 10000,
-	"plugin_max_port": 25000,
-
 	"builders": {
-		"amazon-ebs": "packer-builder-amazon-ebs",
-		"amazon-chroot": "packer-builder-amazon-chroot",
-		"amazon-instance": "packer-builder-amazon-instance",
-		"digitalocean": "packer-builder-digitalocean",
-		"openstack": "packer-builder-openstack",
-		"virtualbox": "packer-builder-virtualbox",
-		"vmware": "packer-builder-vmware"
+		"amazon-ebs": "builtin",
+		"amazon-chroot": "builtin",
+		"amazon-instance": "builtin",
+		"digitalocean": "builtin",
+		"openstack": "builtin",
+		"virtualbox": "builtin",
+		"vmware": "builtin"
 	},
 
 	"commands": {
 		"build": "packer-command-build",
 		"fix": "packer-command-fix",
 		"inspect": "packer-command-inspect",
+		"post-process": "packer-command-post-process",
 		"validate": "packer-command-validate"
 	},
 
 	"post-processors": {
-		"vagrant": "packer-post-processor-vagrant"
+		"shell-local": "builtin",
+		"vagrant": "builtin"
 	},
 
 	"provisioners": {
-		"chef-solo": "packer-provisioner-chef-solo",
-		"file": "packer-provisioner-file",
-		"puppet-masterless": "packer-provisioner-puppet-masterless",
-		"shell": "packer-provisioner-shell",
-		"salt-masterless": "packer-provisioner-salt-masterless"
+		"ansible": "builtin",
+		"ansible-local": "builtin",
+		"chef-solo": "builtin",
+		"file": "builtin",
+		"puppet-masterless": "builtin",
+		"shell": "builtin",
+		"shell-local": "builtin",
+		"salt-masterless": "builtin"
 	}
 }
 `
@@ -85,6 +89,16 @@ func (c *config) LoadBuilder(name string) (packer.Builder, error) {
 		return nil, nil
 	}
 
+	if bin == builtinPlugin {
+		builder, ok := builtinBuilders[name]
+		if !ok {
+			return nil, fmt.Errorf("There is no builtin builder named '%s'", name)
+		}
+
+		log.Printf("Using builtin builder: %s", name)
+		return builder(), nil
+	}
+
 	return c.pluginClient(bin).Builder()
 }
 
@@ -118,6 +132,16 @@ func (c *config) LoadPostProcessor(name string) (packer.PostProcessor, error) {
 		return nil, nil
 	}
 
+	if bin == builtinPlugin {
+		pp, ok := builtinPostProcessors[name]
+		if !ok {
+			return nil, fmt.Errorf("There is no builtin post-processor named '%s'", name)
+		}
+
+		log.Printf("Using builtin post-processor: %s", name)
+		return pp(), nil
+	}
+
 	return c.pluginClient(bin).PostProcessor()
 }
 
@@ -131,6 +155,16 @@ func (c *config) LoadProvisioner(name string) (packer.Provisioner, error) {
 		return nil, nil
 	}
 
+	if bin == builtinPlugin {
+		provisioner, ok := builtinProvisioners[name]
+		if !ok {
+			return nil, fmt.Errorf("There is no builtin provisioner named '%s'", name)
+		}
+
+		log.Printf("Using builtin provisioner: %s", name)
+		return provisioner(), nil
+	}
+
 	return c.pluginClient(bin).Provisioner()
 }
 
@@ -162,6 +196,7 @@ func (c *config) pluginClient(path string) *plugin.Client {
 	var config plugin.ClientConfig
 	config.Cmd = exec.Command(path)
 	config.Managed = true
+	config.CrashLogDir = "."
 	config.MinPort = c.PluginMinPort
 	config.MaxPort = c.PluginMaxPort
 	return plugin.NewClient(&config)
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func testConfig(t *testing.T) *config {
+	var c config
+	if err := decodeConfig(bytes.NewBufferString(defaultConfig), &c); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return &c
+}
+
+func TestDefaultConfig_builtins(t *testing.T) {
+	c := testConfig(t)
+
+	for name, bin := range c.Builders {
+		if bin != builtinPlugin {
+			continue
+		}
+
+		if _, ok := builtinBuilders[name]; !ok {
+			t.Fatalf("builder not builtin: %s", name)
+		}
+	}
+
+	for name, bin := range c.PostProcessors {
+		if bin != builtinPlugin {
+			continue
+		}
+
+		if _, ok := builtinPostProcessors[name]; !ok {
+			t.Fatalf("post-processor not builtin: %s", name)
+		}
+	}
+
+	for name, bin := range c.Provisioners {
+		if bin != builtinPlugin {
+			continue
+		}
+
+		if _, ok := builtinProvisioners[name]; !ok {
+			t.Fatalf("provisioner not builtin: %s", name)
+		}
+	}
+}
+
+func TestConfigLoadBuilder_builtin(t *testing.T) {
+	c := testConfig(t)
+
+	b, err := c.LoadBuilder("vmware")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if b == nil {
+		t.Fatal("builder should not be nil")
+	}
+
+	c.Builders["foo"] = builtinPlugin
+	if _, err := c.LoadBuilder("foo"); err == nil {
+		t.Fatal("should error for unknown builtin")
+	}
+}
+
+func TestConfigLoadProvisioner_builtin(t *testing.T) {
+	c := testConfig(t)
+
+	p1, err := c.LoadProvisioner("shell")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	p2, err := c.LoadProvisioner("shell")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if p1 == p2 {
+		t.Fatal("each load should create a new provisioner")
+	}
+}
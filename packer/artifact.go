@@ -25,6 +25,17 @@ type Artifact interface {
 	// This is used for UI output. It can be multiple lines.
 	String() string
 
+	// State allows the caller to ask for builder specific state information
+	// relating to the artifact instance, such as the map of regions to AMI
+	// IDs or the name of the VM. The keys that are available are specific
+	// to each builder. If the key isn't known, nil is returned. Given
+	// ArtifactStateKeysKey, a []string of all the known keys should be
+	// returned.
+	//
+	// Values must be able to be encoded with encoding/gob so that they
+	// can be sent across the plugin boundary.
+	State(name string) interface{}
+
 	// Destroy deletes the artifact. Packer calls this for various reasons,
 	// such as if a post-processor has processed this artifact and it is
 	// no longer needed.
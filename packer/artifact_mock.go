@@ -3,6 +3,8 @@ package packer
 // MockArtifact is an implementation of Artifact that can be used for tests.
 type MockArtifact struct {
 	IdValue       string
+	FilesValue    []string
+	StateValues   map[string]interface{}
 	DestroyCalled bool
 }
 
@@ -10,7 +12,11 @@ func (*MockArtifact) BuilderId() string {
 	return "bid"
 }
 
-func (*MockArtifact) Files() []string {
+func (a *MockArtifact) Files() []string {
+	if a.FilesValue != nil {
+		return a.FilesValue
+	}
+
 	return []string{"a", "b"}
 }
 
@@ -27,6 +33,19 @@ func (*MockArtifact) String() string {
 	return "string"
 }
 
+func (a *MockArtifact) State(name string) interface{} {
+	if name == ArtifactStateKeysKey {
+		keys := make([]string, 0, len(a.StateValues))
+		for key := range a.StateValues {
+			keys = append(keys, key)
+		}
+
+		return keys
+	}
+
+	return a.StateValues[name]
+}
+
 func (a *MockArtifact) Destroy() error {
 	a.DestroyCalled = true
 	return nil
@@ -0,0 +1,146 @@
+package packer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+)
+
+// This is the key that can be given to Artifact.State in order to get
+// a []string of all the other state keys the artifact knows about. This
+// is used to save the state of an artifact to disk.
+const ArtifactStateKeysKey = "packer_state_keys"
+
+// SavedArtifact is an Artifact that has been saved to disk after the
+// builder of a build ran, so that post-processors can be run against it
+// again later without re-running the builder.
+//
+// State values are saved as JSON, so after loading they are the generic
+// JSON types (string, float64, []interface{}, map[string]interface{})
+// rather than the types the original artifact returned. The only
+// exception is that maps with only string values are restored as
+// map[string]string.
+type SavedArtifact struct {
+	BuildName      string                 `json:"build_name"`
+	BuilderIdValue string                 `json:"builder_id"`
+	IdValue        string                 `json:"id"`
+	FilesValue     []string               `json:"files"`
+	StringValue    string                 `json:"string"`
+	StateValues    map[string]interface{} `json:"state"`
+}
+
+// NewSavedArtifact creates a SavedArtifact from the given artifact that
+// was built by the build with the given name.
+func NewSavedArtifact(buildName string, a Artifact) *SavedArtifact {
+	result := &SavedArtifact{
+		BuildName:      buildName,
+		BuilderIdValue: a.BuilderId(),
+		IdValue:        a.Id(),
+		FilesValue:     a.Files(),
+		StringValue:    a.String(),
+		StateValues:    make(map[string]interface{}),
+	}
+
+	if keys, ok := a.State(ArtifactStateKeysKey).([]string); ok {
+		for _, key := range keys {
+			result.StateValues[key] = a.State(key)
+		}
+	}
+
+	return result
+}
+
+// LoadArtifact loads a SavedArtifact from the file at the given path.
+func LoadArtifact(path string) (*SavedArtifact, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var result SavedArtifact
+	if err := json.NewDecoder(f).Decode(&result); err != nil {
+		return nil, fmt.Errorf("Error parsing saved artifact: %s", err)
+	}
+
+	for key, value := range result.StateValues {
+		result.StateValues[key] = restoreStringMap(value)
+	}
+
+	return &result, nil
+}
+
+// Save writes the artifact to the file at the given path.
+func (a *SavedArtifact) Save(path string) error {
+	data, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(data)
+	return err
+}
+
+func (a *SavedArtifact) BuilderId() string {
+	return a.BuilderIdValue
+}
+
+func (a *SavedArtifact) Files() []string {
+	return a.FilesValue
+}
+
+func (a *SavedArtifact) Id() string {
+	return a.IdValue
+}
+
+func (a *SavedArtifact) String() string {
+	return a.StringValue
+}
+
+func (a *SavedArtifact) State(name string) interface{} {
+	if name == ArtifactStateKeysKey {
+		keys := make([]string, 0, len(a.StateValues))
+		for key := range a.StateValues {
+			keys = append(keys, key)
+		}
+
+		return keys
+	}
+
+	return a.StateValues[name]
+}
+
+// Destroy does nothing, since a saved artifact doesn't have access to
+// the builder that knows how to destroy it.
+func (a *SavedArtifact) Destroy() error {
+	log.Printf("Not destroying saved artifact from build '%s': %s", a.BuildName, a.IdValue)
+	return nil
+}
+
+// restoreStringMap turns a map decoded from JSON that only contains
+// strings back into a map[string]string.
+func restoreStringMap(v interface{}) interface{} {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return v
+	}
+
+	result := make(map[string]string)
+	for key, raw := range m {
+		value, ok := raw.(string)
+		if !ok {
+			return v
+		}
+
+		result[key] = value
+	}
+
+	return result
+}
@@ -0,0 +1,86 @@
+package packer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSavedArtifact_Implements(t *testing.T) {
+	var raw interface{}
+	raw = &SavedArtifact{}
+	if _, ok := raw.(Artifact); !ok {
+		t.Fatal("SavedArtifact must be an Artifact")
+	}
+}
+
+func TestSavedArtifact_SaveLoad(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	original := &MockArtifact{
+		StateValues: map[string]interface{}{
+			"foo":  "bar",
+			"amis": map[string]string{"us-east-1": "ami-1234"},
+		},
+	}
+
+	path := filepath.Join(td, "artifact.json")
+	if err := NewSavedArtifact("test", original).Save(path); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	a, err := LoadArtifact(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if a.BuildName != "test" {
+		t.Fatalf("bad: %s", a.BuildName)
+	}
+
+	if a.BuilderId() != original.BuilderId() {
+		t.Fatalf("bad: %s", a.BuilderId())
+	}
+
+	if a.Id() != original.Id() {
+		t.Fatalf("bad: %s", a.Id())
+	}
+
+	if a.State("foo") != "bar" {
+		t.Fatalf("bad: %#v", a.State("foo"))
+	}
+
+	expected := map[string]string{"us-east-1": "ami-1234"}
+	if !reflect.DeepEqual(a.State("amis"), expected) {
+		t.Fatalf("bad: %#v", a.State("amis"))
+	}
+
+	if a.State("nope") != nil {
+		t.Fatalf("bad: %#v", a.State("nope"))
+	}
+
+	if err := a.Destroy(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestLoadArtifact_invalid(t *testing.T) {
+	tf, err := ioutil.TempFile("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Remove(tf.Name())
+
+	tf.Write([]byte("{"))
+	tf.Close()
+
+	if _, err := LoadArtifact(tf.Name()); err == nil {
+		t.Fatal("should have error")
+	}
+}
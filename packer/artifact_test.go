@@ -26,6 +26,10 @@ func (*TestArtifact) String() string {
 	return "string"
 }
 
+func (*TestArtifact) State(name string) interface{} {
+	return nil
+}
+
 func (a *TestArtifact) Destroy() error {
 	a.destroyCalled = true
 	return nil
@@ -45,6 +45,12 @@ type Build interface {
 	// of what is built. If anything goes wrong, an error is returned.
 	Run(Ui, Cache) ([]Artifact, error)
 
+	// PostProcess runs only the post-processors of the build against
+	// the given artifact, which is usually a SavedArtifact from an
+	// earlier run of this build. The given artifact is never destroyed.
+	// Prepare must be called prior to this.
+	PostProcess(Ui, Artifact) ([]Artifact, error)
+
 	// Cancel will cancel a running build. This will block until the build
 	// is actually completely cancelled.
 	Cancel()
@@ -63,6 +69,13 @@ type Build interface {
 	// When SetForce is set to true, existing artifacts from the build are
 	// deleted prior to the build.
 	SetForce(bool)
+
+	// SetArtifactPath sets the path of a file that the artifact of the
+	// builder is saved to with SavedArtifact before any post-processors
+	// run, so that the post-processors can be run against it again later.
+	// If this is empty, the artifact isn't saved. This must be called
+	// prior to Run.
+	SetArtifactPath(string)
 }
 
 // A build struct represents a single build job, the result of which should
@@ -79,6 +92,7 @@ type coreBuild struct {
 	provisioners   []coreBuildProvisioner
 	variables      map[string]coreBuildVariable
 
+	artifactPath  string
 	debug         bool
 	force         bool
 	l             sync.Mutex
@@ -228,7 +242,6 @@ func (b *coreBuild) Run(originalUi Ui, cache Cache) ([]Artifact, error) {
 	}
 
 	hook := &DispatchHook{Mapping: hooks}
-	artifacts := make([]Artifact, 0, 1)
 
 	// The builder just has a normal Ui, but targetted
 	builderUi := &TargettedUi{
@@ -248,6 +261,40 @@ func (b *coreBuild) Run(originalUi Ui, cache Cache) ([]Artifact, error) {
 		return nil, nil
 	}
 
+	if b.artifactPath != "" {
+		log.Printf("Saving builder artifact to: %s", b.artifactPath)
+		saved := NewSavedArtifact(b.name, builderArtifact)
+		if err := saved.Save(b.artifactPath); err != nil {
+			builderUi.Error(fmt.Sprintf("Error saving artifact: %s", err))
+		}
+	}
+
+	return b.runPostProcessors(originalUi, builderArtifact, false)
+}
+
+// PostProcess runs the post-processors against an existing artifact.
+// Prepare must be called prior to running this.
+func (b *coreBuild) PostProcess(originalUi Ui, artifact Artifact) ([]Artifact, error) {
+	if !b.prepareCalled {
+		panic("Prepare must be called first")
+	}
+
+	return b.runPostProcessors(originalUi, artifact, true)
+}
+
+// runPostProcessors runs the post-processor chains against the artifact
+// of the builder. The builder artifact is destroyed unless a post-processor
+// asks for it to be kept, keepBuilderArtifact is true, or a post-processor
+// fails after the artifact was saved, so that it can be post-processed
+// again.
+func (b *coreBuild) runPostProcessors(originalUi Ui, builderArtifact Artifact, keepBuilderArtifact bool) ([]Artifact, error) {
+	builderUi := &TargettedUi{
+		Target: b.Name(),
+		Ui:     originalUi,
+	}
+
+	var err error
+	artifacts := make([]Artifact, 0, 1)
 	errors := make([]error, 0)
 	keepOriginalArtifact := len(b.postProcessors) == 0
 
@@ -306,6 +353,13 @@ PostProcessorRunSeqLoop:
 		}
 	}
 
+	// Without a saved artifact, there is nothing to post-process again
+	saved := b.artifactPath != ""
+	if !keepOriginalArtifact && (keepBuilderArtifact || (saved && len(errors) > 0)) {
+		log.Printf("Keeping original artifact for build '%s'", b.name)
+		keepOriginalArtifact = true
+	}
+
 	if keepOriginalArtifact {
 		artifacts = append(artifacts, nil)
 		copy(artifacts[1:], artifacts)
@@ -332,6 +386,14 @@ func (b *coreBuild) SetDebug(val bool) {
 	b.debug = val
 }
 
+func (b *coreBuild) SetArtifactPath(path string) {
+	if b.prepareCalled {
+		panic("prepare has already been called")
+	}
+
+	b.artifactPath = path
+}
+
 func (b *coreBuild) SetForce(val bool) {
 	if b.prepareCalled {
 		panic("prepare has already been called")
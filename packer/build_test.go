@@ -2,6 +2,10 @@ package packer
 
 import (
 	"cgl.tideland.biz/asserts"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -355,6 +359,122 @@ func TestBuild_Run_Artifacts(t *testing.T) {
 	}
 }
 
+func TestBuild_Run_ArtifactPath(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	path := filepath.Join(td, "test.json")
+	build := testBuild()
+	build.SetArtifactPath(path)
+	build.Prepare(nil)
+	if _, err := build.Run(testUi(), &TestCache{}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	saved, err := LoadArtifact(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if saved.BuildName != "test" {
+		t.Fatalf("bad: %s", saved.BuildName)
+	}
+
+	if saved.Id() != "b" {
+		t.Fatalf("bad: %s", saved.Id())
+	}
+}
+
+func TestBuild_Run_PostProcessorErrorKeepsArtifact(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	failing := [][]coreBuildPostProcessor{
+		[]coreBuildPostProcessor{
+			coreBuildPostProcessor{
+				&TestPostProcessor{err: errors.New("failed")}, "pp", make(map[string]interface{}), false,
+			},
+		},
+	}
+
+	// The saved artifact is kept so that it can be post-processed again
+	build := testBuild()
+	build.postProcessors = failing
+	build.SetArtifactPath(filepath.Join(td, "test.json"))
+	build.Prepare(nil)
+	artifacts, err := build.Run(testUi(), &TestCache{})
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	if len(artifacts) != 1 || artifacts[0].Id() != "b" {
+		t.Fatalf("bad: %#v", artifacts)
+	}
+
+	if build.builder.(*TestBuilder).runArtifact.destroyCalled {
+		t.Fatal("saved artifact should not be destroyed")
+	}
+
+	// Without an artifact path, nothing is saved, so it is destroyed
+	build = testBuild()
+	build.postProcessors = failing
+	build.Prepare(nil)
+	artifacts, err = build.Run(testUi(), &TestCache{})
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	if len(artifacts) != 0 {
+		t.Fatalf("bad: %#v", artifacts)
+	}
+
+	if !build.builder.(*TestBuilder).runArtifact.destroyCalled {
+		t.Fatal("artifact should be destroyed")
+	}
+}
+
+func TestBuild_PostProcess(t *testing.T) {
+	build := testBuild()
+	build.postProcessors = [][]coreBuildPostProcessor{
+		[]coreBuildPostProcessor{
+			coreBuildPostProcessor{&TestPostProcessor{artifactId: "pp"}, "pp", make(map[string]interface{}), false},
+		},
+	}
+
+	build.Prepare(nil)
+
+	artifact := &TestArtifact{id: "saved"}
+	artifacts, err := build.PostProcess(testUi(), artifact)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expectedIds := []string{"saved", "pp"}
+	artifactIds := make([]string, len(artifacts))
+	for i, artifact := range artifacts {
+		artifactIds[i] = artifact.Id()
+	}
+
+	if !reflect.DeepEqual(artifactIds, expectedIds) {
+		t.Fatalf("unexpected ids: %#v", artifactIds)
+	}
+
+	if artifact.destroyCalled {
+		t.Fatal("artifact should not be destroyed")
+	}
+
+	builder := build.builder.(*TestBuilder)
+	if builder.runCalled {
+		t.Fatal("builder should not run")
+	}
+}
+
 func TestBuild_RunBeforePrepare(t *testing.T) {
 	assert := asserts.NewTestingAsserts(t, true)
 
@@ -6,6 +6,7 @@ type TestBuilder struct {
 	prepareCalled bool
 	prepareConfig []interface{}
 	runCalled     bool
+	runArtifact   *TestArtifact
 	runCache      Cache
 	runHook       Hook
 	runUi         Ui
@@ -23,7 +24,8 @@ func (tb *TestBuilder) Run(ui Ui, h Hook, c Cache) (Artifact, error) {
 	tb.runHook = h
 	tb.runUi = ui
 	tb.runCache = c
-	return &TestArtifact{id: tb.artifactId}, nil
+	tb.runArtifact = &TestArtifact{id: tb.artifactId}
+	return tb.runArtifact, nil
 }
 
 func (tb *TestBuilder) Cancel() {
@@ -1,12 +1,24 @@
 package packer
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/mitchellh/iochan"
 	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 )
 
+// DefaultReconnectTimeout is how long communicators wait for the machine
+// to come back after a command that expects to be disconnected, if the
+// command doesn't set its own ReconnectTimeout.
+const DefaultReconnectTimeout = 5 * time.Minute
+
 // RemoteCmd represents a remote command being prepared or run.
 type RemoteCmd struct {
 	// Command is the command to run remotely. This is executed as if
@@ -14,6 +26,21 @@ type RemoteCmd struct {
 	// necessary.
 	Command string
 
+	// Env are environment variables, in "key=value" form, to set for the
+	// command. Communicators quote them as needed, so the values can hold
+	// any characters.
+	Env []string
+
+	// Dir is the directory to run the command in. If empty, the command
+	// runs in the default directory of the communicator, such as the home
+	// directory of the SSH user.
+	Dir string
+
+	// Elevated, if true, runs the command with administrative privileges,
+	// such as with sudo over SSH. Communicators that already run commands
+	// with those privileges ignore it.
+	Elevated bool
+
 	// Stdin specifies the process's standard input. If Stdin is
 	// nil, the process reads from an empty bytes.Buffer.
 	Stdin io.Reader
@@ -25,6 +52,18 @@ type RemoteCmd struct {
 	Stdout io.Writer
 	Stderr io.Writer
 
+	// ExpectDisconnect, if true, means that the command is expected to
+	// drop the connection to the machine, such as by rebooting it. If the
+	// connection is lost, the communicator waits for the machine to come
+	// back and then marks the command as exited successfully, rather than
+	// failing it. The command shouldn't exit before the connection drops.
+	ExpectDisconnect bool
+
+	// ReconnectTimeout is how long to wait for the machine to come back
+	// when ExpectDisconnect is set. If zero, DefaultReconnectTimeout is
+	// used.
+	ReconnectTimeout time.Duration
+
 	// This will be set to true when the remote command has exited. It
 	// shouldn't be set manually by the user, but there is no harm in
 	// doing so.
@@ -60,8 +99,9 @@ type Communicator interface {
 	Upload(string, io.Reader) error
 
 	// UploadDir uploads the contents of a directory recursively to
-	// the remote path. It also takes an optional slice of paths to
-	// ignore when uploading.
+	// the remote path. It also takes an optional slice of exclude
+	// patterns, as matched by ExcludeMatch, of paths to ignore when
+	// uploading.
 	//
 	// The folder name of the source folder should be created unless there
 	// is a trailing slash on the source "/". For example: "/tmp/src" as
@@ -73,6 +113,224 @@ type Communicator interface {
 	// with the contents writing to the given writer. This method will
 	// block until it completes.
 	Download(string, io.Writer) error
+
+	// DownloadDir downloads the contents of a remote directory recursively
+	// to the local path dst. It also takes an optional slice of exclude
+	// patterns, as matched by ExcludeMatch, of paths to ignore when
+	// downloading.
+	//
+	// The source directory name is created in the destination unless
+	// there is a trailing slash on the source, just like UploadDir.
+	DownloadDir(src string, dst string, exclude []string) error
+}
+
+// SyncDirOptions are the options for uploading a directory incrementally
+// with SyncDir.
+type SyncDirOptions struct {
+	// Exclude are the patterns, as matched by ExcludeMatch, of paths to
+	// ignore when uploading. Excluded paths are never deleted.
+	Exclude []string
+
+	// Delete, if true, deletes the files in the destination that don't
+	// exist in the source.
+	Delete bool
+}
+
+// A DirSyncer is a Communicator that can upload a directory incrementally,
+// only transferring the files that differ from the ones already on the
+// machine.
+type DirSyncer interface {
+	// SyncDir uploads the directory like UploadDir, but skips the files
+	// that the destination already has with the same contents.
+	SyncDir(dst string, src string, opts *SyncDirOptions) error
+}
+
+// SyncDir uploads the directory incrementally if the communicator is a
+// DirSyncer, and otherwise uploads all of it with UploadDir, in which case
+// files aren't deleted.
+func SyncDir(c Communicator, dst string, src string, opts *SyncDirOptions) error {
+	if opts == nil {
+		opts = new(SyncDirOptions)
+	}
+
+	if syncer, ok := c.(DirSyncer); ok {
+		return syncer.SyncDir(dst, src, opts)
+	}
+
+	if opts.Delete {
+		log.Printf("Communicator can't sync directories, so not deleting files in: %s", dst)
+	}
+
+	return c.UploadDir(dst, src, opts.Exclude)
+}
+
+// ExcludeMatch returns true if the path, relative to the directory being
+// transferred and separated by slashes, matches one of the exclude
+// patterns. The patterns are shell globs as used by path.Match. Like
+// rsync(1), a pattern containing a slash other than a trailing one is
+// matched against the whole relative path, and any other pattern against
+// the name of every element of it. Excluding a directory excludes
+// everything in it.
+func ExcludeMatch(rel string, exclude []string) bool {
+	rel = strings.Trim(rel, "/")
+	for _, pattern := range exclude {
+		pattern = strings.TrimRight(filepath.ToSlash(pattern), "/")
+		anchored := strings.Contains(pattern, "/")
+		pattern = strings.TrimLeft(pattern, "/")
+		if pattern == "" {
+			continue
+		}
+
+		parts := strings.Split(rel, "/")
+		for i := range parts {
+			subject := parts[i]
+			if anchored {
+				subject = strings.Join(parts[:i+1], "/")
+			}
+
+			if matched, _ := path.Match(pattern, subject); matched {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// UploadOptions are the metadata of a file uploaded with UploadFile.
+type UploadOptions struct {
+	// Mode is the permission bits of the file. If it is zero, the file gets
+	// the default permissions of the communicator, which is 0644 before
+	// the umask on Unix machines, and the mode of an existing file isn't
+	// changed.
+	Mode os.FileMode
+
+	// Owner and Group, if not empty, are the user and group, by name or
+	// ID, that the file is given to. Changing them requires elevated
+	// privileges, so it is done with sudo.
+	Owner string
+	Group string
+}
+
+// RemoteCmd returns the command that applies the owner, group and mode
+// to the file at path on a machine with a POSIX shell, or nil if none of
+// them are set. The command is elevated if it changes the owner or group.
+func (o *UploadOptions) RemoteCmd(path string) *RemoteCmd {
+	if o == nil {
+		return nil
+	}
+
+	var commands []string
+	elevated := false
+	if o.Owner != "" || o.Group != "" {
+		spec := o.Owner
+		if o.Group != "" {
+			spec += ":" + o.Group
+		}
+
+		commands = append(commands, fmt.Sprintf("chown %s %s", ShellQuote(spec), ShellQuote(path)))
+		elevated = true
+	}
+
+	if o.Mode != 0 {
+		commands = append(commands, fmt.Sprintf("chmod %04o %s", uint32(o.Mode.Perm()), ShellQuote(path)))
+	}
+
+	if len(commands) == 0 {
+		return nil
+	}
+
+	return &RemoteCmd{
+		Command:  strings.Join(commands, " && "),
+		Elevated: elevated,
+	}
+}
+
+// A FileUploader is a Communicator that can upload a file with metadata,
+// such as its mode and owner.
+type FileUploader interface {
+	// UploadFile uploads the file like Upload, and then applies the
+	// metadata in the options to it.
+	UploadFile(dst string, r io.Reader, opts *UploadOptions) error
+}
+
+// UploadFile uploads the file with its metadata if the communicator is a
+// FileUploader, and otherwise uploads it with Upload and then applies the
+// metadata with the commands of UploadOptions.RemoteCmd.
+func UploadFile(c Communicator, dst string, r io.Reader, opts *UploadOptions) error {
+	if opts == nil {
+		opts = new(UploadOptions)
+	}
+
+	if uploader, ok := c.(FileUploader); ok {
+		return uploader.UploadFile(dst, r, opts)
+	}
+
+	if err := c.Upload(dst, r); err != nil {
+		return err
+	}
+
+	cmd := opts.RemoteCmd(dst)
+	if cmd == nil {
+		return nil
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := c.Start(cmd); err != nil {
+		return err
+	}
+
+	cmd.Wait()
+	if cmd.ExitStatus != 0 {
+		return fmt.Errorf(
+			"Error setting the mode and owner of %s, exit status %d: %s",
+			dst, cmd.ExitStatus, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+// ShellCommand returns the command as a single POSIX shell command that
+// sets the environment variables of the RemoteCmd, changes to its
+// directory and runs it with sudo if it is elevated. Communicators for
+// machines with a POSIX shell use it to run the command. If none of those
+// are set, the command is returned unchanged.
+func (r *RemoteCmd) ShellCommand() string {
+	if len(r.Env) == 0 && r.Dir == "" && !r.Elevated {
+		return r.Command
+	}
+
+	command := r.Command
+	if r.Dir != "" {
+		command = fmt.Sprintf("cd %s || exit 1\n%s", ShellQuote(r.Dir), command)
+	}
+
+	// The variables are set with env so that sudo doesn't reset them
+	parts := make([]string, 0, len(r.Env)+4)
+	if r.Elevated {
+		parts = append(parts, "sudo")
+	}
+
+	if len(r.Env) > 0 {
+		parts = append(parts, "env")
+		for _, kv := range r.Env {
+			if !strings.Contains(kv, "=") {
+				kv += "="
+			}
+
+			parts = append(parts, ShellQuote(kv))
+		}
+	}
+
+	parts = append(parts, "sh", "-c", ShellQuote(command))
+	return strings.Join(parts, " ")
+}
+
+// ShellQuote quotes the string so that a POSIX shell treats it as a single
+// word, whatever characters it has.
+func ShellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'"'"'`, -1) + "'"
 }
 
 // StartWithUi runs the remote command and streams the output to any
@@ -23,10 +23,16 @@ type MockCommunicator struct {
 	UploadDirDst     string
 	UploadDirSrc     string
 	UploadDirExclude []string
+	UploadDirFunc    func(dst string, src string, excl []string) error
 
 	DownloadCalled bool
 	DownloadPath   string
 	DownloadData   string
+
+	DownloadDirSrc     string
+	DownloadDirDst     string
+	DownloadDirExclude []string
+	DownloadDirFunc    func(src string, dst string, excl []string) error
 }
 
 func (c *MockCommunicator) Start(rc *RemoteCmd) error {
@@ -73,13 +79,10 @@ func (c *MockCommunicator) Upload(path string, r io.Reader) error {
 	c.UploadPath = path
 
 	var data bytes.Buffer
-	if _, err := io.Copy(&data, r); err != nil {
-		panic(err)
-	}
-
+	_, err := io.Copy(&data, r)
 	c.UploadData = data.String()
 
-	return nil
+	return err
 }
 
 func (c *MockCommunicator) UploadDir(dst string, src string, excl []string) error {
@@ -87,6 +90,10 @@ func (c *MockCommunicator) UploadDir(dst string, src string, excl []string) erro
 	c.UploadDirSrc = src
 	c.UploadDirExclude = excl
 
+	if c.UploadDirFunc != nil {
+		return c.UploadDirFunc(dst, src, excl)
+	}
+
 	return nil
 }
 
@@ -97,3 +104,15 @@ func (c *MockCommunicator) Download(path string, w io.Writer) error {
 
 	return nil
 }
+
+func (c *MockCommunicator) DownloadDir(src string, dst string, excl []string) error {
+	c.DownloadDirSrc = src
+	c.DownloadDirDst = dst
+	c.DownloadDirExclude = excl
+
+	if c.DownloadDirFunc != nil {
+		return c.DownloadDirFunc(src, dst, excl)
+	}
+
+	return nil
+}
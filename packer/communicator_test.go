@@ -2,6 +2,7 @@ package packer
 
 import (
 	"bytes"
+	"io"
 	"strings"
 	"testing"
 	"time"
@@ -60,3 +61,190 @@ func TestRemoteCmd_Wait(t *testing.T) {
 		t.Fatal("never got exit notification")
 	}
 }
+
+func TestRemoteCmd_ShellCommand(t *testing.T) {
+	cases := []struct {
+		Cmd      *RemoteCmd
+		Expected string
+	}{
+		{
+			&RemoteCmd{Command: "echo foo"},
+			"echo foo",
+		},
+		{
+			&RemoteCmd{Command: "echo $FOO", Env: []string{"FOO=it's", "BAR"}},
+			`env 'FOO=it'"'"'s' 'BAR=' sh -c 'echo $FOO'`,
+		},
+		{
+			&RemoteCmd{Command: "ls", Dir: "/tmp/my dir"},
+			"sh -c 'cd '\"'\"'/tmp/my dir'\"'\"' || exit 1\nls'",
+		},
+		{
+			&RemoteCmd{Command: "whoami", Env: []string{"FOO=bar"}, Elevated: true},
+			"sudo env 'FOO=bar' sh -c 'whoami'",
+		},
+	}
+
+	for _, tc := range cases {
+		if actual := tc.Cmd.ShellCommand(); actual != tc.Expected {
+			t.Fatalf("bad: %s", actual)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	cases := map[string]string{
+		"foo":       "'foo'",
+		"foo bar":   "'foo bar'",
+		"it's":      `'it'"'"'s'`,
+		"$HOME/`x`": "'$HOME/`x`'",
+	}
+
+	for input, expected := range cases {
+		if actual := ShellQuote(input); actual != expected {
+			t.Fatalf("bad: %s", actual)
+		}
+	}
+}
+
+func TestExcludeMatch(t *testing.T) {
+	cases := []struct {
+		Rel      string
+		Exclude  []string
+		Expected bool
+	}{
+		{"foo", nil, false},
+		{"foo", []string{"foo"}, true},
+		{"foo/bar", []string{"foo/"}, true},
+		{"sub/foo", []string{"foo"}, true},
+		{"sub/foo/bar", []string{"foo"}, true},
+		{"foobar", []string{"foo"}, false},
+		{"sub/foo", []string{"/foo"}, false},
+		{"sub/foo", []string{"sub/foo"}, true},
+		{"other/sub/foo", []string{"sub/foo"}, false},
+		{"sub/foo.log", []string{"*.log"}, true},
+		{"sub/foo.log/bar", []string{"*.log"}, true},
+		{"sub/foo.txt", []string{"*.log"}, false},
+		{"sub/foo/bar", []string{"sub/*/bar"}, true},
+		{"sub/.git/config", []string{".git"}, true},
+		{"sub/a", []string{"sub/?"}, true},
+	}
+
+	for _, tc := range cases {
+		if ExcludeMatch(tc.Rel, tc.Exclude) != tc.Expected {
+			t.Fatalf("bad: %s %#v", tc.Rel, tc.Exclude)
+		}
+	}
+}
+
+type testDirSyncer struct {
+	MockCommunicator
+
+	SyncDirDst  string
+	SyncDirOpts *SyncDirOptions
+}
+
+func (c *testDirSyncer) SyncDir(dst string, src string, opts *SyncDirOptions) error {
+	c.SyncDirDst = dst
+	c.SyncDirOpts = opts
+	return nil
+}
+
+func TestSyncDir(t *testing.T) {
+	opts := &SyncDirOptions{Exclude: []string{"*.log"}, Delete: true}
+
+	syncer := new(testDirSyncer)
+	if err := SyncDir(syncer, "/dst", "src", opts); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if syncer.SyncDirDst != "/dst" || syncer.SyncDirOpts != opts {
+		t.Fatalf("should sync: %#v", syncer)
+	}
+
+	// Other communicators upload all of it
+	comm := new(MockCommunicator)
+	if err := SyncDir(comm, "/dst", "src", opts); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if comm.UploadDirDst != "/dst" || comm.UploadDirExclude[0] != "*.log" {
+		t.Fatalf("should upload: %#v", comm)
+	}
+}
+
+func TestUploadOptions_RemoteCmd(t *testing.T) {
+	var opts *UploadOptions
+	if cmd := opts.RemoteCmd("/tmp/foo"); cmd != nil {
+		t.Fatalf("bad: %#v", cmd)
+	}
+
+	opts = &UploadOptions{Mode: 0755}
+	cmd := opts.RemoteCmd("/tmp/foo")
+	if cmd.Command != "chmod 0755 '/tmp/foo'" || cmd.Elevated {
+		t.Fatalf("bad: %#v", cmd)
+	}
+
+	opts = &UploadOptions{Mode: 0600, Owner: "root", Group: "wheel"}
+	cmd = opts.RemoteCmd("/tmp/my file")
+	expected := "chown 'root:wheel' '/tmp/my file' && chmod 0600 '/tmp/my file'"
+	if cmd.Command != expected || !cmd.Elevated {
+		t.Fatalf("bad: %#v", cmd)
+	}
+}
+
+type testFileUploader struct {
+	MockCommunicator
+
+	UploadFileDst  string
+	UploadFileOpts *UploadOptions
+}
+
+func (c *testFileUploader) UploadFile(dst string, r io.Reader, opts *UploadOptions) error {
+	c.UploadFileDst = dst
+	c.UploadFileOpts = opts
+	return nil
+}
+
+func TestUploadFile(t *testing.T) {
+	opts := &UploadOptions{Mode: 0755, Owner: "root"}
+
+	uploader := new(testFileUploader)
+	if err := UploadFile(uploader, "/dst", strings.NewReader("foo"), opts); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if uploader.UploadFileDst != "/dst" || uploader.UploadFileOpts != opts {
+		t.Fatalf("should upload with the options: %#v", uploader)
+	}
+
+	// Other communicators upload it and then apply the options
+	comm := new(MockCommunicator)
+	if err := UploadFile(comm, "/dst", strings.NewReader("foo"), opts); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if comm.UploadPath != "/dst" || comm.UploadData != "foo" {
+		t.Fatalf("should upload: %#v", comm)
+	}
+
+	if !comm.StartCalled || comm.StartCmd.Command != "chown 'root' '/dst' && chmod 0755 '/dst'" {
+		t.Fatalf("should set the mode and owner: %#v", comm.StartCmd)
+	}
+
+	comm = new(MockCommunicator)
+	comm.StartExitStatus = 1
+	if err := UploadFile(comm, "/dst", strings.NewReader("foo"), opts); err == nil {
+		t.Fatal("should fail if the command fails")
+	}
+
+	// Without options, nothing is run
+	comm = new(MockCommunicator)
+	if err := UploadFile(comm, "/dst", strings.NewReader("foo"), nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if comm.StartCalled {
+		t.Fatal("should not run a command")
+	}
+}
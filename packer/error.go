@@ -0,0 +1,38 @@
+package packer
+
+import (
+	"fmt"
+)
+
+// CancelledError is returned when an operation, such as a build, stopped
+// because it was cancelled rather than because something failed.
+type CancelledError struct {
+	Message string
+}
+
+func (e *CancelledError) Error() string {
+	return e.Message
+}
+
+// TimeoutError is returned when waiting for something, such as SSH to
+// become available or a machine to shut down, took too long.
+type TimeoutError struct {
+	Message string
+}
+
+func (e *TimeoutError) Error() string {
+	return e.Message
+}
+
+// ValidationError is an error with the value of a single configuration
+// key. Builders, provisioners and post-processors can return these from
+// Prepare so that the key that is wrong can be reported along with the
+// error.
+type ValidationError struct {
+	Key     string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Key, e.Message)
+}
@@ -0,0 +1,25 @@
+package packer
+
+import (
+	"testing"
+)
+
+func TestErrors_Impl(t *testing.T) {
+	var raw interface{}
+	for _, raw = range []interface{}{
+		new(CancelledError),
+		new(TimeoutError),
+		new(ValidationError),
+	} {
+		if _, ok := raw.(error); !ok {
+			t.Fatalf("%#v must implement error", raw)
+		}
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	err := &ValidationError{"ssh_port", "must be a number"}
+	if err.Error() != "ssh_port: must be a number" {
+		t.Fatalf("bad: %s", err.Error())
+	}
+}
@@ -16,7 +16,7 @@ func (b *cmdBuilder) Prepare(config ...interface{}) error {
 		b.checkExit(r, nil)
 	}()
 
-	return b.builder.Prepare(config...)
+	return b.client.callError(b.builder.Prepare(config...))
 }
 
 func (b *cmdBuilder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packer.Artifact, error) {
@@ -25,7 +25,8 @@ func (b *cmdBuilder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (pa
 		b.checkExit(r, nil)
 	}()
 
-	return b.builder.Run(ui, hook, cache)
+	artifact, err := b.builder.Run(ui, hook, cache)
+	return artifact, b.client.callError(err)
 }
 
 func (b *cmdBuilder) Cancel() {
@@ -2,7 +2,12 @@ package plugin
 
 import (
 	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"log"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -18,6 +23,17 @@ func (helperBuilder) Run(packer.Ui, packer.Hook, packer.Cache) (packer.Artifact,
 
 func (helperBuilder) Cancel() {}
 
+// helperCrashBuilder is a builder that crashes the plugin in Prepare.
+type helperCrashBuilder struct {
+	helperBuilder
+}
+
+func (helperCrashBuilder) Prepare(...interface{}) error {
+	log.Println("preparing to crash")
+	go panic("crash")
+	select {}
+}
+
 func TestBuilder_NoExist(t *testing.T) {
 	c := NewClient(&ClientConfig{Cmd: exec.Command("i-should-not-exist")})
 	defer c.Kill()
@@ -37,3 +53,49 @@ func TestBuilder_Good(t *testing.T) {
 		t.Fatalf("should not have error: %s", err)
 	}
 }
+
+func TestBuilder_crash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	process := helperProcess("builder-crash")
+	c := NewClient(&ClientConfig{Cmd: process, CrashLogDir: dir})
+	defer c.Kill()
+
+	b, err := c.Builder()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = b.Prepare()
+	crash, ok := err.(*CrashError)
+	if !ok {
+		t.Fatalf("should be a crash error: %#v", err)
+	}
+
+	if crash.Path != process.Path {
+		t.Fatalf("bad path: %s", crash.Path)
+	}
+
+	for _, s := range []string{"preparing to crash", "panic: crash"} {
+		if !strings.Contains(crash.Error(), s) {
+			t.Fatalf("error should contain %q: %s", s, crash.Error())
+		}
+	}
+
+	data, err := ioutil.ReadFile(crash.LogPath)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if filepath.Dir(crash.LogPath) != dir {
+		t.Fatalf("bad log path: %s", crash.LogPath)
+	}
+
+	if !strings.Contains(string(data), "panic: crash") {
+		t.Fatalf("bad crash log: %s", data)
+	}
+}
@@ -9,10 +9,9 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
-	"net"
-	"net/rpc"
 	"os"
 	"os/exec"
+	"runtime"
 	"strings"
 	"sync"
 	"time"
@@ -31,11 +30,23 @@ var managedClients = make([]*Client, 0, 5)
 // RPC address, and returning various types of packer interface implementations
 // across the multi-process communication layer.
 type Client struct {
-	config      *ClientConfig
-	exited      bool
-	doneLogging chan struct{}
-	l           sync.Mutex
-	address     string
+	config        *ClientConfig
+	exited        bool
+	doneLogging   chan struct{}
+	l             sync.Mutex
+	address       string
+	componentType string
+	socketDir     string
+	secret        string
+
+	// These are used to detect and report crashes of the plugin. crash
+	// is set before exitCh is closed, if the plugin crashed. exitL
+	// guards exited and killed.
+	output outputTail
+	exitCh chan struct{}
+	crash  *CrashError
+	killed bool
+	exitL  sync.Mutex
 }
 
 // ClientConfig is the configuration used to initialize a new
@@ -53,8 +64,8 @@ type ClientConfig struct {
 	Managed bool
 
 	// The minimum and maximum port to use for communicating with
-	// the subprocess. If not set, this defaults to 10,000 and 25,000
-	// respectively.
+	// the subprocess if Unix domain sockets can't be used. If not set,
+	// this defaults to 10,000 and 25,000 respectively.
 	MinPort, MaxPort uint
 
 	// StartTimeout is the timeout to wait for the plugin to say it
@@ -64,6 +75,10 @@ type ClientConfig struct {
 	// If non-nil, then the stderr of the client will be written to here
 	// (as well as the log).
 	Stderr io.Writer
+
+	// If set, a crash log with the last output of the plugin is written
+	// to this directory if the plugin crashes.
+	CrashLogDir string
 }
 
 // This makes sure all the managed subprocesses are killed and properly
@@ -122,64 +137,64 @@ func NewClient(config *ClientConfig) (c *Client) {
 
 // Tells whether or not the underlying process has exited.
 func (c *Client) Exited() bool {
-	c.l.Lock()
-	defer c.l.Unlock()
+	c.exitL.Lock()
+	defer c.exitL.Unlock()
 	return c.exited
 }
 
 // Returns a builder implementation that is communicating over this
 // client. If the client hasn't been started, this will start it.
 func (c *Client) Builder() (packer.Builder, error) {
-	client, err := c.rpcClient()
+	client, err := c.rpcClient(ComponentBuilder)
 	if err != nil {
 		return nil, err
 	}
 
-	return &cmdBuilder{packrpc.Builder(client), c}, nil
+	return &cmdBuilder{client.Builder(), c}, nil
 }
 
 // Returns a command implementation that is communicating over this
 // client. If the client hasn't been started, this will start it.
 func (c *Client) Command() (packer.Command, error) {
-	client, err := c.rpcClient()
+	client, err := c.rpcClient(ComponentCommand)
 	if err != nil {
 		return nil, err
 	}
 
-	return &cmdCommand{packrpc.Command(client), c}, nil
+	return &cmdCommand{client.Command(), c}, nil
 }
 
 // Returns a hook implementation that is communicating over this
 // client. If the client hasn't been started, this will start it.
 func (c *Client) Hook() (packer.Hook, error) {
-	client, err := c.rpcClient()
+	client, err := c.rpcClient(ComponentHook)
 	if err != nil {
 		return nil, err
 	}
 
-	return &cmdHook{packrpc.Hook(client), c}, nil
+	return &cmdHook{client.Hook(), c}, nil
 }
 
 // Returns a post-processor implementation that is communicating over
 // this client. If the client hasn't been started, this will start it.
 func (c *Client) PostProcessor() (packer.PostProcessor, error) {
-	client, err := c.rpcClient()
+	client, err := c.rpcClient(ComponentPostProcessor)
 	if err != nil {
 		return nil, err
 	}
 
-	return &cmdPostProcessor{packrpc.PostProcessor(client), c}, nil
+	return &cmdPostProcessor{client.PostProcessor(), c}, nil
 }
 
 // Returns a provisioner implementation that is communicating over this
 // client. If the client hasn't been started, this will start it.
 func (c *Client) Provisioner() (packer.Provisioner, error) {
-	client, err := c.rpcClient()
+	client, err := c.rpcClient(ComponentProvisioner)
 	if err != nil {
 		return nil, err
 	}
 
-	return &cmdProvisioner{packrpc.Provisioner(client), c}, nil
+	return &cmdProvisioner{client.Provisioner(), c}, nil
 }
 
 // End the executing subprocess (if it is running) and perform any cleanup
@@ -195,10 +210,15 @@ func (c *Client) Kill() {
 		return
 	}
 
+	c.exitL.Lock()
+	c.killed = true
+	c.exitL.Unlock()
+
 	cmd.Process.Kill()
 
-	// Wait for the client to finish logging so we have a complete log
-	<-c.doneLogging
+	// Wait for the process to exit and the client to finish logging
+	// so we have a complete log
+	<-c.exitCh
 }
 
 // Starts the underlying subprocess, communicating with it to negotiate
@@ -217,8 +237,28 @@ func (c *Client) Start() (address string, err error) {
 
 	c.doneLogging = make(chan struct{})
 
+	// Create the secret that the plugin connection is authenticated
+	// with, so that only we can connect to the plugin.
+	c.secret, err = packrpc.NewSecret()
+	if err != nil {
+		return
+	}
+
+	// Create a private directory for the Unix domain sockets of the
+	// plugin. If this fails, the plugin falls back to TCP.
+	if runtime.GOOS != "windows" {
+		c.socketDir, err = ioutil.TempDir("", "packer-plugin")
+		if err != nil {
+			log.Printf("Error creating plugin socket directory: %s", err)
+			c.socketDir = ""
+			err = nil
+		}
+	}
+
 	env := []string{
 		fmt.Sprintf("%s=%s", MagicCookieKey, MagicCookieValue),
+		fmt.Sprintf("%s=%s", SecretKey, c.secret),
+		fmt.Sprintf("%s=%s", SocketDirKey, c.socketDir),
 		fmt.Sprintf("PACKER_PLUGIN_MIN_PORT=%d", c.config.MinPort),
 		fmt.Sprintf("PACKER_PLUGIN_MAX_PORT=%d", c.config.MaxPort),
 	}
@@ -236,6 +276,7 @@ func (c *Client) Start() (address string, err error) {
 	log.Printf("Starting plugin: %s %#v", cmd.Path, cmd.Args)
 	err = cmd.Start()
 	if err != nil {
+		c.removeSocketDir()
 		return
 	}
 
@@ -244,6 +285,10 @@ func (c *Client) Start() (address string, err error) {
 		r := recover()
 
 		if err != nil || r != nil {
+			c.exitL.Lock()
+			c.killed = true
+			c.exitL.Unlock()
+
 			cmd.Process.Kill()
 		}
 
@@ -254,26 +299,32 @@ func (c *Client) Start() (address string, err error) {
 
 	// Start goroutine to wait for process to exit
 	exitCh := make(chan struct{})
+	c.exitCh = exitCh
 	go func() {
-		// Make sure we close the write end of our stderr/stdout so
-		// that the readers send EOF properly.
-		defer stderr_w.Close()
-		defer stdout_w.Close()
-
 		// Wait for the command to end.
-		cmd.Wait()
+		exitErr := cmd.Wait()
+
+		// Close the write end of our stderr/stdout so that the readers
+		// send EOF properly, and wait for all of stderr to be read so
+		// that we have the complete output if the plugin crashed.
+		stderr_w.Close()
+		stdout_w.Close()
+		<-c.doneLogging
+
+		// The sockets are gone with the process, so remove the directory
+		c.removeSocketDir()
 
 		// Log and make sure to flush the logs write away
 		log.Printf("%s: plugin process exited\n", cmd.Path)
 		os.Stderr.Sync()
 
-		// Mark that we exited
-		close(exitCh)
+		c.checkCrash(exitErr)
 
-		// Set that we exited, which takes a lock
-		c.l.Lock()
-		defer c.l.Unlock()
+		// Mark that we exited
+		c.exitL.Lock()
 		c.exited = true
+		c.exitL.Unlock()
+		close(exitCh)
 	}()
 
 	// Start goroutine that logs the stderr
@@ -316,31 +367,53 @@ func (c *Client) Start() (address string, err error) {
 	case <-timeout:
 		err = errors.New("timeout while waiting for plugin to start")
 	case <-exitCh:
-		err = errors.New("plugin exited before we could connect")
+		err = c.crashError(errors.New("plugin exited before we could connect"))
 	case lineBytes := <-linesCh:
 		// Trim the line and split by "|" in order to get the parts of
-		// the output.
+		// the output. The handshake is "version|component type|address".
 		line := strings.TrimSpace(string(lineBytes))
-		parts := strings.SplitN(line, "|", 2)
+		parts := strings.SplitN(line, "|", 3)
 		if len(parts) < 2 {
-			err = fmt.Errorf("Unrecognized remote plugin message: %s", line)
+			err = fmt.Errorf(
+				"Unrecognized remote plugin message from %s: %s", cmd.Path, line)
 			return
 		}
 
-		// Test the API version
+		// Test the API version. This is checked before anything else
+		// since older plugins may not send the rest of the handshake.
 		if parts[0] != APIVersion {
-			err = fmt.Errorf("Incompatible API version with plugin. "+
-				"Plugin version: %s, Ours: %s", parts[0], APIVersion)
+			err = fmt.Errorf(
+				"Incompatible API version with plugin %s. "+
+					"Plugin version: %s, Ours: %s. The plugin must be "+
+					"rebuilt against this version of Packer.",
+				cmd.Path, parts[0], APIVersion)
+			return
+		}
+
+		if len(parts) < 3 {
+			err = fmt.Errorf(
+				"Unrecognized remote plugin message from %s: %s", cmd.Path, line)
 			return
 		}
 
-		c.address = parts[1]
+		c.componentType = parts[1]
+		c.address = parts[2]
 		address = c.address
 	}
 
 	return
 }
 
+func (c *Client) removeSocketDir() {
+	if c.socketDir == "" {
+		return
+	}
+
+	if err := os.RemoveAll(c.socketDir); err != nil {
+		log.Printf("Error removing plugin socket directory: %s", err)
+	}
+}
+
 func (c *Client) logStderr(r io.Reader) {
 	bufR := bufio.NewReader(r)
 	for {
@@ -350,6 +423,7 @@ func (c *Client) logStderr(r io.Reader) {
 
 			line = strings.TrimRightFunc(line, unicode.IsSpace)
 			log.Printf("%s: %s", c.config.Cmd.Path, line)
+			c.output.Add(line)
 		}
 
 		if err == io.EOF {
@@ -361,20 +435,90 @@ func (c *Client) logStderr(r io.Reader) {
 	close(c.doneLogging)
 }
 
-func (c *Client) rpcClient() (*rpc.Client, error) {
+// checkCrash records a crash of the plugin if it exited abnormally
+// rather than being killed, writing the crash log if configured.
+func (c *Client) checkCrash(exitErr error) {
+	c.exitL.Lock()
+	killed := c.killed
+	c.exitL.Unlock()
+
+	if exitErr == nil || killed || Killed {
+		return
+	}
+
+	crash := &CrashError{
+		Path:    c.config.Cmd.Path,
+		ExitErr: exitErr,
+		Output:  c.output.Lines(),
+	}
+
+	log.Printf("%s: plugin crashed: %s", crash.Path, exitErr)
+	if c.config.CrashLogDir != "" {
+		if err := crash.writeLog(c.config.CrashLogDir); err != nil {
+			log.Printf("Error writing plugin crash log: %s", err)
+		}
+	}
+
+	c.crash = crash
+}
+
+// crashError returns a CrashError wrapping the given error if the
+// plugin crashed, and the error itself otherwise.
+func (c *Client) crashError(err error) error {
+	if err == nil || c.exitCh == nil {
+		return err
+	}
+
+	select {
+	case <-c.exitCh:
+	default:
+		return err
+	}
+
+	if c.crash == nil {
+		return err
+	}
+
+	crash := *c.crash
+	crash.Err = err
+	return &crash
+}
+
+// callError turns an error returned by a call to the plugin into a
+// CrashError if the call failed because the plugin crashed. The
+// connection is usually lost before we notice the process exited, so
+// this waits a short time for that.
+func (c *Client) callError(err error) error {
+	if !isConnectionError(err) || c.exitCh == nil {
+		return err
+	}
+
+	select {
+	case <-c.exitCh:
+	case <-time.After(crashWaitTimeout):
+	}
+
+	return c.crashError(err)
+}
+
+// rpcClient starts the plugin if it hasn't been started and connects
+// to it, verifying that it serves the given type of component.
+func (c *Client) rpcClient(componentType string) (*packrpc.Client, error) {
 	address, err := c.Start()
 	if err != nil {
 		return nil, err
 	}
 
-	conn, err := net.Dial("tcp", address)
+	if c.componentType != componentType {
+		return nil, fmt.Errorf(
+			"Plugin %s is a %s plugin, but was expected to be a %s plugin",
+			c.config.Cmd.Path, c.componentType, componentType)
+	}
+
+	conn, err := packrpc.Dial(address)
 	if err != nil {
 		return nil, err
 	}
 
-	// Make sure to set keep alive so that the connection doesn't die
-	tcpConn := conn.(*net.TCPConn)
-	tcpConn.SetKeepAlive(true)
-
-	return rpc.NewClient(tcpConn), nil
+	return packrpc.NewClient(conn, c.secret)
 }
@@ -2,8 +2,10 @@ package plugin
 
 import (
 	"bytes"
+	packrpc "github.com/mitchellh/packer/packer/rpc"
 	"io/ioutil"
 	"os"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
@@ -52,6 +54,42 @@ func TestClientStart_badVersion(t *testing.T) {
 	}
 }
 
+func TestClientStart_oldVersion(t *testing.T) {
+	process := helperProcess("old-version")
+	c := NewClient(&ClientConfig{Cmd: process})
+	defer c.Kill()
+
+	_, err := c.Start()
+	if err == nil {
+		t.Fatal("err should not be nil")
+	}
+
+	for _, s := range []string{process.Path, "Plugin version: 1", "Ours: " + APIVersion} {
+		if !strings.Contains(err.Error(), s) {
+			t.Fatalf("error should contain %q: %s", s, err)
+		}
+	}
+}
+
+func TestClient_wrongComponentType(t *testing.T) {
+	process := helperProcess("builder")
+	c := NewClient(&ClientConfig{Cmd: process})
+	defer c.Kill()
+
+	_, err := c.Provisioner()
+	if err == nil {
+		t.Fatal("err should not be nil")
+	}
+
+	if !strings.Contains(err.Error(), process.Path) {
+		t.Fatalf("error should contain plugin path: %s", err)
+	}
+
+	if _, err := c.Builder(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
 func TestClient_Start_Timeout(t *testing.T) {
 	config := &ClientConfig{
 		Cmd:          helperProcess("start-timeout"),
@@ -139,3 +177,60 @@ func TestClient_Stdin(t *testing.T) {
 		t.Fatal("process didn't exit cleanly")
 	}
 }
+
+func TestClient_unixSocket(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Unix domain sockets aren't used on Windows")
+	}
+
+	c := NewClient(&ClientConfig{Cmd: helperProcess("builder")})
+	defer c.Kill()
+
+	addr, err := c.Start()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !strings.HasPrefix(addr, "unix:"+c.socketDir) {
+		t.Fatalf("bad addr: %s", addr)
+	}
+
+	if _, err := c.Builder(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.Kill()
+	if _, err := os.Stat(c.socketDir); !os.IsNotExist(err) {
+		t.Fatalf("socket dir should be removed: %s", err)
+	}
+}
+
+func TestClient_unauthenticated(t *testing.T) {
+	c := NewClient(&ClientConfig{Cmd: helperProcess("builder")})
+	defer c.Kill()
+
+	addr, err := c.Start()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Another process without the secret can't connect
+	conn, err := packrpc.Dial(addr)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := packrpc.NewClient(conn, "bad"); err == nil {
+		t.Fatal("should not authenticate without the secret")
+	}
+
+	// But we still can
+	b, err := c.Builder()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := b.Prepare(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
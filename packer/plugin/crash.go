@@ -0,0 +1,129 @@
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/rpc"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The number of lines of a plugin's stderr that are kept to report
+// if the plugin crashes.
+const crashOutputLines = 100
+
+// The maximum number of lines of a panic trace that are kept. Panic
+// traces list every goroutine, so they can be much longer than the
+// normal output that is kept.
+const crashPanicLines = 1000
+
+// How long to wait for the plugin process to exit after the connection
+// to it was lost, to determine whether it crashed.
+var crashWaitTimeout = 2 * time.Second
+
+// CrashError is the error returned by the components of a plugin when
+// the plugin process exited abnormally while they were being used. It
+// contains the last output of the plugin, including the panic trace if
+// the plugin panicked.
+type CrashError struct {
+	// Path is the path to the plugin binary.
+	Path string
+
+	// ExitErr is the error the plugin process exited with.
+	ExitErr error
+
+	// Err is the error the call to the plugin failed with, usually
+	// because the connection to the plugin was lost.
+	Err error
+
+	// Output is the last output of the plugin on stderr.
+	Output []string
+
+	// LogPath is the path to the crash log that was written for the
+	// crash, or empty if none was written.
+	LogPath string
+}
+
+func (e *CrashError) Error() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Plugin %s crashed (%s). This is always a bug in the plugin.", e.Path, e.ExitErr)
+	if e.LogPath != "" {
+		fmt.Fprintf(&buf, " A crash log was written to %s; please include it "+
+			"when reporting the crash.", e.LogPath)
+	}
+
+	if len(e.Output) > 0 {
+		fmt.Fprintf(&buf, "\n\nLast output from the plugin:\n\n%s", strings.Join(e.Output, "\n"))
+	}
+
+	return buf.String()
+}
+
+// writeLog writes a crash log for the crash to the given directory,
+// setting LogPath to the path of the written log.
+func (e *CrashError) writeLog(dir string) error {
+	path := filepath.Join(dir, fmt.Sprintf(
+		"crash-%s-%d.log", filepath.Base(e.Path), time.Now().Unix()))
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Plugin: %s\n", e.Path)
+	fmt.Fprintf(&buf, "Exit error: %s\n\n", e.ExitErr)
+	fmt.Fprintf(&buf, "%s\n", strings.Join(e.Output, "\n"))
+
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	e.LogPath = path
+	return nil
+}
+
+// isConnectionError returns true if the error is from losing the
+// connection to the plugin rather than an error the plugin returned.
+func isConnectionError(err error) bool {
+	return err == io.EOF || err == io.ErrUnexpectedEOF || err == rpc.ErrShutdown
+}
+
+// outputTail keeps the last lines of the output of a plugin. Once a
+// panic is seen, every following line is kept along with the lines
+// before it, so that the whole trace can be reported.
+type outputTail struct {
+	l        sync.Mutex
+	lines    []string
+	panicked bool
+}
+
+func (t *outputTail) Add(line string) {
+	t.l.Lock()
+	defer t.l.Unlock()
+
+	if strings.HasPrefix(line, "panic: ") {
+		t.panicked = true
+	}
+
+	if t.panicked {
+		if len(t.lines) < crashOutputLines+crashPanicLines {
+			t.lines = append(t.lines, line)
+		}
+
+		return
+	}
+
+	t.lines = append(t.lines, line)
+	if len(t.lines) > crashOutputLines {
+		t.lines = t.lines[len(t.lines)-crashOutputLines:]
+	}
+}
+
+func (t *outputTail) Lines() []string {
+	t.l.Lock()
+	defer t.l.Unlock()
+
+	result := make([]string, len(t.lines))
+	copy(result, t.lines)
+	return result
+}
@@ -0,0 +1,65 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCrashError_Impl(t *testing.T) {
+	var raw interface{}
+	raw = new(CrashError)
+	if _, ok := raw.(error); !ok {
+		t.Fatal("CrashError must implement error")
+	}
+}
+
+func TestCrashError_Error(t *testing.T) {
+	err := &CrashError{
+		Path:    "/bin/packer-builder-foo",
+		ExitErr: errors.New("exit status 2"),
+		Output:  []string{"foo", "bar"},
+		LogPath: "crash.log",
+	}
+
+	for _, s := range []string{err.Path, "exit status 2", "crash.log", "foo\nbar"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Fatalf("error should contain %q: %s", s, err.Error())
+		}
+	}
+}
+
+func TestOutputTail(t *testing.T) {
+	var tail outputTail
+	for i := 0; i < crashOutputLines+10; i++ {
+		tail.Add(fmt.Sprintf("%d", i))
+	}
+
+	lines := tail.Lines()
+	if len(lines) != crashOutputLines {
+		t.Fatalf("bad: %d", len(lines))
+	}
+
+	if lines[0] != "10" {
+		t.Fatalf("bad: %s", lines[0])
+	}
+
+	// Everything after a panic is kept
+	tail.Add("panic: foo")
+	for i := 0; i < crashOutputLines+10; i++ {
+		tail.Add("trace")
+	}
+
+	lines = tail.Lines()
+	if len(lines) != 2*crashOutputLines+11 {
+		t.Fatalf("bad: %d", len(lines))
+	}
+
+	actual := lines[crashOutputLines-1 : crashOutputLines+2]
+	expected := []string{fmt.Sprintf("%d", crashOutputLines+9), "panic: foo", "trace"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
@@ -16,7 +16,7 @@ func (c *cmdHook) Run(name string, ui packer.Ui, comm packer.Communicator, data
 		c.checkExit(r, nil)
 	}()
 
-	return c.hook.Run(name, ui, comm, data)
+	return c.client.callError(c.hook.Run(name, ui, comm, data))
 }
 
 func (c *cmdHook) Cancel() {
@@ -13,8 +13,6 @@ import (
 	"github.com/mitchellh/packer/packer"
 	packrpc "github.com/mitchellh/packer/packer/rpc"
 	"log"
-	"net"
-	"net/rpc"
 	"os"
 	"os/signal"
 	"runtime"
@@ -32,70 +30,113 @@ const MagicCookieValue = "d602bf8f470bc67ca7faa0386276bbdd4330efaf76d1a219cb4d69
 
 // The APIVersion is outputted along with the RPC address. The plugin
 // client validates this API version and will show an error if it doesn't
-// know how to speak it.
-const APIVersion = "1"
+// know how to speak it. This must be changed whenever the RPC protocol
+// between Packer and its plugins changes.
+const APIVersion = "3"
+
+// These are the component types that a plugin announces along with the
+// API version, so that the plugin client can verify that a plugin is
+// the type of component it was configured as.
+const (
+	ComponentBuilder       = "builder"
+	ComponentCommand       = "command"
+	ComponentHook          = "hook"
+	ComponentPostProcessor = "post-processor"
+	ComponentProvisioner   = "provisioner"
+)
+
+// This is the environmental variable that contains the directory that
+// the plugin creates its Unix domain sockets in.
+const SocketDirKey = "PACKER_PLUGIN_SOCKET_DIR"
+
+// This is the environmental variable that contains the secret that
+// Packer and the plugin authenticate the plugin connection with, so
+// that no other process can connect to the plugin.
+const SecretKey = "PACKER_PLUGIN_SECRET"
 
-// This serves a single RPC connection on the given RPC server on
-// a random port.
-func serve(server *rpc.Server) (err error) {
+// This serves a single RPC connection on a Unix domain socket, or a
+// random port if that isn't possible. All of the objects used by the
+// plugin are served over this connection. The given function registers
+// the component of the plugin on the server.
+func serve(componentType string, register func(*packrpc.Server)) (err error) {
 	log.Printf("Plugin build against Packer '%s'", packer.GitCommit)
 
 	if os.Getenv(MagicCookieKey) != MagicCookieValue {
 		return errors.New("Please do not execute plugins directly. Packer will execute these for you.")
 	}
 
+	// Don't leak the secret to any processes the plugin starts
+	secret := os.Getenv(SecretKey)
+	os.Setenv(SecretKey, "")
+	if secret == "" {
+		return errors.New("No plugin secret was given. Packer will set this for you.")
+	}
+
 	// If there is no explicit number of Go threads to use, then set it
 	if os.Getenv("GOMAXPROCS") == "" {
 		runtime.GOMAXPROCS(runtime.NumCPU())
 	}
 
-	minPort, err := strconv.ParseInt(os.Getenv("PACKER_PLUGIN_MIN_PORT"), 10, 32)
-	if err != nil {
-		return
-	}
+	// The port range is only used if Unix domain sockets can't be,
+	// so it is optional.
+	if os.Getenv("PACKER_PLUGIN_MIN_PORT") != "" || os.Getenv("PACKER_PLUGIN_MAX_PORT") != "" {
+		var minPort, maxPort int64
+		minPort, err = strconv.ParseInt(os.Getenv("PACKER_PLUGIN_MIN_PORT"), 10, 32)
+		if err != nil {
+			return
+		}
 
-	maxPort, err := strconv.ParseInt(os.Getenv("PACKER_PLUGIN_MAX_PORT"), 10, 32)
-	if err != nil {
-		return
-	}
+		maxPort, err = strconv.ParseInt(os.Getenv("PACKER_PLUGIN_MAX_PORT"), 10, 32)
+		if err != nil {
+			return
+		}
 
-	log.Printf("Plugin minimum port: %d\n", minPort)
-	log.Printf("Plugin maximum port: %d\n", maxPort)
+		log.Printf("Plugin minimum port: %d\n", minPort)
+		log.Printf("Plugin maximum port: %d\n", maxPort)
 
-	// Set the RPC port range
-	packrpc.PortRange(int(minPort), int(maxPort))
+		// Set the RPC port range
+		packrpc.PortRange(int(minPort), int(maxPort))
+	}
 
-	var address string
-	var listener net.Listener
-	for port := minPort; port <= maxPort; port++ {
-		address = fmt.Sprintf("127.0.0.1:%d", port)
-		listener, err = net.Listen("tcp", address)
-		if err != nil {
-			err = nil
-			continue
-		}
+	socketDir := os.Getenv(SocketDirKey)
+	log.Printf("Plugin socket directory: %s", socketDir)
+	packrpc.SocketDir(socketDir)
 
-		break
+	listener, err := packrpc.Listen()
+	if err != nil {
+		return
 	}
 
 	defer listener.Close()
+	address := packrpc.Address(listener)
 
 	// Output the address to stdout
 	log.Printf("Plugin address: %s\n", address)
-	fmt.Printf("%s|%s\n", APIVersion, address)
+	fmt.Printf("%s|%s|%s\n", APIVersion, componentType, address)
 	os.Stdout.Sync()
 
-	// Accept a connection
-	log.Println("Waiting for connection...")
-	conn, err := listener.Accept()
-	if err != nil {
-		log.Printf("Error accepting connection: %s\n", err.Error())
-		return
+	// Accept connections until one authenticates, so that other
+	// processes connecting can't keep Packer from connecting.
+	var server *packrpc.Server
+	for server == nil {
+		log.Println("Waiting for connection...")
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Printf("Error accepting connection: %s\n", err.Error())
+			return err
+		}
+
+		server, err = packrpc.NewServer(conn, secret)
+		if err != nil {
+			log.Printf("Rejected plugin connection: %s", err)
+		}
 	}
 
 	// Serve a single connection
 	log.Println("Serving a plugin connection...")
-	server.ServeConn(conn)
+	defer server.Close()
+	register(server)
+	server.Serve()
 	return
 }
 
@@ -119,11 +160,11 @@ func countInterrupts() {
 func ServeBuilder(builder packer.Builder) {
 	log.Println("Preparing to serve a builder plugin...")
 
-	server := rpc.NewServer()
-	packrpc.RegisterBuilder(server, builder)
-
 	countInterrupts()
-	if err := serve(server); err != nil {
+	err := serve(ComponentBuilder, func(server *packrpc.Server) {
+		server.RegisterBuilder(builder)
+	})
+	if err != nil {
 		log.Printf("ERROR: %s", err)
 		os.Exit(1)
 	}
@@ -133,11 +174,11 @@ func ServeBuilder(builder packer.Builder) {
 func ServeCommand(command packer.Command) {
 	log.Println("Preparing to serve a command plugin...")
 
-	server := rpc.NewServer()
-	packrpc.RegisterCommand(server, command)
-
 	countInterrupts()
-	if err := serve(server); err != nil {
+	err := serve(ComponentCommand, func(server *packrpc.Server) {
+		server.RegisterCommand(command)
+	})
+	if err != nil {
 		log.Printf("ERROR: %s", err)
 		os.Exit(1)
 	}
@@ -147,11 +188,11 @@ func ServeCommand(command packer.Command) {
 func ServeHook(hook packer.Hook) {
 	log.Println("Preparing to serve a hook plugin...")
 
-	server := rpc.NewServer()
-	packrpc.RegisterHook(server, hook)
-
 	countInterrupts()
-	if err := serve(server); err != nil {
+	err := serve(ComponentHook, func(server *packrpc.Server) {
+		server.RegisterHook(hook)
+	})
+	if err != nil {
 		log.Printf("ERROR: %s", err)
 		os.Exit(1)
 	}
@@ -161,11 +202,11 @@ func ServeHook(hook packer.Hook) {
 func ServePostProcessor(p packer.PostProcessor) {
 	log.Println("Preparing to serve a post-processor plugin...")
 
-	server := rpc.NewServer()
-	packrpc.RegisterPostProcessor(server, p)
-
 	countInterrupts()
-	if err := serve(server); err != nil {
+	err := serve(ComponentPostProcessor, func(server *packrpc.Server) {
+		server.RegisterPostProcessor(p)
+	})
+	if err != nil {
 		log.Printf("ERROR: %s", err)
 		os.Exit(1)
 	}
@@ -175,11 +216,11 @@ func ServePostProcessor(p packer.PostProcessor) {
 func ServeProvisioner(p packer.Provisioner) {
 	log.Println("Preparing to serve a provisioner plugin...")
 
-	server := rpc.NewServer()
-	packrpc.RegisterProvisioner(server, p)
-
 	countInterrupts()
-	if err := serve(server); err != nil {
+	err := serve(ComponentProvisioner, func(server *packrpc.Server) {
+		server.RegisterProvisioner(p)
+	})
+	if err != nil {
 		log.Printf("ERROR: %s", err)
 		os.Exit(1)
 	}
@@ -51,10 +51,15 @@ func TestHelperProcess(*testing.T) {
 	cmd, args := args[0], args[1:]
 	switch cmd {
 	case "bad-version":
-		fmt.Printf("%s1|:1234\n", APIVersion)
+		fmt.Printf("%s1|%s|:1234\n", APIVersion, ComponentBuilder)
+		<-make(chan int)
+	case "old-version":
+		fmt.Println("1|127.0.0.1:1234")
 		<-make(chan int)
 	case "builder":
 		ServeBuilder(new(helperBuilder))
+	case "builder-crash":
+		ServeBuilder(new(helperCrashBuilder))
 	case "command":
 		ServeCommand(new(helperCommand))
 	case "hook":
@@ -62,7 +67,7 @@ func TestHelperProcess(*testing.T) {
 	case "invalid-rpc-address":
 		fmt.Println("lolinvalid")
 	case "mock":
-		fmt.Printf("%s|:1234\n", APIVersion)
+		fmt.Printf("%s|%s|:1234\n", APIVersion, ComponentBuilder)
 		<-make(chan int)
 	case "post-processor":
 		ServePostProcessor(new(helperPostProcessor))
@@ -72,11 +77,11 @@ func TestHelperProcess(*testing.T) {
 		time.Sleep(1 * time.Minute)
 		os.Exit(1)
 	case "stderr":
-		fmt.Printf("%s|:1234\n", APIVersion)
+		fmt.Printf("%s|%s|:1234\n", APIVersion, ComponentBuilder)
 		log.Println("HELLO")
 		log.Println("WORLD")
 	case "stdin":
-		fmt.Printf("%s|:1234\n", APIVersion)
+		fmt.Printf("%s|%s|:1234\n", APIVersion, ComponentBuilder)
 		data := make([]byte, 5)
 		if _, err := os.Stdin.Read(data); err != nil {
 			log.Printf("stdin read error: %s", err)
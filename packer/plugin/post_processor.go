@@ -16,7 +16,7 @@ func (c *cmdPostProcessor) Configure(config ...interface{}) error {
 		c.checkExit(r, nil)
 	}()
 
-	return c.p.Configure(config...)
+	return c.client.callError(c.p.Configure(config...))
 }
 
 func (c *cmdPostProcessor) PostProcess(ui packer.Ui, a packer.Artifact) (packer.Artifact, bool, error) {
@@ -25,7 +25,8 @@ func (c *cmdPostProcessor) PostProcess(ui packer.Ui, a packer.Artifact) (packer.
 		c.checkExit(r, nil)
 	}()
 
-	return c.p.PostProcess(ui, a)
+	artifact, keep, err := c.p.PostProcess(ui, a)
+	return artifact, keep, c.client.callError(err)
 }
 
 func (c *cmdPostProcessor) checkExit(p interface{}, cb func()) {
@@ -16,7 +16,7 @@ func (c *cmdProvisioner) Prepare(configs ...interface{}) error {
 		c.checkExit(r, nil)
 	}()
 
-	return c.p.Prepare(configs...)
+	return c.client.callError(c.p.Prepare(configs...))
 }
 
 func (c *cmdProvisioner) Provision(ui packer.Ui, comm packer.Communicator) error {
@@ -25,7 +25,7 @@ func (c *cmdProvisioner) Provision(ui packer.Ui, comm packer.Communicator) error
 		c.checkExit(r, nil)
 	}()
 
-	return c.p.Provision(ui, comm)
+	return c.client.callError(c.p.Provision(ui, comm))
 }
 
 func (c *cmdProvisioner) Cancel() {
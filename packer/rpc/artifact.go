@@ -1,8 +1,13 @@
 package rpc
 
 import (
+	"encoding/gob"
+	"fmt"
 	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"log"
 	"net/rpc"
+	"reflect"
 )
 
 // An implementation of packer.Artifact where the artifact is actually
@@ -17,10 +22,6 @@ type ArtifactServer struct {
 	artifact packer.Artifact
 }
 
-func Artifact(client *rpc.Client) *artifact {
-	return &artifact{client}
-}
-
 func (a *artifact) BuilderId() (result string) {
 	a.client.Call("Artifact.BuilderId", new(interface{}), &result)
 	return
@@ -41,13 +42,29 @@ func (a *artifact) String() (result string) {
 	return
 }
 
+func (a *artifact) State(name string) (result interface{}) {
+	if err := a.client.Call("Artifact.State", name, &result); err != nil {
+		log.Printf("Error getting artifact state '%s': %s", name, err)
+		return nil
+	}
+
+	// Types that are registered with gob as pointers, such as maps, are
+	// decoded as pointers, so dereference them to get back the value
+	// the artifact actually returned.
+	if v := reflect.ValueOf(result); v.Kind() == reflect.Ptr && !v.IsNil() {
+		result = v.Elem().Interface()
+	}
+
+	return
+}
+
 func (a *artifact) Destroy() error {
 	var result error
 	if err := a.client.Call("Artifact.Destroy", new(interface{}), &result); err != nil {
 		return err
 	}
 
-	return result
+	return decodeError(result)
 }
 
 func (s *ArtifactServer) BuilderId(args *interface{}, reply *string) error {
@@ -70,6 +87,19 @@ func (s *ArtifactServer) String(args *interface{}, reply *string) error {
 	return nil
 }
 
+func (s *ArtifactServer) State(name string, reply *interface{}) error {
+	state := s.artifact.State(name)
+
+	// Check that the state can be encoded first, since a failure to encode
+	// the reply would otherwise break the whole connection.
+	if err := gob.NewEncoder(ioutil.Discard).Encode(&state); err != nil {
+		return fmt.Errorf("Error encoding artifact state '%s': %s", name, err)
+	}
+
+	*reply = state
+	return nil
+}
+
 func (s *ArtifactServer) Destroy(args *interface{}, reply *error) error {
 	err := s.artifact.Destroy()
 	if err != nil {
@@ -3,7 +3,6 @@ package rpc
 import (
 	"cgl.tideland.biz/asserts"
 	"github.com/mitchellh/packer/packer"
-	"net/rpc"
 	"testing"
 )
 
@@ -25,6 +24,19 @@ func (testArtifact) String() string {
 	return "string"
 }
 
+func (testArtifact) State(name string) interface{} {
+	switch name {
+	case "amis":
+		return map[string]string{"us-east-1": "ami-123"}
+	case "vm_name":
+		return "packer"
+	case "unencodable":
+		return func() {}
+	}
+
+	return nil
+}
+
 func (testArtifact) Destroy() error {
 	return nil
 }
@@ -36,27 +48,31 @@ func TestArtifactRPC(t *testing.T) {
 	a := new(testArtifact)
 
 	// Start the server
-	server := rpc.NewServer()
-	RegisterArtifact(server, a)
-	address := serveSingleConn(server)
+	client, server := testClientServer(t)
+	defer client.Close()
+	defer server.Close()
+	server.RegisterArtifact(a)
 
 	// Create the client over RPC and run some methods to verify it works
-	client, err := rpc.Dial("tcp", address)
-	assert.Nil(err, "should be able to connect")
-	aClient := Artifact(client)
+	aClient := client.Artifact()
 
 	// Test
 	assert.Equal(aClient.BuilderId(), "bid", "should have correct builder ID")
 	assert.Equal(aClient.Files(), []string{"a", "b"}, "should have correct builder ID")
 	assert.Equal(aClient.Id(), "id", "should have correct builder ID")
 	assert.Equal(aClient.String(), "string", "should have correct builder ID")
+	assert.Equal(aClient.State("amis"), map[string]string{"us-east-1": "ami-123"}, "should have correct state")
+	assert.Equal(aClient.State("vm_name"), "packer", "should have correct state")
+	assert.Nil(aClient.State("unknown"), "should have no state")
+	assert.Nil(aClient.State("unencodable"), "should have no state")
+	assert.Equal(aClient.Id(), "id", "should still work after a bad state")
 }
 
 func TestArtifact_Implements(t *testing.T) {
 	assert := asserts.NewTestingAsserts(t, true)
 
 	var r packer.Artifact
-	a := Artifact(nil)
+	a := new(artifact)
 
 	assert.Implementor(a, &r, "should be an Artifact")
 }
@@ -0,0 +1,139 @@
+package rpc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// The size of the random challenges that are exchanged when a
+// connection is authenticated.
+const authChallengeSize = 32
+
+// The maximum amount of time that authenticating a connection can take,
+// so that a connection that never authenticates can't block a server.
+var authTimeout = 10 * time.Second
+
+// NewSecret returns a new random secret that the client and server of a
+// connection can use to authenticate each other.
+func NewSecret() (string, error) {
+	data := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, data); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(data), nil
+}
+
+// authenticateServer authenticates the server side of a connection.
+// The server sends a challenge that the client must answer with the
+// secret, and then answers the challenge of the client to prove that it
+// knows the secret too. The secret itself never crosses the connection.
+func authenticateServer(conn io.ReadWriter, secret string) error {
+	if secret == "" {
+		return errors.New("No secret to authenticate the connection with")
+	}
+
+	defer authDeadline(conn)()
+
+	challenge, err := authChallenge()
+	if err != nil {
+		return err
+	}
+
+	if _, err := conn.Write(challenge); err != nil {
+		return err
+	}
+
+	response := make([]byte, authChallengeSize+sha256.Size)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return err
+	}
+
+	clientChallenge := response[:authChallengeSize]
+	expected := authMAC(secret, "client", challenge, clientChallenge)
+	if !hmac.Equal(response[authChallengeSize:], expected) {
+		return errors.New("Connection failed to authenticate")
+	}
+
+	_, err = conn.Write(authMAC(secret, "server", clientChallenge, challenge))
+	return err
+}
+
+// authenticateClient authenticates the client side of a connection.
+// It is the other side of authenticateServer.
+func authenticateClient(conn io.ReadWriter, secret string) error {
+	if secret == "" {
+		return errors.New("No secret to authenticate the connection with")
+	}
+
+	defer authDeadline(conn)()
+
+	serverChallenge := make([]byte, authChallengeSize)
+	if _, err := io.ReadFull(conn, serverChallenge); err != nil {
+		return err
+	}
+
+	challenge, err := authChallenge()
+	if err != nil {
+		return err
+	}
+
+	response := append(challenge, authMAC(secret, "client", serverChallenge, challenge)...)
+	if _, err := conn.Write(response); err != nil {
+		return err
+	}
+
+	serverResponse := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(conn, serverResponse); err != nil {
+		return errors.New("Server rejected the connection or failed to authenticate")
+	}
+
+	expected := authMAC(secret, "server", challenge, serverChallenge)
+	if !hmac.Equal(serverResponse, expected) {
+		return errors.New("Server failed to authenticate")
+	}
+
+	return nil
+}
+
+func authChallenge() ([]byte, error) {
+	result := make([]byte, authChallengeSize)
+	if _, err := io.ReadFull(rand.Reader, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// authMAC returns the answer to the given challenges for the given side
+// of the connection. The side is included so that one side can't just
+// echo the answer of the other.
+func authMAC(secret string, side string, challenges ...[]byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(side))
+	for _, c := range challenges {
+		mac.Write(c)
+	}
+
+	return mac.Sum(nil)
+}
+
+// authDeadline sets the deadline for authenticating on the connection,
+// if it supports deadlines, and returns a function that clears it.
+func authDeadline(conn io.ReadWriter) func() {
+	c, ok := conn.(net.Conn)
+	if !ok {
+		return func() {}
+	}
+
+	c.SetDeadline(time.Now().Add(authTimeout))
+	return func() {
+		c.SetDeadline(time.Time{})
+	}
+}
@@ -0,0 +1,92 @@
+package rpc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewSecret(t *testing.T) {
+	s1, err := NewSecret()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	s2, err := NewSecret()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(s1) != 64 {
+		t.Fatalf("bad: %s", s1)
+	}
+
+	if s1 == s2 {
+		t.Fatal("secrets should be random")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	clientConn, serverConn := testConn(t)
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- authenticateServer(serverConn, "foo")
+	}()
+
+	if err := authenticateClient(clientConn, "foo"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := <-errCh; err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestAuthenticate_badSecret(t *testing.T) {
+	clientConn, serverConn := testConn(t)
+	defer clientConn.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := NewServer(serverConn, "foo")
+		errCh <- err
+	}()
+
+	if _, err := NewClient(clientConn, "bar"); err == nil {
+		t.Fatal("client should fail to authenticate")
+	}
+
+	if err := <-errCh; err == nil {
+		t.Fatal("server should reject the client")
+	}
+}
+
+func TestAuthenticate_noSecret(t *testing.T) {
+	clientConn, serverConn := testConn(t)
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	if err := authenticateServer(serverConn, ""); err == nil {
+		t.Fatal("should require a secret")
+	}
+
+	if err := authenticateClient(clientConn, ""); err == nil {
+		t.Fatal("should require a secret")
+	}
+}
+
+func TestAuthenticate_timeout(t *testing.T) {
+	clientConn, serverConn := testConn(t)
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	old := authTimeout
+	authTimeout = 50 * time.Millisecond
+	defer func() { authTimeout = old }()
+
+	if err := authenticateServer(serverConn, "foo"); err == nil {
+		t.Fatal("should time out")
+	}
+}
@@ -9,20 +9,19 @@ import (
 // over an RPC connection.
 type build struct {
 	client *rpc.Client
+	mux    *MuxConn
 }
 
 // BuildServer wraps a packer.Build implementation and makes it exportable
 // as part of a Golang RPC server.
 type BuildServer struct {
 	build packer.Build
+	mux   *MuxConn
 }
 
-type BuildRunArgs struct {
-	UiRPCAddress string
-}
-
-func Build(client *rpc.Client) *build {
-	return &build{client}
+type BuildArtifactsResponse struct {
+	Err       error
+	StreamIds []uint32
 }
 
 func (b *build) Name() (result string) {
@@ -35,32 +34,44 @@ func (b *build) Prepare(v map[string]string) (err error) {
 		return cerr
 	}
 
-	return
+	return decodeError(err)
 }
 
 func (b *build) Run(ui packer.Ui, cache packer.Cache) ([]packer.Artifact, error) {
 	// Create and start the server for the UI
-	server := rpc.NewServer()
-	RegisterCache(server, cache)
-	RegisterUi(server, ui)
-	args := &BuildRunArgs{serveSingleConn(server)}
+	streamId := serveOnStream(b.mux, func(s *Server) {
+		s.RegisterCache(cache)
+		s.RegisterUi(ui)
+	})
 
-	var result []string
-	if err := b.client.Call("Build.Run", args, &result); err != nil {
+	var response BuildArtifactsResponse
+	if err := b.client.Call("Build.Run", streamId, &response); err != nil {
 		return nil, err
 	}
 
-	artifacts := make([]packer.Artifact, len(result))
-	for i, addr := range result {
-		client, err := rpcDial(addr)
-		if err != nil {
-			return nil, err
-		}
+	if response.Err != nil {
+		return nil, decodeError(response.Err)
+	}
 
-		artifacts[i] = Artifact(client)
+	return dialArtifacts(b.mux, response.StreamIds)
+}
+
+func (b *build) PostProcess(ui packer.Ui, a packer.Artifact) ([]packer.Artifact, error) {
+	streamId := serveOnStream(b.mux, func(s *Server) {
+		s.RegisterArtifact(a)
+		s.RegisterUi(ui)
+	})
+
+	var response BuildArtifactsResponse
+	if err := b.client.Call("Build.PostProcess", streamId, &response); err != nil {
+		return nil, err
 	}
 
-	return artifacts, nil
+	if response.Err != nil {
+		return nil, decodeError(response.Err)
+	}
+
+	return dialArtifacts(b.mux, response.StreamIds)
 }
 
 func (b *build) SetDebug(val bool) {
@@ -75,6 +86,12 @@ func (b *build) SetForce(val bool) {
 	}
 }
 
+func (b *build) SetArtifactPath(path string) {
+	if err := b.client.Call("Build.SetArtifactPath", path, new(interface{})); err != nil {
+		panic(err)
+	}
+}
+
 func (b *build) Cancel() {
 	if err := b.client.Call("Build.Cancel", new(interface{}), new(interface{})); err != nil {
 		panic(err)
@@ -87,28 +104,52 @@ func (b *BuildServer) Name(args *interface{}, reply *string) error {
 }
 
 func (b *BuildServer) Prepare(v map[string]string, reply *error) error {
-	*reply = b.build.Prepare(v)
+	err := b.build.Prepare(v)
+	if err != nil {
+		err = NewBasicError(err)
+	}
+
+	*reply = err
 	return nil
 }
 
-func (b *BuildServer) Run(args *BuildRunArgs, reply *[]string) error {
-	client, err := rpcDial(args.UiRPCAddress)
+func (b *BuildServer) Run(streamId uint32, reply *BuildArtifactsResponse) error {
+	client, err := newClientWithMux(b.mux, streamId)
 	if err != nil {
-		return err
+		return NewBasicError(err)
+	}
+	defer client.Close()
+
+	artifacts, err := b.build.Run(client.Ui(), client.Cache())
+	if err != nil {
+		*reply = BuildArtifactsResponse{Err: NewBasicError(err)}
+		return nil
 	}
 
-	artifacts, err := b.build.Run(&Ui{client}, Cache(client))
+	*reply = BuildArtifactsResponse{StreamIds: serveArtifacts(b.mux, artifacts)}
+	return nil
+}
+
+func (b *BuildServer) PostProcess(streamId uint32, reply *BuildArtifactsResponse) error {
+	client, err := newClientWithMux(b.mux, streamId)
 	if err != nil {
 		return NewBasicError(err)
 	}
 
-	*reply = make([]string, len(artifacts))
-	for i, artifact := range artifacts {
-		server := rpc.NewServer()
-		RegisterArtifact(server, artifact)
-		(*reply)[i] = serveSingleConn(server)
+	// The artifact is usually among the results, since it is kept, and then
+	// it is served back over this client, so the client can't be closed.
+	artifact := client.Artifact()
+	artifacts, err := b.build.PostProcess(client.Ui(), artifact)
+	if !containsArtifact(artifacts, artifact) {
+		defer client.Close()
 	}
 
+	if err != nil {
+		*reply = BuildArtifactsResponse{Err: NewBasicError(err)}
+		return nil
+	}
+
+	*reply = BuildArtifactsResponse{StreamIds: serveArtifacts(b.mux, artifacts)}
 	return nil
 }
 
@@ -122,7 +163,52 @@ func (b *BuildServer) SetForce(val *bool, reply *interface{}) error {
 	return nil
 }
 
+func (b *BuildServer) SetArtifactPath(path *string, reply *interface{}) error {
+	b.build.SetArtifactPath(*path)
+	return nil
+}
+
 func (b *BuildServer) Cancel(args *interface{}, reply *interface{}) error {
 	b.build.Cancel()
 	return nil
 }
+
+// containsArtifact returns true if the artifact is in the list.
+func containsArtifact(artifacts []packer.Artifact, artifact packer.Artifact) bool {
+	for _, a := range artifacts {
+		if a == artifact {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dialArtifacts connects to the artifacts served on the given streams.
+func dialArtifacts(mux *MuxConn, streamIds []uint32) ([]packer.Artifact, error) {
+	artifacts := make([]packer.Artifact, len(streamIds))
+	for i, streamId := range streamIds {
+		client, err := newClientWithMux(mux, streamId)
+		if err != nil {
+			return nil, err
+		}
+
+		artifacts[i] = client.Artifact()
+	}
+
+	return artifacts, nil
+}
+
+// serveArtifacts serves each of the artifacts on its own stream and
+// returns the IDs of the streams to connect to them.
+func serveArtifacts(mux *MuxConn, artifacts []packer.Artifact) []uint32 {
+	result := make([]uint32, len(artifacts))
+	for i, artifact := range artifacts {
+		artifact := artifact
+		result[i] = serveOnStream(mux, func(s *Server) {
+			s.RegisterArtifact(artifact)
+		})
+	}
+
+	return result
+}
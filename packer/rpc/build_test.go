@@ -4,22 +4,24 @@ import (
 	"cgl.tideland.biz/asserts"
 	"errors"
 	"github.com/mitchellh/packer/packer"
-	"net/rpc"
 	"testing"
 )
 
 var testBuildArtifact = &testArtifact{}
 
 type testBuild struct {
-	nameCalled     bool
-	prepareCalled  bool
-	prepareVars    map[string]string
-	runCalled      bool
-	runCache       packer.Cache
-	runUi          packer.Ui
-	setDebugCalled bool
-	setForceCalled bool
-	cancelCalled   bool
+	nameCalled            bool
+	prepareCalled         bool
+	prepareVars           map[string]string
+	runCalled             bool
+	runCache              packer.Cache
+	runUi                 packer.Ui
+	postProcessCalled     bool
+	postProcessArtifactId string
+	setArtifactPath       string
+	setDebugCalled        bool
+	setForceCalled        bool
+	cancelCalled          bool
 
 	errRunResult bool
 }
@@ -40,6 +42,10 @@ func (b *testBuild) Run(ui packer.Ui, cache packer.Cache) ([]packer.Artifact, er
 	b.runCache = cache
 	b.runUi = ui
 
+	// The UI and cache only work while the run is in progress
+	cache.Lock("foo")
+	ui.Say("format")
+
 	if b.errRunResult {
 		return nil, errors.New("foo")
 	} else {
@@ -47,6 +53,16 @@ func (b *testBuild) Run(ui packer.Ui, cache packer.Cache) ([]packer.Artifact, er
 	}
 }
 
+func (b *testBuild) PostProcess(ui packer.Ui, a packer.Artifact) ([]packer.Artifact, error) {
+	b.postProcessCalled = true
+	b.postProcessArtifactId = a.Id()
+	return []packer.Artifact{a, testBuildArtifact}, nil
+}
+
+func (b *testBuild) SetArtifactPath(path string) {
+	b.setArtifactPath = path
+}
+
 func (b *testBuild) SetDebug(bool) {
 	b.setDebugCalled = true
 }
@@ -66,14 +82,13 @@ func TestBuildRPC(t *testing.T) {
 	b := new(testBuild)
 
 	// Start the server
-	server := rpc.NewServer()
-	RegisterBuild(server, b)
-	address := serveSingleConn(server)
+	client, server := testClientServer(t)
+	defer client.Close()
+	defer server.Close()
+	server.RegisterBuild(b)
 
 	// Create the client over RPC and run some methods to verify it works
-	client, err := rpc.Dial("tcp", address)
-	assert.Nil(err, "should be able to connect")
-	bClient := Build(client)
+	bClient := client.Build()
 
 	// Test Name
 	bClient.Name()
@@ -101,10 +116,7 @@ func TestBuildRPC(t *testing.T) {
 
 	// Test the UI given to run, which should be fully functional
 	if b.runCalled {
-		b.runCache.Lock("foo")
 		assert.True(cache.lockCalled, "lock should be called")
-
-		b.runUi.Say("format")
 		assert.True(ui.sayCalled, "say should be called")
 		assert.Equal(ui.sayMessage, "format", "message should be correct")
 	}
@@ -114,6 +126,20 @@ func TestBuildRPC(t *testing.T) {
 	_, err = bClient.Run(ui, cache)
 	assert.NotNil(err, "should not nil")
 
+	// Test PostProcess
+	artifacts, err = bClient.PostProcess(ui, new(testArtifact))
+	assert.True(b.postProcessCalled, "post-process should be called")
+	assert.Nil(err, "should not error")
+	assert.Equal(len(artifacts), 2, "should have two artifacts")
+	assert.Equal(b.postProcessArtifactId, "id", "should have proper artifact")
+	if len(artifacts) == 2 {
+		assert.Equal(artifacts[0].Id(), "id", "kept artifact should still work")
+	}
+
+	// Test SetArtifactPath
+	bClient.SetArtifactPath("foo.json")
+	assert.Equal(b.setArtifactPath, "foo.json", "should set path")
+
 	// Test SetDebug
 	bClient.SetDebug(true)
 	assert.True(b.setDebugCalled, "should be called")
@@ -131,7 +157,7 @@ func TestBuild_ImplementsBuild(t *testing.T) {
 	assert := asserts.NewTestingAsserts(t, true)
 
 	var realBuild packer.Build
-	b := Build(nil)
+	b := new(build)
 
 	assert.Implementor(b, &realBuild, "should be a Build")
 }
@@ -1,8 +1,6 @@
 package rpc
 
 import (
-	"encoding/gob"
-	"fmt"
 	"github.com/mitchellh/packer/packer"
 	"log"
 	"net/rpc"
@@ -12,94 +10,61 @@ import (
 // over an RPC connection.
 type builder struct {
 	client *rpc.Client
+	mux    *MuxConn
 }
 
 // BuilderServer wraps a packer.Builder implementation and makes it exportable
 // as part of a Golang RPC server.
 type BuilderServer struct {
 	builder packer.Builder
+	mux     *MuxConn
 }
 
 type BuilderPrepareArgs struct {
 	Configs []interface{}
 }
 
-type BuilderRunArgs struct {
-	RPCAddress      string
-	ResponseAddress string
-}
-
 type BuilderRunResponse struct {
-	Err        error
-	RPCAddress string
-}
-
-func Builder(client *rpc.Client) *builder {
-	return &builder{client}
+	Err      error
+	StreamId uint32
 }
 
 func (b *builder) Prepare(config ...interface{}) (err error) {
 	cerr := b.client.Call("Builder.Prepare", &BuilderPrepareArgs{config}, &err)
 	if cerr != nil {
-		err = cerr
+		return cerr
 	}
 
-	return
+	return decodeError(err)
 }
 
 func (b *builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packer.Artifact, error) {
 	// Create and start the server for the Build and UI
-	server := rpc.NewServer()
-	RegisterCache(server, cache)
-	RegisterHook(server, hook)
-	RegisterUi(server, ui)
-
-	// Create a server for the response
-	responseL := netListenerInRange(portRangeMin, portRangeMax)
-	runResponseCh := make(chan *BuilderRunResponse)
-	go func() {
-		defer responseL.Close()
-
-		var response BuilderRunResponse
-		defer func() { runResponseCh <- &response }()
-
-		conn, err := responseL.Accept()
-		if err != nil {
-			response.Err = err
-			return
-		}
-		defer conn.Close()
-
-		decoder := gob.NewDecoder(conn)
-		if err := decoder.Decode(&response); err != nil {
-			response.Err = fmt.Errorf("Error waiting for Run: %s", err)
-		}
-	}()
-
-	args := &BuilderRunArgs{
-		serveSingleConn(server),
-		responseL.Addr().String(),
-	}
-
-	if err := b.client.Call("Builder.Run", args, new(interface{})); err != nil {
+	streamId := serveOnStream(b.mux, func(s *Server) {
+		s.RegisterCache(cache)
+		s.RegisterHook(hook)
+		s.RegisterUi(ui)
+	})
+
+	var response BuilderRunResponse
+	if err := b.client.Call("Builder.Run", streamId, &response); err != nil {
 		return nil, err
 	}
 
-	response := <-runResponseCh
 	if response.Err != nil {
-		return nil, response.Err
+		return nil, decodeError(response.Err)
 	}
 
-	if response.RPCAddress == "" {
+	if response.StreamId == 0 {
 		return nil, nil
 	}
 
-	client, err := rpcDial(response.RPCAddress)
+	client, err := newClientWithMux(b.mux, response.StreamId)
 	if err != nil {
 		return nil, err
 	}
 
-	return Artifact(client), nil
+	return client.Artifact(), nil
 }
 
 func (b *builder) Cancel() {
@@ -117,46 +82,28 @@ func (b *BuilderServer) Prepare(args *BuilderPrepareArgs, reply *error) error {
 	return nil
 }
 
-func (b *BuilderServer) Run(args *BuilderRunArgs, reply *interface{}) error {
-	client, err := rpcDial(args.RPCAddress)
-	if err != nil {
-		return err
-	}
-
-	responseC, err := tcpDial(args.ResponseAddress)
+func (b *BuilderServer) Run(streamId uint32, reply *BuilderRunResponse) error {
+	client, err := newClientWithMux(b.mux, streamId)
 	if err != nil {
-		return err
+		return NewBasicError(err)
 	}
+	defer client.Close()
 
-	responseWriter := gob.NewEncoder(responseC)
-
-	// Run the build in a goroutine so we don't block the RPC connection
-	go func() {
-		defer responseC.Close()
-
-		cache := Cache(client)
-		hook := Hook(client)
-		ui := &Ui{client}
-		artifact, responseErr := b.builder.Run(ui, hook, cache)
-		responseAddress := ""
+	artifact, responseErr := b.builder.Run(client.Ui(), client.Hook(), client.Cache())
+	var responseId uint32
 
-		if responseErr == nil && artifact != nil {
-			// Wrap the artifact
-			server := rpc.NewServer()
-			RegisterArtifact(server, artifact)
-			responseAddress = serveSingleConn(server)
-		}
-
-		if responseErr != nil {
-			responseErr = NewBasicError(responseErr)
-		}
+	if responseErr == nil && artifact != nil {
+		// Wrap the artifact
+		responseId = serveOnStream(b.mux, func(s *Server) {
+			s.RegisterArtifact(artifact)
+		})
+	}
 
-		err := responseWriter.Encode(&BuilderRunResponse{responseErr, responseAddress})
-		if err != nil {
-			log.Printf("BuildServer.Run error: %s", err)
-		}
-	}()
+	if responseErr != nil {
+		responseErr = NewBasicError(responseErr)
+	}
 
+	*reply = BuilderRunResponse{responseErr, responseId}
 	return nil
 }
 
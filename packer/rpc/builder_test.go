@@ -4,7 +4,6 @@ import (
 	"cgl.tideland.biz/asserts"
 	"errors"
 	"github.com/mitchellh/packer/packer"
-	"net/rpc"
 	"testing"
 )
 
@@ -35,6 +34,11 @@ func (b *testBuilder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (p
 	b.runHook = hook
 	b.runUi = ui
 
+	// The UI, hook and cache only work while the run is in progress
+	cache.Lock("foo")
+	hook.Run("foo", nil, nil, nil)
+	ui.Say("format")
+
 	if b.errRunResult {
 		return nil, errors.New("foo")
 	} else if b.nilRunResult {
@@ -55,17 +59,16 @@ func TestBuilderRPC(t *testing.T) {
 	b := new(testBuilder)
 
 	// Start the server
-	server := rpc.NewServer()
-	RegisterBuilder(server, b)
-	address := serveSingleConn(server)
+	client, server := testClientServer(t)
+	defer client.Close()
+	defer server.Close()
+	server.RegisterBuilder(b)
 
 	// Create the client over RPC and run some methods to verify it works
-	client, err := rpc.Dial("tcp", address)
-	assert.Nil(err, "should be able to connect")
 
 	// Test Prepare
 	config := 42
-	bClient := Builder(client)
+	bClient := client.Builder()
 	bClient.Prepare(config)
 	assert.True(b.prepareCalled, "prepare should be called")
 	assert.Equal(b.prepareConfig, []interface{}{42}, "prepare should be called with right arg")
@@ -79,13 +82,8 @@ func TestBuilderRPC(t *testing.T) {
 	assert.True(b.runCalled, "runs hould be called")
 
 	if b.runCalled {
-		b.runCache.Lock("foo")
 		assert.True(cache.lockCalled, "lock should be called")
-
-		b.runHook.Run("foo", nil, nil, nil)
 		assert.True(hook.RunCalled, "run should be called")
-
-		b.runUi.Say("format")
 		assert.True(ui.sayCalled, "say should be called")
 		assert.Equal(ui.sayMessage, "format", "message should be correct")
 
@@ -114,7 +112,7 @@ func TestBuilder_ImplementsBuilder(t *testing.T) {
 	assert := asserts.NewTestingAsserts(t, true)
 
 	var realBuilder packer.Builder
-	b := Builder(nil)
+	b := new(builder)
 
 	assert.Implementor(b, &realBuilder, "should be a Builder")
 }
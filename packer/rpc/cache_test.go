@@ -3,7 +3,6 @@ package rpc
 import (
 	"cgl.tideland.biz/asserts"
 	"github.com/mitchellh/packer/packer"
-	"net/rpc"
 	"testing"
 )
 
@@ -42,7 +41,7 @@ func (t *testCache) RUnlock(key string) {
 
 func TestCache_Implements(t *testing.T) {
 	var raw interface{}
-	raw = Cache(nil)
+	raw = new(cache)
 	if _, ok := raw.(packer.Cache); !ok {
 		t.Fatal("Cache must be a cache.")
 	}
@@ -55,14 +54,13 @@ func TestCacheRPC(t *testing.T) {
 	c := new(testCache)
 
 	// Start the server
-	server := rpc.NewServer()
-	RegisterCache(server, c)
-	address := serveSingleConn(server)
+	rpcClient, server := testClientServer(t)
+	defer rpcClient.Close()
+	defer server.Close()
+	server.RegisterCache(c)
 
 	// Create the client over RPC and run some methods to verify it works
-	rpcClient, err := rpc.Dial("tcp", address)
-	assert.Nil(err, "should be able to connect")
-	client := Cache(rpcClient)
+	client := rpcClient.Cache()
 
 	// Test Lock
 	client.Lock("foo")
@@ -0,0 +1,136 @@
+package rpc
+
+import (
+	"github.com/mitchellh/packer/packer"
+	"io"
+	"net/rpc"
+)
+
+// Client is the client end that communicates with a Packer RPC server.
+// Establishing a connection is up to the user, the Client can just
+// communicate over any ReadWriteCloser.
+type Client struct {
+	mux      *MuxConn
+	client   *rpc.Client
+	closeMux bool
+}
+
+// NewClient returns a new Packer RPC client that communicates with the
+// Server at the other end of the given connection. The client and the
+// server authenticate each other with the given secret first.
+func NewClient(conn io.ReadWriteCloser, secret string) (*Client, error) {
+	if err := authenticateClient(conn, secret); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	mux := newMuxConn(conn, true)
+	result, err := newClientWithMux(mux, 0)
+	if err != nil {
+		mux.Close()
+		return nil, err
+	}
+
+	result.closeMux = true
+	return result, nil
+}
+
+func newClientWithMux(mux *MuxConn, streamId uint32) (*Client, error) {
+	stream, err := mux.Dial(streamId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		mux:    mux,
+		client: rpc.NewClient(stream),
+	}, nil
+}
+
+// Close closes the client and, if it was created with NewClient, the
+// underlying connection.
+func (c *Client) Close() error {
+	if err := c.client.Close(); err != nil {
+		return err
+	}
+
+	if c.closeMux {
+		return c.mux.Close()
+	}
+
+	return nil
+}
+
+func (c *Client) Artifact() packer.Artifact {
+	return &artifact{
+		client: c.client,
+	}
+}
+
+func (c *Client) Build() packer.Build {
+	return &build{
+		client: c.client,
+		mux:    c.mux,
+	}
+}
+
+func (c *Client) Builder() packer.Builder {
+	return &builder{
+		client: c.client,
+		mux:    c.mux,
+	}
+}
+
+func (c *Client) Cache() packer.Cache {
+	return &cache{
+		client: c.client,
+	}
+}
+
+func (c *Client) Command() packer.Command {
+	return &command{
+		client: c.client,
+		mux:    c.mux,
+	}
+}
+
+func (c *Client) Communicator() packer.Communicator {
+	return &communicator{
+		client: c.client,
+		mux:    c.mux,
+	}
+}
+
+func (c *Client) Environment() packer.Environment {
+	return &Environment{
+		client: c.client,
+		mux:    c.mux,
+	}
+}
+
+func (c *Client) Hook() packer.Hook {
+	return &hook{
+		client: c.client,
+		mux:    c.mux,
+	}
+}
+
+func (c *Client) PostProcessor() packer.PostProcessor {
+	return &postProcessor{
+		client: c.client,
+		mux:    c.mux,
+	}
+}
+
+func (c *Client) Provisioner() packer.Provisioner {
+	return &provisioner{
+		client: c.client,
+		mux:    c.mux,
+	}
+}
+
+func (c *Client) Ui() packer.Ui {
+	return &Ui{
+		client: c.client,
+	}
+}
@@ -9,25 +9,23 @@ import (
 // command is actually executed over an RPC connection.
 type command struct {
 	client *rpc.Client
+	mux    *MuxConn
 }
 
 // A CommandServer wraps a packer.Command and makes it exportable as part
 // of a Golang RPC server.
 type CommandServer struct {
 	command packer.Command
+	mux     *MuxConn
 }
 
 type CommandRunArgs struct {
-	RPCAddress string
-	Args       []string
+	StreamId uint32
+	Args     []string
 }
 
 type CommandSynopsisArgs byte
 
-func Command(client *rpc.Client) *command {
-	return &command{client}
-}
-
 func (c *command) Help() (result string) {
 	err := c.client.Call("Command.Help", new(interface{}), &result)
 	if err != nil {
@@ -39,10 +37,11 @@ func (c *command) Help() (result string) {
 
 func (c *command) Run(env packer.Environment, args []string) (result int) {
 	// Create and start the server for the Environment
-	server := rpc.NewServer()
-	RegisterEnvironment(server, env)
+	streamId := serveOnStream(c.mux, func(s *Server) {
+		s.RegisterEnvironment(env)
+	})
 
-	rpcArgs := &CommandRunArgs{serveSingleConn(server), args}
+	rpcArgs := &CommandRunArgs{streamId, args}
 	err := c.client.Call("Command.Run", rpcArgs, &result)
 	if err != nil {
 		panic(err)
@@ -66,14 +65,13 @@ func (c *CommandServer) Help(args *interface{}, reply *string) error {
 }
 
 func (c *CommandServer) Run(args *CommandRunArgs, reply *int) error {
-	client, err := rpcDial(args.RPCAddress)
+	client, err := newClientWithMux(c.mux, args.StreamId)
 	if err != nil {
-		return err
+		return NewBasicError(err)
 	}
+	defer client.Close()
 
-	env := &Environment{client}
-
-	*reply = c.command.Run(env, args.Args)
+	*reply = c.command.Run(client.Environment(), args.Args)
 	return nil
 }
 
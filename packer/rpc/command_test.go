@@ -3,7 +3,6 @@ package rpc
 import (
 	"cgl.tideland.biz/asserts"
 	"github.com/mitchellh/packer/packer"
-	"net/rpc"
 	"testing"
 )
 
@@ -21,6 +20,9 @@ func (tc *TestCommand) Run(env packer.Environment, args []string) int {
 	tc.runCalled = true
 	tc.runArgs = args
 	tc.runEnv = env
+
+	// The environment only works while the command is running
+	env.Ui()
 	return 0
 }
 
@@ -35,16 +37,14 @@ func TestRPCCommand(t *testing.T) {
 	command := new(TestCommand)
 
 	// Start the server
-	server := rpc.NewServer()
-	RegisterCommand(server, command)
-	address := serveSingleConn(server)
+	client, server := testClientServer(t)
+	defer client.Close()
+	defer server.Close()
+	server.RegisterCommand(command)
 
 	// Create the command client over RPC and run some methods to verify
 	// we get the proper behavior.
-	client, err := rpc.Dial("tcp", address)
-	assert.Nil(err, "should be no error")
-
-	clientComm := Command(client)
+	clientComm := client.Command()
 
 	//Test Help
 	help := clientComm.Help()
@@ -59,7 +59,6 @@ func TestRPCCommand(t *testing.T) {
 
 	assert.NotNil(command.runEnv, "should have an env")
 	if command.runEnv != nil {
-		command.runEnv.Ui()
 		assert.True(testEnv.uiCalled, "UI should be called on env")
 	}
 
@@ -72,7 +71,7 @@ func TestCommand_Implements(t *testing.T) {
 	assert := asserts.NewTestingAsserts(t, true)
 
 	var r packer.Command
-	c := Command(nil)
+	c := new(command)
 
 	assert.Implementor(c, &r, "should be a Builder")
 }
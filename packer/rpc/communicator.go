@@ -2,24 +2,29 @@ package rpc
 
 import (
 	"encoding/gob"
-	"errors"
 	"github.com/mitchellh/packer/packer"
 	"io"
+	"io/ioutil"
 	"log"
-	"net"
 	"net/rpc"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 // An implementation of packer.Communicator where the communicator is actually
 // executed over an RPC connection.
 type communicator struct {
 	client *rpc.Client
+	mux    *MuxConn
 }
 
 // CommunicatorServer wraps a packer.Communicator implementation and makes
 // it exportable as part of a Golang RPC server.
 type CommunicatorServer struct {
-	c packer.Communicator
+	c   packer.Communicator
+	mux *MuxConn
 }
 
 type CommandFinished struct {
@@ -27,73 +32,95 @@ type CommandFinished struct {
 }
 
 type CommunicatorStartArgs struct {
-	Command         string
-	StdinAddress    string
-	StdoutAddress   string
-	StderrAddress   string
-	ResponseAddress string
+	Command          string
+	Env              []string
+	Dir              string
+	Elevated         bool
+	ExpectDisconnect bool
+	ReconnectTimeout time.Duration
+	StdinStreamId    uint32
+	StdoutStreamId   uint32
+	StderrStreamId   uint32
+	ResponseStreamId uint32
 }
 
 type CommunicatorDownloadArgs struct {
-	Path          string
-	WriterAddress string
+	Path           string
+	WriterStreamId uint32
+}
+
+type CommunicatorDownloadDirArgs struct {
+	Src            string
+	Dst            string
+	Exclude        []string
+	WriterStreamId uint32
 }
 
 type CommunicatorUploadArgs struct {
-	Path          string
-	ReaderAddress string
+	Path           string
+	Mode           os.FileMode
+	Owner          string
+	Group          string
+	ReaderStreamId uint32
 }
 
 type CommunicatorUploadDirArgs struct {
-	Dst     string
-	Src     string
-	Exclude []string
+	Dst            string
+	Src            string
+	Exclude        []string
+	ReaderStreamId uint32
 }
 
-func Communicator(client *rpc.Client) *communicator {
-	return &communicator{client}
+type CommunicatorSyncDirArgs struct {
+	Dst            string
+	Src            string
+	Exclude        []string
+	Delete         bool
+	ReaderStreamId uint32
 }
 
 func (c *communicator) Start(cmd *packer.RemoteCmd) (err error) {
 	var args CommunicatorStartArgs
 	args.Command = cmd.Command
+	args.Env = cmd.Env
+	args.Dir = cmd.Dir
+	args.Elevated = cmd.Elevated
+	args.ExpectDisconnect = cmd.ExpectDisconnect
+	args.ReconnectTimeout = cmd.ReconnectTimeout
 
 	if cmd.Stdin != nil {
-		stdinL := netListenerInRange(portRangeMin, portRangeMax)
-		args.StdinAddress = stdinL.Addr().String()
-		go serveSingleCopy("stdin", stdinL, nil, cmd.Stdin)
+		args.StdinStreamId = c.mux.NextId()
+		go serveSingleCopy("stdin", c.mux, args.StdinStreamId, nil, cmd.Stdin, nil)
 	}
 
 	if cmd.Stdout != nil {
-		stdoutL := netListenerInRange(portRangeMin, portRangeMax)
-		args.StdoutAddress = stdoutL.Addr().String()
-		go serveSingleCopy("stdout", stdoutL, cmd.Stdout, nil)
+		args.StdoutStreamId = c.mux.NextId()
+		go serveSingleCopy("stdout", c.mux, args.StdoutStreamId, cmd.Stdout, nil, nil)
 	}
 
 	if cmd.Stderr != nil {
-		stderrL := netListenerInRange(portRangeMin, portRangeMax)
-		args.StderrAddress = stderrL.Addr().String()
-		go serveSingleCopy("stderr", stderrL, cmd.Stderr, nil)
+		args.StderrStreamId = c.mux.NextId()
+		go serveSingleCopy("stderr", c.mux, args.StderrStreamId, cmd.Stderr, nil, nil)
 	}
 
-	responseL := netListenerInRange(portRangeMin, portRangeMax)
-	args.ResponseAddress = responseL.Addr().String()
+	responseStreamId := c.mux.NextId()
+	args.ResponseStreamId = responseStreamId
 
 	go func() {
-		defer responseL.Close()
-
-		conn, err := responseL.Accept()
+		conn, err := c.mux.Accept(responseStreamId)
 		if err != nil {
+			log.Printf("[ERR] Error accepting response stream %d: %s",
+				responseStreamId, err)
 			cmd.SetExited(123)
 			return
 		}
-
 		defer conn.Close()
 
-		decoder := gob.NewDecoder(conn)
-
 		var finished CommandFinished
+		decoder := gob.NewDecoder(conn)
 		if err := decoder.Decode(&finished); err != nil {
+			log.Printf("[ERR] Error decoding response stream %d: %s",
+				responseStreamId, err)
 			cmd.SetExited(123)
 			return
 		}
@@ -102,42 +129,60 @@ func (c *communicator) Start(cmd *packer.RemoteCmd) (err error) {
 	}()
 
 	err = c.client.Call("Communicator.Start", &args, new(interface{}))
-	return
-}
+	if err != nil {
+		// The streams may never be dialed, so don't leave anything
+		// waiting on them.
+		ids := []uint32{
+			args.StdinStreamId,
+			args.StdoutStreamId,
+			args.StderrStreamId,
+			responseStreamId,
+		}
 
-func (c *communicator) Upload(path string, r io.Reader) (err error) {
-	// We need to create a server that can proxy the reader data
-	// over because we can't simply gob encode an io.Reader
-	readerL := netListenerInRange(portRangeMin, portRangeMax)
-	if readerL == nil {
-		err = errors.New("couldn't allocate listener for upload reader")
-		return
+		for _, id := range ids {
+			if id > 0 {
+				c.mux.cancelAccept(id)
+			}
+		}
 	}
 
-	// Make sure at the end of this call, we close the listener
-	defer readerL.Close()
+	return
+}
+
+func (c *communicator) Upload(path string, r io.Reader) error {
+	return c.UploadFile(path, r, new(packer.UploadOptions))
+}
 
-	// Pipe the reader through to the connection
-	go serveSingleCopy("uploadReader", readerL, nil, r)
+func (c *communicator) UploadFile(path string, r io.Reader, opts *packer.UploadOptions) (err error) {
+	// Stream the reader through to the other side on a new stream, since
+	// we can't simply gob encode an io.Reader
+	streamId := c.mux.NextId()
+	go serveSingleCopy("uploadReader", c.mux, streamId, nil, r, nil)
 
 	args := CommunicatorUploadArgs{
-		path,
-		readerL.Addr().String(),
+		Path:           path,
+		Mode:           opts.Mode,
+		Owner:          opts.Owner,
+		Group:          opts.Group,
+		ReaderStreamId: streamId,
 	}
 
 	err = c.client.Call("Communicator.Upload", &args, new(interface{}))
+	c.mux.cancelAccept(streamId)
 	return
 }
 
 func (c *communicator) UploadDir(dst string, src string, exclude []string) error {
 	args := &CommunicatorUploadDirArgs{
-		Dst:     dst,
-		Src:     src,
-		Exclude: exclude,
+		Dst:            dst,
+		Src:            src,
+		Exclude:        exclude,
+		ReaderStreamId: c.streamDir("uploadDir", src, exclude),
 	}
 
 	var reply error
 	err := c.client.Call("Communicator.UploadDir", args, &reply)
+	c.mux.cancelAccept(args.ReaderStreamId)
 	if err == nil {
 		err = reply
 	}
@@ -145,78 +190,179 @@ func (c *communicator) UploadDir(dst string, src string, exclude []string) error
 	return err
 }
 
-func (c *communicator) Download(path string, w io.Writer) (err error) {
-	// We need to create a server that can proxy that data downloaded
-	// into the writer because we can't gob encode a writer directly.
-	writerL := netListenerInRange(portRangeMin, portRangeMax)
-	if writerL == nil {
-		err = errors.New("couldn't allocate listener for download writer")
-		return
+func (c *communicator) SyncDir(dst string, src string, opts *packer.SyncDirOptions) error {
+	args := &CommunicatorSyncDirArgs{
+		Dst:            dst,
+		Src:            src,
+		Exclude:        opts.Exclude,
+		Delete:         opts.Delete,
+		ReaderStreamId: c.streamDir("syncDir", src, opts.Exclude),
 	}
 
-	// Make sure we close the listener once we're done because we'll be done
-	defer writerL.Close()
+	var reply error
+	err := c.client.Call("Communicator.SyncDir", args, &reply)
+	c.mux.cancelAccept(args.ReaderStreamId)
+	if err == nil {
+		err = reply
+	}
+
+	return err
+}
+
+// streamDir streams the directory src as a tar archive on a new stream,
+// returning the ID of the stream. The directory may not exist on the
+// other side of the connection, so it is sent over, without the
+// excluded paths. The other side unpacks it with receiveDir.
+func (c *communicator) streamDir(name string, src string, exclude []string) uint32 {
+	streamId := c.mux.NextId()
+	go func() {
+		conn, err := c.mux.Accept(streamId)
+		if err != nil {
+			log.Printf("'%s' accept error: %s", name, err)
+			return
+		}
+
+		if err := writeDirTar(conn, src, exclude); err != nil {
+			log.Printf("'%s' error: %s", name, err)
+			conn.CloseWithError(err)
+			return
+		}
+
+		conn.Close()
+	}()
 
-	// Serve a single connection and a single copy
-	go serveSingleCopy("downloadWriter", writerL, w, nil)
+	return streamId
+}
+
+func (c *communicator) Download(path string, w io.Writer) (err error) {
+	// Copy the data downloaded on a new stream into the writer, since
+	// we can't gob encode a writer directly.
+	streamId := c.mux.NextId()
+	errCh := make(chan error, 1)
+	go serveSingleCopy("downloadWriter", c.mux, streamId, w, nil, errCh)
 
 	args := CommunicatorDownloadArgs{
-		path,
-		writerL.Addr().String(),
+		Path:           path,
+		WriterStreamId: streamId,
 	}
 
 	err = c.client.Call("Communicator.Download", &args, new(interface{}))
+	c.mux.cancelAccept(streamId)
+	if err == nil {
+		// Wait for all of the data to be copied into the writer
+		err = <-errCh
+	}
+
 	return
 }
 
+func (c *communicator) DownloadDir(src string, dst string, exclude []string) error {
+	// The directory is streamed back to us as a tar archive, which is
+	// unpacked into the destination as it arrives.
+	streamId := c.mux.NextId()
+	errCh := make(chan error, 1)
+	go func() {
+		conn, err := c.mux.Accept(streamId)
+		if err != nil {
+			log.Printf("'downloadDir' accept error: %s", err)
+			errCh <- err
+			return
+		}
+
+		err = readDirTar(conn, dst)
+		if err != nil {
+			conn.CloseWithError(err)
+		} else {
+			conn.Close()
+		}
+
+		errCh <- err
+	}()
+
+	args := &CommunicatorDownloadDirArgs{
+		Src:            src,
+		Dst:            dst,
+		Exclude:        exclude,
+		WriterStreamId: streamId,
+	}
+
+	var reply error
+	err := c.client.Call("Communicator.DownloadDir", args, &reply)
+	c.mux.cancelAccept(streamId)
+	if err == nil {
+		err = reply
+	}
+
+	// Wait for the archive to be unpacked. If the download failed, the
+	// error from the other side is more useful than the aborted stream.
+	if tarErr := <-errCh; err == nil {
+		err = tarErr
+	}
+
+	return err
+}
+
 func (c *CommunicatorServer) Start(args *CommunicatorStartArgs, reply *interface{}) (err error) {
 	// Build the RemoteCmd on this side so that it all pipes over
 	// to the remote side.
 	var cmd packer.RemoteCmd
 	cmd.Command = args.Command
-
-	toClose := make([]net.Conn, 0)
-	if args.StdinAddress != "" {
-		stdinC, err := tcpDial(args.StdinAddress)
+	cmd.Env = args.Env
+	cmd.Dir = args.Dir
+	cmd.Elevated = args.Elevated
+	cmd.ExpectDisconnect = args.ExpectDisconnect
+	cmd.ReconnectTimeout = args.ReconnectTimeout
+
+	toClose := make([]io.Closer, 0)
+	if args.StdinStreamId > 0 {
+		stdinC, err := c.mux.Dial(args.StdinStreamId)
 		if err != nil {
-			return err
+			return NewBasicError(err)
 		}
 
 		toClose = append(toClose, stdinC)
 		cmd.Stdin = stdinC
 	}
 
-	if args.StdoutAddress != "" {
-		stdoutC, err := tcpDial(args.StdoutAddress)
+	if args.StdoutStreamId > 0 {
+		stdoutC, err := c.mux.Dial(args.StdoutStreamId)
 		if err != nil {
-			return err
+			return NewBasicError(err)
 		}
 
 		toClose = append(toClose, stdoutC)
 		cmd.Stdout = stdoutC
 	}
 
-	if args.StderrAddress != "" {
-		stderrC, err := tcpDial(args.StderrAddress)
+	if args.StderrStreamId > 0 {
+		stderrC, err := c.mux.Dial(args.StderrStreamId)
 		if err != nil {
-			return err
+			return NewBasicError(err)
 		}
 
 		toClose = append(toClose, stderrC)
 		cmd.Stderr = stderrC
 	}
 
-	// Connect to the response address so we can write our result to it
+	// Connect to the response stream so we can write our result to it
 	// when ready.
-	responseC, err := tcpDial(args.ResponseAddress)
+	responseC, err := c.mux.Dial(args.ResponseStreamId)
 	if err != nil {
-		return err
+		return NewBasicError(err)
 	}
 
 	responseWriter := gob.NewEncoder(responseC)
 
 	// Start the actual command
 	err = c.c.Start(&cmd)
+	if err != nil {
+		responseC.Close()
+		for _, conn := range toClose {
+			conn.Close()
+		}
+
+		return NewBasicError(err)
+	}
 
 	// Start a goroutine to spin and wait for the process to actual
 	// exit. When it does, report it back to caller...
@@ -234,46 +380,149 @@ func (c *CommunicatorServer) Start(args *CommunicatorStartArgs, reply *interface
 }
 
 func (c *CommunicatorServer) Upload(args *CommunicatorUploadArgs, reply *interface{}) (err error) {
-	readerC, err := tcpDial(args.ReaderAddress)
+	readerC, err := c.mux.Dial(args.ReaderStreamId)
 	if err != nil {
 		return
 	}
-
 	defer readerC.Close()
 
-	err = c.c.Upload(args.Path, readerC)
+	opts := &packer.UploadOptions{
+		Mode:  args.Mode,
+		Owner: args.Owner,
+		Group: args.Group,
+	}
+
+	err = packer.UploadFile(c.c, args.Path, readerC, opts)
 	return
 }
 
 func (c *CommunicatorServer) UploadDir(args *CommunicatorUploadDirArgs, reply *error) error {
-	return c.c.UploadDir(args.Dst, args.Src, args.Exclude)
+	src, err := c.receiveDir(args.ReaderStreamId, args.Src)
+	if err != nil {
+		return NewBasicError(err)
+	}
+	defer os.RemoveAll(filepath.Dir(src))
+
+	if err := c.c.UploadDir(args.Dst, src, args.Exclude); err != nil {
+		*reply = NewBasicError(err)
+	}
+
+	return nil
+}
+
+func (c *CommunicatorServer) SyncDir(args *CommunicatorSyncDirArgs, reply *error) error {
+	src, err := c.receiveDir(args.ReaderStreamId, args.Src)
+	if err != nil {
+		return NewBasicError(err)
+	}
+	defer os.RemoveAll(filepath.Dir(src))
+
+	opts := &packer.SyncDirOptions{
+		Exclude: args.Exclude,
+		Delete:  args.Delete,
+	}
+
+	if err := packer.SyncDir(c.c, args.Dst, src, opts); err != nil {
+		*reply = NewBasicError(err)
+	}
+
+	return nil
+}
+
+// receiveDir unpacks the directory that is streamed to us into a
+// temporary directory with the same name as src, returning the path to
+// upload it from. The caller removes the temporary directory, which is
+// the parent of the returned path.
+//
+// The directory is copied to disk rather than streamed straight into
+// the communicator, since communicators upload directories from a
+// local path. This side needs room for a copy of the directory.
+func (c *CommunicatorServer) receiveDir(streamId uint32, src string) (string, error) {
+	readerC, err := c.mux.Dial(streamId)
+	if err != nil {
+		return "", err
+	}
+	defer readerC.Close()
+
+	td, err := ioutil.TempDir("", "packer-upload-dir")
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(td, filepath.Base(src))
+	if err := readDirTar(readerC, path); err != nil {
+		os.RemoveAll(td)
+		return "", err
+	}
+
+	// Keep the trailing slash, which means the contents of the
+	// directory are uploaded rather than the directory itself.
+	if strings.HasSuffix(src, "/") {
+		path += "/"
+	}
+
+	return path, nil
 }
 
 func (c *CommunicatorServer) Download(args *CommunicatorDownloadArgs, reply *interface{}) (err error) {
-	writerC, err := tcpDial(args.WriterAddress)
+	writerC, err := c.mux.Dial(args.WriterStreamId)
 	if err != nil {
 		return
 	}
-
 	defer writerC.Close()
 
 	err = c.c.Download(args.Path, writerC)
 	return
 }
 
-func serveSingleCopy(name string, l net.Listener, dst io.Writer, src io.Reader) {
-	defer l.Close()
+func (c *CommunicatorServer) DownloadDir(args *CommunicatorDownloadDirArgs, reply *error) error {
+	writerC, err := c.mux.Dial(args.WriterStreamId)
+	if err != nil {
+		return NewBasicError(err)
+	}
+
+	// Download the directory into a temporary directory and stream it
+	// back from there as a tar archive.
+	td, err := ioutil.TempDir("", "packer-download-dir")
+	if err != nil {
+		writerC.CloseWithError(err)
+		return NewBasicError(err)
+	}
+	defer os.RemoveAll(td)
+
+	err = c.c.DownloadDir(args.Src, td, args.Exclude)
+	if err == nil {
+		err = writeDirTar(writerC, td, nil)
+	}
 
-	conn, err := l.Accept()
+	if err != nil {
+		writerC.CloseWithError(err)
+		*reply = NewBasicError(err)
+		return nil
+	}
+
+	writerC.Close()
+	return nil
+}
+
+// serveSingleCopy accepts the stream with the given ID and copies it
+// into dst, or src into it, whichever is nil. If errCh is given, the
+// result of the copy is sent on it once the copy is complete.
+//
+// If the copy fails, the stream is aborted so that the other side sees
+// the error rather than EOF and doesn't mistake the partial data for
+// all of it.
+func serveSingleCopy(name string, mux *MuxConn, id uint32, dst io.Writer, src io.Reader, errCh chan<- error) {
+	conn, err := mux.Accept(id)
 	if err != nil {
 		log.Printf("'%s' accept error: %s", name, err)
+		if errCh != nil {
+			errCh <- err
+		}
+
 		return
 	}
 
-	// Be sure to close the connection after we're done copying so
-	// that an EOF will successfully be sent to the remote side
-	defer conn.Close()
-
 	// The connection is the destination/source that is nil
 	if dst == nil {
 		dst = conn
@@ -285,5 +534,14 @@ func serveSingleCopy(name string, l net.Listener, dst io.Writer, src io.Reader)
 	log.Printf("%d bytes written for '%s'", written, name)
 	if err != nil {
 		log.Printf("'%s' copy error: %s", name, err)
+		conn.CloseWithError(err)
+	} else {
+		// Close the connection after we're done copying so that an
+		// EOF will successfully be sent to the remote side
+		conn.Close()
+	}
+
+	if errCh != nil {
+		errCh <- err
 	}
 }
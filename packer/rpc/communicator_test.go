@@ -2,28 +2,86 @@ package rpc
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"github.com/mitchellh/packer/packer"
 	"io"
-	"net/rpc"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
+// testDir creates a directory with some files in it to upload.
+func testDir(t *testing.T) string {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(td, "sub", "empty"), 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	files := map[string]string{
+		"foo":                       "foo\n",
+		filepath.Join("sub", "bar"): "bar\n",
+	}
+
+	for name, contents := range files {
+		err := ioutil.WriteFile(filepath.Join(td, name), []byte(contents), 0644)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	return td
+}
+
+// readTestDir returns the relative paths of everything in a directory
+// mapped to the contents of the files.
+func readTestDir(t *testing.T, dir string) map[string]string {
+	result := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, _ := filepath.Rel(dir, path)
+		result[rel] = ""
+		if !info.IsDir() {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+
+			result[rel] = string(data)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return result
+}
+
 func TestCommunicatorRPC(t *testing.T) {
 	// Create the interface to test
 	c := new(packer.MockCommunicator)
 
 	// Start the server
-	server := rpc.NewServer()
-	RegisterCommunicator(server, c)
-	address := serveSingleConn(server)
+	client, server := testClientServer(t)
+	defer client.Close()
+	defer server.Close()
+	server.RegisterCommunicator(c)
 
 	// Create the client over RPC and run some methods to verify it works
-	client, err := rpc.Dial("tcp", address)
-	if err != nil {
-		t.Fatalf("err: %s", err)
-	}
-	remote := Communicator(client)
+	remote := client.Communicator()
 
 	// The remote command we'll use
 	stdin_r, stdin_w := io.Pipe()
@@ -32,9 +90,14 @@ func TestCommunicatorRPC(t *testing.T) {
 
 	var cmd packer.RemoteCmd
 	cmd.Command = "foo"
+	cmd.Env = []string{"FOO=bar"}
+	cmd.Dir = "/tmp"
+	cmd.Elevated = true
 	cmd.Stdin = stdin_r
 	cmd.Stdout = stdout_w
 	cmd.Stderr = stderr_w
+	cmd.ExpectDisconnect = true
+	cmd.ReconnectTimeout = 10 * time.Minute
 
 	// Send some data on stdout and stderr from the mock
 	c.StartStdout = "outfoo\n"
@@ -42,7 +105,7 @@ func TestCommunicatorRPC(t *testing.T) {
 	c.StartExitStatus = 42
 
 	// Test Start
-	err = remote.Start(&cmd)
+	err := remote.Start(&cmd)
 	if err != nil {
 		t.Fatalf("err: %s", err)
 	}
@@ -82,6 +145,16 @@ func TestCommunicatorRPC(t *testing.T) {
 		t.Fatalf("bad exit: %d", cmd.ExitStatus)
 	}
 
+	// Test that the environment, directory and elevation made it across
+	if !reflect.DeepEqual(c.StartCmd.Env, cmd.Env) || c.StartCmd.Dir != "/tmp" || !c.StartCmd.Elevated {
+		t.Fatalf("bad: %#v", c.StartCmd)
+	}
+
+	// Test that the reconnect options made it across
+	if !c.StartCmd.ExpectDisconnect || c.StartCmd.ReconnectTimeout != 10*time.Minute {
+		t.Fatalf("bad: %#v", c.StartCmd)
+	}
+
 	// Test that we can upload things
 	uploadR, uploadW := io.Pipe()
 	go func() {
@@ -105,10 +178,19 @@ func TestCommunicatorRPC(t *testing.T) {
 		t.Fatalf("bad: %s", c.UploadData)
 	}
 
-	// Test that we can upload directories
+	// Test that we can upload directories, which are streamed over and
+	// uploaded from a copy with the same name.
 	dirDst := "foo"
-	dirSrc := "bar"
+	dirSrc := testDir(t)
+	defer os.RemoveAll(dirSrc)
 	dirExcl := []string{"foo"}
+
+	var dirContents map[string]string
+	c.UploadDirFunc = func(dst, src string, excl []string) error {
+		dirContents = readTestDir(t, src)
+		return nil
+	}
+
 	err = remote.UploadDir(dirDst, dirSrc, dirExcl)
 	if err != nil {
 		t.Fatalf("err: %s", err)
@@ -118,7 +200,7 @@ func TestCommunicatorRPC(t *testing.T) {
 		t.Fatalf("bad: %s", c.UploadDirDst)
 	}
 
-	if c.UploadDirSrc != dirSrc {
+	if filepath.Base(c.UploadDirSrc) != filepath.Base(dirSrc) {
 		t.Fatalf("bad: %s", c.UploadDirSrc)
 	}
 
@@ -126,6 +208,42 @@ func TestCommunicatorRPC(t *testing.T) {
 		t.Fatalf("bad: %#v", c.UploadDirExclude)
 	}
 
+	// The excluded files aren't streamed
+	dirExpected := readTestDir(t, dirSrc)
+	delete(dirExpected, "foo")
+	if !reflect.DeepEqual(dirContents, dirExpected) {
+		t.Fatalf("bad: %#v", dirContents)
+	}
+
+	// The trailing slash is kept
+	err = remote.UploadDir(dirDst, dirSrc+"/", dirExcl)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !strings.HasSuffix(c.UploadDirSrc, "/") {
+		t.Fatalf("bad: %s", c.UploadDirSrc)
+	}
+
+	// Syncing directories uploads them if the communicator can't sync
+	c.UploadDirDst = ""
+	opts := &packer.SyncDirOptions{Exclude: dirExcl, Delete: true}
+	if err := packer.SyncDir(remote, "bar", dirSrc, opts); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if c.UploadDirDst != "bar" {
+		t.Fatalf("bad: %s", c.UploadDirDst)
+	}
+
+	if !reflect.DeepEqual(c.UploadDirExclude, dirExcl) {
+		t.Fatalf("bad: %#v", c.UploadDirExclude)
+	}
+
+	if !reflect.DeepEqual(dirContents, dirExpected) {
+		t.Fatalf("bad: %#v", dirContents)
+	}
+
 	// Test that we can download things
 	downloadR, downloadW := io.Pipe()
 	downloadDone := make(chan bool)
@@ -164,8 +282,174 @@ func TestCommunicatorRPC(t *testing.T) {
 
 func TestCommunicator_ImplementsCommunicator(t *testing.T) {
 	var raw interface{}
-	raw = Communicator(nil)
+	raw = new(communicator)
 	if _, ok := raw.(packer.Communicator); !ok {
 		t.Fatal("should be a Communicator")
 	}
+
+	if _, ok := raw.(packer.DirSyncer); !ok {
+		t.Fatal("should be a DirSyncer")
+	}
+
+	if _, ok := raw.(packer.FileUploader); !ok {
+		t.Fatal("should be a FileUploader")
+	}
+}
+
+func TestCommunicatorRPC_uploadFile(t *testing.T) {
+	c := new(packer.MockCommunicator)
+	client, server := testClientServer(t)
+	defer client.Close()
+	defer server.Close()
+	server.RegisterCommunicator(c)
+
+	opts := &packer.UploadOptions{Mode: 0755, Owner: "root", Group: "wheel"}
+	err := packer.UploadFile(client.Communicator(), "foo", strings.NewReader("data"), opts)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if c.UploadPath != "foo" || c.UploadData != "data" {
+		t.Fatalf("should upload: %#v", c)
+	}
+
+	// The mock isn't a FileUploader, so the options are applied with
+	// a command on the other side.
+	if c.StartCmd == nil || c.StartCmd.Command != opts.RemoteCmd("foo").Command {
+		t.Fatalf("should set the mode and owner: %#v", c.StartCmd)
+	}
+}
+
+func TestCommunicatorRPC_largeUpload(t *testing.T) {
+	c := new(packer.MockCommunicator)
+	client, server := testClientServer(t)
+	defer client.Close()
+	defer server.Close()
+	server.RegisterCommunicator(c)
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4*muxWindowSize/16)
+	if err := client.Communicator().Upload("foo", bytes.NewReader(data)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if c.UploadData != string(data) {
+		t.Fatalf("bad data, length: %d", len(c.UploadData))
+	}
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, errors.New("read failed")
+}
+
+func TestCommunicatorRPC_uploadReaderError(t *testing.T) {
+	c := new(packer.MockCommunicator)
+	client, server := testClientServer(t)
+	defer client.Close()
+	defer server.Close()
+	server.RegisterCommunicator(c)
+
+	r := io.MultiReader(strings.NewReader("partial"), errReader{})
+	err := client.Communicator().Upload("foo", r)
+	if err == nil {
+		t.Fatal("should error")
+	}
+
+	if !strings.Contains(err.Error(), "read failed") {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestCommunicatorRPC_downloadWriterError(t *testing.T) {
+	c := new(packer.MockCommunicator)
+	c.DownloadData = "foo"
+	client, server := testClientServer(t)
+	defer client.Close()
+	defer server.Close()
+	server.RegisterCommunicator(c)
+
+	if err := client.Communicator().Download("foo", errWriter{}); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestCommunicatorRPC_downloadDir(t *testing.T) {
+	c := new(packer.MockCommunicator)
+	client, server := testClientServer(t)
+	defer client.Close()
+	defer server.Close()
+	server.RegisterCommunicator(c)
+
+	src := testDir(t)
+	defer os.RemoveAll(src)
+
+	// The mock "downloads" by copying the test directory into the
+	// destination it is given.
+	c.DownloadDirFunc = func(_, dst string, _ []string) error {
+		var buf bytes.Buffer
+		if err := writeDirTar(&buf, src, nil); err != nil {
+			return err
+		}
+
+		return readDirTar(&buf, filepath.Join(dst, "data"))
+	}
+
+	dst, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dst)
+
+	excl := []string{"foo"}
+	err = client.Communicator().DownloadDir("/remote/data", dst, excl)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if c.DownloadDirSrc != "/remote/data" {
+		t.Fatalf("bad: %s", c.DownloadDirSrc)
+	}
+
+	if !reflect.DeepEqual(c.DownloadDirExclude, excl) {
+		t.Fatalf("bad: %#v", c.DownloadDirExclude)
+	}
+
+	actual := readTestDir(t, filepath.Join(dst, "data"))
+	if !reflect.DeepEqual(actual, readTestDir(t, src)) {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
+
+func TestCommunicatorRPC_downloadDirError(t *testing.T) {
+	c := new(packer.MockCommunicator)
+	c.DownloadDirFunc = func(string, string, []string) error {
+		return errors.New("download failed")
+	}
+
+	client, server := testClientServer(t)
+	defer client.Close()
+	defer server.Close()
+	server.RegisterCommunicator(c)
+
+	dst, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dst)
+
+	err = client.Communicator().DownloadDir("foo", dst, nil)
+	if err == nil {
+		t.Fatal("should error")
+	}
+
+	if err.Error() != "download failed" {
+		t.Fatalf("bad: %s", err)
+	}
 }
@@ -0,0 +1,147 @@
+package rpc
+
+import (
+	"archive/tar"
+	"fmt"
+	"github.com/mitchellh/packer/packer"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// writeDirTar writes the contents of the directory src to w as a tar
+// archive, with paths relative to src. The paths matching the exclude
+// patterns, as matched by packer.ExcludeMatch, are left out.
+func writeDirTar(w io.Writer, src string, exclude []string) error {
+	tw := tar.NewWriter(w)
+
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+
+		if rel == "." {
+			return nil
+		}
+
+		if packer.ExcludeMatch(filepath.ToSlash(rel), exclude) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+
+		header.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// readDirTar extracts the tar archive read from r into the directory
+// dst, creating it if it doesn't exist.
+func readDirTar(r io.Reader, dst string) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// Don't let the archive write anywhere outside of dst
+		path := filepath.Join(dst, filepath.FromSlash(header.Name))
+		if !strings.HasPrefix(path, dst+string(filepath.Separator)) {
+			return fmt.Errorf("Invalid path in directory archive: %s", header.Name)
+		}
+
+		mode := os.FileMode(header.Mode).Perm()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, mode); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+			if err != nil {
+				return err
+			}
+
+			// Keep the exact mode, which the umask may have changed
+			if err := f.Chmod(mode); err != nil {
+				f.Close()
+				return err
+			}
+
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+
+			// Keep the modification time, which is used to tell whether
+			// the file changed when syncing directories.
+			if err := os.Chtimes(path, header.ModTime, header.ModTime); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.Symlink(header.Linkname, path); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("Unsupported file in directory archive: %s", header.Name)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package rpc
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDirTar(t *testing.T) {
+	src := testDir(t)
+	defer os.RemoveAll(src)
+
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	mtime := time.Unix(1234567890, 0)
+	if err := os.Chtimes(filepath.Join(src, "foo"), mtime, mtime); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := os.Chmod(filepath.Join(src, "foo"), 0775); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeDirTar(&buf, src, nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	dst := filepath.Join(td, "dst")
+	if err := readDirTar(&buf, dst); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !reflect.DeepEqual(readTestDir(t, dst), readTestDir(t, src)) {
+		t.Fatalf("bad: %#v", readTestDir(t, dst))
+	}
+
+	fi, err := os.Stat(filepath.Join(dst, "foo"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !fi.ModTime().Equal(mtime) {
+		t.Fatalf("modification time should be kept: %s", fi.ModTime())
+	}
+
+	if fi.Mode().Perm() != 0775 {
+		t.Fatalf("mode should be kept: %s", fi.Mode())
+	}
+}
+
+func TestDirTar_exclude(t *testing.T) {
+	src := testDir(t)
+	defer os.RemoveAll(src)
+
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	var buf bytes.Buffer
+	if err := writeDirTar(&buf, src, []string{"foo", "sub/empty"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	dst := filepath.Join(td, "dst")
+	if err := readDirTar(&buf, dst); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := map[string]string{
+		".":                         "",
+		"sub":                       "",
+		filepath.Join("sub", "bar"): "bar\n",
+	}
+
+	if actual := readTestDir(t, dst); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
+
+func TestReadDirTar_outside(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "../foo", Mode: 0644, Typeflag: tar.TypeReg})
+	tw.Close()
+
+	if err := readDirTar(&buf, filepath.Join(td, "dst")); err == nil {
+		t.Fatal("should not extract outside of the directory")
+	}
+
+	if _, err := os.Stat(filepath.Join(td, "foo")); err == nil {
+		t.Fatal("file should not exist")
+	}
+}
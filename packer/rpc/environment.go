@@ -9,12 +9,14 @@ import (
 // where the actual environment is executed over an RPC connection.
 type Environment struct {
 	client *rpc.Client
+	mux    *MuxConn
 }
 
 // A EnvironmentServer wraps a packer.Environment and makes it exportable
 // as part of a Golang RPC server.
 type EnvironmentServer struct {
 	env packer.Environment
+	mux *MuxConn
 }
 
 type EnvironmentCliArgs struct {
@@ -22,33 +24,33 @@ type EnvironmentCliArgs struct {
 }
 
 func (e *Environment) Builder(name string) (b packer.Builder, err error) {
-	var reply string
+	var reply uint32
 	err = e.client.Call("Environment.Builder", name, &reply)
 	if err != nil {
 		return
 	}
 
-	client, err := rpcDial(reply)
+	client, err := newClientWithMux(e.mux, reply)
 	if err != nil {
 		return
 	}
 
-	b = Builder(client)
+	b = client.Builder()
 	return
 }
 
 func (e *Environment) Cache() packer.Cache {
-	var reply string
+	var reply uint32
 	if err := e.client.Call("Environment.Cache", new(interface{}), &reply); err != nil {
 		panic(err)
 	}
 
-	client, err := rpcDial(reply)
+	client, err := newClientWithMux(e.mux, reply)
 	if err != nil {
 		panic(err)
 	}
 
-	return Cache(client)
+	return client.Cache()
 }
 
 func (e *Environment) Cli(args []string) (result int, err error) {
@@ -58,85 +60,85 @@ func (e *Environment) Cli(args []string) (result int, err error) {
 }
 
 func (e *Environment) Hook(name string) (h packer.Hook, err error) {
-	var reply string
+	var reply uint32
 	err = e.client.Call("Environment.Hook", name, &reply)
 	if err != nil {
 		return
 	}
 
-	client, err := rpcDial(reply)
+	client, err := newClientWithMux(e.mux, reply)
 	if err != nil {
 		return
 	}
 
-	h = Hook(client)
+	h = client.Hook()
 	return
 }
 
 func (e *Environment) PostProcessor(name string) (p packer.PostProcessor, err error) {
-	var reply string
+	var reply uint32
 	err = e.client.Call("Environment.PostProcessor", name, &reply)
 	if err != nil {
 		return
 	}
 
-	client, err := rpcDial(reply)
+	client, err := newClientWithMux(e.mux, reply)
 	if err != nil {
 		return
 	}
 
-	p = PostProcessor(client)
+	p = client.PostProcessor()
 	return
 }
 
 func (e *Environment) Provisioner(name string) (p packer.Provisioner, err error) {
-	var reply string
+	var reply uint32
 	err = e.client.Call("Environment.Provisioner", name, &reply)
 	if err != nil {
 		return
 	}
 
-	client, err := rpcDial(reply)
+	client, err := newClientWithMux(e.mux, reply)
 	if err != nil {
 		return
 	}
 
-	p = Provisioner(client)
+	p = client.Provisioner()
 	return
 }
 
 func (e *Environment) Ui() packer.Ui {
-	var reply string
+	var reply uint32
 	e.client.Call("Environment.Ui", new(interface{}), &reply)
 
-	client, err := rpcDial(reply)
+	client, err := newClientWithMux(e.mux, reply)
 	if err != nil {
 		panic(err)
 	}
 
-	return &Ui{client}
+	return client.Ui()
 }
 
-func (e *EnvironmentServer) Builder(name *string, reply *string) error {
+func (e *EnvironmentServer) Builder(name *string, reply *uint32) error {
 	builder, err := e.env.Builder(*name)
 	if err != nil {
 		return err
 	}
 
-	// Wrap it
-	server := rpc.NewServer()
-	RegisterBuilder(server, builder)
+	*reply = serveOnStream(e.mux, func(s *Server) {
+		s.RegisterBuilder(builder)
+	})
 
-	*reply = serveSingleConn(server)
 	return nil
 }
 
-func (e *EnvironmentServer) Cache(args *interface{}, reply *string) error {
+func (e *EnvironmentServer) Cache(args *interface{}, reply *uint32) error {
 	cache := e.env.Cache()
 
-	server := rpc.NewServer()
-	RegisterCache(server, cache)
-	*reply = serveSingleConn(server)
+	*reply = serveOnStream(e.mux, func(s *Server) {
+		s.RegisterCache(cache)
+	})
+
 	return nil
 }
 
@@ -145,53 +147,51 @@ func (e *EnvironmentServer) Cli(args *EnvironmentCliArgs, reply *int) (err error
 	return
 }
 
-func (e *EnvironmentServer) Hook(name *string, reply *string) error {
+func (e *EnvironmentServer) Hook(name *string, reply *uint32) error {
 	hook, err := e.env.Hook(*name)
 	if err != nil {
 		return err
 	}
 
-	// Wrap it
-	server := rpc.NewServer()
-	RegisterHook(server, hook)
+	*reply = serveOnStream(e.mux, func(s *Server) {
+		s.RegisterHook(hook)
+	})
 
-	*reply = serveSingleConn(server)
 	return nil
 }
 
-func (e *EnvironmentServer) PostProcessor(name *string, reply *string) error {
+func (e *EnvironmentServer) PostProcessor(name *string, reply *uint32) error {
 	pp, err := e.env.PostProcessor(*name)
 	if err != nil {
 		return err
 	}
 
-	server := rpc.NewServer()
-	RegisterPostProcessor(server, pp)
+	*reply = serveOnStream(e.mux, func(s *Server) {
+		s.RegisterPostProcessor(pp)
+	})
 
-	*reply = serveSingleConn(server)
 	return nil
 }
 
-func (e *EnvironmentServer) Provisioner(name *string, reply *string) error {
+func (e *EnvironmentServer) Provisioner(name *string, reply *uint32) error {
 	prov, err := e.env.Provisioner(*name)
 	if err != nil {
 		return err
 	}
 
-	server := rpc.NewServer()
-	RegisterProvisioner(server, prov)
+	*reply = serveOnStream(e.mux, func(s *Server) {
+		s.RegisterProvisioner(prov)
+	})
 
-	*reply = serveSingleConn(server)
 	return nil
 }
 
-func (e *EnvironmentServer) Ui(args *interface{}, reply *string) error {
+func (e *EnvironmentServer) Ui(args *interface{}, reply *uint32) error {
 	ui := e.env.Ui()
 
-	// Wrap it
-	server := rpc.NewServer()
-	RegisterUi(server, ui)
+	*reply = serveOnStream(e.mux, func(s *Server) {
+		s.RegisterUi(ui)
+	})
 
-	*reply = serveSingleConn(server)
 	return nil
 }
@@ -3,7 +3,6 @@ package rpc
 import (
 	"cgl.tideland.biz/asserts"
 	"github.com/mitchellh/packer/packer"
-	"net/rpc"
 	"testing"
 )
 
@@ -71,14 +70,13 @@ func TestEnvironmentRPC(t *testing.T) {
 	e := &testEnvironment{}
 
 	// Start the server
-	server := rpc.NewServer()
-	RegisterEnvironment(server, e)
-	address := serveSingleConn(server)
+	client, server := testClientServer(t)
+	defer client.Close()
+	defer server.Close()
+	server.RegisterEnvironment(e)
 
 	// Create the client over RPC and run some methods to verify it works
-	client, err := rpc.Dial("tcp", address)
-	assert.Nil(err, "should be able to connect")
-	eClient := &Environment{client}
+	eClient := client.Environment()
 
 	// Test Builder
 	builder, _ := eClient.Builder("foo")
@@ -119,7 +117,7 @@ func TestEnvironment_ImplementsEnvironment(t *testing.T) {
 	assert := asserts.NewTestingAsserts(t, true)
 
 	var realVar packer.Environment
-	e := &Environment{nil}
+	e := new(Environment)
 
 	assert.Implementor(e, &realVar, "should be an Environment")
 }
@@ -1,17 +1,174 @@
 package rpc
 
+import (
+	"github.com/mitchellh/packer/packer"
+	"strings"
+	"sync"
+)
+
 // This is a type that wraps error types so that they can be messaged
 // across RPC channels. Since "error" is an interface, we can't always
 // gob-encode the underlying structure. This is a valid error interface
 // implementer that we will push across.
+//
+// Errors of a kind registered with RegisterErrorKind also carry the name
+// of their kind and the structured data the kind needs, so that the
+// other side can turn them back into the original error type.
 type BasicError struct {
-	Message string
+	Message  string
+	Kind     string
+	Key      string
+	Children []*BasicError
+}
+
+// ErrorKind is a kind of error that keeps its type when it is sent
+// across RPC.
+type ErrorKind struct {
+	// Name uniquely identifies the kind of error on the wire.
+	Name string
+
+	// Encode returns true if the error is of this kind, filling in
+	// the fields of the BasicError that the kind needs to recreate it.
+	Encode func(error, *BasicError) bool
+
+	// Decode recreates the original error from the BasicError.
+	Decode func(*BasicError) error
+}
+
+var errorKindsLock sync.RWMutex
+var errorKinds []*ErrorKind
+
+// RegisterErrorKind registers a kind of error so that errors of that
+// kind are recreated on the other side of an RPC connection. Both sides
+// of the connection must register the kind. Kinds are checked in the
+// order they were registered, and registering a kind with a name that
+// is already registered replaces it.
+func RegisterErrorKind(kind *ErrorKind) {
+	errorKindsLock.Lock()
+	defer errorKindsLock.Unlock()
+
+	for i, existing := range errorKinds {
+		if existing.Name == kind.Name {
+			errorKinds[i] = kind
+			return
+		}
+	}
+
+	errorKinds = append(errorKinds, kind)
 }
 
 func NewBasicError(err error) *BasicError {
-	return &BasicError{err.Error()}
+	if err, ok := err.(*BasicError); ok {
+		return err
+	}
+
+	result := &BasicError{Message: err.Error()}
+
+	errorKindsLock.RLock()
+	defer errorKindsLock.RUnlock()
+
+	for _, kind := range errorKinds {
+		if kind.Encode(err, result) {
+			result.Kind = kind.Name
+			break
+		}
+	}
+
+	return result
 }
 
 func (e *BasicError) Error() string {
 	return e.Message
 }
+
+// decodeError turns an error received over RPC back into the kind of
+// error that was originally sent. Errors of unknown kinds are returned
+// as-is.
+func decodeError(err error) error {
+	e, ok := err.(*BasicError)
+	if !ok || e == nil || e.Kind == "" {
+		return err
+	}
+
+	errorKindsLock.RLock()
+	var decode func(*BasicError) error
+	for _, kind := range errorKinds {
+		if kind.Name == e.Kind {
+			decode = kind.Decode
+			break
+		}
+	}
+	errorKindsLock.RUnlock()
+
+	if decode == nil {
+		return err
+	}
+
+	return decode(e)
+}
+
+func init() {
+	RegisterErrorKind(&ErrorKind{
+		Name: "multi",
+		Encode: func(err error, e *BasicError) bool {
+			multi, ok := err.(*packer.MultiError)
+			if !ok {
+				return false
+			}
+
+			e.Children = make([]*BasicError, len(multi.Errors))
+			for i, child := range multi.Errors {
+				e.Children[i] = NewBasicError(child)
+			}
+
+			return true
+		},
+		Decode: func(e *BasicError) error {
+			errs := make([]error, len(e.Children))
+			for i, child := range e.Children {
+				errs[i] = decodeError(child)
+			}
+
+			return &packer.MultiError{Errors: errs}
+		},
+	})
+
+	RegisterErrorKind(&ErrorKind{
+		Name: "cancelled",
+		Encode: func(err error, e *BasicError) bool {
+			_, ok := err.(*packer.CancelledError)
+			return ok
+		},
+		Decode: func(e *BasicError) error {
+			return &packer.CancelledError{Message: e.Message}
+		},
+	})
+
+	RegisterErrorKind(&ErrorKind{
+		Name: "timeout",
+		Encode: func(err error, e *BasicError) bool {
+			_, ok := err.(*packer.TimeoutError)
+			return ok
+		},
+		Decode: func(e *BasicError) error {
+			return &packer.TimeoutError{Message: e.Message}
+		},
+	})
+
+	RegisterErrorKind(&ErrorKind{
+		Name: "validation",
+		Encode: func(err error, e *BasicError) bool {
+			verr, ok := err.(*packer.ValidationError)
+			if !ok {
+				return false
+			}
+
+			e.Key = verr.Key
+			return true
+		},
+		Decode: func(e *BasicError) error {
+			message := strings.TrimPrefix(e.Message, e.Key+": ")
+			return &packer.ValidationError{Key: e.Key, Message: message}
+		},
+	})
+}
@@ -3,6 +3,8 @@ package rpc
 import (
 	"cgl.tideland.biz/asserts"
 	"errors"
+	"github.com/mitchellh/packer/packer"
+	"reflect"
 	"testing"
 )
 
@@ -10,7 +12,7 @@ func TestBasicError_ImplementsError(t *testing.T) {
 	assert := asserts.NewTestingAsserts(t, true)
 
 	var r error
-	e := &BasicError{""}
+	e := &BasicError{Message: ""}
 
 	assert.Implementor(e, &r, "should be an error")
 }
@@ -23,3 +25,95 @@ func TestBasicError_MatchesMessage(t *testing.T) {
 
 	assert.Equal(wrapped.Error(), err.Error(), "should have the same error")
 }
+
+func TestBasicError_kinds(t *testing.T) {
+	cases := []error{
+		&packer.CancelledError{Message: "Build was cancelled."},
+		&packer.TimeoutError{Message: "Timeout waiting for SSH."},
+		&packer.ValidationError{Key: "ssh_port", Message: "must be a number"},
+		&packer.MultiError{Errors: []error{
+			&packer.ValidationError{Key: "iso_url", Message: "must be specified"},
+			&packer.MultiError{Errors: []error{&packer.TimeoutError{Message: "nested"}}},
+		}},
+	}
+
+	for _, expected := range cases {
+		wrapped := NewBasicError(expected)
+		if wrapped.Error() != expected.Error() {
+			t.Fatalf("bad message: %s", wrapped.Error())
+		}
+
+		actual := decodeError(wrapped)
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("bad: %#v", actual)
+		}
+	}
+}
+
+func TestBasicError_unknownKind(t *testing.T) {
+	err := &BasicError{Message: "foo", Kind: "unknown"}
+	if decodeError(err) != err {
+		t.Fatal("unknown kinds should decode to the BasicError")
+	}
+
+	plain := NewBasicError(errors.New("foo"))
+	if decodeError(plain) != plain {
+		t.Fatal("plain errors should decode to the BasicError")
+	}
+}
+
+func TestRegisterErrorKind(t *testing.T) {
+	type checksumError struct{ error }
+
+	RegisterErrorKind(&ErrorKind{
+		Name: "test-checksum",
+		Encode: func(err error, e *BasicError) bool {
+			_, ok := err.(*checksumError)
+			return ok
+		},
+		Decode: func(e *BasicError) error {
+			return &checksumError{errors.New(e.Message)}
+		},
+	})
+
+	actual := decodeError(NewBasicError(&checksumError{errors.New("bad checksum")}))
+	if _, ok := actual.(*checksumError); !ok {
+		t.Fatalf("bad: %#v", actual)
+	}
+
+	if actual.Error() != "bad checksum" {
+		t.Fatalf("bad: %s", actual.Error())
+	}
+}
+
+func TestHookRPC_errorKinds(t *testing.T) {
+	expected := &packer.MultiError{Errors: []error{
+		&packer.TimeoutError{Message: "Timeout waiting for SSH."},
+	}}
+
+	h := &packer.MockHook{RunFunc: func() error { return expected }}
+	client, server := testClientServer(t)
+	defer client.Close()
+	defer server.Close()
+	server.RegisterHook(h)
+
+	err := client.Hook().Run("foo", new(testUi), nil, nil)
+	if !reflect.DeepEqual(err, expected) {
+		t.Fatalf("bad: %#v", err)
+	}
+}
+
+func TestProvisionerRPC_errorKinds(t *testing.T) {
+	expected := &packer.CancelledError{Message: "cancelled"}
+	p := &packer.MockProvisioner{ProvFunc: func() error { return expected }}
+
+	client, server := testClientServer(t)
+	defer client.Close()
+	defer server.Close()
+	server.RegisterProvisioner(p)
+
+	err := client.Provisioner().Provision(new(testUi), new(packer.MockCommunicator))
+	if !reflect.DeepEqual(err, expected) {
+		t.Fatalf("bad: %#v", err)
+	}
+}
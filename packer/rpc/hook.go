@@ -10,32 +10,35 @@ import (
 // over an RPC connection.
 type hook struct {
 	client *rpc.Client
+	mux    *MuxConn
 }
 
 // HookServer wraps a packer.Hook implementation and makes it exportable
 // as part of a Golang RPC server.
 type HookServer struct {
 	hook packer.Hook
+	mux  *MuxConn
 }
 
 type HookRunArgs struct {
-	Name       string
-	Data       interface{}
-	RPCAddress string
-}
-
-func Hook(client *rpc.Client) *hook {
-	return &hook{client}
+	Name     string
+	Data     interface{}
+	StreamId uint32
 }
 
 func (h *hook) Run(name string, ui packer.Ui, comm packer.Communicator, data interface{}) error {
-	server := rpc.NewServer()
-	RegisterCommunicator(server, comm)
-	RegisterUi(server, ui)
-	address := serveSingleConn(server)
+	streamId := serveOnStream(h.mux, func(s *Server) {
+		s.RegisterCommunicator(comm)
+		s.RegisterUi(ui)
+	})
+
+	var err error
+	args := &HookRunArgs{name, data, streamId}
+	if cerr := h.client.Call("Hook.Run", args, &err); cerr != nil {
+		return cerr
+	}
 
-	args := &HookRunArgs{name, data, address}
-	return h.client.Call("Hook.Run", args, new(interface{}))
+	return decodeError(err)
 }
 
 func (h *hook) Cancel() {
@@ -45,17 +48,17 @@ func (h *hook) Cancel() {
 	}
 }
 
-func (h *HookServer) Run(args *HookRunArgs, reply *interface{}) error {
-	client, err := rpcDial(args.RPCAddress)
+func (h *HookServer) Run(args *HookRunArgs, reply *error) error {
+	client, err := newClientWithMux(h.mux, args.StreamId)
 	if err != nil {
-		return err
+		return NewBasicError(err)
 	}
+	defer client.Close()
 
-	if err := h.hook.Run(args.Name, &Ui{client}, Communicator(client), args.Data); err != nil {
-		return NewBasicError(err)
+	if err := h.hook.Run(args.Name, client.Ui(), client.Communicator(), args.Data); err != nil {
+		*reply = NewBasicError(err)
 	}
 
-	*reply = nil
 	return nil
 }
 
@@ -3,7 +3,6 @@ package rpc
 import (
 	"cgl.tideland.biz/asserts"
 	"github.com/mitchellh/packer/packer"
-	"net/rpc"
 	"reflect"
 	"sync"
 	"testing"
@@ -17,15 +16,14 @@ func TestHookRPC(t *testing.T) {
 	h := new(packer.MockHook)
 
 	// Serve
-	server := rpc.NewServer()
-	RegisterHook(server, h)
-	address := serveSingleConn(server)
+	client, server := testClientServer(t)
+	defer client.Close()
+	defer server.Close()
+	server.RegisterHook(h)
 
 	// Create the client over RPC and run some methods to verify it works
-	client, err := rpc.Dial("tcp", address)
-	assert.Nil(err, "should be able to connect")
 
-	hClient := Hook(client)
+	hClient := client.Hook()
 
 	// Test Run
 	ui := &testUi{}
@@ -41,7 +39,7 @@ func TestHook_Implements(t *testing.T) {
 	assert := asserts.NewTestingAsserts(t, true)
 
 	var r packer.Hook
-	h := &hook{nil}
+	h := new(hook)
 
 	assert.Implementor(h, &r, "should be a Hook")
 }
@@ -62,17 +60,14 @@ func TestHook_cancelWhileRun(t *testing.T) {
 	}
 
 	// Serve
-	server := rpc.NewServer()
-	RegisterHook(server, h)
-	address := serveSingleConn(server)
+	client, server := testClientServer(t)
+	defer client.Close()
+	defer server.Close()
+	server.RegisterHook(h)
 
 	// Create the client over RPC and run some methods to verify it works
-	client, err := rpc.Dial("tcp", address)
-	if err != nil {
-		t.Fatalf("err: %s", err)
-	}
 
-	hClient := Hook(client)
+	hClient := client.Hook()
 
 	// Start the run
 	finished := make(chan struct{})
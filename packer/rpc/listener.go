@@ -0,0 +1,84 @@
+package rpc
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+)
+
+// The prefix of addresses that refer to Unix domain sockets. Addresses
+// without this prefix are TCP addresses.
+const unixAddressPrefix = "unix:"
+
+var socketDir string
+var socketCount uint64
+
+// This sets the directory that the RPC stuff will create Unix domain
+// sockets in when creating new servers. This directory should only be
+// accessible by the current user. If this is empty, or Unix domain sockets
+// aren't supported on this platform, TCP listeners within the port range
+// set with PortRange are used instead.
+func SocketDir(dir string) {
+	socketDir = dir
+}
+
+// Listen returns a listener for a new RPC server. This is a Unix domain
+// socket within the directory set with SocketDir if possible, and
+// otherwise a TCP listener on the local host within the port range.
+func Listen() (net.Listener, error) {
+	if socketDir != "" && runtime.GOOS != "windows" {
+		n := atomic.AddUint64(&socketCount, 1)
+		path := filepath.Join(socketDir, fmt.Sprintf("%d-%d.sock", os.Getpid(), n))
+		l, err := net.Listen("unix", path)
+		if err == nil {
+			return l, nil
+		}
+
+		log.Printf("Error listening on Unix socket, falling back to TCP: %s", err)
+	}
+
+	l := netListenerInRange(portRangeMin, portRangeMax)
+	if l == nil {
+		return nil, fmt.Errorf(
+			"couldn't find an open port between %d and %d", portRangeMin, portRangeMax)
+	}
+
+	return l, nil
+}
+
+// Address returns the address of a listener created with Listen in
+// the form that can be given to Dial.
+func Address(l net.Listener) string {
+	addr := l.Addr()
+	if addr.Network() == "unix" {
+		return unixAddressPrefix + addr.String()
+	}
+
+	return addr.String()
+}
+
+// Dial connects to an address returned by Address.
+func Dial(address string) (net.Conn, error) {
+	network := "tcp"
+	if strings.HasPrefix(address, unixAddressPrefix) {
+		network = "unix"
+		address = address[len(unixAddressPrefix):]
+	}
+
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set a keep-alive so that the connection stays alive even when idle
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetKeepAlive(true)
+	}
+
+	return conn, nil
+}
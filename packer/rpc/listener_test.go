@@ -0,0 +1,86 @@
+package rpc
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestListen_tcp(t *testing.T) {
+	l, err := Listen()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer l.Close()
+
+	if strings.HasPrefix(Address(l), unixAddressPrefix) {
+		t.Fatalf("should be TCP: %s", Address(l))
+	}
+}
+
+func TestListen_unix(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Unix domain sockets aren't used on Windows")
+	}
+
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	SocketDir(td)
+	defer SocketDir("")
+
+	l, err := Listen()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer l.Close()
+
+	address := Address(l)
+	if !strings.HasPrefix(address, unixAddressPrefix+td) {
+		t.Fatalf("bad address: %s", address)
+	}
+
+	// Serve a Ui over the socket and make sure we can talk to it
+	ui := new(testUi)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		server, err := NewServer(conn, testSecret)
+		if err != nil {
+			return
+		}
+
+		server.RegisterUi(ui)
+		server.Serve()
+	}()
+
+	conn, err := Dial(address)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	client, err := NewClient(conn, testSecret)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer client.Close()
+
+	client.Ui().Say("hello")
+	if !ui.sayCalled || ui.sayMessage != "hello" {
+		t.Fatalf("bad: %#v", ui)
+	}
+}
+
+func TestDial_noExist(t *testing.T) {
+	if _, err := Dial(unixAddressPrefix + "/i/should/not/exist"); err == nil {
+		t.Fatal("should have error")
+	}
+}
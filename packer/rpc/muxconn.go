@@ -0,0 +1,524 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// The maximum amount of data that is sent in a single packet. Packets
+// with more data are rejected, closing the connection, so that the other
+// side can't make us allocate an arbitrary amount of memory.
+const muxMaxPacketData = 32 * 1024
+
+// The amount of data that can be sent on a stream before the other side
+// has read it. Writes block once this much data is unread, so that a
+// fast writer can't make the reader buffer an unbounded amount of data.
+const muxWindowSize = 1024 * 1024
+
+type muxPacketType byte
+
+const (
+	muxPacketSyn muxPacketType = iota
+	muxPacketData
+	muxPacketFin
+	muxPacketWindow
+	muxPacketReset
+)
+
+// MuxConn is able to multiplex multiple streams on top of any
+// io.ReadWriteCloser. These streams act like TCP connections (Dial,
+// Accept, Close, full duplex, etc.).
+//
+// Streams are identified by a uint32 ID. One side of the connection
+// creates a stream with Accept and the other side connects to it with
+// Dial. So that both sides can choose new stream IDs with NextId without
+// coordinating, the client side of the connection uses even IDs and the
+// server side uses odd IDs. Stream 0 is reserved for the RPC server
+// that is served over the connection.
+type MuxConn struct {
+	rwc     io.ReadWriteCloser
+	curId   uint32
+	streams map[uint32]*Stream
+	closed  bool
+	l       sync.Mutex
+	wlock   sync.Mutex
+}
+
+// newMuxConn creates a MuxConn on top of the given connection. client
+// must be true on one side of the connection and false on the other.
+func newMuxConn(rwc io.ReadWriteCloser, client bool) *MuxConn {
+	m := &MuxConn{
+		rwc:     rwc,
+		streams: make(map[uint32]*Stream),
+	}
+
+	if !client {
+		m.curId = 1
+	}
+
+	go m.loop()
+	return m
+}
+
+// Accept waits for the other side of the connection to Dial the stream
+// with the given ID and returns it.
+func (m *MuxConn) Accept(id uint32) (*Stream, error) {
+	s, err := m.openStream(id)
+	if err != nil {
+		return nil, err
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	if s.accepted {
+		return nil, fmt.Errorf("Stream %d already accepted", id)
+	}
+
+	for !s.opened && !s.muxClosed && !s.cancelled {
+		s.cond.Wait()
+	}
+
+	if s.cancelled {
+		m.removeStream(id)
+		return nil, fmt.Errorf("Stream %d was not dialed", id)
+	}
+
+	if !s.opened {
+		return nil, errors.New("Connection closed before stream was dialed")
+	}
+
+	s.accepted = true
+	return s, nil
+}
+
+// Dial connects to the stream with the given ID, which the other side
+// of the connection should Accept.
+func (m *MuxConn) Dial(id uint32) (*Stream, error) {
+	s, err := m.openStream(id)
+	if err != nil {
+		return nil, err
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	if s.opened || s.dialed {
+		return nil, fmt.Errorf("Stream %d already open", id)
+	}
+
+	if err := m.write(id, muxPacketSyn, nil); err != nil {
+		return nil, err
+	}
+
+	s.dialed = true
+	return s, nil
+}
+
+// cancelAccept makes a pending or future Accept of the stream with the
+// given ID return an error if the other side hasn't dialed it yet. This
+// is used once the other side is known to never dial the stream, such
+// as when the RPC call that would have dialed it returned.
+func (m *MuxConn) cancelAccept(id uint32) {
+	s, err := m.openStream(id)
+	if err != nil {
+		return
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+	if !s.opened {
+		s.cancelled = true
+		s.cond.Broadcast()
+	}
+}
+
+// NextId returns an ID for a new stream that is not in use by either
+// side of the connection.
+func (m *MuxConn) NextId() uint32 {
+	return atomic.AddUint32(&m.curId, 2)
+}
+
+// Close closes the underlying connection and all of the streams.
+func (m *MuxConn) Close() error {
+	m.l.Lock()
+	if m.closed {
+		m.l.Unlock()
+		return nil
+	}
+
+	m.closed = true
+	streams := m.streams
+	m.streams = make(map[uint32]*Stream)
+	m.l.Unlock()
+
+	// The streams are notified without holding our lock since closing
+	// a stream removes it from the connection.
+	for _, s := range streams {
+		s.setMuxClosed()
+	}
+
+	return m.rwc.Close()
+}
+
+// openStream returns the stream with the given ID, creating it if
+// it doesn't exist yet.
+func (m *MuxConn) openStream(id uint32) (*Stream, error) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	if m.closed {
+		return nil, errors.New("Connection closed")
+	}
+
+	if s, ok := m.streams[id]; ok {
+		return s, nil
+	}
+
+	s := &Stream{id: id, mux: m, sendWindow: muxWindowSize}
+	s.cond = sync.NewCond(&s.l)
+	m.streams[id] = s
+	return s, nil
+}
+
+func (m *MuxConn) isClosed() bool {
+	m.l.Lock()
+	defer m.l.Unlock()
+	return m.closed
+}
+
+func (m *MuxConn) removeStream(id uint32) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	delete(m.streams, id)
+}
+
+// loop reads packets from the underlying connection and dispatches
+// them to the streams until the connection is closed.
+func (m *MuxConn) loop() {
+	defer m.Close()
+
+	var header [9]byte
+	for {
+		if _, err := io.ReadFull(m.rwc, header[:]); err != nil {
+			if err != io.EOF && !m.isClosed() {
+				log.Printf("[ERR] Error reading from muxconn: %s", err)
+			}
+
+			return
+		}
+
+		id := binary.BigEndian.Uint32(header[0:4])
+		packetType := muxPacketType(header[4])
+		length := binary.BigEndian.Uint32(header[5:9])
+
+		if length > muxMaxPacketData {
+			log.Printf("[ERR] Muxconn packet too large: %d bytes", length)
+			return
+		}
+
+		var data []byte
+		if length > 0 {
+			data = make([]byte, length)
+			if _, err := io.ReadFull(m.rwc, data); err != nil {
+				log.Printf("[ERR] Error reading from muxconn: %s", err)
+				return
+			}
+		}
+
+		// Only a SYN creates a stream. Any other packets for streams
+		// we don't know about are for streams that were already closed.
+		var s *Stream
+		if packetType == muxPacketSyn {
+			var err error
+			if s, err = m.openStream(id); err != nil {
+				return
+			}
+		} else {
+			m.l.Lock()
+			s = m.streams[id]
+			m.l.Unlock()
+
+			if s == nil {
+				continue
+			}
+		}
+
+		switch packetType {
+		case muxPacketSyn:
+			s.setOpened()
+		case muxPacketData:
+			s.push(data)
+		case muxPacketFin:
+			s.setRemoteClosed()
+		case muxPacketWindow:
+			if len(data) == 4 {
+				s.growWindow(binary.BigEndian.Uint32(data))
+			}
+		case muxPacketReset:
+			s.setReset(string(data))
+		default:
+			log.Printf("[ERR] Unknown muxconn packet type: %d", packetType)
+		}
+	}
+}
+
+// write writes a single packet to the underlying connection.
+func (m *MuxConn) write(id uint32, packetType muxPacketType, data []byte) error {
+	var header [9]byte
+	binary.BigEndian.PutUint32(header[0:4], id)
+	header[4] = byte(packetType)
+	binary.BigEndian.PutUint32(header[5:9], uint32(len(data)))
+
+	m.wlock.Lock()
+	defer m.wlock.Unlock()
+
+	if _, err := m.rwc.Write(header[:]); err != nil {
+		return err
+	}
+
+	if len(data) > 0 {
+		if _, err := m.rwc.Write(data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Stream is a single stream of data within a MuxConn. It is created
+// with MuxConn.Accept or MuxConn.Dial.
+//
+// Streams are flow controlled: a Write blocks while the other side has
+// muxWindowSize bytes of unread data, until the other side reads it or
+// the stream is closed.
+type Stream struct {
+	id   uint32
+	mux  *MuxConn
+	l    sync.Mutex
+	cond *sync.Cond
+	buf  bytes.Buffer
+
+	// sendWindow is how much more we can write before the other side
+	// reads. unacked is how much we read that we haven't told the other
+	// side about yet.
+	sendWindow uint32
+	unacked    uint32
+
+	accepted     bool
+	dialed       bool
+	opened       bool
+	localClosed  bool
+	remoteClosed bool
+	muxClosed    bool
+	cancelled    bool
+	resetErr     error
+}
+
+func (s *Stream) Read(p []byte) (int, error) {
+	s.l.Lock()
+
+	for s.buf.Len() == 0 && !s.remoteClosed && !s.localClosed && !s.muxClosed {
+		s.cond.Wait()
+	}
+
+	if s.localClosed {
+		s.l.Unlock()
+		return 0, errors.New("Stream closed")
+	}
+
+	if s.resetErr != nil {
+		s.l.Unlock()
+		return 0, s.resetErr
+	}
+
+	if s.buf.Len() == 0 {
+		s.l.Unlock()
+		return 0, io.EOF
+	}
+
+	n, err := s.buf.Read(p)
+
+	// Let the other side know that it can send more once we've read a
+	// good part of the window, rather than for every read.
+	var ack uint32
+	s.unacked += uint32(n)
+	if s.unacked >= muxWindowSize/2 && !s.remoteClosed {
+		ack = s.unacked
+		s.unacked = 0
+	}
+	s.l.Unlock()
+
+	if ack > 0 {
+		var data [4]byte
+		binary.BigEndian.PutUint32(data[:], ack)
+		if err := s.mux.write(s.id, muxPacketWindow, data[:]); err != nil {
+			log.Printf("[ERR] Error updating window of stream %d: %s", s.id, err)
+		}
+	}
+
+	return n, err
+}
+
+func (s *Stream) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		s.l.Lock()
+		for s.sendWindow == 0 && !s.isClosed() {
+			s.cond.Wait()
+		}
+
+		if s.isClosed() {
+			err := s.resetErr
+			s.l.Unlock()
+
+			if err == nil {
+				err = errors.New("Stream closed")
+			}
+
+			return n, err
+		}
+
+		chunk := p
+		if len(chunk) > muxMaxPacketData {
+			chunk = chunk[:muxMaxPacketData]
+		}
+
+		if uint32(len(chunk)) > s.sendWindow {
+			chunk = chunk[:s.sendWindow]
+		}
+
+		s.sendWindow -= uint32(len(chunk))
+		s.l.Unlock()
+
+		if err := s.mux.write(s.id, muxPacketData, chunk); err != nil {
+			return n, err
+		}
+
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+
+	return n, nil
+}
+
+// Close closes the stream. The other side of the stream will read EOF
+// once it has read any data that was already sent.
+func (s *Stream) Close() error {
+	return s.close(muxPacketFin, nil)
+}
+
+// CloseWithError closes the stream, aborting it. Rather than EOF, the
+// other side of the stream gets an error with the given error's message
+// from its next Read or Write, and any data it hasn't read yet is
+// discarded. This is used to tell the other side that the data it read
+// is incomplete.
+func (s *Stream) CloseWithError(err error) error {
+	message := []byte(err.Error())
+	if len(message) > muxMaxPacketData {
+		message = message[:muxMaxPacketData]
+	}
+
+	return s.close(muxPacketReset, message)
+}
+
+func (s *Stream) close(packetType muxPacketType, data []byte) error {
+	s.l.Lock()
+	if s.localClosed {
+		s.l.Unlock()
+		return nil
+	}
+
+	s.localClosed = true
+	s.buf.Reset()
+	s.cond.Broadcast()
+
+	// If the other side already closed the stream, it is done. Otherwise
+	// it is removed once the other side closes it too.
+	muxClosed := s.muxClosed
+	if s.remoteClosed {
+		s.mux.removeStream(s.id)
+	}
+
+	// The packet is sent without holding the lock, since the write can
+	// block until the read loop, which needs the lock, makes progress.
+	s.l.Unlock()
+
+	if muxClosed {
+		return nil
+	}
+
+	return s.mux.write(s.id, packetType, data)
+}
+
+// isClosed returns true if the stream can no longer be written to. The
+// lock must be held.
+func (s *Stream) isClosed() bool {
+	return s.localClosed || s.remoteClosed || s.muxClosed
+}
+
+func (s *Stream) growWindow(n uint32) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.sendWindow += n
+	s.cond.Broadcast()
+}
+
+func (s *Stream) push(data []byte) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	// If we've closed our side, nobody is going to read this
+	if s.localClosed {
+		return
+	}
+
+	s.buf.Write(data)
+	s.cond.Broadcast()
+}
+
+func (s *Stream) setOpened() {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.opened = true
+	s.cond.Broadcast()
+}
+
+func (s *Stream) setRemoteClosed() {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.remoteClosed = true
+	s.cond.Broadcast()
+
+	if s.localClosed {
+		s.mux.removeStream(s.id)
+	}
+}
+
+func (s *Stream) setReset(message string) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.resetErr = fmt.Errorf("Stream aborted by other side: %s", message)
+	s.remoteClosed = true
+	s.buf.Reset()
+	s.cond.Broadcast()
+
+	if s.localClosed {
+		s.mux.removeStream(s.id)
+	}
+}
+
+func (s *Stream) setMuxClosed() {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.muxClosed = true
+	s.cond.Broadcast()
+}
@@ -0,0 +1,351 @@
+package rpc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testMux(t *testing.T) (client *MuxConn, server *MuxConn) {
+	clientConn, serverConn := testConn(t)
+	client = newMuxConn(clientConn, true)
+	server = newMuxConn(serverConn, false)
+	return
+}
+
+func TestMuxConn(t *testing.T) {
+	client, server := testMux(t)
+	defer client.Close()
+	defer server.Close()
+
+	// The server side reports its errors here, and closes it when done
+	errCh := make(chan error, 2)
+
+	go func() {
+		defer close(errCh)
+
+		s0, err := server.Accept(0)
+		if err != nil {
+			errCh <- err
+			return
+		}
+
+		s1, err := server.Dial(1)
+		if err != nil {
+			errCh <- err
+			return
+		}
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			defer s0.Close()
+			errCh <- expectStream(s0, "hello")
+		}()
+
+		go func() {
+			defer wg.Done()
+			defer s1.Close()
+			errCh <- expectStream(s1, "world")
+		}()
+
+		wg.Wait()
+	}()
+
+	s0, err := client.Dial(0)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	s1, err := client.Accept(1)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := s0.Write([]byte("hello")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := s1.Write([]byte("world")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	s0.Close()
+	s1.Close()
+
+	for err := range errCh {
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+}
+
+func TestMuxConn_largeWrite(t *testing.T) {
+	client, server := testMux(t)
+	defer client.Close()
+	defer server.Close()
+
+	data := bytes.Repeat([]byte("a"), muxMaxPacketData*3+7)
+	errCh := make(chan error, 1)
+	go func() {
+		s, err := client.Dial(0)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		defer s.Close()
+
+		_, err = s.Write(data)
+		errCh <- err
+	}()
+
+	s, err := server.Accept(0)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer s.Close()
+
+	result, err := ioutil.ReadAll(s)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !bytes.Equal(result, data) {
+		t.Fatalf("bad length: %d", len(result))
+	}
+
+	if err := <-errCh; err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestMuxConn_backpressure(t *testing.T) {
+	client, server := testMux(t)
+	defer client.Close()
+	defer server.Close()
+
+	dialCh := make(chan error, 1)
+	written := make(chan int, 1)
+	go func() {
+		s, err := client.Dial(0)
+		dialCh <- err
+		if err != nil {
+			return
+		}
+
+		// This blocks once the window is full, since nothing reads
+		n, _ := s.Write(make([]byte, muxWindowSize*2))
+		written <- n
+	}()
+
+	s, err := server.Accept(0)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := <-dialCh; err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	select {
+	case n := <-written:
+		t.Fatalf("write should block, wrote: %d", n)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Reading lets the write finish
+	if _, err := io.ReadFull(s, make([]byte, muxWindowSize*2)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if n := <-written; n != muxWindowSize*2 {
+		t.Fatalf("bad: %d", n)
+	}
+}
+
+func TestMuxConn_closeWithError(t *testing.T) {
+	client, server := testMux(t)
+	defer client.Close()
+	defer server.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		s, err := client.Dial(0)
+		if err != nil {
+			errCh <- err
+			return
+		}
+
+		s.Write([]byte("partial"))
+		errCh <- s.CloseWithError(errors.New("failed"))
+	}()
+
+	s, err := server.Accept(0)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	_, err = ioutil.ReadAll(s)
+	if err == nil || !strings.Contains(err.Error(), "failed") {
+		t.Fatalf("bad: %s", err)
+	}
+
+	if err := <-errCh; err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestMuxConn_closeUnblocksWrite(t *testing.T) {
+	client, server := testMux(t)
+	defer client.Close()
+	defer server.Close()
+
+	dialCh := make(chan error, 1)
+	errCh := make(chan error, 1)
+	go func() {
+		s, err := client.Dial(0)
+		dialCh <- err
+		if err != nil {
+			return
+		}
+
+		_, err = s.Write(make([]byte, muxWindowSize*2))
+		errCh <- err
+	}()
+
+	s, err := server.Accept(0)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := <-dialCh; err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Closing the reading side cancels the blocked write
+	s.Close()
+	if err := <-errCh; err == nil {
+		t.Fatal("write should fail")
+	}
+}
+
+func TestMuxConn_clientClosesStreams(t *testing.T) {
+	client, server := testMux(t)
+	defer server.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := client.Dial(0)
+		client.Close()
+		errCh <- err
+	}()
+
+	s, err := server.Accept(0)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := <-errCh; err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := s.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("err should be EOF: %s", err)
+	}
+}
+
+func TestMuxConn_acceptClosed(t *testing.T) {
+	client, server := testMux(t)
+	defer server.Close()
+
+	client.Close()
+	if _, err := client.Accept(1); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestMuxConn_cancelAccept(t *testing.T) {
+	client, server := testMux(t)
+	defer client.Close()
+	defer server.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := client.Accept(2)
+		errCh <- err
+	}()
+
+	client.cancelAccept(2)
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Fatal("should have error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("accept should not block")
+	}
+
+	// Cancelling before accepting works too
+	client.cancelAccept(4)
+	if _, err := client.Accept(4); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestMuxConn_packetTooLarge(t *testing.T) {
+	clientConn, serverConn := testConn(t)
+	defer clientConn.Close()
+
+	server := newMuxConn(serverConn, false)
+	defer server.Close()
+
+	// A header claiming 4 GiB of data closes the connection
+	header := []byte{0, 0, 0, 1, byte(muxPacketData), 0xff, 0xff, 0xff, 0xff}
+	if _, err := clientConn.Write(header); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	clientConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := clientConn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("connection should be closed: %s", err)
+	}
+}
+
+func TestMuxConnNextId(t *testing.T) {
+	client, server := testMux(t)
+	defer client.Close()
+	defer server.Close()
+
+	a, b := client.NextId(), client.NextId()
+	if a%2 != 0 || b%2 != 0 || a == b {
+		t.Fatalf("bad client ids: %d %d", a, b)
+	}
+
+	a, b = server.NextId(), server.NextId()
+	if a%2 != 1 || b%2 != 1 || a == b {
+		t.Fatalf("bad server ids: %d %d", a, b)
+	}
+}
+
+// expectStream reads the stream to the end and returns an error if it
+// didn't contain the expected data.
+func expectStream(s io.Reader, expected string) error {
+	var data bytes.Buffer
+	if _, err := io.Copy(&data, s); err != nil {
+		return err
+	}
+
+	if data.String() != expected {
+		return fmt.Errorf("bad: %#v", data.String())
+	}
+
+	return nil
+}
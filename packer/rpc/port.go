@@ -9,8 +9,8 @@ var portRangeMin int = 10000
 var portRangeMax int = 11000
 
 // This sets the port range that the RPC stuff will use when creating
-// new temporary servers. Some RPC calls require the creation of temporary
-// RPC servers. These allow you to pick a range these bind to.
+// new listeners with Listen. This range is only used if Unix domain
+// sockets can't be used. See SocketDir.
 func PortRange(min, max int) {
 	portRangeMin = min
 	portRangeMax = max
@@ -9,12 +9,14 @@ import (
 // executed over an RPC connection.
 type postProcessor struct {
 	client *rpc.Client
+	mux    *MuxConn
 }
 
 // PostProcessorServer wraps a packer.PostProcessor implementation and makes it
 // exportable as part of a Golang RPC server.
 type PostProcessorServer struct {
-	p packer.PostProcessor
+	p   packer.PostProcessor
+	mux *MuxConn
 }
 
 type PostProcessorConfigureArgs struct {
@@ -22,47 +24,45 @@ type PostProcessorConfigureArgs struct {
 }
 
 type PostProcessorProcessResponse struct {
-	Err        error
-	Keep       bool
-	RPCAddress string
+	Err      error
+	Keep     bool
+	StreamId uint32
 }
 
-func PostProcessor(client *rpc.Client) *postProcessor {
-	return &postProcessor{client}
-}
 func (p *postProcessor) Configure(raw ...interface{}) (err error) {
 	args := &PostProcessorConfigureArgs{Configs: raw}
 	if cerr := p.client.Call("PostProcessor.Configure", args, &err); cerr != nil {
-		err = cerr
+		return cerr
 	}
 
-	return
+	return decodeError(err)
 }
 
 func (p *postProcessor) PostProcess(ui packer.Ui, a packer.Artifact) (packer.Artifact, bool, error) {
-	server := rpc.NewServer()
-	RegisterArtifact(server, a)
-	RegisterUi(server, ui)
+	streamId := serveOnStream(p.mux, func(s *Server) {
+		s.RegisterArtifact(a)
+		s.RegisterUi(ui)
+	})
 
 	var response PostProcessorProcessResponse
-	if err := p.client.Call("PostProcessor.PostProcess", serveSingleConn(server), &response); err != nil {
+	if err := p.client.Call("PostProcessor.PostProcess", streamId, &response); err != nil {
 		return nil, false, err
 	}
 
 	if response.Err != nil {
-		return nil, false, response.Err
+		return nil, false, decodeError(response.Err)
 	}
 
-	if response.RPCAddress == "" {
+	if response.StreamId == 0 {
 		return nil, false, nil
 	}
 
-	client, err := rpcDial(response.RPCAddress)
+	client, err := newClientWithMux(p.mux, response.StreamId)
 	if err != nil {
 		return nil, false, err
 	}
 
-	return Artifact(client), response.Keep, nil
+	return client.Artifact(), response.Keep, nil
 }
 
 func (p *PostProcessorServer) Configure(args *PostProcessorConfigureArgs, reply *error) error {
@@ -74,19 +74,19 @@ func (p *PostProcessorServer) Configure(args *PostProcessorConfigureArgs, reply
 	return nil
 }
 
-func (p *PostProcessorServer) PostProcess(address string, reply *PostProcessorProcessResponse) error {
-	client, err := rpcDial(address)
+func (p *PostProcessorServer) PostProcess(streamId uint32, reply *PostProcessorProcessResponse) error {
+	client, err := newClientWithMux(p.mux, streamId)
 	if err != nil {
-		return err
+		return NewBasicError(err)
 	}
 
-	responseAddress := ""
+	var responseId uint32
 
-	artifact, keep, err := p.p.PostProcess(&Ui{client}, Artifact(client))
+	artifact, keep, err := p.p.PostProcess(client.Ui(), client.Artifact())
 	if err == nil && artifact != nil {
-		server := rpc.NewServer()
-		RegisterArtifact(server, artifact)
-		responseAddress = serveSingleConn(server)
+		responseId = serveOnStream(p.mux, func(s *Server) {
+			s.RegisterArtifact(artifact)
+		})
 	}
 
 	if err != nil {
@@ -94,9 +94,9 @@ func (p *PostProcessorServer) PostProcess(address string, reply *PostProcessorPr
 	}
 
 	*reply = PostProcessorProcessResponse{
-		Err:        err,
-		Keep:       keep,
-		RPCAddress: responseAddress,
+		Err:      err,
+		Keep:     keep,
+		StreamId: responseId,
 	}
 
 	return nil
@@ -2,7 +2,6 @@ package rpc
 
 import (
 	"github.com/mitchellh/packer/packer"
-	"net/rpc"
 	"reflect"
 	"testing"
 )
@@ -35,20 +34,16 @@ func TestPostProcessorRPC(t *testing.T) {
 	p := new(TestPostProcessor)
 
 	// Start the server
-	server := rpc.NewServer()
-	RegisterPostProcessor(server, p)
-	address := serveSingleConn(server)
+	client, server := testClientServer(t)
+	defer client.Close()
+	defer server.Close()
+	server.RegisterPostProcessor(p)
 
 	// Create the client over RPC and run some methods to verify it works
-	client, err := rpc.Dial("tcp", address)
-	if err != nil {
-		t.Fatalf("Error connecting to rpc: %s", err)
-	}
-
 	// Test Configure
 	config := 42
-	pClient := PostProcessor(client)
-	err = pClient.Configure(config)
+	pClient := client.PostProcessor()
+	err := pClient.Configure(config)
 	if err != nil {
 		t.Fatalf("error: %s", err)
 	}
@@ -84,7 +79,7 @@ func TestPostProcessorRPC(t *testing.T) {
 
 func TestPostProcessor_Implements(t *testing.T) {
 	var raw interface{}
-	raw = PostProcessor(nil)
+	raw = new(postProcessor)
 	if _, ok := raw.(packer.PostProcessor); !ok {
 		t.Fatal("not a postprocessor")
 	}
@@ -10,42 +10,41 @@ import (
 // executed over an RPC connection.
 type provisioner struct {
 	client *rpc.Client
+	mux    *MuxConn
 }
 
 // ProvisionerServer wraps a packer.Provisioner implementation and makes it
 // exportable as part of a Golang RPC server.
 type ProvisionerServer struct {
-	p packer.Provisioner
+	p   packer.Provisioner
+	mux *MuxConn
 }
 
 type ProvisionerPrepareArgs struct {
 	Configs []interface{}
 }
 
-type ProvisionerProvisionArgs struct {
-	RPCAddress string
-}
-
-func Provisioner(client *rpc.Client) *provisioner {
-	return &provisioner{client}
-}
 func (p *provisioner) Prepare(configs ...interface{}) (err error) {
 	args := &ProvisionerPrepareArgs{configs}
 	if cerr := p.client.Call("Provisioner.Prepare", args, &err); cerr != nil {
-		err = cerr
+		return cerr
 	}
 
-	return
+	return decodeError(err)
 }
 
 func (p *provisioner) Provision(ui packer.Ui, comm packer.Communicator) error {
-	// TODO: Error handling
-	server := rpc.NewServer()
-	RegisterCommunicator(server, comm)
-	RegisterUi(server, ui)
+	streamId := serveOnStream(p.mux, func(s *Server) {
+		s.RegisterCommunicator(comm)
+		s.RegisterUi(ui)
+	})
+
+	var err error
+	if cerr := p.client.Call("Provisioner.Provision", streamId, &err); cerr != nil {
+		return cerr
+	}
 
-	args := &ProvisionerProvisionArgs{serveSingleConn(server)}
-	return p.client.Call("Provisioner.Provision", args, new(interface{}))
+	return decodeError(err)
 }
 
 func (p *provisioner) Cancel() {
@@ -64,17 +63,15 @@ func (p *ProvisionerServer) Prepare(args *ProvisionerPrepareArgs, reply *error)
 	return nil
 }
 
-func (p *ProvisionerServer) Provision(args *ProvisionerProvisionArgs, reply *interface{}) error {
-	client, err := rpcDial(args.RPCAddress)
+func (p *ProvisionerServer) Provision(streamId uint32, reply *error) error {
+	client, err := newClientWithMux(p.mux, streamId)
 	if err != nil {
-		return err
+		return NewBasicError(err)
 	}
+	defer client.Close()
 
-	comm := Communicator(client)
-	ui := &Ui{client}
-
-	if err := p.p.Provision(ui, comm); err != nil {
-		return NewBasicError(err)
+	if err := p.p.Provision(client.Ui(), client.Communicator()); err != nil {
+		*reply = NewBasicError(err)
 	}
 
 	return nil
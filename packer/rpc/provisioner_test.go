@@ -3,7 +3,6 @@ package rpc
 import (
 	"cgl.tideland.biz/asserts"
 	"github.com/mitchellh/packer/packer"
-	"net/rpc"
 	"testing"
 )
 
@@ -12,19 +11,23 @@ func TestProvisionerRPC(t *testing.T) {
 
 	// Create the interface to test
 	p := new(packer.MockProvisioner)
+	p.ProvFunc = func() error {
+		// The UI only works while provisioning
+		p.ProvUi.Say("foo")
+		return nil
+	}
 
 	// Start the server
-	server := rpc.NewServer()
-	RegisterProvisioner(server, p)
-	address := serveSingleConn(server)
+	client, server := testClientServer(t)
+	defer client.Close()
+	defer server.Close()
+	server.RegisterProvisioner(p)
 
 	// Create the client over RPC and run some methods to verify it works
-	client, err := rpc.Dial("tcp", address)
-	assert.Nil(err, "should be able to connect")
 
 	// Test Prepare
 	config := 42
-	pClient := Provisioner(client)
+	pClient := client.Provisioner()
 	pClient.Prepare(config)
 	assert.True(p.PrepCalled, "prepare should be called")
 	assert.Equal(p.PrepConfigs, []interface{}{42}, "prepare should be called with right arg")
@@ -34,8 +37,6 @@ func TestProvisionerRPC(t *testing.T) {
 	comm := &packer.MockCommunicator{}
 	pClient.Provision(ui, comm)
 	assert.True(p.ProvCalled, "provision should be called")
-
-	p.ProvUi.Say("foo")
 	assert.True(ui.sayCalled, "say should be called")
 
 	// Test Cancel
@@ -49,7 +50,7 @@ func TestProvisioner_Implements(t *testing.T) {
 	assert := asserts.NewTestingAsserts(t, true)
 
 	var r packer.Provisioner
-	p := Provisioner(nil)
+	p := new(provisioner)
 
 	assert.Implementor(p, &r, "should be a provisioner")
 }
@@ -2,87 +2,174 @@ package rpc
 
 import (
 	"github.com/mitchellh/packer/packer"
+	"io"
+	"log"
 	"net/rpc"
 )
 
+// Server represents an RPC server for Packer. This must be paired on
+// the other side with a Client.
+//
+// Any objects that are sent across the connection, such as the Ui
+// given to a Builder, are served on their own streams within the same
+// connection, so they are covered by the authentication of the
+// connection as well.
+type Server struct {
+	mux      *MuxConn
+	streamId uint32
+	server   *rpc.Server
+	closeMux bool
+}
+
+// NewServer returns a new Packer RPC server that serves over the given
+// connection. The client on the other end of the connection must
+// authenticate with the given secret before anything is served, and the
+// connection is closed if it doesn't.
+func NewServer(conn io.ReadWriteCloser, secret string) (*Server, error) {
+	if err := authenticateServer(conn, secret); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	result := newServerWithMux(newMuxConn(conn, false), 0)
+	result.closeMux = true
+	return result, nil
+}
+
+func newServerWithMux(mux *MuxConn, streamId uint32) *Server {
+	return &Server{
+		mux:      mux,
+		streamId: streamId,
+		server:   rpc.NewServer(),
+	}
+}
+
+// Close closes the server and, if it was created with NewServer, the
+// underlying connection.
+func (s *Server) Close() error {
+	if s.closeMux {
+		return s.mux.Close()
+	}
+
+	return nil
+}
+
 // Registers the appropriate endpoint on an RPC server to serve an
 // Artifact.
-func RegisterArtifact(s *rpc.Server, a packer.Artifact) {
-	s.RegisterName("Artifact", &ArtifactServer{a})
+func (s *Server) RegisterArtifact(a packer.Artifact) {
+	s.server.RegisterName("Artifact", &ArtifactServer{
+		artifact: a,
+	})
 }
 
 // Registers the appropriate endpoint on an RPC server to serve a
 // Packer Build.
-func RegisterBuild(s *rpc.Server, b packer.Build) {
-	s.RegisterName("Build", &BuildServer{b})
+func (s *Server) RegisterBuild(b packer.Build) {
+	s.server.RegisterName("Build", &BuildServer{
+		build: b,
+		mux:   s.mux,
+	})
 }
 
 // Registers the appropriate endpoint on an RPC server to serve a
 // Packer Builder.
-func RegisterBuilder(s *rpc.Server, b packer.Builder) {
-	s.RegisterName("Builder", &BuilderServer{b})
+func (s *Server) RegisterBuilder(b packer.Builder) {
+	s.server.RegisterName("Builder", &BuilderServer{
+		builder: b,
+		mux:     s.mux,
+	})
 }
 
 // Registers the appropriate endpoint on an RPC server to serve a
 // Packer Cache.
-func RegisterCache(s *rpc.Server, c packer.Cache) {
-	s.RegisterName("Cache", &CacheServer{c})
+func (s *Server) RegisterCache(c packer.Cache) {
+	s.server.RegisterName("Cache", &CacheServer{
+		cache: c,
+	})
 }
 
 // Registers the appropriate endpoint on an RPC server to serve a
 // Packer Command.
-func RegisterCommand(s *rpc.Server, c packer.Command) {
-	s.RegisterName("Command", &CommandServer{c})
+func (s *Server) RegisterCommand(c packer.Command) {
+	s.server.RegisterName("Command", &CommandServer{
+		command: c,
+		mux:     s.mux,
+	})
 }
 
 // Registers the appropriate endpoint on an RPC server to serve a
 // Packer Communicator.
-func RegisterCommunicator(s *rpc.Server, c packer.Communicator) {
-	s.RegisterName("Communicator", &CommunicatorServer{c})
+func (s *Server) RegisterCommunicator(c packer.Communicator) {
+	s.server.RegisterName("Communicator", &CommunicatorServer{
+		c:   c,
+		mux: s.mux,
+	})
 }
 
 // Registers the appropriate endpoint on an RPC server to serve a
 // Packer Environment
-func RegisterEnvironment(s *rpc.Server, e packer.Environment) {
-	s.RegisterName("Environment", &EnvironmentServer{e})
+func (s *Server) RegisterEnvironment(e packer.Environment) {
+	s.server.RegisterName("Environment", &EnvironmentServer{
+		env: e,
+		mux: s.mux,
+	})
 }
 
 // Registers the appropriate endpoint on an RPC server to serve a
 // Hook.
-func RegisterHook(s *rpc.Server, hook packer.Hook) {
-	s.RegisterName("Hook", &HookServer{hook})
+func (s *Server) RegisterHook(h packer.Hook) {
+	s.server.RegisterName("Hook", &HookServer{
+		hook: h,
+		mux:  s.mux,
+	})
 }
 
-// Registers the appropriate endpoing on an RPC server to serve a
+// Registers the appropriate endpoint on an RPC server to serve a
 // PostProcessor.
-func RegisterPostProcessor(s *rpc.Server, p packer.PostProcessor) {
-	s.RegisterName("PostProcessor", &PostProcessorServer{p})
+func (s *Server) RegisterPostProcessor(p packer.PostProcessor) {
+	s.server.RegisterName("PostProcessor", &PostProcessorServer{
+		p:   p,
+		mux: s.mux,
+	})
 }
 
-// Registers the appropriate endpoint on an RPC server to serve a packer.Provisioner
-func RegisterProvisioner(s *rpc.Server, p packer.Provisioner) {
-	s.RegisterName("Provisioner", &ProvisionerServer{p})
+// Registers the appropriate endpoint on an RPC server to serve a
+// packer.Provisioner
+func (s *Server) RegisterProvisioner(p packer.Provisioner) {
+	s.server.RegisterName("Provisioner", &ProvisionerServer{
+		p:   p,
+		mux: s.mux,
+	})
 }
 
 // Registers the appropriate endpoint on an RPC server to serve a
 // Packer UI
-func RegisterUi(s *rpc.Server, ui packer.Ui) {
-	s.RegisterName("Ui", &UiServer{ui})
+func (s *Server) RegisterUi(ui packer.Ui) {
+	s.server.RegisterName("Ui", &UiServer{
+		ui: ui,
+	})
 }
 
-func serveSingleConn(s *rpc.Server) string {
-	l := netListenerInRange(portRangeMin, portRangeMax)
-
-	// Accept a single connection in a goroutine and then exit
-	go func() {
-		defer l.Close()
-		conn, err := l.Accept()
-		if err != nil {
-			panic(err)
-		}
+// Serve waits for the client to connect to the stream of this server
+// and serves it. This blocks until the client disconnects.
+func (s *Server) Serve() {
+	stream, err := s.mux.Accept(s.streamId)
+	if err != nil {
+		log.Printf("[ERR] Error retrieving stream for serving: %s", err)
+		return
+	}
 
-		s.ServeConn(conn)
-	}()
+	s.server.ServeConn(stream)
+}
 
-	return l.Addr().String()
+// serveOnStream starts a server on a new stream of the given connection
+// for the objects registered by the given function, and returns the ID
+// of the stream that the other side should connect to. The server runs
+// until the other side closes the stream or the connection is closed.
+func serveOnStream(mux *MuxConn, register func(*Server)) uint32 {
+	streamId := mux.NextId()
+	server := newServerWithMux(mux, streamId)
+	register(server)
+	go server.Serve()
+	return streamId
 }
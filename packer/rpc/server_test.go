@@ -1 +1,62 @@
 package rpc
+
+import (
+	"net"
+	"testing"
+)
+
+func testConn(t *testing.T) (net.Conn, net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var serverConn net.Conn
+	var serverErr error
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		defer l.Close()
+		serverConn, serverErr = l.Accept()
+	}()
+
+	clientConn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	<-doneCh
+
+	if serverErr != nil {
+		t.Fatalf("err: %s", serverErr)
+	}
+
+	return clientConn, serverConn
+}
+
+const testSecret = "secret"
+
+func testClientServer(t *testing.T) (*Client, *Server) {
+	clientConn, serverConn := testConn(t)
+
+	var server *Server
+	var serverErr error
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		server, serverErr = NewServer(serverConn, testSecret)
+	}()
+
+	client, err := NewClient(clientConn, testSecret)
+	<-doneCh
+	if serverErr != nil {
+		t.Fatalf("err: %s", serverErr)
+	}
+
+	if err != nil {
+		server.Close()
+		t.Fatalf("err: %s", err)
+	}
+
+	go server.Serve()
+	return client, server
+}
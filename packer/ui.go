@@ -180,8 +180,8 @@ func (rw *BasicUi) Ask(query string) (string, error) {
 
 	result := make(chan string, 1)
 	go func() {
-		var line string
-		if _, err := fmt.Fscanln(rw.Reader, &line); err != nil {
+		line, err := readLine(rw.Reader)
+		if err != nil {
 			log.Printf("ui: scan err: %s", err)
 		}
 
@@ -280,3 +280,31 @@ func (u *MachineReadableUi) Machine(category string, args ...string) {
 		panic(err)
 	}
 }
+
+// readLine reads a single line from the reader, including any spaces,
+// without the trailing newline. The reader is consumed a byte at a time
+// so that nothing past the newline is buffered and lost.
+func readLine(r io.Reader) (string, error) {
+	var line bytes.Buffer
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				break
+			}
+
+			line.WriteByte(b[0])
+		}
+
+		if err != nil {
+			if err == io.EOF && line.Len() > 0 {
+				break
+			}
+
+			return line.String(), err
+		}
+	}
+
+	return strings.TrimRight(line.String(), "\r"), nil
+}
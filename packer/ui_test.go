@@ -83,6 +83,29 @@ func TestBasicUi_ImplUi(t *testing.T) {
 	}
 }
 
+func TestBasicUi_Ask(t *testing.T) {
+	bufferUi := testUi()
+	bufferUi.Reader = bytes.NewBufferString("ls -la /tmp\r\nsecond\n")
+
+	result, err := bufferUi.Ask("?")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result != "ls -la /tmp" {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	result, err = bufferUi.Ask("?")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result != "second" {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
 func TestBasicUi_Error(t *testing.T) {
 	assert := asserts.NewTestingAsserts(t, true)
 
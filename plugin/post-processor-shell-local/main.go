@@ -0,0 +1,10 @@
+package main
+
+import (
+	"github.com/mitchellh/packer/packer/plugin"
+	"github.com/mitchellh/packer/post-processor/shell-local"
+)
+
+func main() {
+	plugin.ServePostProcessor(new(shelllocal.PostProcessor))
+}
@@ -0,0 +1,10 @@
+package main
+
+import (
+	"github.com/mitchellh/packer/packer/plugin"
+	"github.com/mitchellh/packer/provisioner/ansible"
+)
+
+func main() {
+	plugin.ServeProvisioner(new(ansible.Provisioner))
+}
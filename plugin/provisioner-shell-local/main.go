@@ -0,0 +1,10 @@
+package main
+
+import (
+	"github.com/mitchellh/packer/packer/plugin"
+	"github.com/mitchellh/packer/provisioner/shell-local"
+)
+
+func main() {
+	plugin.ServeProvisioner(new(shelllocal.Provisioner))
+}
@@ -0,0 +1,67 @@
+// shelllocal implements the packer.PostProcessor interface and adds a
+// post-processor that runs shell scripts on the machine Packer runs on,
+// with the artifact of the build described in their environment.
+package shelllocal
+
+import (
+	"github.com/mitchellh/packer/common"
+	slcommon "github.com/mitchellh/packer/common/shell-local"
+	"github.com/mitchellh/packer/packer"
+	"strings"
+)
+
+type Config struct {
+	common.PackerConfig `mapstructure:",squash"`
+	slcommon.Config     `mapstructure:",squash"`
+
+	tpl *packer.ConfigTemplate
+}
+
+type PostProcessor struct {
+	config Config
+}
+
+func (p *PostProcessor) Configure(raws ...interface{}) error {
+	md, err := common.DecodeConfig(&p.config, raws...)
+	if err != nil {
+		return err
+	}
+
+	p.config.tpl, err = packer.NewConfigTemplate()
+	if err != nil {
+		return err
+	}
+	p.config.tpl.UserVars = p.config.PackerUserVars
+
+	// Accumulate any errors
+	errs := common.CheckUnusedConfig(md)
+	errs = packer.MultiErrorAppend(errs, p.config.Config.Prepare(p.config.tpl)...)
+
+	if errs != nil && len(errs.Errors) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// PostProcess runs the scripts with the artifact in their environment.
+// The artifact isn't changed, so it is returned and always kept.
+func (p *PostProcessor) PostProcess(ui packer.Ui, artifact packer.Artifact) (packer.Artifact, bool, error) {
+	env := slcommon.BuildEnv(&p.config.PackerConfig)
+	env = append(env, artifactEnv(artifact)...)
+	if err := slcommon.Run(ui, &p.config.Config, p.config.tpl, env); err != nil {
+		return nil, false, err
+	}
+
+	return artifact, true, nil
+}
+
+// artifactEnv returns the environment variables describing the artifact.
+// Its files are separated by newlines, since their paths can have spaces.
+func artifactEnv(artifact packer.Artifact) []string {
+	return []string{
+		"PACKER_ARTIFACT_BUILDER_ID=" + artifact.BuilderId(),
+		"PACKER_ARTIFACT_ID=" + artifact.Id(),
+		"PACKER_ARTIFACT_FILES=" + strings.Join(artifact.Files(), "\n"),
+	}
+}
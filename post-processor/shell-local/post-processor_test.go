@@ -0,0 +1,118 @@
+package shelllocal
+
+import (
+	"bytes"
+	"github.com/mitchellh/packer/packer"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func testConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"inline": []string{"echo $PACKER_BUILD_NAME $PACKER_ARTIFACT_ID $PACKER_ARTIFACT_FILES"},
+	}
+}
+
+func TestPostProcessor_ImplementsPostProcessor(t *testing.T) {
+	var raw interface{}
+	raw = &PostProcessor{}
+	if _, ok := raw.(packer.PostProcessor); !ok {
+		t.Fatalf("must be a PostProcessor")
+	}
+}
+
+func TestPostProcessorConfigure(t *testing.T) {
+	var p PostProcessor
+	if err := p.Configure(testConfig()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Neither a script nor inline commands
+	p = PostProcessor{}
+	if err := p.Configure(map[string]interface{}{}); err == nil {
+		t.Fatal("should have error")
+	}
+
+	// Unknown keys
+	p = PostProcessor{}
+	c := testConfig()
+	c["i_should_not_be_valid"] = true
+	if err := p.Configure(c); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestPostProcessorPostProcess(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("not supported on windows")
+	}
+
+	var p PostProcessor
+	c := testConfig()
+	c["packer_build_name"] = "foo"
+	if err := p.Configure(c); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var output bytes.Buffer
+	ui := &packer.BasicUi{Reader: new(bytes.Buffer), Writer: &output}
+	artifact := &packer.MockArtifact{IdValue: "ami-1234"}
+	result, keep, err := p.PostProcess(ui, artifact)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result != artifact || !keep {
+		t.Fatalf("bad: %#v %t", result, keep)
+	}
+
+	if !strings.Contains(output.String(), "foo ami-1234 a b\n") {
+		t.Fatalf("bad: %q", output.String())
+	}
+}
+
+func TestPostProcessorPostProcess_filesWithSpaces(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("not supported on windows")
+	}
+
+	var p PostProcessor
+	c := map[string]interface{}{
+		"inline": []string{
+			`echo "$PACKER_ARTIFACT_FILES" | while read -r f; do echo "[$f]"; done`,
+		},
+	}
+	if err := p.Configure(c); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var output bytes.Buffer
+	ui := &packer.BasicUi{Reader: new(bytes.Buffer), Writer: &output}
+	artifact := &packer.MockArtifact{
+		FilesValue: []string{"output/disk 1.vmdk", "output/box.ovf"},
+	}
+	if _, _, err := p.PostProcess(ui, artifact); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !strings.Contains(output.String(), "[output/disk 1.vmdk]\n[output/box.ovf]\n") {
+		t.Fatalf("bad: %q", output.String())
+	}
+}
+
+func TestPostProcessorPostProcess_fails(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("not supported on windows")
+	}
+
+	var p PostProcessor
+	if err := p.Configure(map[string]interface{}{"inline": []string{"exit 1"}}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ui := &packer.BasicUi{Reader: new(bytes.Buffer), Writer: new(bytes.Buffer)}
+	if _, _, err := p.PostProcess(ui, new(packer.MockArtifact)); err == nil {
+		t.Fatal("should have error")
+	}
+}
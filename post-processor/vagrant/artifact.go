@@ -2,6 +2,7 @@ package vagrant
 
 import (
 	"fmt"
+	"github.com/mitchellh/packer/packer"
 	"os"
 )
 
@@ -35,6 +36,21 @@ func (a *Artifact) String() string {
 	return fmt.Sprintf("'%s' provider box: %s", a.Provider, a.Path)
 }
 
+// State returns the state of the artifact. The following keys are
+// available:
+//
+//   provider string - The Vagrant provider the box is for
+func (a *Artifact) State(name string) interface{} {
+	switch name {
+	case packer.ArtifactStateKeysKey:
+		return []string{"provider"}
+	case "provider":
+		return a.Provider
+	default:
+		return nil
+	}
+}
+
 func (a *Artifact) Destroy() error {
 	return os.Remove(a.Path)
 }
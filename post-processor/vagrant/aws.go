@@ -68,13 +68,21 @@ func (p *AWSBoxPostProcessor) PostProcess(ui packer.Ui, artifact packer.Artifact
 		Images: make(map[string]string),
 	}
 
-	for _, regions := range strings.Split(artifact.Id(), ",") {
-		parts := strings.Split(regions, ":")
-		if len(parts) != 2 {
-			return nil, false, fmt.Errorf("Poorly formatted artifact ID: %s", artifact.Id())
+	if amis, ok := artifact.State("amis").(map[string]string); ok {
+		for region, ami := range amis {
+			tplData.Images[region] = ami
+		}
+	} else {
+		// The artifact doesn't expose its AMIs, so fall back to parsing
+		// them out of the ID.
+		for _, regions := range strings.Split(artifact.Id(), ",") {
+			parts := strings.Split(regions, ":")
+			if len(parts) != 2 {
+				return nil, false, fmt.Errorf("Poorly formatted artifact ID: %s", artifact.Id())
+			}
+
+			tplData.Images[parts[0]] = parts[1]
 		}
-
-		tplData.Images[parts[0]] = parts[1]
 	}
 
 	// Compile the output path
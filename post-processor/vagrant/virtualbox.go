@@ -107,9 +107,13 @@ func (p *VBoxBoxPostProcessor) PostProcess(ui packer.Ui, artifact packer.Artifac
 
 	// Create the Vagrantfile from the template
 	tplData := &VBoxVagrantfileTemplate{}
-	tplData.BaseMacAddress, err = p.findBaseMacAddress(dir)
-	if err != nil {
-		return nil, false, err
+	if mac, ok := artifact.State("mac_address").(string); ok && mac != "" {
+		tplData.BaseMacAddress = mac
+	} else {
+		tplData.BaseMacAddress, err = p.findBaseMacAddress(dir)
+		if err != nil {
+			return nil, false, err
+		}
 	}
 
 	vf, err := os.Create(filepath.Join(dir, "Vagrantfile"))
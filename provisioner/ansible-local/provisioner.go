@@ -0,0 +1,367 @@
+// This package implements a provisioner for Packer that runs an Ansible
+// playbook within the remote machine
+package ansiblelocal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mitchellh/packer/common"
+	"github.com/mitchellh/packer/packer"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const DefaultStagingDir = "/tmp/packer-provisioner-ansible-local"
+
+// The name of the generated inventory in the staging directory
+const generatedInventory = "packer-inventory"
+
+type Config struct {
+	common.PackerConfig `mapstructure:",squash"`
+
+	// The command to run Ansible with
+	Command string
+
+	// Local path to the main playbook to run
+	PlaybookFile string `mapstructure:"playbook_file"`
+
+	// Local path to a directory of roles, group_vars, host_vars and
+	// anything else the playbook uses, which is uploaded with it
+	PlaybookDir string `mapstructure:"playbook_dir"`
+
+	// Local path to the inventory file. By default an inventory with
+	// only localhost in it, in the inventory groups, is generated.
+	InventoryFile   string   `mapstructure:"inventory_file"`
+	InventoryGroups []string `mapstructure:"inventory_groups"`
+
+	// Variables, tags and arguments given to ansible-playbook
+	ExtraVars      map[string]string `mapstructure:"extra_vars"`
+	Tags           []string
+	SkipTags       []string `mapstructure:"skip_tags"`
+	ExtraArguments []string `mapstructure:"extra_arguments"`
+
+	// If true, `sudo` will NOT be used to run Ansible.
+	PreventSudo bool `mapstructure:"prevent_sudo"`
+
+	// Where files will be copied before running Ansible
+	StagingDir string `mapstructure:"staging_directory"`
+
+	tpl *packer.ConfigTemplate
+}
+
+type Provisioner struct {
+	config Config
+}
+
+func (p *Provisioner) Prepare(raws ...interface{}) error {
+	md, err := common.DecodeConfig(&p.config, raws...)
+	if err != nil {
+		return err
+	}
+
+	p.config.tpl, err = packer.NewConfigTemplate()
+	if err != nil {
+		return err
+	}
+	p.config.tpl.UserVars = p.config.PackerUserVars
+
+	if p.config.Command == "" {
+		p.config.Command = "ansible-playbook"
+	}
+
+	if p.config.StagingDir == "" {
+		p.config.StagingDir = DefaultStagingDir
+	}
+
+	// Accumulate any errors
+	errs := common.CheckUnusedConfig(md)
+
+	templates := map[string]*string{
+		"command":           &p.config.Command,
+		"playbook_file":     &p.config.PlaybookFile,
+		"playbook_dir":      &p.config.PlaybookDir,
+		"inventory_file":    &p.config.InventoryFile,
+		"staging_directory": &p.config.StagingDir,
+	}
+
+	for n, ptr := range templates {
+		var err error
+		*ptr, err = p.config.tpl.Process(*ptr, nil)
+		if err != nil {
+			errs = packer.MultiErrorAppend(
+				errs, fmt.Errorf("Error processing %s: %s", n, err))
+		}
+	}
+
+	sliceTemplates := map[string][]string{
+		"inventory_groups": p.config.InventoryGroups,
+		"tags":             p.config.Tags,
+		"skip_tags":        p.config.SkipTags,
+		"extra_arguments":  p.config.ExtraArguments,
+	}
+
+	for n, slice := range sliceTemplates {
+		for i, elem := range slice {
+			var err error
+			slice[i], err = p.config.tpl.Process(elem, nil)
+			if err != nil {
+				errs = packer.MultiErrorAppend(
+					errs, fmt.Errorf("Error processing %s[%d]: %s", n, i, err))
+			}
+		}
+	}
+
+	extraVars := make(map[string]string)
+	for k, v := range p.config.ExtraVars {
+		var err error
+		v, err = p.config.tpl.Process(v, nil)
+		if err != nil {
+			errs = packer.MultiErrorAppend(
+				errs, fmt.Errorf("Error processing extra_vars %s: %s", k, err))
+		}
+
+		extraVars[k] = v
+	}
+	p.config.ExtraVars = extraVars
+
+	if p.config.PlaybookFile == "" {
+		errs = packer.MultiErrorAppend(errs,
+			errors.New("A playbook_file must be specified."))
+	} else {
+		info, err := os.Stat(p.config.PlaybookFile)
+		if err != nil || info.IsDir() {
+			errs = packer.MultiErrorAppend(errs,
+				errors.New("playbook_file must exist and be a file"))
+		}
+	}
+
+	if p.config.PlaybookDir != "" {
+		info, err := os.Stat(p.config.PlaybookDir)
+		if err != nil || !info.IsDir() {
+			errs = packer.MultiErrorAppend(errs,
+				errors.New("playbook_dir must exist and be a directory"))
+		}
+	}
+
+	if p.config.InventoryFile != "" {
+		if _, err := os.Stat(p.config.InventoryFile); err != nil {
+			errs = packer.MultiErrorAppend(errs,
+				errors.New("inventory_file must exist and be accessible"))
+		}
+
+		if len(p.config.InventoryGroups) > 0 {
+			errs = packer.MultiErrorAppend(errs,
+				errors.New("inventory_groups can't be used with an inventory_file"))
+		}
+	}
+
+	// The files outside of the playbook directory are uploaded next to its
+	// contents, so they can't have the same name as anything in it or as
+	// each other.
+	names := make(map[string]string)
+	files := map[string]string{
+		"playbook_file":  p.config.PlaybookFile,
+		"inventory_file": p.config.InventoryFile,
+	}
+
+	for n, path := range files {
+		if path == "" {
+			continue
+		}
+
+		name, inDir := p.stagingPath(path)
+		if inDir {
+			continue
+		}
+
+		if other, ok := names[name]; ok && files[other] != path {
+			errs = packer.MultiErrorAppend(errs,
+				fmt.Errorf("%s and %s can't have the same name: %s", n, other, name))
+		}
+		names[name] = n
+
+		if p.config.PlaybookDir != "" {
+			if _, err := os.Stat(filepath.Join(p.config.PlaybookDir, name)); err == nil {
+				errs = packer.MultiErrorAppend(errs,
+					fmt.Errorf("%s would replace %s in the playbook_dir", n, name))
+			}
+		}
+	}
+
+	if p.config.InventoryFile == "" && p.config.PlaybookDir != "" {
+		if _, err := os.Stat(filepath.Join(p.config.PlaybookDir, generatedInventory)); err == nil {
+			errs = packer.MultiErrorAppend(errs,
+				fmt.Errorf("The generated inventory would replace %s in the playbook_dir", generatedInventory))
+		}
+	}
+
+	if errs != nil && len(errs.Errors) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func (p *Provisioner) Provision(ui packer.Ui, comm packer.Communicator) error {
+	ui.Say("Provisioning with Ansible...")
+	ui.Message(fmt.Sprintf("Creating remote directory: %s", p.config.StagingDir))
+	if err := p.createDir(ui, comm, p.config.StagingDir); err != nil {
+		return fmt.Errorf("Error creating staging directory: %s", err)
+	}
+
+	if p.config.PlaybookDir != "" {
+		ui.Message(fmt.Sprintf("Uploading playbook directory: %s", p.config.PlaybookDir))
+		src := filepath.Clean(p.config.PlaybookDir) + "/"
+		if err := comm.UploadDir(p.config.StagingDir, src, nil); err != nil {
+			return fmt.Errorf("Error uploading playbook directory: %s", err)
+		}
+	}
+
+	playbookFile, err := p.uploadFile(ui, comm, p.config.PlaybookFile)
+	if err != nil {
+		return fmt.Errorf("Error uploading playbook: %s", err)
+	}
+
+	inventoryFile := p.config.StagingDir + "/" + generatedInventory
+	if p.config.InventoryFile != "" {
+		inventoryFile, err = p.uploadFile(ui, comm, p.config.InventoryFile)
+	} else {
+		ui.Message("Uploading generated inventory")
+		err = comm.Upload(inventoryFile, strings.NewReader(p.inventory()))
+	}
+	if err != nil {
+		return fmt.Errorf("Error uploading inventory: %s", err)
+	}
+
+	command, err := p.command(playbookFile, inventoryFile)
+	if err != nil {
+		return err
+	}
+
+	cmd := &packer.RemoteCmd{
+		Command:  command,
+		Env:      []string{"ANSIBLE_FORCE_COLOR=1", "PYTHONUNBUFFERED=1"},
+		Dir:      p.config.StagingDir,
+		Elevated: !p.config.PreventSudo,
+	}
+
+	ui.Message(fmt.Sprintf("Running Ansible: %s", command))
+	if err := cmd.StartWithUi(comm, ui); err != nil {
+		return err
+	}
+
+	if cmd.ExitStatus != 0 {
+		return fmt.Errorf("Ansible exited with a non-zero exit status: %d", cmd.ExitStatus)
+	}
+
+	return nil
+}
+
+func (p *Provisioner) Cancel() {
+	// Just hard quit. It isn't a big deal if what we're doing keeps
+	// running on the other side.
+	os.Exit(0)
+}
+
+// command returns the ansible-playbook command that runs the playbook
+// against the machine itself.
+func (p *Provisioner) command(playbookFile string, inventoryFile string) (string, error) {
+	args := []string{
+		p.config.Command,
+		packer.ShellQuote(playbookFile),
+		"-c", "local",
+		"-i", packer.ShellQuote(inventoryFile),
+	}
+
+	if len(p.config.ExtraVars) > 0 {
+		extraVars, err := json.Marshal(p.config.ExtraVars)
+		if err != nil {
+			return "", fmt.Errorf("Error encoding extra_vars: %s", err)
+		}
+
+		args = append(args, "--extra-vars", packer.ShellQuote(string(extraVars)))
+	}
+
+	if len(p.config.Tags) > 0 {
+		args = append(args, "--tags", packer.ShellQuote(strings.Join(p.config.Tags, ",")))
+	}
+
+	if len(p.config.SkipTags) > 0 {
+		args = append(args, "--skip-tags", packer.ShellQuote(strings.Join(p.config.SkipTags, ",")))
+	}
+
+	for _, arg := range p.config.ExtraArguments {
+		args = append(args, packer.ShellQuote(arg))
+	}
+
+	return strings.Join(args, " "), nil
+}
+
+// inventory returns the contents of the generated inventory, which has
+// only the machine itself in it, in every one of the inventory groups.
+func (p *Provisioner) inventory() string {
+	host := "127.0.0.1 ansible_connection=local\n"
+	result := host
+	for _, group := range p.config.InventoryGroups {
+		result += fmt.Sprintf("\n[%s]\n%s", group, host)
+	}
+
+	return result
+}
+
+// stagingPath returns the path of the local file within the staging
+// directory, and whether it is uploaded with the playbook directory. Files
+// in the playbook directory keep their place in it, and the others are
+// put at the root of the staging directory.
+func (p *Provisioner) stagingPath(path string) (string, bool) {
+	if p.config.PlaybookDir != "" {
+		rel, err := filepath.Rel(p.config.PlaybookDir, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return filepath.ToSlash(rel), true
+		}
+	}
+
+	return filepath.Base(path), false
+}
+
+// uploadFile uploads the local file to the staging directory, returning
+// its remote path. Files in the playbook directory were already uploaded
+// with it.
+func (p *Provisioner) uploadFile(ui packer.Ui, comm packer.Communicator, path string) (string, error) {
+	name, inDir := p.stagingPath(path)
+	remotePath := p.config.StagingDir + "/" + name
+	if inDir {
+		return remotePath, nil
+	}
+
+	ui.Message(fmt.Sprintf("Uploading %s", path))
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if err := comm.Upload(remotePath, f); err != nil {
+		return "", err
+	}
+
+	return remotePath, nil
+}
+
+func (p *Provisioner) createDir(ui packer.Ui, comm packer.Communicator, dir string) error {
+	cmd := &packer.RemoteCmd{
+		Command: fmt.Sprintf("mkdir -p %s", packer.ShellQuote(dir)),
+	}
+
+	if err := cmd.StartWithUi(comm, ui); err != nil {
+		return err
+	}
+
+	if cmd.ExitStatus != 0 {
+		return fmt.Errorf("Non-zero exit status.")
+	}
+
+	return nil
+}
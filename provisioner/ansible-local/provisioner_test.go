@@ -0,0 +1,312 @@
+package ansiblelocal
+
+import (
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testConfig(t *testing.T) map[string]interface{} {
+	tf, err := ioutil.TempFile("", "playbook")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	tf.Close()
+
+	return map[string]interface{}{
+		"playbook_file": tf.Name(),
+	}
+}
+
+func TestProvisioner_Impl(t *testing.T) {
+	var raw interface{}
+	raw = &Provisioner{}
+	if _, ok := raw.(packer.Provisioner); !ok {
+		t.Fatalf("must be a Provisioner")
+	}
+}
+
+func TestProvisionerPrepare_Defaults(t *testing.T) {
+	var p Provisioner
+	config := testConfig(t)
+	defer os.Remove(config["playbook_file"].(string))
+
+	err := p.Prepare(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if p.config.StagingDir != DefaultStagingDir {
+		t.Errorf("unexpected staging dir: %s", p.config.StagingDir)
+	}
+
+	if p.config.Command != "ansible-playbook" {
+		t.Errorf("unexpected command: %s", p.config.Command)
+	}
+}
+
+func TestProvisionerPrepare_InvalidKey(t *testing.T) {
+	var p Provisioner
+	config := testConfig(t)
+	defer os.Remove(config["playbook_file"].(string))
+
+	// Add a random key
+	config["i_should_not_be_valid"] = true
+	err := p.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestProvisionerPrepare_PlaybookFile(t *testing.T) {
+	var p Provisioner
+	config := testConfig(t)
+	defer os.Remove(config["playbook_file"].(string))
+
+	delete(config, "playbook_file")
+	if err := p.Prepare(config); err == nil {
+		t.Fatal("should have error")
+	}
+
+	p = Provisioner{}
+	config["playbook_file"] = "/i/dont/exist/i/think"
+	if err := p.Prepare(config); err == nil {
+		t.Fatal("should have error")
+	}
+
+	p = Provisioner{}
+	config["playbook_file"] = os.TempDir()
+	if err := p.Prepare(config); err == nil {
+		t.Fatal("should not be a directory")
+	}
+}
+
+func TestProvisionerPrepare_PlaybookDir(t *testing.T) {
+	var p Provisioner
+	config := testConfig(t)
+	defer os.Remove(config["playbook_file"].(string))
+
+	config["playbook_dir"] = "/i/dont/exist/i/think"
+	if err := p.Prepare(config); err == nil {
+		t.Fatal("should have error")
+	}
+
+	p = Provisioner{}
+	config["playbook_dir"] = config["playbook_file"]
+	if err := p.Prepare(config); err == nil {
+		t.Fatal("should be a directory")
+	}
+
+	p = Provisioner{}
+	config["playbook_dir"] = os.TempDir()
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestProvisionerPrepare_StagingNames(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	playbookDir := filepath.Join(td, "playbooks")
+	for _, dir := range []string{"playbooks", "other"} {
+		if err := os.Mkdir(filepath.Join(td, dir), 0755); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	files := []string{
+		filepath.Join("playbooks", "site.yml"),
+		filepath.Join("playbooks", generatedInventory),
+		filepath.Join("other", "site.yml"),
+		filepath.Join("other", "hosts"),
+		filepath.Join("other", "inventory", "hosts"),
+	}
+
+	for _, name := range files {
+		path := filepath.Join(td, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	cases := []struct {
+		playbook  string
+		inventory string
+		ok        bool
+	}{
+		{filepath.Join("playbooks", "site.yml"), filepath.Join("other", "hosts"), true},
+		{filepath.Join("other", "site.yml"), filepath.Join("other", "hosts"), false},
+		{filepath.Join("playbooks", "site.yml"), "", false},
+		{filepath.Join("playbooks", "site.yml"), filepath.Join("playbooks", generatedInventory), true},
+		{filepath.Join("other", "hosts"), filepath.Join("other", "inventory", "hosts"), false},
+	}
+
+	for _, tc := range cases {
+		var p Provisioner
+		config := map[string]interface{}{
+			"playbook_file": filepath.Join(td, tc.playbook),
+			"playbook_dir":  playbookDir,
+		}
+
+		if tc.inventory != "" {
+			config["inventory_file"] = filepath.Join(td, tc.inventory)
+		}
+
+		err := p.Prepare(config)
+		if tc.ok && err != nil {
+			t.Fatalf("%s %s: err: %s", tc.playbook, tc.inventory, err)
+		}
+
+		if !tc.ok && err == nil {
+			t.Fatalf("%s %s: should error", tc.playbook, tc.inventory)
+		}
+	}
+}
+
+func TestProvisionerPrepare_InventoryFile(t *testing.T) {
+	var p Provisioner
+	config := testConfig(t)
+	defer os.Remove(config["playbook_file"].(string))
+
+	config["inventory_file"] = "/i/dont/exist/i/think"
+	if err := p.Prepare(config); err == nil {
+		t.Fatal("should have error")
+	}
+
+	p = Provisioner{}
+	config["inventory_file"] = config["playbook_file"]
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	p = Provisioner{}
+	config["inventory_groups"] = []string{"web"}
+	if err := p.Prepare(config); err == nil {
+		t.Fatal("should not generate groups with an inventory file")
+	}
+}
+
+func TestProvisionerProvision(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	playbook := filepath.Join(td, "site.yml")
+	if err := ioutil.WriteFile(playbook, []byte("---\n"), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var p Provisioner
+	config := map[string]interface{}{
+		"playbook_file":    playbook,
+		"playbook_dir":     td,
+		"inventory_groups": []string{"web", "db"},
+		"extra_vars":       map[string]string{"version": "1.0 final"},
+		"tags":             []string{"app", "config"},
+		"skip_tags":        []string{"slow"},
+		"extra_arguments":  []string{"--limit", "web"},
+	}
+
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ui := &packer.BasicUi{Writer: ioutil.Discard}
+	comm := new(packer.MockCommunicator)
+	if err := p.Provision(ui, comm); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The playbook directory is uploaded with the playbook in it
+	if comm.UploadDirDst != DefaultStagingDir || comm.UploadDirSrc != filepath.Clean(td)+"/" {
+		t.Fatalf("bad: %s %s", comm.UploadDirDst, comm.UploadDirSrc)
+	}
+
+	if comm.UploadPath != DefaultStagingDir+"/packer-inventory" {
+		t.Fatalf("bad: %s", comm.UploadPath)
+	}
+
+	expected := "127.0.0.1 ansible_connection=local\n\n[web]\n" +
+		"127.0.0.1 ansible_connection=local\n\n[db]\n" +
+		"127.0.0.1 ansible_connection=local\n"
+	if comm.UploadData != expected {
+		t.Fatalf("bad: %q", comm.UploadData)
+	}
+
+	cmd := comm.StartCmd
+	expected = "ansible-playbook '" + DefaultStagingDir + "/site.yml' -c local " +
+		"-i '" + DefaultStagingDir + "/packer-inventory' " +
+		`--extra-vars '{"version":"1.0 final"}' --tags 'app,config' ` +
+		"--skip-tags 'slow' '--limit' 'web'"
+	if cmd.Command != expected {
+		t.Fatalf("bad: %s", cmd.Command)
+	}
+
+	if cmd.Dir != DefaultStagingDir || !cmd.Elevated {
+		t.Fatalf("bad: %#v", cmd)
+	}
+
+	// With prevent_sudo, Ansible isn't run with sudo
+	config["prevent_sudo"] = true
+	p = Provisioner{}
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	comm = new(packer.MockCommunicator)
+	if err := p.Provision(ui, comm); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if comm.StartCmd.Elevated {
+		t.Fatalf("bad: %#v", comm.StartCmd)
+	}
+}
+
+func TestProvisionerProvision_playbookOutsideDir(t *testing.T) {
+	var p Provisioner
+	config := testConfig(t)
+	defer os.Remove(config["playbook_file"].(string))
+
+	inventory, err := ioutil.TempFile("", "hosts")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	inventory.WriteString("localhost\n")
+	inventory.Close()
+	defer os.Remove(inventory.Name())
+
+	config["inventory_file"] = inventory.Name()
+	config["staging_directory"] = "/tmp/ansible"
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ui := &packer.BasicUi{Writer: ioutil.Discard}
+	comm := new(packer.MockCommunicator)
+	if err := p.Provision(ui, comm); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	remoteInventory := "/tmp/ansible/" + filepath.Base(inventory.Name())
+	if comm.UploadPath != remoteInventory || comm.UploadData != "localhost\n" {
+		t.Fatalf("bad: %s %q", comm.UploadPath, comm.UploadData)
+	}
+
+	if !strings.Contains(comm.StartCmd.Command, "-i '"+remoteInventory+"'") {
+		t.Fatalf("bad: %s", comm.StartCmd.Command)
+	}
+}
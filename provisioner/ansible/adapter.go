@@ -0,0 +1,297 @@
+package ansible
+
+import (
+	"code.google.com/p/go.crypto/ssh"
+	"encoding/binary"
+	"github.com/mitchellh/packer/packer"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// adapter is a local SSH server that Ansible connects to, which runs the
+// commands and copies the files it is asked to with the communicator of
+// the build. This lets Ansible provision machines that it can't reach
+// itself, such as machines behind NAT or chroots.
+type adapter struct {
+	comm     packer.Communicator
+	listener *ssh.Listener
+	wg       sync.WaitGroup
+
+	// The connections being served, which are closed on shutdown
+	l      sync.Mutex
+	conns  map[*ssh.ServerConn]struct{}
+	closed bool
+}
+
+func newAdapter(comm packer.Communicator, config *ssh.ServerConfig, port uint) (*adapter, error) {
+	addr := net.JoinHostPort("127.0.0.1", strconv.FormatUint(uint64(port), 10))
+	l, err := ssh.Listen("tcp", addr, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &adapter{
+		comm:     comm,
+		listener: l,
+		conns:    make(map[*ssh.ServerConn]struct{}),
+	}, nil
+}
+
+// Port returns the port that the adapter listens on.
+func (a *adapter) Port() (int, error) {
+	_, port, err := net.SplitHostPort(a.listener.Addr().String())
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(port)
+}
+
+// Serve accepts connections until the adapter is shut down.
+func (a *adapter) Serve() {
+	for {
+		conn, err := a.listener.Accept()
+		if err != nil {
+			log.Printf("Ansible adapter stopped accepting connections: %s", err)
+			return
+		}
+
+		if !a.track(conn) {
+			conn.Close()
+			return
+		}
+
+		a.wg.Add(1)
+		go func() {
+			defer a.wg.Done()
+			defer a.untrack(conn)
+			a.handleConn(conn)
+		}()
+	}
+}
+
+// Shutdown stops accepting connections, closes the ones being served and
+// waits for them to be done.
+func (a *adapter) Shutdown() {
+	a.listener.Close()
+
+	a.l.Lock()
+	a.closed = true
+	for conn := range a.conns {
+		conn.Close()
+	}
+	a.l.Unlock()
+
+	a.wg.Wait()
+}
+
+// track records the connection so that it is closed on shutdown. It
+// returns false if the adapter was already shut down.
+func (a *adapter) track(conn *ssh.ServerConn) bool {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	if a.closed {
+		return false
+	}
+
+	a.conns[conn] = struct{}{}
+	return true
+}
+
+func (a *adapter) untrack(conn *ssh.ServerConn) {
+	a.l.Lock()
+	defer a.l.Unlock()
+	delete(a.conns, conn)
+}
+
+func (a *adapter) handleConn(conn *ssh.ServerConn) {
+	defer conn.Close()
+
+	if err := conn.Handshake(); err != nil {
+		log.Printf("Ansible adapter handshake error: %s", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		ch, err := conn.Accept()
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Ansible adapter connection error: %s", err)
+			}
+
+			return
+		}
+
+		if ch.ChannelType() != "session" {
+			ch.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+
+		if err := ch.Accept(); err != nil {
+			log.Printf("Ansible adapter couldn't accept session: %s", err)
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.handleSession(ch)
+		}()
+	}
+}
+
+// handleSession handles the requests of a session until a command is
+// executed, then runs the command and sends its exit status.
+func (a *adapter) handleSession(ch ssh.Channel) {
+	defer ch.Close()
+
+	var env []string
+	buf := make([]byte, 1024)
+	for {
+		_, err := ch.Read(buf)
+		req, ok := err.(ssh.ChannelRequest)
+		if !ok {
+			// Data before a command, or the end of the session
+			if err != nil && err != io.EOF {
+				log.Printf("Ansible adapter session error: %s", err)
+			}
+
+			return
+		}
+
+		switch req.Request {
+		case "env":
+			name, rest, ok := parseString(req.Payload)
+			value, _, ok2 := parseString(rest)
+			if ok && ok2 {
+				env = append(env, name+"="+value)
+			}
+
+			ackRequest(ch, req, ok && ok2)
+		case "pty-req":
+			// Commands get a terminal from the communicator if they
+			// need one, so the request is accepted but ignored.
+			ackRequest(ch, req, true)
+		case "exec":
+			command, _, ok := parseString(req.Payload)
+			ackRequest(ch, req, ok)
+			if !ok {
+				return
+			}
+
+			sendExitStatus(ch, a.exec(command, env, ch))
+			return
+		default:
+			log.Printf("Ansible adapter rejected request: %s", req.Request)
+			ackRequest(ch, req, false)
+		}
+	}
+}
+
+// exec runs the command, returning its exit status. Copies made with scp
+// are served with the communicator instead.
+func (a *adapter) exec(command string, env []string, ch ssh.Channel) int {
+	log.Printf("Ansible adapter executing: %s", command)
+	stdin := &channelReader{ch}
+
+	scp, err := parseScpCommand(command)
+	if err != nil {
+		log.Printf("Ansible adapter can't run scp command: %s", err)
+		io.WriteString(ch.Stderr(), err.Error()+"\n")
+		return 1
+	}
+
+	if scp != nil {
+		return scp.Run(a.comm, stdin, ch)
+	}
+
+	cmd := &packer.RemoteCmd{
+		Command: command,
+		Env:     env,
+		Stdin:   stdin,
+		Stdout:  ch,
+		Stderr:  ch.Stderr(),
+	}
+
+	if err := a.comm.Start(cmd); err != nil {
+		log.Printf("Ansible adapter couldn't start command: %s", err)
+		io.WriteString(ch.Stderr(), err.Error()+"\n")
+		return 1
+	}
+
+	cmd.Wait()
+	return cmd.ExitStatus
+}
+
+// channelReader reads the data of a channel, rejecting any requests that
+// are made while it is read.
+type channelReader struct {
+	ch ssh.Channel
+}
+
+func (r *channelReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.ch.Read(p)
+		req, ok := err.(ssh.ChannelRequest)
+		if !ok {
+			return n, err
+		}
+
+		ackRequest(r.ch, req, false)
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// requestSender is implemented by channels that can send requests to the
+// client, which is needed to send the exit status of commands.
+type requestSender interface {
+	SendRequest(name string, wantReply bool, payload []byte) (bool, error)
+}
+
+func sendExitStatus(ch ssh.Channel, status int) {
+	sender, ok := ch.(requestSender)
+	if !ok {
+		log.Printf("Ansible adapter can't send exit status %d", status)
+		return
+	}
+
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(status))
+	if _, err := sender.SendRequest("exit-status", false, payload); err != nil {
+		log.Printf("Ansible adapter couldn't send exit status: %s", err)
+	}
+}
+
+func ackRequest(ch ssh.Channel, req ssh.ChannelRequest, ok bool) {
+	if !req.WantReply {
+		return
+	}
+
+	if err := ch.AckRequest(ok); err != nil {
+		log.Printf("Ansible adapter couldn't reply to request: %s", err)
+	}
+}
+
+// parseString parses an SSH string from the payload of a request,
+// returning it and the rest of the payload.
+func parseString(in []byte) (string, []byte, bool) {
+	if len(in) < 4 {
+		return "", nil, false
+	}
+
+	length := binary.BigEndian.Uint32(in)
+	in = in[4:]
+	if uint32(len(in)) < length {
+		return "", nil, false
+	}
+
+	return string(in[:length]), in[length:], true
+}
@@ -0,0 +1,24 @@
+package ansible
+
+import (
+	"testing"
+)
+
+func TestParseString(t *testing.T) {
+	payload := []byte("\x00\x00\x00\x04LANG\x00\x00\x00\x05C.UTF")
+	name, rest, ok := parseString(payload)
+	if !ok || name != "LANG" {
+		t.Fatalf("bad: %q", name)
+	}
+
+	value, rest, ok := parseString(rest)
+	if !ok || value != "C.UTF" || len(rest) != 0 {
+		t.Fatalf("bad: %q", value)
+	}
+
+	for _, invalid := range []string{"", "\x00\x00", "\x00\x00\x00\x05LANG"} {
+		if _, _, ok := parseString([]byte(invalid)); ok {
+			t.Fatalf("should not parse: %q", invalid)
+		}
+	}
+}
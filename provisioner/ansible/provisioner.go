@@ -0,0 +1,360 @@
+// This package implements a provisioner for Packer that runs an Ansible
+// playbook on the machine Packer runs on, against the machine being built.
+package ansible
+
+import (
+	"code.google.com/p/go.crypto/ssh"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/mitchellh/packer/common"
+	"github.com/mitchellh/packer/communicator/local"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+)
+
+type Config struct {
+	common.PackerConfig `mapstructure:",squash"`
+
+	// The command to run Ansible with
+	Command string
+
+	// Local path to the playbook to run
+	PlaybookFile string `mapstructure:"playbook_file"`
+
+	// The name of the machine in the generated inventory, and the groups
+	// it is put in
+	HostAlias       string   `mapstructure:"host_alias"`
+	InventoryGroups []string `mapstructure:"inventory_groups"`
+
+	// The local port that the SSH adapter listens on. By default a free
+	// port is picked.
+	LocalPort uint `mapstructure:"local_port"`
+
+	// Variables, tags and arguments given to ansible-playbook
+	ExtraVars      map[string]string `mapstructure:"extra_vars"`
+	Tags           []string
+	SkipTags       []string `mapstructure:"skip_tags"`
+	ExtraArguments []string `mapstructure:"extra_arguments"`
+
+	// Environment variables to set for Ansible, as KEY=VALUE
+	AnsibleEnvVars []string `mapstructure:"ansible_env_vars"`
+
+	tpl *packer.ConfigTemplate
+}
+
+type Provisioner struct {
+	config Config
+}
+
+func (p *Provisioner) Prepare(raws ...interface{}) error {
+	md, err := common.DecodeConfig(&p.config, raws...)
+	if err != nil {
+		return err
+	}
+
+	p.config.tpl, err = packer.NewConfigTemplate()
+	if err != nil {
+		return err
+	}
+	p.config.tpl.UserVars = p.config.PackerUserVars
+
+	if p.config.Command == "" {
+		p.config.Command = "ansible-playbook"
+	}
+
+	if p.config.HostAlias == "" {
+		p.config.HostAlias = "default"
+	}
+
+	// Accumulate any errors
+	errs := common.CheckUnusedConfig(md)
+
+	templates := map[string]*string{
+		"command":       &p.config.Command,
+		"playbook_file": &p.config.PlaybookFile,
+		"host_alias":    &p.config.HostAlias,
+	}
+
+	for n, ptr := range templates {
+		var err error
+		*ptr, err = p.config.tpl.Process(*ptr, nil)
+		if err != nil {
+			errs = packer.MultiErrorAppend(
+				errs, fmt.Errorf("Error processing %s: %s", n, err))
+		}
+	}
+
+	sliceTemplates := map[string][]string{
+		"inventory_groups": p.config.InventoryGroups,
+		"tags":             p.config.Tags,
+		"skip_tags":        p.config.SkipTags,
+		"extra_arguments":  p.config.ExtraArguments,
+		"ansible_env_vars": p.config.AnsibleEnvVars,
+	}
+
+	for n, slice := range sliceTemplates {
+		for i, elem := range slice {
+			var err error
+			slice[i], err = p.config.tpl.Process(elem, nil)
+			if err != nil {
+				errs = packer.MultiErrorAppend(
+					errs, fmt.Errorf("Error processing %s[%d]: %s", n, i, err))
+			}
+		}
+	}
+
+	extraVars := make(map[string]string)
+	for k, v := range p.config.ExtraVars {
+		var err error
+		v, err = p.config.tpl.Process(v, nil)
+		if err != nil {
+			errs = packer.MultiErrorAppend(
+				errs, fmt.Errorf("Error processing extra_vars %s: %s", k, err))
+		}
+
+		extraVars[k] = v
+	}
+	p.config.ExtraVars = extraVars
+
+	if p.config.PlaybookFile == "" {
+		errs = packer.MultiErrorAppend(errs,
+			errors.New("A playbook_file must be specified."))
+	} else {
+		info, err := os.Stat(p.config.PlaybookFile)
+		if err != nil || info.IsDir() {
+			errs = packer.MultiErrorAppend(errs,
+				errors.New("playbook_file must exist and be a file"))
+		}
+	}
+
+	if p.config.LocalPort > 65535 {
+		errs = packer.MultiErrorAppend(errs,
+			errors.New("local_port must be a valid port"))
+	}
+
+	for _, kv := range p.config.AnsibleEnvVars {
+		vs := strings.SplitN(kv, "=", 2)
+		if len(vs) != 2 || vs[0] == "" {
+			errs = packer.MultiErrorAppend(errs,
+				fmt.Errorf("Ansible environment variable not in format 'key=value': %s", kv))
+		}
+	}
+
+	if errs != nil && len(errs.Errors) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func (p *Provisioner) Provision(ui packer.Ui, comm packer.Communicator) error {
+	ui.Say("Provisioning with Ansible...")
+
+	ui.Message("Generating keys for the SSH adapter")
+	hostKey, err := generateKey()
+	if err != nil {
+		return fmt.Errorf("Error generating host key: %s", err)
+	}
+
+	userKey, err := generateKey()
+	if err != nil {
+		return fmt.Errorf("Error generating user key: %s", err)
+	}
+
+	userPublicKey := marshalPublicKey(&userKey.PublicKey)
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(c *ssh.ServerConn, user, algo string, pubkey []byte) bool {
+			return algo == "ssh-rsa" && string(pubkey) == string(userPublicKey)
+		},
+	}
+
+	if err := config.SetRSAPrivateKey(encodeKey(hostKey)); err != nil {
+		return fmt.Errorf("Error setting host key: %s", err)
+	}
+
+	adapter, err := newAdapter(comm, config, p.config.LocalPort)
+	if err != nil {
+		return fmt.Errorf("Error starting the SSH adapter: %s", err)
+	}
+	go adapter.Serve()
+	defer adapter.Shutdown()
+
+	port, err := adapter.Port()
+	if err != nil {
+		return fmt.Errorf("Error starting the SSH adapter: %s", err)
+	}
+
+	keyFile, err := tempFile("packer-ansible-key", encodeKey(userKey))
+	if err != nil {
+		return fmt.Errorf("Error writing private key: %s", err)
+	}
+	defer os.Remove(keyFile)
+
+	inventoryFile, err := tempFile("packer-ansible-inventory", []byte(p.inventory(port, keyFile)))
+	if err != nil {
+		return fmt.Errorf("Error writing inventory: %s", err)
+	}
+	defer os.Remove(inventoryFile)
+
+	command, err := p.command(inventoryFile)
+	if err != nil {
+		return err
+	}
+
+	cmd := &packer.RemoteCmd{
+		Command: command,
+		Env:     p.env(),
+	}
+
+	ui.Message(fmt.Sprintf("Running Ansible: %s", command))
+	if err := cmd.StartWithUi(new(local.Communicator), ui); err != nil {
+		return err
+	}
+
+	if cmd.ExitStatus != 0 {
+		return fmt.Errorf("Ansible exited with a non-zero exit status: %d", cmd.ExitStatus)
+	}
+
+	return nil
+}
+
+func (p *Provisioner) Cancel() {
+	// Just hard quit. It isn't a big deal if what we're doing keeps
+	// running on the other side.
+	os.Exit(0)
+}
+
+// command returns the ansible-playbook command that runs the playbook
+// against the machine through the SSH adapter.
+func (p *Provisioner) command(inventoryFile string) (string, error) {
+	args := []string{
+		p.config.Command,
+		packer.ShellQuote(p.config.PlaybookFile),
+		"-i", packer.ShellQuote(inventoryFile),
+	}
+
+	if len(p.config.ExtraVars) > 0 {
+		extraVars, err := json.Marshal(p.config.ExtraVars)
+		if err != nil {
+			return "", fmt.Errorf("Error encoding extra_vars: %s", err)
+		}
+
+		args = append(args, "--extra-vars", packer.ShellQuote(string(extraVars)))
+	}
+
+	if len(p.config.Tags) > 0 {
+		args = append(args, "--tags", packer.ShellQuote(strings.Join(p.config.Tags, ",")))
+	}
+
+	if len(p.config.SkipTags) > 0 {
+		args = append(args, "--skip-tags", packer.ShellQuote(strings.Join(p.config.SkipTags, ",")))
+	}
+
+	for _, arg := range p.config.ExtraArguments {
+		args = append(args, packer.ShellQuote(arg))
+	}
+
+	return strings.Join(args, " "), nil
+}
+
+// env returns the environment that Ansible is run with. Files are copied
+// with scp, which the adapter serves, and the generated host key isn't
+// checked or remembered.
+func (p *Provisioner) env() []string {
+	env := []string{
+		"ANSIBLE_FORCE_COLOR=1",
+		"ANSIBLE_HOST_KEY_CHECKING=False",
+		"ANSIBLE_SCP_IF_SSH=True",
+		"ANSIBLE_SSH_ARGS=-o UserKnownHostsFile=/dev/null -o IdentitiesOnly=yes",
+		"PYTHONUNBUFFERED=1",
+	}
+
+	return append(env, p.config.AnsibleEnvVars...)
+}
+
+// inventory returns the contents of the generated inventory, which has
+// only the machine in it, reached through the adapter, in every one of
+// the inventory groups.
+func (p *Provisioner) inventory(port int, keyFile string) string {
+	host := fmt.Sprintf(
+		"%s ansible_ssh_host=127.0.0.1 ansible_ssh_port=%d ansible_ssh_user=packer ansible_ssh_private_key_file=%s\n",
+		p.config.HostAlias, port, keyFile)
+	result := host
+	for _, group := range p.config.InventoryGroups {
+		result += fmt.Sprintf("\n[%s]\n%s\n", group, p.config.HostAlias)
+	}
+
+	return result
+}
+
+func generateKey() (*rsa.PrivateKey, error) {
+	return rsa.GenerateKey(rand.Reader, 2048)
+}
+
+// encodeKey returns the private key PEM encoded.
+func encodeKey(key *rsa.PrivateKey) []byte {
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+}
+
+// marshalPublicKey returns the public key in the SSH wire format, which
+// is how clients present it.
+func marshalPublicKey(key *rsa.PublicKey) []byte {
+	e := new(big.Int).SetInt64(int64(key.E))
+	var result []byte
+	result = appendString(result, []byte("ssh-rsa"))
+	result = appendString(result, mpint(e))
+	result = appendString(result, mpint(key.N))
+	return result
+}
+
+// mpint returns the bytes of the SSH mpint encoding of a positive number,
+// without the length.
+func mpint(n *big.Int) []byte {
+	b := n.Bytes()
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+
+	return b
+}
+
+func appendString(buf []byte, s []byte) []byte {
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(s)))
+	buf = append(buf, length...)
+	return append(buf, s...)
+}
+
+// tempFile writes the contents to a new temporary file that only the
+// current user can read, returning its path.
+func tempFile(prefix string, contents []byte) (string, error) {
+	tf, err := ioutil.TempFile("", prefix)
+	if err != nil {
+		return "", err
+	}
+	defer tf.Close()
+
+	if err := tf.Chmod(0600); err != nil {
+		os.Remove(tf.Name())
+		return "", err
+	}
+
+	if _, err := tf.Write(contents); err != nil {
+		os.Remove(tf.Name())
+		return "", err
+	}
+
+	return tf.Name(), nil
+}
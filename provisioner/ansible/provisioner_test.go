@@ -0,0 +1,218 @@
+package ansible
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func testConfig(t *testing.T) map[string]interface{} {
+	tf, err := ioutil.TempFile("", "playbook")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	tf.Close()
+
+	return map[string]interface{}{
+		"playbook_file": tf.Name(),
+	}
+}
+
+func TestProvisioner_Impl(t *testing.T) {
+	var raw interface{}
+	raw = &Provisioner{}
+	if _, ok := raw.(packer.Provisioner); !ok {
+		t.Fatalf("must be a Provisioner")
+	}
+}
+
+func TestProvisionerPrepare_Defaults(t *testing.T) {
+	var p Provisioner
+	config := testConfig(t)
+	defer os.Remove(config["playbook_file"].(string))
+
+	err := p.Prepare(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if p.config.Command != "ansible-playbook" {
+		t.Errorf("unexpected command: %s", p.config.Command)
+	}
+
+	if p.config.HostAlias != "default" {
+		t.Errorf("unexpected host alias: %s", p.config.HostAlias)
+	}
+}
+
+func TestProvisionerPrepare_InvalidKey(t *testing.T) {
+	var p Provisioner
+	config := testConfig(t)
+	defer os.Remove(config["playbook_file"].(string))
+
+	// Add a random key
+	config["i_should_not_be_valid"] = true
+	err := p.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestProvisionerPrepare_PlaybookFile(t *testing.T) {
+	var p Provisioner
+	config := testConfig(t)
+	defer os.Remove(config["playbook_file"].(string))
+
+	delete(config, "playbook_file")
+	if err := p.Prepare(config); err == nil {
+		t.Fatal("should have error")
+	}
+
+	p = Provisioner{}
+	config["playbook_file"] = "/i/dont/exist/i/think"
+	if err := p.Prepare(config); err == nil {
+		t.Fatal("should have error")
+	}
+
+	p = Provisioner{}
+	config["playbook_file"] = os.TempDir()
+	if err := p.Prepare(config); err == nil {
+		t.Fatal("should not be a directory")
+	}
+}
+
+func TestProvisionerPrepare_LocalPort(t *testing.T) {
+	var p Provisioner
+	config := testConfig(t)
+	defer os.Remove(config["playbook_file"].(string))
+
+	config["local_port"] = 65536
+	if err := p.Prepare(config); err == nil {
+		t.Fatal("should have error")
+	}
+
+	p = Provisioner{}
+	config["local_port"] = 2200
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestProvisionerPrepare_AnsibleEnvVars(t *testing.T) {
+	var p Provisioner
+	config := testConfig(t)
+	defer os.Remove(config["playbook_file"].(string))
+
+	config["ansible_env_vars"] = []string{"ANSIBLE_NOCOWS=1", "EMPTY="}
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	env := p.env()
+	if env[len(env)-2] != "ANSIBLE_NOCOWS=1" || env[len(env)-1] != "EMPTY=" {
+		t.Fatalf("bad: %#v", env)
+	}
+
+	for _, kv := range []string{"ANSIBLE_NOCOWS", "=1"} {
+		p = Provisioner{}
+		config["ansible_env_vars"] = []string{kv}
+		if err := p.Prepare(config); err == nil {
+			t.Fatalf("should have error: %s", kv)
+		}
+	}
+}
+
+func TestProvisionerCommand(t *testing.T) {
+	var p Provisioner
+	config := testConfig(t)
+	playbook := config["playbook_file"].(string)
+	defer os.Remove(playbook)
+
+	config["extra_vars"] = map[string]string{"version": "1.0 final"}
+	config["tags"] = []string{"app", "config"}
+	config["skip_tags"] = []string{"slow"}
+	config["extra_arguments"] = []string{"--limit", "web"}
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	command, err := p.command("/tmp/inventory")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := "ansible-playbook '" + playbook + "' -i '/tmp/inventory' " +
+		`--extra-vars '{"version":"1.0 final"}' --tags 'app,config' ` +
+		"--skip-tags 'slow' '--limit' 'web'"
+	if command != expected {
+		t.Fatalf("bad: %s", command)
+	}
+}
+
+func TestProvisionerInventory(t *testing.T) {
+	var p Provisioner
+	config := testConfig(t)
+	defer os.Remove(config["playbook_file"].(string))
+
+	config["host_alias"] = "web1"
+	config["inventory_groups"] = []string{"web", "db"}
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	host := "web1 ansible_ssh_host=127.0.0.1 ansible_ssh_port=2200 " +
+		"ansible_ssh_user=packer ansible_ssh_private_key_file=/tmp/key\n"
+	expected := host + "\n[web]\nweb1\n\n[db]\nweb1\n"
+	if actual := p.inventory(2200, "/tmp/key"); actual != expected {
+		t.Fatalf("bad: %q", actual)
+	}
+}
+
+func TestMarshalPublicKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	result := marshalPublicKey(&key.PublicKey)
+	algo, rest, ok := parseString(result)
+	if !ok || algo != "ssh-rsa" {
+		t.Fatalf("bad: %q", algo)
+	}
+
+	e, rest, ok := parseString(rest)
+	if !ok || e != "\x01\x00\x01" {
+		t.Fatalf("bad: %q", e)
+	}
+
+	n, rest, ok := parseString(rest)
+	if !ok || len(rest) != 0 || n[0] != 0 || len(n) != 65 {
+		t.Fatalf("bad: %q", n)
+	}
+}
+
+func TestTempFile(t *testing.T) {
+	path, err := tempFile("packer", []byte("secret"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Remove(path)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("bad: %s", info.Mode())
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil || !strings.Contains(string(data), "secret") {
+		t.Fatalf("bad: %q %s", data, err)
+	}
+}
@@ -0,0 +1,275 @@
+package ansible
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/mitchellh/packer/packer"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// scpCommand is an scp command run by Ansible to copy files to or from
+// the machine, which is served with the communicator rather than run.
+type scpCommand struct {
+	// Sink is true for "scp -t", which receives files, and false for
+	// "scp -f", which sends them.
+	Sink bool
+
+	// Dir is true if the target is a directory to copy files into.
+	Dir bool
+
+	Path string
+}
+
+// parseScpCommand returns the scp command if the command is one, or nil.
+func parseScpCommand(command string) (*scpCommand, error) {
+	args, err := shellSplit(command)
+	if err != nil || len(args) == 0 || path.Base(args[0]) != "scp" {
+		return nil, err
+	}
+
+	result := new(scpCommand)
+	var sink, source bool
+	for _, arg := range args[1:] {
+		switch {
+		case arg == "--":
+		case strings.HasPrefix(arg, "-"):
+			for _, flag := range arg[1:] {
+				switch flag {
+				case 't':
+					sink = true
+				case 'f':
+					source = true
+				case 'd':
+					result.Dir = true
+				case 'r':
+					return nil, errors.New("Recursive copies aren't supported.")
+				}
+			}
+		case result.Path != "":
+			return nil, errors.New("Only one path can be copied.")
+		default:
+			result.Path = arg
+		}
+	}
+
+	if sink == source || result.Path == "" {
+		return nil, fmt.Errorf("Unsupported scp command: %s", command)
+	}
+
+	result.Sink = sink
+	return result, nil
+}
+
+// Run serves the scp command, talking the SCP protocol over r and w, and
+// returns the exit status of the command.
+func (s *scpCommand) Run(comm packer.Communicator, r io.Reader, w io.Writer) int {
+	var err error
+	br := bufio.NewReader(r)
+	if s.Sink {
+		err = scpSink(comm, s.Path, s.Dir, br, w)
+	} else {
+		err = scpSource(comm, s.Path, br, w)
+	}
+
+	if err != nil {
+		log.Printf("scp error: %s", err)
+		fmt.Fprintf(w, "\x02%s\n", strings.Replace(err.Error(), "\n", " ", -1))
+		return 1
+	}
+
+	return 0
+}
+
+// scpSink receives files from the client and uploads them. Files are
+// uploaded to the target path, or into it if it is a directory.
+func scpSink(comm packer.Communicator, target string, dir bool, r *bufio.Reader, w io.Writer) error {
+	// Tell the client that we're ready
+	if _, err := w.Write([]byte{0}); err != nil {
+		return err
+	}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		line = strings.TrimRight(line, "\n")
+		if line == "" {
+			return errors.New("Empty SCP message")
+		}
+
+		switch line[0] {
+		case 'T':
+			// Modification times aren't kept
+		case 'C':
+			parts := strings.SplitN(line[1:], " ", 3)
+			if len(parts) != 3 {
+				return fmt.Errorf("Bad SCP file message: %s", line)
+			}
+
+			mode, err := strconv.ParseUint(parts[0], 8, 32)
+			if err != nil {
+				return fmt.Errorf("Bad SCP file mode: %s", line)
+			}
+
+			size, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("Bad SCP file size: %s", line)
+			}
+
+			dst := target
+			if dir {
+				dst = strings.TrimRight(target, "/") + "/" + parts[2]
+			}
+
+			if _, err := w.Write([]byte{0}); err != nil {
+				return err
+			}
+
+			// The file is streamed to the communicator as it is received
+			data := &io.LimitedReader{R: r, N: size}
+			opts := &packer.UploadOptions{Mode: os.FileMode(mode).Perm()}
+			if err := packer.UploadFile(comm, dst, data, opts); err != nil {
+				return err
+			}
+
+			if data.N != 0 {
+				return fmt.Errorf("Short SCP file %s: %d bytes missing", parts[2], data.N)
+			}
+
+			// The file is followed by a status byte
+			if _, err := r.ReadByte(); err != nil {
+				return err
+			}
+		case 'D', 'E':
+			return errors.New("Directories can't be copied.")
+		default:
+			return fmt.Errorf("Unknown SCP message: %s", line)
+		}
+
+		if _, err := w.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+}
+
+// scpSource downloads the file and sends it to the client. The size of
+// the file is sent before it, so it is downloaded to a temporary file
+// first rather than kept in memory.
+func scpSource(comm packer.Communicator, src string, r *bufio.Reader, w io.Writer) error {
+	tf, err := ioutil.TempFile("", "packer-scp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tf.Name())
+	defer tf.Close()
+
+	if err := comm.Download(src, tf); err != nil {
+		return err
+	}
+
+	size, err := tf.Seek(0, 2)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tf.Seek(0, 0); err != nil {
+		return err
+	}
+
+	// Wait for the client to be ready
+	if err := scpAck(r); err != nil {
+		return err
+	}
+
+	// The mode of the file isn't known, so the default is used
+	fmt.Fprintf(w, "C0644 %d %s\n", size, path.Base(src))
+	if err := scpAck(r); err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, tf); err != nil {
+		return err
+	}
+
+	if _, err := w.Write([]byte{0}); err != nil {
+		return err
+	}
+
+	return scpAck(r)
+}
+
+// scpAck reads the response of the client to the last message.
+func scpAck(r *bufio.Reader) error {
+	code, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	if code == 0 {
+		return nil
+	}
+
+	message, _ := r.ReadString('\n')
+	return fmt.Errorf("scp: %s", strings.TrimSpace(message))
+}
+
+// shellSplit splits the command into words like a POSIX shell, handling
+// quotes and backslashes but not expansions.
+func shellSplit(command string) ([]string, error) {
+	var words []string
+	var word []rune
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, c := range command {
+		switch {
+		case escaped:
+			word = append(word, c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word = append(word, c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, string(word))
+				word = word[:0]
+				inWord = false
+			}
+		default:
+			word = append(word, c)
+			inWord = true
+		}
+	}
+
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("Unterminated quote in command: %s", command)
+	}
+
+	if inWord {
+		words = append(words, string(word))
+	}
+
+	return words, nil
+}
@@ -0,0 +1,162 @@
+package ansible
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/mitchellh/packer/packer"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestShellSplit(t *testing.T) {
+	cases := map[string][]string{
+		"":                            nil,
+		"scp -t /tmp/foo":             []string{"scp", "-t", "/tmp/foo"},
+		"scp  -t  '/tmp/a b'":         []string{"scp", "-t", "/tmp/a b"},
+		`scp -t "/tmp/\"a\"" b\ c`:    []string{"scp", "-t", `/tmp/"a"`, "b c"},
+		`echo 'it'\''s' ''`:           []string{"echo", "it's", ""},
+		"/bin/sh -c 'echo $HOME'":     []string{"/bin/sh", "-c", "echo $HOME"},
+		"scp -t -- '/tmp/foo'\n":      []string{"scp", "-t", "--", "/tmp/foo"},
+		"mkdir -p \"$HOME/.ansible\"": []string{"mkdir", "-p", "$HOME/.ansible"},
+	}
+
+	for input, expected := range cases {
+		actual, err := shellSplit(input)
+		if err != nil {
+			t.Fatalf("err %q: %s", input, err)
+		}
+
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("bad %q: %#v", input, actual)
+		}
+	}
+
+	if _, err := shellSplit("echo 'foo"); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestParseScpCommand(t *testing.T) {
+	cmd, err := parseScpCommand("/bin/sh -c 'echo hi'")
+	if err != nil || cmd != nil {
+		t.Fatalf("should not be scp: %#v %s", cmd, err)
+	}
+
+	cmd, err = parseScpCommand("scp -t '/tmp/foo bar'")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !cmd.Sink || cmd.Dir || cmd.Path != "/tmp/foo bar" {
+		t.Fatalf("bad: %#v", cmd)
+	}
+
+	cmd, err = parseScpCommand("scp -v -d -f -- /tmp/foo")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if cmd.Sink || !cmd.Dir || cmd.Path != "/tmp/foo" {
+		t.Fatalf("bad: %#v", cmd)
+	}
+
+	invalid := []string{
+		"scp -r -t /tmp",
+		"scp -t",
+		"scp -t -f /tmp/foo",
+		"scp /tmp/foo",
+		"scp -t /tmp/foo /tmp/bar",
+	}
+
+	for _, command := range invalid {
+		if _, err := parseScpCommand(command); err == nil {
+			t.Fatalf("should have error: %s", command)
+		}
+	}
+}
+
+func TestScpSink(t *testing.T) {
+	comm := new(packer.MockCommunicator)
+	input := "T1234 0 1234 0\nC0644 5 foo\nhello\x00"
+	var output bytes.Buffer
+	err := scpSink(comm, "/tmp/foo", false, bufio.NewReader(strings.NewReader(input)), &output)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if output.String() != "\x00\x00\x00\x00" {
+		t.Fatalf("bad: %q", output.String())
+	}
+
+	if comm.UploadPath != "/tmp/foo" || comm.UploadData != "hello" {
+		t.Fatalf("bad: %s %q", comm.UploadPath, comm.UploadData)
+	}
+
+	// The mode of the file is kept
+	if !comm.StartCalled || !strings.Contains(comm.StartCmd.Command, "chmod 0644") {
+		t.Fatalf("bad: %#v", comm.StartCmd)
+	}
+
+	// Files that end before their size are an error
+	input = "C0644 10 foo\nhello"
+	err = scpSink(comm, "/tmp/foo", false, bufio.NewReader(strings.NewReader(input)), &output)
+	if err == nil || !strings.Contains(err.Error(), "Short") {
+		t.Fatalf("should have error: %s", err)
+	}
+}
+
+func TestScpSink_dir(t *testing.T) {
+	comm := new(packer.MockCommunicator)
+	input := "C0755 2 bar\nhi\x00"
+	var output bytes.Buffer
+	err := scpSink(comm, "/tmp/foo/", true, bufio.NewReader(strings.NewReader(input)), &output)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if comm.UploadPath != "/tmp/foo/bar" || comm.UploadData != "hi" {
+		t.Fatalf("bad: %s %q", comm.UploadPath, comm.UploadData)
+	}
+
+	err = scpSink(comm, "/tmp/foo", true, bufio.NewReader(strings.NewReader("D0755 0 bar\n")), &output)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestScpSource(t *testing.T) {
+	comm := new(packer.MockCommunicator)
+	comm.DownloadData = "hello"
+	var output bytes.Buffer
+	err := scpSource(comm, "/tmp/foo", bufio.NewReader(strings.NewReader("\x00\x00\x00")), &output)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if comm.DownloadPath != "/tmp/foo" {
+		t.Fatalf("bad: %s", comm.DownloadPath)
+	}
+
+	if output.String() != "C0644 5 foo\nhello\x00" {
+		t.Fatalf("bad: %q", output.String())
+	}
+
+	err = scpSource(comm, "/tmp/foo", bufio.NewReader(strings.NewReader("\x01no\n")), &output)
+	if err == nil || !strings.Contains(err.Error(), "no") {
+		t.Fatalf("should have error: %s", err)
+	}
+}
+
+func TestScpCommandRun_error(t *testing.T) {
+	comm := new(packer.MockCommunicator)
+	cmd := &scpCommand{Sink: true, Path: "/tmp/foo"}
+	var output bytes.Buffer
+	if status := cmd.Run(comm, strings.NewReader("X\n"), &output); status != 1 {
+		t.Fatalf("bad: %d", status)
+	}
+
+	if !strings.HasPrefix(output.String(), "\x00\x02Unknown SCP message") {
+		t.Fatalf("bad: %q", output.String())
+	}
+}
@@ -33,6 +33,12 @@ type Config struct {
 	SkipInstall         bool     `mapstructure:"skip_install"`
 	StagingDir          string   `mapstructure:"staging_directory"`
 
+	// These are set when the default commands are used, which are run
+	// with sudo by Packer. Custom commands are run as they are, and call
+	// sudo themselves based on the Sudo template variable.
+	defaultExecuteCommand bool
+	defaultInstallCommand bool
+
 	tpl *packer.ConfigTemplate
 }
 
@@ -78,11 +84,13 @@ func (p *Provisioner) Prepare(raws ...interface{}) error {
 	p.config.tpl.UserVars = p.config.PackerUserVars
 
 	if p.config.ExecuteCommand == "" {
-		p.config.ExecuteCommand = "{{if .Sudo}}sudo {{end}}chef-solo --no-color -c {{.ConfigPath}} -j {{.JsonPath}}"
+		p.config.ExecuteCommand = "chef-solo --no-color -c {{.ConfigPath}} -j {{.JsonPath}}"
+		p.config.defaultExecuteCommand = true
 	}
 
 	if p.config.InstallCommand == "" {
-		p.config.InstallCommand = "curl -L https://www.opscode.com/chef/install.sh | {{if .Sudo}}sudo {{end}}bash"
+		p.config.InstallCommand = "curl -L https://www.opscode.com/chef/install.sh | bash"
+		p.config.defaultInstallCommand = true
 	}
 
 	if p.config.RunList == nil {
@@ -284,7 +292,9 @@ func (p *Provisioner) uploadDirectory(ui packer.Ui, comm packer.Communicator, ds
 		src = src + "/"
 	}
 
-	return comm.UploadDir(dst, src, nil)
+	// Only the files that changed since a previous run are uploaded, and
+	// the ones that were removed are deleted.
+	return packer.SyncDir(comm, dst, src, &packer.SyncDirOptions{Delete: true})
 }
 
 func (p *Provisioner) createConfig(ui packer.Ui, comm packer.Communicator, localCookbooks []string, rolesPath string, dataBagsPath string, environmentsPath string, chefEnvironment string) (string, error) {
@@ -371,7 +381,7 @@ func (p *Provisioner) createJson(ui packer.Ui, comm packer.Communicator) (string
 func (p *Provisioner) createDir(ui packer.Ui, comm packer.Communicator, dir string) error {
 	ui.Message(fmt.Sprintf("Creating directory: %s", dir))
 	cmd := &packer.RemoteCmd{
-		Command: fmt.Sprintf("mkdir -p '%s'", dir),
+		Command: fmt.Sprintf("mkdir -p %s", packer.ShellQuote(dir)),
 	}
 
 	if err := cmd.StartWithUi(comm, ui); err != nil {
@@ -397,8 +407,10 @@ func (p *Provisioner) executeChef(ui packer.Ui, comm packer.Communicator, config
 
 	ui.Message(fmt.Sprintf("Executing Chef: %s", command))
 
-	cmd := &packer.RemoteCmd{
-		Command: command,
+	cmd := &packer.RemoteCmd{Command: command}
+	if p.config.defaultExecuteCommand {
+		cmd.Dir = p.config.StagingDir
+		cmd.Elevated = !p.config.PreventSudo
 	}
 
 	if err := cmd.StartWithUi(comm, ui); err != nil {
@@ -422,7 +434,11 @@ func (p *Provisioner) installChef(ui packer.Ui, comm packer.Communicator) error
 		return err
 	}
 
-	cmd := &packer.RemoteCmd{Command: command}
+	cmd := &packer.RemoteCmd{
+		Command:  command,
+		Elevated: p.config.defaultInstallCommand && !p.config.PreventSudo,
+	}
+
 	if err := cmd.StartWithUi(comm, ui); err != nil {
 		return err
 	}
@@ -1,6 +1,7 @@
 package chefsolo
 
 import (
+	"bytes"
 	"github.com/mitchellh/packer/packer"
 	"io/ioutil"
 	"os"
@@ -219,3 +220,46 @@ func TestProvisionerPrepare_json(t *testing.T) {
 		t.Fatalf("bad: %#v", p.config.Json)
 	}
 }
+
+func TestProvisionerExecuteChef_sudo(t *testing.T) {
+	ui := &packer.BasicUi{
+		Reader: new(bytes.Buffer),
+		Writer: new(bytes.Buffer),
+	}
+
+	// The default command is run with sudo by Packer
+	var p Provisioner
+	if err := p.Prepare(testConfig()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	comm := new(packer.MockCommunicator)
+	if err := p.executeChef(ui, comm, "solo.rb", "node.json"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !comm.StartCmd.Elevated {
+		t.Fatal("default command should be elevated")
+	}
+
+	// A custom command is run as it is
+	config := testConfig()
+	config["execute_command"] = "echo packer | {{if .Sudo}}sudo -S {{end}}chef-solo"
+	p = Provisioner{}
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	comm = new(packer.MockCommunicator)
+	if err := p.executeChef(ui, comm, "solo.rb", "node.json"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if comm.StartCmd.Elevated {
+		t.Fatal("custom command should not be elevated")
+	}
+
+	if comm.StartCmd.Command != "echo packer | sudo -S chef-solo" {
+		t.Fatalf("bad: %s", comm.StartCmd.Command)
+	}
+}
@@ -5,19 +5,49 @@ import (
 	"fmt"
 	"github.com/mitchellh/packer/common"
 	"github.com/mitchellh/packer/packer"
+	"log"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 type config struct {
 	common.PackerConfig `mapstructure:",squash"`
 
-	// The local path of the file to upload.
+	// The path of the file to copy. This is a local path for uploads
+	// and a remote path for downloads.
 	Source string
 
-	// The remote path where the local file will be uploaded to.
+	// The path where the file will be copied to. This is a remote path
+	// for uploads and a local path for downloads.
 	Destination string
 
-	tpl *packer.ConfigTemplate
+	// The direction of the copy, either "upload" or "download".
+	Direction string
+
+	// Patterns of paths to skip when copying a directory, as matched by
+	// packer.ExcludeMatch.
+	Exclude []string
+
+	// If true, directories are uploaded incrementally, only copying the
+	// files that changed. Delete additionally deletes the files in the
+	// destination that aren't in the source.
+	Sync   bool
+	Delete bool
+
+	// The permissions, in octal, of an uploaded file. By default new
+	// files get the default permissions of the communicator, and files
+	// in directories keep their local permissions.
+	Mode string
+
+	// The user and group that uploaded files and directories are given
+	// to. Changing them is done with sudo.
+	Owner string
+	Group string
+
+	mode os.FileMode
+	tpl  *packer.ConfigTemplate
 }
 
 type Provisioner struct {
@@ -39,9 +69,17 @@ func (p *Provisioner) Prepare(raws ...interface{}) error {
 	// Accumulate any errors
 	errs := common.CheckUnusedConfig(md)
 
+	if p.config.Direction == "" {
+		p.config.Direction = "upload"
+	}
+
 	templates := map[string]*string{
 		"source":      &p.config.Source,
 		"destination": &p.config.Destination,
+		"direction":   &p.config.Direction,
+		"mode":        &p.config.Mode,
+		"owner":       &p.config.Owner,
+		"group":       &p.config.Group,
 	}
 
 	for n, ptr := range templates {
@@ -53,9 +91,32 @@ func (p *Provisioner) Prepare(raws ...interface{}) error {
 		}
 	}
 
-	if _, err := os.Stat(p.config.Source); err != nil {
+	for i, pattern := range p.config.Exclude {
+		var err error
+		p.config.Exclude[i], err = p.config.tpl.Process(pattern, nil)
+		if err != nil {
+			errs = packer.MultiErrorAppend(
+				errs, fmt.Errorf("Error processing exclude[%d]: %s", i, err))
+		}
+	}
+
+	switch p.config.Direction {
+	case "upload":
+		if info, err := os.Stat(p.config.Source); err != nil {
+			errs = packer.MultiErrorAppend(errs,
+				fmt.Errorf("Bad source '%s': %s", p.config.Source, err))
+		} else if info.IsDir() && p.config.Mode != "" {
+			errs = packer.MultiErrorAppend(errs,
+				errors.New("Mode can only be set when uploading a file."))
+		}
+	case "download":
+		if p.config.Source == "" {
+			errs = packer.MultiErrorAppend(errs,
+				errors.New("Source must be specified."))
+		}
+	default:
 		errs = packer.MultiErrorAppend(errs,
-			fmt.Errorf("Bad source '%s': %s", p.config.Source, err))
+			errors.New("Direction must be one of: upload, download"))
 	}
 
 	if p.config.Destination == "" {
@@ -63,6 +124,32 @@ func (p *Provisioner) Prepare(raws ...interface{}) error {
 			errors.New("Destination must be specified."))
 	}
 
+	if p.config.Sync && p.config.Direction != "upload" {
+		errs = packer.MultiErrorAppend(errs,
+			errors.New("Only uploads can be synced."))
+	}
+
+	if p.config.Delete && !p.config.Sync {
+		errs = packer.MultiErrorAppend(errs,
+			errors.New("Delete can only be used with sync."))
+	}
+
+	if p.config.Mode != "" {
+		mode, err := strconv.ParseUint(p.config.Mode, 8, 32)
+		if err != nil || mode > 0777 {
+			errs = packer.MultiErrorAppend(errs,
+				fmt.Errorf("Mode must be octal permissions, such as 0755: %s", p.config.Mode))
+		}
+
+		p.config.mode = os.FileMode(mode)
+	}
+
+	if p.config.Direction != "upload" &&
+		(p.config.Mode != "" || p.config.Owner != "" || p.config.Group != "") {
+		errs = packer.MultiErrorAppend(errs,
+			errors.New("Mode, owner and group can only be used with uploads."))
+	}
+
 	if errs != nil && len(errs.Errors) > 0 {
 		return errs
 	}
@@ -71,6 +158,10 @@ func (p *Provisioner) Prepare(raws ...interface{}) error {
 }
 
 func (p *Provisioner) Provision(ui packer.Ui, comm packer.Communicator) error {
+	if p.config.Direction == "download" {
+		return p.provisionDownload(ui, comm)
+	}
+
 	ui.Say(fmt.Sprintf("Uploading %s => %s", p.config.Source, p.config.Destination))
 	info, err := os.Stat(p.config.Source)
 	if err != nil {
@@ -79,7 +170,22 @@ func (p *Provisioner) Provision(ui packer.Ui, comm packer.Communicator) error {
 
 	// If we're uploading a directory, short circuit and do that
 	if info.IsDir() {
-		return comm.UploadDir(p.config.Destination, p.config.Source, nil)
+		if p.config.Sync {
+			opts := &packer.SyncDirOptions{
+				Exclude: p.config.Exclude,
+				Delete:  p.config.Delete,
+			}
+
+			err = packer.SyncDir(comm, p.config.Destination, p.config.Source, opts)
+		} else {
+			err = comm.UploadDir(p.config.Destination, p.config.Source, p.config.Exclude)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		return p.chownDir(ui, comm)
 	}
 
 	// We're uploading a file...
@@ -89,13 +195,126 @@ func (p *Provisioner) Provision(ui packer.Ui, comm packer.Communicator) error {
 	}
 	defer f.Close()
 
-	err = comm.Upload(p.config.Destination, f)
+	opts := &packer.UploadOptions{
+		Mode:  p.config.mode,
+		Owner: p.config.Owner,
+		Group: p.config.Group,
+	}
+
+	err = packer.UploadFile(comm, p.config.Destination, f, opts)
 	if err != nil {
 		ui.Error(fmt.Sprintf("Upload failed: %s", err))
 	}
 	return err
 }
 
+// chownDir gives the uploaded directory, or the uploaded contents of it if
+// the source has a trailing slash, to the configured owner and group.
+func (p *Provisioner) chownDir(ui packer.Ui, comm packer.Communicator) error {
+	if p.config.Owner == "" && p.config.Group == "" {
+		return nil
+	}
+
+	dst := strings.TrimRight(p.config.Destination, "/")
+	var paths []string
+	if strings.HasSuffix(p.config.Source, "/") {
+		f, err := os.Open(p.config.Source)
+		if err != nil {
+			return err
+		}
+
+		entries, err := f.Readdir(-1)
+		f.Close()
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if !packer.ExcludeMatch(entry.Name(), p.config.Exclude) {
+				paths = append(paths, packer.ShellQuote(dst+"/"+entry.Name()))
+			}
+		}
+	} else {
+		paths = append(paths, packer.ShellQuote(dst+"/"+filepath.Base(p.config.Source)))
+	}
+
+	if len(paths) == 0 {
+		return nil
+	}
+
+	spec := p.config.Owner
+	if p.config.Group != "" {
+		spec += ":" + p.config.Group
+	}
+
+	cmd := &packer.RemoteCmd{
+		Command:  fmt.Sprintf("chown -R %s %s", packer.ShellQuote(spec), strings.Join(paths, " ")),
+		Elevated: true,
+	}
+	if err := cmd.StartWithUi(comm, ui); err != nil {
+		return err
+	}
+
+	if cmd.ExitStatus != 0 {
+		return fmt.Errorf("Error changing the owner of the uploaded files, exit status %d", cmd.ExitStatus)
+	}
+
+	return nil
+}
+
+func (p *Provisioner) provisionDownload(ui packer.Ui, comm packer.Communicator) error {
+	ui.Say(fmt.Sprintf("Downloading %s => %s", p.config.Source, p.config.Destination))
+
+	// A trailing slash on the source means it is a directory whose
+	// contents are downloaded into the destination.
+	if strings.HasSuffix(p.config.Source, "/") {
+		err := comm.DownloadDir(p.config.Source, p.config.Destination, p.config.Exclude)
+		if err != nil {
+			ui.Error(fmt.Sprintf("Download failed: %s", err))
+		}
+		return err
+	}
+
+	// If the destination is a directory, download into it with the
+	// name of the source file.
+	dst := p.config.Destination
+	if strings.HasSuffix(dst, "/") {
+		dst = filepath.Join(dst, filepath.Base(p.config.Source))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	err = comm.Download(p.config.Source, f)
+	f.Close()
+	if err == nil {
+		return nil
+	}
+
+	// Don't leave a partial file behind
+	os.Remove(dst)
+
+	// Communicators can't tell us whether a remote path is a directory,
+	// so a source without a trailing slash that can't be downloaded as
+	// a file is tried as a directory, which is downloaded into the
+	// destination with its own name.
+	log.Printf("Download failed, trying '%s' as a directory: %s", p.config.Source, err)
+	dirErr := comm.DownloadDir(p.config.Source, p.config.Destination, p.config.Exclude)
+	if dirErr == nil {
+		return nil
+	}
+
+	log.Printf("Directory download failed: %s", dirErr)
+	ui.Error(fmt.Sprintf("Download failed: %s", err))
+	return err
+}
+
 func (p *Provisioner) Cancel() {
 	// Just hard quit. It isn't a big deal if what we're doing keeps
 	// running on the other side.
@@ -1,9 +1,12 @@
 package file
 
 import (
+	"errors"
 	"github.com/mitchellh/packer/packer"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -74,6 +77,114 @@ func TestProvisionerPrepare_EmptyDestination(t *testing.T) {
 	}
 }
 
+func TestProvisionerPrepare_Direction(t *testing.T) {
+	var p Provisioner
+
+	config := testConfig()
+	config["source"] = "/nonexistent/on/this/machine"
+	config["direction"] = "download"
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	p = Provisioner{}
+	config["direction"] = "sideways"
+	if err := p.Prepare(config); err == nil {
+		t.Fatal("should error with a bad direction")
+	}
+
+	p = Provisioner{}
+	delete(config, "source")
+	config["direction"] = "download"
+	if err := p.Prepare(config); err == nil {
+		t.Fatal("should require a source to download")
+	}
+}
+
+func TestProvisionerPrepare_Sync(t *testing.T) {
+	var p Provisioner
+
+	config := testConfig()
+	config["source"] = "/nonexistent/on/this/machine"
+	config["direction"] = "download"
+	config["sync"] = true
+	if err := p.Prepare(config); err == nil {
+		t.Fatal("should not sync downloads")
+	}
+
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	p = Provisioner{}
+	config = testConfig()
+	config["source"] = td
+	config["delete"] = true
+	if err := p.Prepare(config); err == nil {
+		t.Fatal("should require sync to delete")
+	}
+
+	p = Provisioner{}
+	config["sync"] = true
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestProvisionerPrepare_Mode(t *testing.T) {
+	tf, err := ioutil.TempFile("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Remove(tf.Name())
+
+	for _, mode := range []string{"755", "0600"} {
+		var p Provisioner
+		config := testConfig()
+		config["source"] = tf.Name()
+		config["mode"] = mode
+		if err := p.Prepare(config); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	for _, mode := range []string{"rwx", "0999", "7777"} {
+		var p Provisioner
+		config := testConfig()
+		config["source"] = tf.Name()
+		config["mode"] = mode
+		if err := p.Prepare(config); err == nil {
+			t.Fatalf("should error: %s", mode)
+		}
+	}
+
+	var p Provisioner
+	config := testConfig()
+	config["source"] = "/nonexistent/on/this/machine"
+	config["direction"] = "download"
+	config["owner"] = "root"
+	if err := p.Prepare(config); err == nil {
+		t.Fatal("should not set the owner of downloads")
+	}
+
+	// Directories can't be given a mode
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	p = Provisioner{}
+	config = testConfig()
+	config["source"] = td
+	config["mode"] = "0755"
+	if err := p.Prepare(config); err == nil {
+		t.Fatal("should not set the mode of directories")
+	}
+}
+
 type stubUi struct {
 	sayMessages string
 }
@@ -139,3 +250,264 @@ func TestProvisionerProvision_SendsFile(t *testing.T) {
 		t.Fatalf("should upload with source file's data")
 	}
 }
+
+func TestProvisionerProvision_DownloadsFile(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	var p Provisioner
+	config := map[string]interface{}{
+		"source":      "/var/log/build.log",
+		"destination": td + "/logs/",
+		"direction":   "download",
+	}
+
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ui := &stubUi{}
+	comm := &packer.MockCommunicator{DownloadData: "hello"}
+	if err := p.Provision(ui, comm); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if comm.DownloadPath != "/var/log/build.log" {
+		t.Fatalf("bad: %s", comm.DownloadPath)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(td, "logs", "build.log"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if string(data) != "hello" {
+		t.Fatalf("bad: %s", data)
+	}
+}
+
+// failingDownloadComm is a communicator whose downloads fail partway.
+type failingDownloadComm struct {
+	packer.MockCommunicator
+}
+
+func (c *failingDownloadComm) Download(path string, w io.Writer) error {
+	w.Write([]byte("partial"))
+	return errors.New("download failed")
+}
+
+func TestProvisionerProvision_DownloadFails(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	var p Provisioner
+	config := map[string]interface{}{
+		"source":      "/var/log/build.log",
+		"destination": td + "/build.log",
+		"direction":   "download",
+	}
+
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The source isn't a directory either
+	comm := new(failingDownloadComm)
+	comm.DownloadDirFunc = func(string, string, []string) error {
+		return errors.New("not a directory")
+	}
+
+	ui := &stubUi{}
+	err = p.Provision(ui, comm)
+	if err == nil || err.Error() != "download failed" {
+		t.Fatalf("bad: %s", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(td, "build.log")); !os.IsNotExist(err) {
+		t.Fatalf("partial download should be removed: %s", err)
+	}
+}
+
+func TestProvisionerProvision_DownloadsDirWithoutSlash(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	var p Provisioner
+	config := map[string]interface{}{
+		"source":      "/var/log",
+		"destination": td + "/",
+		"direction":   "download",
+	}
+
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ui := &stubUi{}
+	comm := new(failingDownloadComm)
+	if err := p.Provision(ui, comm); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if comm.DownloadDirSrc != "/var/log" {
+		t.Fatalf("bad: %s", comm.DownloadDirSrc)
+	}
+
+	if comm.DownloadDirDst != td+"/" {
+		t.Fatalf("bad: %s", comm.DownloadDirDst)
+	}
+
+	if _, err := os.Stat(filepath.Join(td, "log")); !os.IsNotExist(err) {
+		t.Fatalf("failed file download should be removed: %s", err)
+	}
+}
+
+func TestProvisionerProvision_DownloadsDir(t *testing.T) {
+	var p Provisioner
+	config := map[string]interface{}{
+		"source":      "/var/log/",
+		"destination": "logs",
+		"direction":   "download",
+	}
+
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ui := &stubUi{}
+	comm := &packer.MockCommunicator{}
+	if err := p.Provision(ui, comm); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if comm.DownloadDirSrc != "/var/log/" {
+		t.Fatalf("bad: %s", comm.DownloadDirSrc)
+	}
+
+	if comm.DownloadDirDst != "logs" {
+		t.Fatalf("bad: %s", comm.DownloadDirDst)
+	}
+}
+
+func TestProvisionerProvision_SyncsDir(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	var p Provisioner
+	config := map[string]interface{}{
+		"source":      td,
+		"destination": "something",
+		"exclude":     []string{"*.log"},
+		"sync":        true,
+	}
+
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The mock can't sync, so the directory is uploaded
+	ui := &stubUi{}
+	comm := &packer.MockCommunicator{}
+	if err := p.Provision(ui, comm); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if comm.UploadDirSrc != td {
+		t.Fatalf("bad: %s", comm.UploadDirSrc)
+	}
+
+	if len(comm.UploadDirExclude) != 1 || comm.UploadDirExclude[0] != "*.log" {
+		t.Fatalf("bad: %#v", comm.UploadDirExclude)
+	}
+}
+
+func TestProvisionerProvision_SendsFileWithMode(t *testing.T) {
+	tf, err := ioutil.TempFile("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Remove(tf.Name())
+
+	var p Provisioner
+	config := map[string]interface{}{
+		"source":      tf.Name(),
+		"destination": "/tmp/my file",
+		"mode":        "0755",
+		"owner":       "root",
+		"group":       "wheel",
+	}
+
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ui := &stubUi{}
+	comm := &packer.MockCommunicator{}
+	if err := p.Provision(ui, comm); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if comm.UploadPath != "/tmp/my file" {
+		t.Fatalf("bad: %s", comm.UploadPath)
+	}
+
+	expected := "chown 'root:wheel' '/tmp/my file' && chmod 0755 '/tmp/my file'"
+	if comm.StartCmd == nil || comm.StartCmd.Command != expected || !comm.StartCmd.Elevated {
+		t.Fatalf("should set the mode and owner: %#v", comm.StartCmd)
+	}
+}
+
+func TestProvisionerProvision_ChownsDir(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	for _, name := range []string{"foo", "debug.log"} {
+		if err := ioutil.WriteFile(filepath.Join(td, name), nil, 0644); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	cases := map[string]string{
+		td:       "chown -R 'www' '/srv/" + filepath.Base(td) + "'",
+		td + "/": "chown -R 'www' '/srv/foo'",
+	}
+
+	for source, expected := range cases {
+		var p Provisioner
+		config := map[string]interface{}{
+			"source":      source,
+			"destination": "/srv/",
+			"exclude":     []string{"*.log"},
+			"owner":       "www",
+		}
+
+		if err := p.Prepare(config); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		ui := &stubUi{}
+		comm := &packer.MockCommunicator{}
+		if err := p.Provision(ui, comm); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if comm.StartCmd == nil || comm.StartCmd.Command != expected || !comm.StartCmd.Elevated {
+			t.Fatalf("bad: %#v", comm.StartCmd)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/mitchellh/packer/packer"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -37,6 +38,10 @@ type Config struct {
 	// The directory where files will be uploaded. Packer requires write
 	// permissions in this directory.
 	StagingDir string `mapstructure:"staging_directory"`
+
+	// Set when the default command is used, which Packer runs with sudo
+	// and the facts in its environment. A custom command is run as it is.
+	defaultExecuteCommand bool
 }
 
 type Provisioner struct {
@@ -69,11 +74,11 @@ func (p *Provisioner) Prepare(raws ...interface{}) error {
 
 	// Set some defaults
 	if p.config.ExecuteCommand == "" {
-		p.config.ExecuteCommand = "{{.FacterVars}} {{if .Sudo}} sudo -E {{end}}" +
-			"puppet apply --verbose --modulepath='{{.ModulePath}}' " +
+		p.config.ExecuteCommand = "puppet apply --verbose --modulepath='{{.ModulePath}}' " +
 			"{{if .HasHieraConfigPath}}--hiera_config='{{.HieraConfigPath}}' {{end}}" +
 			"--detailed-exitcodes " +
 			"{{.ManifestFile}}"
+		p.config.defaultExecuteCommand = true
 	}
 
 	if p.config.StagingDir == "" {
@@ -222,11 +227,16 @@ func (p *Provisioner) Provision(ui packer.Ui, comm packer.Communicator) error {
 		return fmt.Errorf("Error uploading manifests: %s", err)
 	}
 
-	// Compile the facter variables
+	// Compile the facter variables, which are set in the environment of
+	// the default command, and quoted for custom commands to use.
+	facterEnv := make([]string, 0, len(p.config.Facter))
 	facterVars := make([]string, 0, len(p.config.Facter))
 	for k, v := range p.config.Facter {
-		facterVars = append(facterVars, fmt.Sprintf("FACTER_%s='%s'", k, v))
+		facterEnv = append(facterEnv, fmt.Sprintf("FACTER_%s=%s", k, v))
+		facterVars = append(facterVars, fmt.Sprintf("FACTER_%s=%s", k, packer.ShellQuote(v)))
 	}
+	sort.Strings(facterEnv)
+	sort.Strings(facterVars)
 
 	// Execute Puppet
 	command, err := p.config.tpl.Process(p.config.ExecuteCommand, &ExecuteTemplate{
@@ -241,8 +251,11 @@ func (p *Provisioner) Provision(ui packer.Ui, comm packer.Communicator) error {
 		return err
 	}
 
-	cmd := &packer.RemoteCmd{
-		Command: command,
+	cmd := &packer.RemoteCmd{Command: command}
+	if p.config.defaultExecuteCommand {
+		cmd.Env = facterEnv
+		cmd.Dir = p.config.StagingDir
+		cmd.Elevated = !p.config.PreventSudo
 	}
 
 	ui.Message(fmt.Sprintf("Running Puppet: %s", command))
@@ -305,7 +318,7 @@ func (p *Provisioner) uploadManifests(ui packer.Ui, comm packer.Communicator) (s
 
 func (p *Provisioner) createDir(ui packer.Ui, comm packer.Communicator, dir string) error {
 	cmd := &packer.RemoteCmd{
-		Command: fmt.Sprintf("mkdir -p '%s'", dir),
+		Command: fmt.Sprintf("mkdir -p %s", packer.ShellQuote(dir)),
 	}
 
 	if err := cmd.StartWithUi(comm, ui); err != nil {
@@ -1,9 +1,12 @@
 package puppetmasterless
 
 import (
+	"bytes"
 	"github.com/mitchellh/packer/packer"
 	"io/ioutil"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -108,3 +111,51 @@ func TestProvisionerPrepare_modulePaths(t *testing.T) {
 		t.Fatalf("err: %s", err)
 	}
 }
+
+func TestProvisionerProvision_sudo(t *testing.T) {
+	ui := &packer.BasicUi{
+		Reader: new(bytes.Buffer),
+		Writer: new(bytes.Buffer),
+	}
+
+	// The default command is run with sudo and the facts by Packer
+	config := testConfig()
+	config["facter"] = map[string]string{"foo": "bar"}
+	p := new(Provisioner)
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	comm := new(packer.MockCommunicator)
+	if err := p.Provision(ui, comm); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !comm.StartCmd.Elevated {
+		t.Fatal("default command should be elevated")
+	}
+
+	if !reflect.DeepEqual(comm.StartCmd.Env, []string{"FACTER_foo=bar"}) {
+		t.Fatalf("bad: %#v", comm.StartCmd.Env)
+	}
+
+	// A custom command is run as it is
+	config["execute_command"] = "{{.FacterVars}} {{if .Sudo}}sudo -E {{end}}puppet apply"
+	p = new(Provisioner)
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	comm = new(packer.MockCommunicator)
+	if err := p.Provision(ui, comm); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if comm.StartCmd.Elevated || comm.StartCmd.Env != nil {
+		t.Fatalf("bad: %#v", comm.StartCmd)
+	}
+
+	if !strings.Contains(comm.StartCmd.Command, "FACTER_foo='bar' ") {
+		t.Fatalf("bad: %s", comm.StartCmd.Command)
+	}
+}
@@ -110,7 +110,8 @@ func (p *Provisioner) Provision(ui packer.Ui, comm packer.Communicator) error {
 	ui.Say("Provisioning with Salt...")
 	if !p.config.SkipBootstrap {
 		cmd := &packer.RemoteCmd{
-			Command: fmt.Sprintf("wget -O - http://bootstrap.saltstack.org | sudo sh -s %s", p.config.BootstrapArgs),
+			Command:  fmt.Sprintf("wget -O - http://bootstrap.saltstack.org | sh -s %s", p.config.BootstrapArgs),
+			Elevated: true,
 		}
 		ui.Message(fmt.Sprintf("Installing Salt with command %s", cmd))
 		if err = cmd.StartWithUi(comm, ui); err != nil {
@@ -119,7 +120,7 @@ func (p *Provisioner) Provision(ui packer.Ui, comm packer.Communicator) error {
 	}
 
 	ui.Message(fmt.Sprintf("Creating remote directory: %s", p.config.TempConfigDir))
-	cmd := &packer.RemoteCmd{Command: fmt.Sprintf("mkdir -p %s", p.config.TempConfigDir)}
+	cmd := &packer.RemoteCmd{Command: fmt.Sprintf("mkdir -p %s", packer.ShellQuote(p.config.TempConfigDir))}
 	if err = cmd.StartWithUi(comm, ui); err != nil || cmd.ExitStatus != 0 {
 		if err == nil {
 			err = fmt.Errorf("Bad exit status: %d", cmd.ExitStatus)
@@ -135,7 +136,11 @@ func (p *Provisioner) Provision(ui packer.Ui, comm packer.Communicator) error {
 		}
 
 		ui.Message(fmt.Sprintf("Moving %s/minion to /etc/salt/minion", p.config.TempConfigDir))
-		cmd = &packer.RemoteCmd{Command: fmt.Sprintf("sudo mv %s/minion /etc/salt/minion", p.config.TempConfigDir)}
+		cmd = &packer.RemoteCmd{
+			Command:  "mv minion /etc/salt/minion",
+			Dir:      p.config.TempConfigDir,
+			Elevated: true,
+		}
 		if err = cmd.StartWithUi(comm, ui); err != nil || cmd.ExitStatus != 0 {
 			if err == nil {
 				err = fmt.Errorf("Bad exit status: %d", cmd.ExitStatus)
@@ -151,7 +156,11 @@ func (p *Provisioner) Provision(ui packer.Ui, comm packer.Communicator) error {
 	}
 
 	ui.Message(fmt.Sprintf("Moving %s to /srv/salt", p.config.TempConfigDir))
-	cmd = &packer.RemoteCmd{Command: fmt.Sprintf("sudo mv %s/states /srv/salt/", p.config.TempConfigDir)}
+	cmd = &packer.RemoteCmd{
+		Command:  "mv states /srv/salt/",
+		Dir:      p.config.TempConfigDir,
+		Elevated: true,
+	}
 	if err = cmd.StartWithUi(comm, ui); err != nil || cmd.ExitStatus != 0 {
 		if err == nil {
 			err = fmt.Errorf("Bad exit status: %d", cmd.ExitStatus)
@@ -162,7 +171,10 @@ func (p *Provisioner) Provision(ui packer.Ui, comm packer.Communicator) error {
 
 	if p.config.LocalPillarRoots != "" {
 		ui.Message(fmt.Sprintf("Creating remote pillar directory: %s/pillar", p.config.TempConfigDir))
-		cmd := &packer.RemoteCmd{Command: fmt.Sprintf("mkdir -p %s/pillar", p.config.TempConfigDir)}
+		cmd := &packer.RemoteCmd{
+			Command: "mkdir -p pillar",
+			Dir:     p.config.TempConfigDir,
+		}
 		if err = cmd.StartWithUi(comm, ui); err != nil || cmd.ExitStatus != 0 {
 			if err == nil {
 				err = fmt.Errorf("Bad exit status: %d", cmd.ExitStatus)
@@ -177,7 +189,11 @@ func (p *Provisioner) Provision(ui packer.Ui, comm packer.Communicator) error {
 		}
 
 		ui.Message(fmt.Sprintf("Moving %s/pillar to /srv/pillar", p.config.TempConfigDir))
-		cmd = &packer.RemoteCmd{Command: fmt.Sprintf("sudo mv %s/pillar /srv/pillar", p.config.TempConfigDir)}
+		cmd = &packer.RemoteCmd{
+			Command:  "mv pillar /srv/pillar",
+			Dir:      p.config.TempConfigDir,
+			Elevated: true,
+		}
 		if err = cmd.StartWithUi(comm, ui); err != nil || cmd.ExitStatus != 0 {
 			if err == nil {
 				err = fmt.Errorf("Bad exit status: %d", cmd.ExitStatus)
@@ -188,7 +204,10 @@ func (p *Provisioner) Provision(ui packer.Ui, comm packer.Communicator) error {
 	}
 
 	ui.Message("Running highstate")
-	cmd = &packer.RemoteCmd{Command: "sudo salt-call --local state.highstate -l info"}
+	cmd = &packer.RemoteCmd{
+		Command:  "salt-call --local state.highstate -l info",
+		Elevated: true,
+	}
 	if err = cmd.StartWithUi(comm, ui); err != nil || cmd.ExitStatus != 0 {
 		if err == nil {
 			err = fmt.Errorf("Bad exit status: %d", cmd.ExitStatus)
@@ -216,7 +235,7 @@ func UploadLocalDirectory(localDir string, remoteDir string, comm packer.Communi
 		}
 		if f.IsDir() {
 			// Make remote directory
-			cmd := &packer.RemoteCmd{Command: fmt.Sprintf("mkdir -p %s", remotePath)}
+			cmd := &packer.RemoteCmd{Command: fmt.Sprintf("mkdir -p %s", packer.ShellQuote(remotePath))}
 			if err = cmd.StartWithUi(comm, ui); err != nil {
 				return err
 			}
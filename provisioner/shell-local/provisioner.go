@@ -0,0 +1,54 @@
+// This package implements a provisioner for Packer that executes
+// shell scripts on the machine Packer runs on.
+package shelllocal
+
+import (
+	"github.com/mitchellh/packer/common"
+	slcommon "github.com/mitchellh/packer/common/shell-local"
+	"github.com/mitchellh/packer/packer"
+	"os"
+)
+
+type Config struct {
+	common.PackerConfig `mapstructure:",squash"`
+	slcommon.Config     `mapstructure:",squash"`
+
+	tpl *packer.ConfigTemplate
+}
+
+type Provisioner struct {
+	config Config
+}
+
+func (p *Provisioner) Prepare(raws ...interface{}) error {
+	md, err := common.DecodeConfig(&p.config, raws...)
+	if err != nil {
+		return err
+	}
+
+	p.config.tpl, err = packer.NewConfigTemplate()
+	if err != nil {
+		return err
+	}
+	p.config.tpl.UserVars = p.config.PackerUserVars
+
+	// Accumulate any errors
+	errs := common.CheckUnusedConfig(md)
+	errs = packer.MultiErrorAppend(errs, p.config.Config.Prepare(p.config.tpl)...)
+
+	if errs != nil && len(errs.Errors) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func (p *Provisioner) Provision(ui packer.Ui, _ packer.Communicator) error {
+	env := slcommon.BuildEnv(&p.config.PackerConfig)
+	return slcommon.Run(ui, &p.config.Config, p.config.tpl, env)
+}
+
+func (p *Provisioner) Cancel() {
+	// Just hard quit. The scripts are local, so they end along with us.
+	os.Exit(0)
+}
@@ -0,0 +1,76 @@
+package shelllocal
+
+import (
+	"bytes"
+	"github.com/mitchellh/packer/packer"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func testConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"inline": []string{"echo $PACKER_BUILD_NAME $PACKER_BUILDER_TYPE $PACKER_VAR_version $FOO"},
+	}
+}
+
+func TestProvisioner_Impl(t *testing.T) {
+	var raw interface{}
+	raw = &Provisioner{}
+	if _, ok := raw.(packer.Provisioner); !ok {
+		t.Fatalf("must be a Provisioner")
+	}
+}
+
+func TestProvisionerPrepare(t *testing.T) {
+	var p Provisioner
+	if err := p.Prepare(testConfig()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Neither a script nor inline commands
+	p = Provisioner{}
+	if err := p.Prepare(map[string]interface{}{}); err == nil {
+		t.Fatal("should have error")
+	}
+
+	// Unknown keys
+	p = Provisioner{}
+	config := testConfig()
+	config["i_should_not_be_valid"] = true
+	if err := p.Prepare(config); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestProvisionerProvision(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("not supported on windows")
+	}
+
+	var p Provisioner
+	config := testConfig()
+	config["environment_vars"] = []string{"FOO=bar"}
+	config["packer_build_name"] = "foo"
+	config["packer_builder_type"] = "virtualbox"
+	config["packer_user_variables"] = map[string]string{"version": "1.0"}
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var output bytes.Buffer
+	ui := &packer.BasicUi{Reader: new(bytes.Buffer), Writer: &output}
+	comm := new(packer.MockCommunicator)
+	if err := p.Provision(ui, comm); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !strings.Contains(output.String(), "foo virtualbox 1.0 bar\n") {
+		t.Fatalf("bad: %q", output.String())
+	}
+
+	// Nothing is run on the machine being built
+	if comm.StartCalled || comm.UploadCalled {
+		t.Fatal("should not use the communicator")
+	}
+}
@@ -49,6 +49,7 @@ type config struct {
 	// The command used to execute the script. The '{{ .Path }}' variable
 	// should be used to specify where the script goes, {{ .Vars }}
 	// can be used to inject the environment_vars into the environment.
+	// The default command gets the environment_vars set by Packer instead.
 	ExecuteCommand string `mapstructure:"execute_command"`
 
 	// The timeout for retrying to start the process. Until this timeout
@@ -56,8 +57,16 @@ type config struct {
 	// This can be set high to allow for reboots.
 	RawStartRetryTimeout string `mapstructure:"start_retry_timeout"`
 
-	startRetryTimeout time.Duration
-	tpl               *packer.ConfigTemplate
+	// If true, the scripts are expected to drop the connection, such as
+	// by rebooting the machine. Packer then waits for the machine to come
+	// back, up to the reconnect timeout, rather than failing.
+	ExpectDisconnect    bool   `mapstructure:"expect_disconnect"`
+	RawReconnectTimeout string `mapstructure:"reconnect_timeout"`
+
+	defaultExecuteCommand bool
+	startRetryTimeout     time.Duration
+	reconnectTimeout      time.Duration
+	tpl                   *packer.ConfigTemplate
 }
 
 type Provisioner struct {
@@ -85,7 +94,8 @@ func (p *Provisioner) Prepare(raws ...interface{}) error {
 	errs := common.CheckUnusedConfig(md)
 
 	if p.config.ExecuteCommand == "" {
-		p.config.ExecuteCommand = "chmod +x {{.Path}}; {{.Vars}} {{.Path}}"
+		p.config.ExecuteCommand = "chmod +x {{.Path}}; {{.Path}}"
+		p.config.defaultExecuteCommand = true
 	}
 
 	if p.config.Inline != nil && len(p.config.Inline) == 0 {
@@ -100,6 +110,10 @@ func (p *Provisioner) Prepare(raws ...interface{}) error {
 		p.config.RawStartRetryTimeout = "5m"
 	}
 
+	if p.config.RawReconnectTimeout == "" {
+		p.config.RawReconnectTimeout = "5m"
+	}
+
 	if p.config.RemotePath == "" {
 		p.config.RemotePath = DefaultRemotePath
 	}
@@ -125,6 +139,7 @@ func (p *Provisioner) Prepare(raws ...interface{}) error {
 		"inline_shebang":      &p.config.InlineShebang,
 		"script":              &p.config.Script,
 		"start_retry_timeout": &p.config.RawStartRetryTimeout,
+		"reconnect_timeout":   &p.config.RawReconnectTimeout,
 		"remote_path":         &p.config.RemotePath,
 	}
 
@@ -171,7 +186,7 @@ func (p *Provisioner) Prepare(raws ...interface{}) error {
 
 	// Do a check for bad environment variables, such as '=foo', 'foobar'
 	for _, kv := range p.config.Vars {
-		vs := strings.Split(kv, "=")
+		vs := strings.SplitN(kv, "=", 2)
 		if len(vs) != 2 || vs[0] == "" {
 			errs = packer.MultiErrorAppend(errs,
 				fmt.Errorf("Environment variable not in format 'key=value': %s", kv))
@@ -186,6 +201,12 @@ func (p *Provisioner) Prepare(raws ...interface{}) error {
 		}
 	}
 
+	p.config.reconnectTimeout, err = time.ParseDuration(p.config.RawReconnectTimeout)
+	if err != nil {
+		errs = packer.MultiErrorAppend(
+			errs, fmt.Errorf("Failed parsing reconnect_timeout: %s", err))
+	}
+
 	if errs != nil && len(errs.Errors) > 0 {
 		return errs
 	}
@@ -273,7 +294,17 @@ func (p *Provisioner) Provision(ui packer.Ui, comm packer.Communicator) error {
 				return fmt.Errorf("Error uploading script: %s", err)
 			}
 
-			cmd = &packer.RemoteCmd{Command: command}
+			cmd = &packer.RemoteCmd{
+				Command:          command,
+				ExpectDisconnect: p.config.ExpectDisconnect,
+				ReconnectTimeout: p.config.reconnectTimeout,
+			}
+
+			// The default command doesn't use the Vars, so the variables
+			// are set for it, quoted so that their values are kept as is
+			if p.config.defaultExecuteCommand {
+				cmd.Env = envVars
+			}
 			return cmd.StartWithUi(comm, ui)
 		})
 		if err != nil {
@@ -1,10 +1,13 @@
 package shell
 
 import (
+	"bytes"
 	"github.com/mitchellh/packer/packer"
 	"io/ioutil"
 	"os"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func testConfig() map[string]interface{} {
@@ -198,4 +201,95 @@ func TestProvisionerPrepare_EnvironmentVars(t *testing.T) {
 	if err != nil {
 		t.Fatalf("should not have error: %s", err)
 	}
+
+	// Values can have any characters, which the default command keeps
+	config["environment_vars"] = []string{"FOO=a=b", "BAR=it's $HOME"}
+	p = new(Provisioner)
+	err = p.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+}
+
+func TestProvisionerProvision_EnvironmentVars(t *testing.T) {
+	config := testConfig()
+	config["environment_vars"] = []string{"FOO=it's $HOME"}
+	config["packer_build_name"] = "foo"
+
+	p := new(Provisioner)
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ui := &packer.BasicUi{
+		Reader: new(bytes.Buffer),
+		Writer: new(bytes.Buffer),
+	}
+
+	// The default command gets the variables in its environment
+	comm := new(packer.MockCommunicator)
+	if err := p.Provision(ui, comm); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []string{
+		"PACKER_BUILD_NAME=foo",
+		"PACKER_BUILDER_TYPE=",
+		"FOO=it's $HOME",
+	}
+	if !reflect.DeepEqual(comm.StartCmd.Env, expected) {
+		t.Fatalf("bad: %#v", comm.StartCmd.Env)
+	}
+
+	// A custom command is run as it is, with the variables as before
+	config["environment_vars"] = []string{"FOO=$HOME"}
+	config["execute_command"] = "{{.Vars}} {{.Path}}"
+	p = new(Provisioner)
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	comm = new(packer.MockCommunicator)
+	if err := p.Provision(ui, comm); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if comm.StartCmd.Env != nil {
+		t.Fatalf("bad: %#v", comm.StartCmd.Env)
+	}
+
+	command := "PACKER_BUILD_NAME=foo PACKER_BUILDER_TYPE= FOO=$HOME " + DefaultRemotePath
+	if comm.StartCmd.Command != command {
+		t.Fatalf("bad: %s", comm.StartCmd.Command)
+	}
+}
+
+func TestProvisionerPrepare_ReconnectTimeout(t *testing.T) {
+	config := testConfig()
+
+	p := new(Provisioner)
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if p.config.reconnectTimeout != 5*time.Minute {
+		t.Fatalf("bad: %s", p.config.reconnectTimeout)
+	}
+
+	config["expect_disconnect"] = true
+	config["reconnect_timeout"] = "10m"
+	p = new(Provisioner)
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !p.config.ExpectDisconnect || p.config.reconnectTimeout != 10*time.Minute {
+		t.Fatalf("bad: %#v", p.config)
+	}
+
+	config["reconnect_timeout"] = "bad"
+	p = new(Provisioner)
+	if err := p.Prepare(config); err == nil {
+		t.Fatal("should have error")
+	}
 }
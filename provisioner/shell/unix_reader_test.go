@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io"
 	"testing"
+	"testing/iotest"
 )
 
 func TestUnixReader_impl(t *testing.T) {
@@ -49,3 +50,23 @@ func TestUnixReader_unixOnly(t *testing.T) {
 		t.Fatalf("bad: %#v", result.String())
 	}
 }
+
+func TestUnixReader_partialLines(t *testing.T) {
+	// Reading a byte at a time, the scanner sees lines before they are
+	// complete, and the last line has no line ending.
+	input := "one\r\ntwo"
+	expected := "one\ntwo\n"
+
+	r := &UnixReader{
+		Reader: iotest.OneByteReader(bytes.NewReader([]byte(input))),
+	}
+
+	result := new(bytes.Buffer)
+	if _, err := io.Copy(result, r); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result.String() != expected {
+		t.Fatalf("bad: %#v", result.String())
+	}
+}